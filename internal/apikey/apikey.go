@@ -0,0 +1,227 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"backend/internal/types"
+	"backend/pkg/secrets"
+
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+// Prefix starts every key so leaked keys are easy to spot in logs and scanners
+const Prefix = "gbk_"
+
+// displayLength is how much of a key is kept in clear to tell keys apart
+const displayLength = len(Prefix) + 6
+
+var (
+	ErrNotFound     = errors.New("api key does not exist")
+	ErrRevoked      = errors.New("api key has been revoked")
+	ErrExpired      = errors.New("api key has expired")
+	ErrLimitReached = errors.New("api key limit reached")
+)
+
+// APIKey is a long lived credential a user hands to a machine client. Only the
+// SHA-256 of the key is stored.
+type APIKey struct {
+	types.Base
+	Subject  string `gorm:"index"`
+	Username string
+	Email    string
+	Name     string
+	// Start is the beginning of the key, shown to tell keys apart
+	Start string
+	Hash  string `gorm:"uniqueIndex"`
+	// Scopes is a comma separated list, empty means the key may do whatever its owner may
+	Scopes     string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// ScopeList returns the scopes of the key
+func (k APIKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return nil
+	}
+
+	return strings.Split(k.Scopes, ",")
+}
+
+// Usable returns why the key can't be used at now, if it can't
+func (k APIKey) Usable(now time.Time) error {
+	switch {
+	case k.RevokedAt != nil:
+		return ErrRevoked
+	case k.ExpiresAt != nil && !now.Before(*k.ExpiresAt):
+		return ErrExpired
+	default:
+		return nil
+	}
+}
+
+// Owner is who a key is created for
+type Owner struct {
+	Subject  string
+	Username string
+	Email    string
+}
+
+// Store keeps API keys in Postgres
+type Store struct {
+	DB *gorm.DB
+	// MaxPerUser bounds the active keys of a user, zero means no bound
+	MaxPerUser int
+	// TouchInterval is how stale last_used_at may get before it is written
+	// again, so busy keys don't cause a write per request
+	TouchInterval time.Duration
+}
+
+func NewStore(db *gorm.DB, maxPerUser int, touchInterval time.Duration) *Store {
+	return &Store{DB: db, MaxPerUser: maxPerUser, TouchInterval: touchInterval}
+}
+
+// Create issues a key for owner. The returned secret is shown once and can't
+// be recovered later.
+func (s *Store) Create(ctx context.Context, owner Owner, name string, scopes []string, expiresAt *time.Time) (*APIKey, string, error) {
+	if s.MaxPerUser > 0 {
+		var active int64
+		err := s.DB.WithContext(ctx).Model(&APIKey{}).
+			Where("subject = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", owner.Subject, time.Now()).
+			Count(&active).Error
+		if err != nil {
+			return nil, "", err
+		}
+		if active >= int64(s.MaxPerUser) {
+			return nil, "", ErrLimitReached
+		}
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	base, err := types.NewBase()
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &APIKey{
+		Base:      *base,
+		Subject:   owner.Subject,
+		Username:  owner.Username,
+		Email:     owner.Email,
+		Name:      name,
+		Start:     secret[:displayLength],
+		Hash:      secrets.Hash(secret),
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: expiresAt,
+	}
+
+	if err := s.DB.WithContext(ctx).Create(key).Error; err != nil {
+		return nil, "", err
+	}
+
+	return key, secret, nil
+}
+
+// List returns the keys of subject newest first, revoked ones included
+func (s *Store) List(ctx context.Context, subject string) ([]APIKey, error) {
+	var keys []APIKey
+	err := s.DB.WithContext(ctx).Where("subject = ?", subject).Order("created_at DESC").Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Revoke revokes a key of subject
+func (s *Store) Revoke(ctx context.Context, subject string, id ulid.ULID) (*APIKey, error) {
+	var key APIKey
+	err := s.DB.WithContext(ctx).Where("id = ? AND subject = ?", id, subject).First(&key).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if key.RevokedAt != nil {
+		return nil, ErrRevoked
+	}
+
+	now := time.Now()
+	key.RevokedAt = &now
+	err = s.DB.WithContext(ctx).Model(&key).Updates(map[string]interface{}{
+		"revoked_at": now,
+		"updated_at": now,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// RevokeAll revokes every active key of subject, e.g. when the account is disabled
+func (s *Store) RevokeAll(ctx context.Context, subject string) error {
+	now := time.Now()
+	return s.DB.WithContext(ctx).Model(&APIKey{}).
+		Where("subject = ? AND revoked_at IS NULL", subject).
+		Updates(map[string]interface{}{
+			"revoked_at": now,
+			"updated_at": now,
+		}).Error
+}
+
+// Authenticate resolves a secret to its key and records that it was used
+func (s *Store) Authenticate(ctx context.Context, secret string) (*APIKey, error) {
+	if !strings.HasPrefix(secret, Prefix) {
+		return nil, ErrNotFound
+	}
+
+	var key APIKey
+	err := s.DB.WithContext(ctx).Where("hash = ?", secrets.Hash(secret)).First(&key).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := key.Usable(now); err != nil {
+		return nil, err
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= s.TouchInterval {
+		key.LastUsedAt = &now
+		err := s.DB.WithContext(ctx).Model(&APIKey{}).Where("id = ?", key.ID).
+			UpdateColumn("last_used_at", now).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+func newSecret() (string, error) {
+	token, err := secrets.Token()
+	if err != nil {
+		return "", err
+	}
+
+	return Prefix + token, nil
+}
@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+
+	"backend/internal/auth"
+	"backend/internal/types"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Outcomes of an audited action
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event is one entry of the audit trail of administrative actions
+type Event struct {
+	types.Base
+	ActorSubject  string `gorm:"index"`
+	ActorUsername string
+	Action        string `gorm:"index"`
+	TargetType    string
+	Target        string `gorm:"index"`
+	Outcome       string
+	Error         string
+	IP            string
+	RequestID     string
+	UserAgent     string
+	// Details holds action specific data as JSON
+	Details string
+}
+
+func (Event) TableName() string {
+	return "audit_events"
+}
+
+// Recorder stores audit events
+type Recorder interface {
+	Record(ctx context.Context, event *Event) error
+}
+
+// DBRecorder writes the audit trail to Postgres
+type DBRecorder struct {
+	DB *gorm.DB
+}
+
+func NewDBRecorder(db *gorm.DB) *DBRecorder {
+	return &DBRecorder{DB: db}
+}
+
+func (r *DBRecorder) Record(ctx context.Context, event *Event) error {
+	base, err := types.NewBase()
+	if err != nil {
+		return err
+	}
+	event.Base = *base
+
+	return r.DB.WithContext(ctx).Create(event).Error
+}
+
+// FromRequest builds an event for an action the authenticated principal takes
+// on target. err decides the outcome; details are stored as JSON.
+func FromRequest(c echo.Context, action, targetType, target string, err error, details map[string]interface{}) *Event {
+	event := &Event{
+		Action:     action,
+		TargetType: targetType,
+		Target:     target,
+		Outcome:    OutcomeSuccess,
+		IP:         c.RealIP(),
+		RequestID:  c.Response().Header().Get(echo.HeaderXRequestID),
+		UserAgent:  c.Request().UserAgent(),
+	}
+
+	if principal, ok := auth.PrincipalFrom(c); ok {
+		event.ActorSubject = principal.Subject
+		event.ActorUsername = principal.Username
+	}
+
+	if err != nil {
+		event.Outcome = OutcomeFailure
+		event.Error = err.Error()
+	}
+
+	if len(details) > 0 {
+		if raw, jsonErr := json.Marshal(details); jsonErr == nil {
+			event.Details = string(raw)
+		}
+	}
+
+	return event
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Denylist remembers tokens that were revoked before their natural expiry
+type Denylist interface {
+	// Revoke rejects tokens whose jti or origin_jti equals id until expiresAt
+	Revoke(id string, expiresAt time.Time)
+	// RevokeSubject rejects every token of sub issued in a second before the given time
+	RevokeSubject(sub string, issuedBefore time.Time, expiresAt time.Time)
+	// IsRevoked reports whether the principal's token has been revoked
+	IsRevoked(p *Principal) bool
+}
+
+type subjectRevocation struct {
+	issuedBefore time.Time
+	expiresAt    time.Time
+}
+
+// MemoryDenylist is a process local Denylist. Entries are dropped once the
+// tokens they refer to have expired.
+type MemoryDenylist struct {
+	mu       sync.RWMutex
+	tokens   map[string]time.Time
+	subjects map[string]subjectRevocation
+	now      func() time.Time
+}
+
+func NewMemoryDenylist() *MemoryDenylist {
+	return &MemoryDenylist{
+		tokens:   map[string]time.Time{},
+		subjects: map[string]subjectRevocation{},
+		now:      time.Now,
+	}
+}
+
+func (d *MemoryDenylist) Revoke(id string, expiresAt time.Time) {
+	if id == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.tokens[id] = expiresAt
+	d.sweep()
+}
+
+func (d *MemoryDenylist) RevokeSubject(sub string, issuedBefore time.Time, expiresAt time.Time) {
+	if sub == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// iat has whole seconds, a token issued in the second of the revocation
+	// counts as issued after it so signing in again right away works
+	d.subjects[sub] = subjectRevocation{issuedBefore: issuedBefore.Truncate(time.Second), expiresAt: expiresAt}
+	d.sweep()
+}
+
+func (d *MemoryDenylist) IsRevoked(p *Principal) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	now := d.now()
+	for _, id := range []string{p.TokenID, p.SessionID} {
+		if exp, ok := d.tokens[id]; ok && id != "" && now.Before(exp) {
+			return true
+		}
+	}
+
+	if rev, ok := d.subjects[p.Subject]; ok && now.Before(rev.expiresAt) && p.IssuedAt.Before(rev.issuedBefore) {
+		return true
+	}
+
+	return false
+}
+
+// sweep drops expired entries, it must be called with mu held
+func (d *MemoryDenylist) sweep() {
+	now := d.now()
+	for jti, exp := range d.tokens {
+		if now.After(exp) {
+			delete(d.tokens, jti)
+		}
+	}
+	for sub, rev := range d.subjects {
+		if now.After(rev.expiresAt) {
+			delete(d.subjects, sub)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLockout caps the lockout duration however often an account is locked
+const maxLockout = 24 * time.Hour
+
+type LoginGuardOptions struct {
+	// MaxFailures is the number of failures per username before it is locked
+	MaxFailures int
+	// IPMaxFailures is the number of failures per client IP before it is locked
+	IPMaxFailures int
+	// BackoffBase is the delay after the first failure, doubled with every further one
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// Lockout is the first lockout duration, doubled with every further lockout
+	Lockout time.Duration
+	// Window is how long a failure is remembered
+	Window time.Duration
+}
+
+// LoginGuard tracks failed credential checks per username and per client IP.
+// Failures impose an exponential backoff and, past a threshold, a temporary
+// lockout. Usernames are tracked whether or not the account exists so the
+// responses don't reveal it. State is process local.
+type LoginGuard struct {
+	mu    sync.Mutex
+	opts  LoginGuardOptions
+	users map[string]*loginAttempts
+	ips   map[string]*loginAttempts
+	now   func() time.Time
+}
+
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+	lockouts    int
+}
+
+func NewLoginGuard(opts LoginGuardOptions) *LoginGuard {
+	return &LoginGuard{
+		opts:  opts,
+		users: map[string]*loginAttempts{},
+		ips:   map[string]*loginAttempts{},
+		now:   time.Now,
+	}
+}
+
+// Check reports how long the caller has to wait before username may be tried
+// again from ip. Zero means the attempt may go ahead.
+func (g *LoginGuard) Check(username, ip string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	wait := g.wait(g.users[normalizeUsername(username)], now)
+	if ipWait := g.wait(g.ips[ip], now); ipWait > wait {
+		wait = ipWait
+	}
+
+	return wait
+}
+
+// Failure records a failed attempt and reports whether it locked the username
+func (g *LoginGuard) Failure(username, ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.sweep(now)
+
+	locked := g.fail(g.users, normalizeUsername(username), g.opts.MaxFailures, now)
+	if ip != "" {
+		g.fail(g.ips, ip, g.opts.IPMaxFailures, now)
+	}
+
+	return locked
+}
+
+// Success forgets the failures of username. Failures of the IP are kept, a
+// credential stuffing run eventually hits valid credentials too.
+func (g *LoginGuard) Success(username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.users, normalizeUsername(username))
+}
+
+// Unlock lifts the lockout and backoff of a username or an IP and reports
+// whether there was anything to lift
+func (g *LoginGuard) Unlock(username, ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	_, userFound := g.users[normalizeUsername(username)]
+	_, ipFound := g.ips[ip]
+	delete(g.users, normalizeUsername(username))
+	delete(g.ips, ip)
+
+	return userFound || ipFound
+}
+
+func (g *LoginGuard) wait(a *loginAttempts, now time.Time) time.Duration {
+	if a == nil {
+		return 0
+	}
+
+	if now.Before(a.lockedUntil) {
+		return a.lockedUntil.Sub(now)
+	}
+
+	if a.failures == 0 || now.Sub(a.lastFailure) >= g.opts.Window {
+		return 0
+	}
+
+	if next := a.lastFailure.Add(g.backoff(a.failures)); now.Before(next) {
+		return next.Sub(now)
+	}
+
+	return 0
+}
+
+func (g *LoginGuard) fail(set map[string]*loginAttempts, key string, max int, now time.Time) bool {
+	a, ok := set[key]
+	if !ok {
+		a = &loginAttempts{}
+		set[key] = a
+	}
+
+	if now.Sub(a.lastFailure) >= g.opts.Window {
+		a.failures = 0
+	}
+	a.failures++
+	a.lastFailure = now
+
+	if max <= 0 || a.failures < max {
+		return false
+	}
+
+	lockout := g.opts.Lockout << a.lockouts
+	if lockout <= 0 || lockout > maxLockout {
+		lockout = maxLockout
+	}
+	a.lockedUntil = now.Add(lockout)
+	a.lockouts++
+	a.failures = 0
+
+	return true
+}
+
+func (g *LoginGuard) backoff(failures int) time.Duration {
+	if failures > 30 {
+		return g.opts.BackoffMax
+	}
+
+	delay := g.opts.BackoffBase << (failures - 1)
+	if delay <= 0 || delay > g.opts.BackoffMax {
+		return g.opts.BackoffMax
+	}
+
+	return delay
+}
+
+func (g *LoginGuard) sweep(now time.Time) {
+	for _, set := range []map[string]*loginAttempts{g.users, g.ips} {
+		for key, a := range set {
+			// a lockout is remembered for a window after it ends so repeat
+			// offenders get a longer one
+			if now.Sub(a.lastFailure) >= g.opts.Window && now.Sub(a.lockedUntil) >= g.opts.Window {
+				delete(set, key)
+			}
+		}
+	}
+}
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// clock is a time source the test moves by hand
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestGuard() (*LoginGuard, *clock) {
+	c := &clock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	g := NewLoginGuard(LoginGuardOptions{
+		MaxFailures:   3,
+		IPMaxFailures: 10,
+		BackoffBase:   time.Second,
+		BackoffMax:    4 * time.Second,
+		Lockout:       time.Minute,
+		Window:        15 * time.Minute,
+	})
+	g.now = c.now
+	return g, c
+}
+
+func TestLoginGuardBackoff(t *testing.T) {
+	g, c := newTestGuard()
+
+	if wait := g.Check("alice", "198.51.100.1"); wait != 0 {
+		t.Fatalf("wait before any failure = %s", wait)
+	}
+
+	// the delay doubles with every failure up to BackoffMax, the lockout after
+	// MaxFailures is tested on its own
+	g.opts.MaxFailures = 0
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		g.Failure("alice", "198.51.100.1")
+		if wait := g.Check("alice", "198.51.100.2"); wait != want {
+			t.Fatalf("wait after %d failures = %s, want %s", i+1, wait, want)
+		}
+	}
+
+	c.advance(3 * time.Second)
+	if wait := g.Check("alice", "198.51.100.2"); wait != time.Second {
+		t.Fatalf("wait = %s, want the rest of the backoff", wait)
+	}
+	c.advance(time.Second)
+	if wait := g.Check("alice", "198.51.100.2"); wait != 0 {
+		t.Fatalf("wait after the backoff = %s", wait)
+	}
+
+	// usernames are compared the way people type them
+	g.Failure(" Alice ", "198.51.100.1")
+	if wait := g.Check("alice", "198.51.100.2"); wait == 0 {
+		t.Fatal("a failure of the same username in other case didn't count")
+	}
+
+	// failures are forgotten after the window, so the next one starts over
+	c.advance(15 * time.Minute)
+	g.Failure("alice", "198.51.100.1")
+	if wait := g.Check("alice", "198.51.100.2"); wait != time.Second {
+		t.Fatalf("wait after the window = %s, want %s", wait, time.Second)
+	}
+}
+
+func TestLoginGuardLockout(t *testing.T) {
+	g, c := newTestGuard()
+
+	for i := 1; i < 3; i++ {
+		if g.Failure("alice", "") {
+			t.Fatalf("locked after %d failures", i)
+		}
+	}
+	if !g.Failure("alice", "") {
+		t.Fatal("not locked after MaxFailures")
+	}
+	if wait := g.Check("alice", ""); wait != time.Minute {
+		t.Fatalf("wait = %s, want the lockout", wait)
+	}
+
+	c.advance(time.Minute)
+	if wait := g.Check("alice", ""); wait != 0 {
+		t.Fatalf("wait after the lockout = %s", wait)
+	}
+
+	// a repeat offender within the window is locked twice as long
+	for i := 0; i < 3; i++ {
+		g.Failure("alice", "")
+	}
+	if wait := g.Check("alice", ""); wait != 2*time.Minute {
+		t.Fatalf("second lockout = %s, want %s", wait, 2*time.Minute)
+	}
+
+	// other usernames aren't affected
+	if wait := g.Check("bob", ""); wait != 0 {
+		t.Fatalf("wait of another username = %s", wait)
+	}
+}
+
+func TestLoginGuardLockoutCap(t *testing.T) {
+	g, _ := newTestGuard()
+	g.opts.Lockout = 10 * time.Hour
+
+	for i := 0; i < 3*3; i++ {
+		g.Failure("alice", "")
+	}
+	if wait := g.Check("alice", ""); wait != maxLockout {
+		t.Fatalf("third lockout = %s, want the cap %s", wait, maxLockout)
+	}
+}
+
+func TestLoginGuardPerIP(t *testing.T) {
+	g, _ := newTestGuard()
+
+	// a credential stuffing run tries a different username every time
+	for i := 0; i < 10; i++ {
+		g.Failure(string(rune('a'+i))+"@example.com", "198.51.100.1")
+	}
+
+	if wait := g.Check("zoe@example.com", "198.51.100.1"); wait != time.Minute {
+		t.Fatalf("wait from the IP = %s, want the lockout", wait)
+	}
+	if wait := g.Check("zoe@example.com", "198.51.100.2"); wait != 0 {
+		t.Fatalf("wait from another IP = %s", wait)
+	}
+
+	// signing in to one account doesn't clear the IP
+	g.Success("a@example.com")
+	if wait := g.Check("zoe@example.com", "198.51.100.1"); wait == 0 {
+		t.Fatal("a success cleared the failures of the IP")
+	}
+}
+
+func TestLoginGuardSuccessAndUnlock(t *testing.T) {
+	g, _ := newTestGuard()
+
+	g.Failure("alice", "198.51.100.1")
+	g.Success("Alice")
+	if wait := g.Check("alice", "198.51.100.2"); wait != 0 {
+		t.Fatalf("wait after a success = %s", wait)
+	}
+
+	for i := 0; i < 3; i++ {
+		g.Failure("alice", "198.51.100.1")
+	}
+	if !g.Unlock("alice", "") {
+		t.Fatal("Unlock() found nothing to lift")
+	}
+	if wait := g.Check("alice", "198.51.100.2"); wait != 0 {
+		t.Fatalf("wait after unlocking = %s", wait)
+	}
+	// the IP keeps its own record until it is unlocked
+	if wait := g.Check("bob", "198.51.100.1"); wait == 0 {
+		t.Fatal("unlocking the username cleared the IP")
+	}
+	if !g.Unlock("", "198.51.100.1") || g.Check("bob", "198.51.100.1") != 0 {
+		t.Fatal("the IP wasn't unlocked")
+	}
+
+	if g.Unlock("carol", "198.51.100.9") {
+		t.Fatal("Unlock() reported a lift for unknown keys")
+	}
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var ErrOAuthStateMissing = errors.New("oauth state cookie is missing or expired")
+
+// OAuthState is what the callback of a federated sign in needs to finish it.
+// It lives in an HttpOnly cookie between the start and the callback request.
+type OAuthState struct {
+	State     string `json:"s"`
+	Nonce     string `json:"n"`
+	Verifier  string `json:"v"`
+	Provider  string `json:"p"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// SetOAuthState stores state in the cookie name for ttl
+func (s *SessionCookies) SetOAuthState(c echo.Context, name string, state OAuthState, ttl time.Duration) error {
+	state.ExpiresAt = time.Now().Add(ttl).Unix()
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	c.SetCookie(s.oauthCookie(name, base64.RawURLEncoding.EncodeToString(raw), int(ttl.Seconds())))
+	return nil
+}
+
+// TakeOAuthState reads and clears the cookie name. Each state can only be used once.
+func (s *SessionCookies) TakeOAuthState(c echo.Context, name string) (*OAuthState, error) {
+	value := s.value(c, name)
+	if value == "" {
+		return nil, ErrOAuthStateMissing
+	}
+	c.SetCookie(s.oauthCookie(name, "", -1))
+
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrOAuthStateMissing
+	}
+
+	var state OAuthState
+	if err := json.Unmarshal(raw, &state); err != nil || time.Now().Unix() >= state.ExpiresAt {
+		return nil, ErrOAuthStateMissing
+	}
+
+	return &state, nil
+}
+
+func (s *SessionCookies) oauthCookie(name, value string, maxAge int) *http.Cookie {
+	cookie := s.cookie(name, value, maxAge, true)
+	if cookie.SameSite == http.SameSiteStrictMode {
+		// the callback is a cross site navigation from the hosted UI
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+
+	return cookie
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// principalKey is the echo.Context key the authenticated principal is stored under
+const principalKey = "auth.principal"
+
+type contextKey struct{}
+
+// Kinds of principals
+const (
+	// PrincipalUser is a person, signed in or acting through one of their API keys
+	PrincipalUser = "user"
+	// PrincipalClient is a machine client using the client credentials grant.
+	// It has no username, its subject is the app client ID.
+	PrincipalClient = "client"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Type               string   `json:"type"`
+	Subject            string   `json:"sub"`
+	Username           string   `json:"username"`
+	Email              string   `json:"email,omitempty"`
+	Groups             []string `json:"groups,omitempty"`
+	SubscriptionStatus string   `json:"subscriptionStatus,omitempty"`
+	TokenType          string   `json:"tokenType"`
+	// Scopes restrict what the credential may be used for, see HasScope
+	Scopes []string `json:"scopes,omitempty"`
+	// TokenID is the jti of the token, SessionID the origin_jti shared by all
+	// tokens handed out for the same sign in
+	TokenID   string                 `json:"-"`
+	SessionID string                 `json:"-"`
+	IssuedAt  time.Time              `json:"-"`
+	ExpiresAt time.Time              `json:"-"`
+	Token     string                 `json:"-"`
+	Claims    map[string]interface{} `json:"-"`
+}
+
+// IsClientCredentials reports whether claims belong to an access token issued
+// with the client credentials grant, which names no user
+func IsClientCredentials(claims map[string]interface{}) bool {
+	return stringClaim(claims, "token_use") == "access" && stringClaim(claims, "username") == ""
+}
+
+// NewPrincipal builds a principal from verified Cognito token claims
+func NewPrincipal(claims map[string]interface{}, token string) *Principal {
+	p := &Principal{
+		Type:               PrincipalUser,
+		Subject:            stringClaim(claims, "sub"),
+		Username:           stringClaim(claims, "cognito:username"),
+		Email:              stringClaim(claims, "email"),
+		Groups:             stringsClaim(claims, "cognito:groups"),
+		SubscriptionStatus: stringClaim(claims, "custom:subscription_status"),
+		TokenType:          stringClaim(claims, "token_use"),
+		TokenID:            stringClaim(claims, "jti"),
+		SessionID:          stringClaim(claims, "origin_jti"),
+		IssuedAt:           timeClaim(claims, "iat"),
+		ExpiresAt:          timeClaim(claims, "exp"),
+		Token:              token,
+		Claims:             claims,
+	}
+
+	if IsClientCredentials(claims) {
+		p.Type = PrincipalClient
+		// machine clients are always limited to the scopes they were granted
+		p.Scopes = strings.Fields(stringClaim(claims, "scope"))
+		if p.Scopes == nil {
+			p.Scopes = []string{}
+		}
+		return p
+	}
+
+	if p.Username == "" {
+		// access tokens carry the username without the cognito prefix
+		p.Username = stringClaim(claims, "username")
+	}
+
+	return p
+}
+
+// IsClient reports whether the principal is a machine client rather than a user
+func (p *Principal) IsClient() bool {
+	return p.Type == PrincipalClient
+}
+
+// HasScope reports whether the principal may act within scope. Users and API
+// keys without scopes are not restricted, machine clients only have the scopes
+// they were granted.
+func (p *Principal) HasScope(scope string) bool {
+	if len(p.Scopes) == 0 && !p.IsClient() {
+		return true
+	}
+
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// InGroup reports whether the principal is a member of group
+func (p *Principal) InGroup(group string) bool {
+	for _, g := range p.Groups {
+		if g == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetPrincipal stores p on the echo context and on the request's context.Context
+func SetPrincipal(c echo.Context, p *Principal) {
+	c.Set(principalKey, p)
+	c.SetRequest(c.Request().WithContext(WithPrincipal(c.Request().Context(), p)))
+}
+
+// PrincipalFrom returns the principal of an authenticated request
+func PrincipalFrom(c echo.Context) (*Principal, bool) {
+	p, ok := c.Get(principalKey).(*Principal)
+	if ok && p != nil {
+		return p, true
+	}
+
+	return FromContext(c.Request().Context())
+}
+
+// MustPrincipal returns the principal of a request that went through AuthValidator
+// and panics otherwise, which points at a route registered without the middleware.
+func MustPrincipal(c echo.Context) *Principal {
+	p, ok := PrincipalFrom(c)
+	if !ok {
+		panic("auth: no principal on context, is the route behind AuthValidator?")
+	}
+
+	return p
+}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal stored by WithPrincipal
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+func stringClaim(claims map[string]interface{}, key string) string {
+	s, _ := claims[key].(string)
+	return s
+}
+
+func timeClaim(claims map[string]interface{}, key string) time.Time {
+	switch v := claims[key].(type) {
+	case float64:
+		return time.Unix(int64(v), 0)
+	case int64:
+		return time.Unix(v, 0)
+	case json.Number:
+		n, _ := v.Int64()
+		return time.Unix(n, 0)
+	}
+
+	return time.Time{}
+}
+
+func stringsClaim(claims map[string]interface{}, key string) []string {
+	switch v := claims[key].(type) {
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	case []string:
+		return v
+	case string:
+		return []string{v}
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestNewPrincipal(t *testing.T) {
+	iat := time.Unix(1700000000, 0)
+	exp := iat.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   Principal
+		client bool
+	}{
+		{
+			name: "AccessToken",
+			claims: map[string]interface{}{
+				"sub":            "sub-1",
+				"username":       "alice",
+				"token_use":      "access",
+				"cognito:groups": []interface{}{"admin", 42, "staff"},
+				"jti":            "jti-1",
+				"origin_jti":     "origin-1",
+				"iat":            float64(iat.Unix()),
+				"exp":            float64(exp.Unix()),
+			},
+			want: Principal{
+				Type: PrincipalUser, Subject: "sub-1", Username: "alice", Groups: []string{"admin", "staff"},
+				TokenType: "access", TokenID: "jti-1", SessionID: "origin-1", IssuedAt: iat, ExpiresAt: exp,
+			},
+		},
+		{
+			name: "IDToken",
+			claims: map[string]interface{}{
+				"sub":                        "sub-1",
+				"cognito:username":           "alice",
+				"email":                      "alice@example.com",
+				"custom:subscription_status": "active",
+				"token_use":                  "id",
+				"cognito:groups":             "admin",
+				"iat":                        json.Number("1700000000"),
+				"exp":                        exp.Unix(),
+			},
+			want: Principal{
+				Type: PrincipalUser, Subject: "sub-1", Username: "alice", Email: "alice@example.com", Groups: []string{"admin"},
+				SubscriptionStatus: "active", TokenType: "id", IssuedAt: iat, ExpiresAt: exp,
+			},
+		},
+		{
+			name: "ClientCredentials",
+			claims: map[string]interface{}{
+				"sub":       "client-1",
+				"token_use": "access",
+				"scope":     "api/read api/write",
+				"jti":       "jti-2",
+				"iat":       float64(iat.Unix()),
+			},
+			want: Principal{
+				Type: PrincipalClient, Subject: "client-1", TokenType: "access", Scopes: []string{"api/read", "api/write"},
+				TokenID: "jti-2", IssuedAt: iat,
+			},
+			client: true,
+		},
+		{
+			name:   "ClientCredentialsWithoutScopes",
+			claims: map[string]interface{}{"sub": "client-1", "token_use": "access"},
+			want:   Principal{Type: PrincipalClient, Subject: "client-1", TokenType: "access", Scopes: []string{}},
+			client: true,
+		},
+		{
+			// an ID token never names the user in the username claim, it isn't a client
+			name:   "IDTokenWithoutUsername",
+			claims: map[string]interface{}{"sub": "sub-1", "token_use": "id"},
+			want:   Principal{Type: PrincipalUser, Subject: "sub-1", TokenType: "id"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsClientCredentials(tt.claims); got != tt.client {
+				t.Fatalf("IsClientCredentials = %v, want %v", got, tt.client)
+			}
+
+			p := NewPrincipal(tt.claims, "token")
+			if p.Token != "token" || !reflect.DeepEqual(p.Claims, tt.claims) {
+				t.Fatalf("token %q, claims %v", p.Token, p.Claims)
+			}
+			if p.IsClient() != tt.client {
+				t.Fatalf("IsClient = %v, want %v", p.IsClient(), tt.client)
+			}
+
+			p.Token, p.Claims = "", nil
+			if !reflect.DeepEqual(*p, tt.want) {
+				t.Fatalf("principal = %+v\nwant %+v", *p, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrincipalScopesAndGroups(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal Principal
+		scope     string
+		hasScope  bool
+	}{
+		{name: "UserWithoutScopes", principal: Principal{Type: PrincipalUser}, scope: "api/write", hasScope: true},
+		{name: "ScopedAPIKey", principal: Principal{Type: PrincipalUser, Scopes: []string{"api/read"}}, scope: "api/write"},
+		{name: "ClientWithScope", principal: Principal{Type: PrincipalClient, Scopes: []string{"api/read"}}, scope: "api/read", hasScope: true},
+		{name: "ClientWithoutScopes", principal: Principal{Type: PrincipalClient, Scopes: []string{}}, scope: "api/read"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.principal.HasScope(tt.scope); got != tt.hasScope {
+				t.Fatalf("HasScope(%q) = %v, want %v", tt.scope, got, tt.hasScope)
+			}
+		})
+	}
+
+	p := Principal{Groups: []string{"admin"}}
+	if !p.InGroup("admin") || p.InGroup("adm") {
+		t.Fatalf("InGroup for %v", p.Groups)
+	}
+}
+
+func TestPrincipalFrom(t *testing.T) {
+	newContext := func() echo.Context {
+		return echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	}
+	p := &Principal{Type: PrincipalUser, Subject: "sub-1"}
+
+	c := newContext()
+	if _, ok := PrincipalFrom(c); ok {
+		t.Fatal("found a principal on a fresh context")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("MustPrincipal didn't panic")
+			}
+		}()
+		MustPrincipal(c)
+	}()
+
+	SetPrincipal(c, p)
+	if got, ok := PrincipalFrom(c); !ok || got != p {
+		t.Fatalf("PrincipalFrom = %v, %v", got, ok)
+	}
+	if got := MustPrincipal(c); got != p {
+		t.Fatalf("MustPrincipal = %v", got)
+	}
+	if got, ok := FromContext(c.Request().Context()); !ok || got != p {
+		t.Fatalf("FromContext = %v, %v", got, ok)
+	}
+
+	// a request context set up by other middleware is enough
+	c = newContext()
+	c.SetRequest(c.Request().WithContext(WithPrincipal(c.Request().Context(), p)))
+	if got, ok := PrincipalFrom(c); !ok || got != p {
+		t.Fatalf("PrincipalFrom request context = %v, %v", got, ok)
+	}
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/pkg/config"
+	"backend/pkg/identity"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Session modes accepted by the SESSION_MODE setting
+const (
+	SessionModeToken  = "token"
+	SessionModeCookie = "cookie"
+)
+
+// SessionCookies writes and reads the cookies that carry tokens for browser clients
+type SessionCookies struct {
+	cfg      config.Session
+	sameSite http.SameSite
+}
+
+func NewSessionCookies(cfg config.Session) *SessionCookies {
+	sameSite := http.SameSiteLaxMode
+	switch strings.ToLower(cfg.COOKIE_SAMESITE) {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+		// browsers drop SameSite=None cookies that are not Secure
+		cfg.COOKIE_SECURE = true
+	}
+
+	return &SessionCookies{cfg: cfg, sameSite: sameSite}
+}
+
+// CookieMode reports whether tokens live in cookies instead of the response body
+func (s *SessionCookies) CookieMode() bool {
+	return s.cfg.MODE == SessionModeCookie
+}
+
+// SameSite is the SameSite mode applied to every session cookie
+func (s *SessionCookies) SameSite() http.SameSite {
+	return s.sameSite
+}
+
+// SetTokens stores the tokens of a sign in or refresh. In token mode only the ID
+// token cookie is written, as it always has been; cookie mode also stores the
+// access and refresh tokens and issues a CSRF token.
+func (s *SessionCookies) SetTokens(c echo.Context, tokens *identity.Tokens) error {
+	maxAge := int(tokens.ExpiresIn)
+	if maxAge <= 0 {
+		maxAge = int(time.Hour.Seconds())
+	}
+
+	c.SetCookie(s.cookie(s.cfg.ID_COOKIE, tokens.IDToken, maxAge, true))
+	if !s.CookieMode() {
+		return nil
+	}
+
+	c.SetCookie(s.cookie(s.cfg.ACCESS_COOKIE, tokens.AccessToken, maxAge, true))
+	if tokens.RefreshToken != "" {
+		c.SetCookie(s.cookie(s.cfg.REFRESH_COOKIE, tokens.RefreshToken, int(s.cfg.REFRESH_MAX_AGE.Seconds()), true))
+	}
+
+	csrf, err := randomString(32)
+	if err != nil {
+		return err
+	}
+	// readable by scripts so they can echo it back in the CSRF header
+	c.SetCookie(s.cookie(s.cfg.CSRF_COOKIE, csrf, int(s.cfg.REFRESH_MAX_AGE.Seconds()), false))
+	c.Response().Header().Set(s.cfg.CSRF_HEADER, csrf)
+
+	return nil
+}
+
+// Clear expires every session cookie
+func (s *SessionCookies) Clear(c echo.Context) {
+	for _, name := range []string{s.cfg.ID_COOKIE, s.cfg.ACCESS_COOKIE, s.cfg.REFRESH_COOKIE, s.cfg.CSRF_COOKIE} {
+		c.SetCookie(s.cookie(name, "", -1, name != s.cfg.CSRF_COOKIE))
+	}
+}
+
+// Credential returns the token cookie to authenticate with in cookie mode. The
+// access token is preferred when the route accepts it.
+func (s *SessionCookies) Credential(c echo.Context, acceptAccess bool) string {
+	if !s.CookieMode() {
+		return ""
+	}
+
+	if acceptAccess {
+		if token := s.value(c, s.cfg.ACCESS_COOKIE); token != "" {
+			return token
+		}
+	}
+
+	return s.value(c, s.cfg.ID_COOKIE)
+}
+
+// RefreshToken returns the refresh token cookie in cookie mode
+func (s *SessionCookies) RefreshToken(c echo.Context) string {
+	if !s.CookieMode() {
+		return ""
+	}
+
+	return s.value(c, s.cfg.REFRESH_COOKIE)
+}
+
+// HasSession reports whether the request carries session cookies that
+// authenticate it, which is when CSRF protection applies
+func (s *SessionCookies) HasSession(c echo.Context) bool {
+	if !s.CookieMode() || c.Request().Header.Get(echo.HeaderAuthorization) != "" {
+		return false
+	}
+
+	return s.value(c, s.cfg.ACCESS_COOKIE) != "" || s.value(c, s.cfg.ID_COOKIE) != "" || s.value(c, s.cfg.REFRESH_COOKIE) != ""
+}
+
+func (s *SessionCookies) value(c echo.Context, name string) string {
+	cookie, err := c.Cookie(name)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+func (s *SessionCookies) cookie(name, value string, maxAge int, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     s.cfg.COOKIE_PATH,
+		Domain:   s.cfg.COOKIE_DOMAIN,
+		MaxAge:   maxAge,
+		Secure:   s.cfg.COOKIE_SECURE,
+		HttpOnly: httpOnly,
+		SameSite: s.sameSite,
+	}
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Throttle limits how often an action may be taken per key: at most once per
+// interval and at most limit times per window. State is process local.
+type Throttle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	limit    int
+	window   time.Duration
+	keys     map[string]*throttleState
+	now      func() time.Time
+}
+
+type throttleState struct {
+	last        time.Time
+	windowStart time.Time
+	count       int
+}
+
+func NewThrottle(interval time.Duration, limit int, window time.Duration) *Throttle {
+	return &Throttle{
+		interval: interval,
+		limit:    limit,
+		window:   window,
+		keys:     map[string]*throttleState{},
+		now:      time.Now,
+	}
+}
+
+// Allow records an attempt for key. When the attempt is refused it returns
+// false and how long the caller has to wait.
+func (t *Throttle) Allow(key string) (bool, time.Duration) {
+	key = strings.ToLower(key)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.sweep(now)
+
+	st, ok := t.keys[key]
+	if !ok {
+		st = &throttleState{windowStart: now}
+		t.keys[key] = st
+	}
+
+	if now.Sub(st.windowStart) >= t.window {
+		st.windowStart = now
+		st.count = 0
+	}
+
+	if !st.last.IsZero() && now.Sub(st.last) < t.interval {
+		return false, t.interval - now.Sub(st.last)
+	}
+
+	if t.limit > 0 && st.count >= t.limit {
+		return false, st.windowStart.Add(t.window).Sub(now)
+	}
+
+	st.count++
+	st.last = now
+	return true, 0
+}
+
+func (t *Throttle) sweep(now time.Time) {
+	for key, st := range t.keys {
+		if now.Sub(st.windowStart) >= t.window && now.Sub(st.last) >= t.interval {
+			delete(t.keys, key)
+		}
+	}
+}
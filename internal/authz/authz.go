@@ -0,0 +1,136 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"backend/internal/auth"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var tracer = otel.GetTracerProvider().Tracer("authz")
+
+// Decision is the outcome of an authorization check
+type Decision struct {
+	Allowed bool
+	// Policy is the name of the policy that decided, empty for the default deny
+	Policy string
+	Reason string
+}
+
+// String is the decision log line recorded on the span of every check
+func (d Decision) String() string {
+	effect := EffectDeny
+	if d.Allowed {
+		effect = EffectAllow
+	}
+
+	if d.Policy == "" {
+		return fmt.Sprintf("%s: %s", effect, d.Reason)
+	}
+
+	return fmt.Sprintf("%s by %s: %s", effect, d.Policy, d.Reason)
+}
+
+// DeniedError is returned by Authorize when the principal may not perform the action
+type DeniedError struct {
+	Action   string
+	Resource Resource
+	Decision Decision
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("authz: %s on %s denied: %s", e.Action, e.Resource, e.Decision.Reason)
+}
+
+// Engine evaluates policies declared in code together with those loaded from
+// Postgres. Anything not explicitly allowed is denied.
+type Engine struct {
+	mu     sync.RWMutex
+	static []Policy
+	loaded []Policy
+}
+
+func NewEngine(policies ...Policy) *Engine {
+	return &Engine{static: policies}
+}
+
+// Add declares more policies in code
+func (e *Engine) Add(policies ...Policy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.static = append(e.static, policies...)
+}
+
+// Replace swaps the policies loaded from storage
+func (e *Engine) Replace(policies []Policy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.loaded = policies
+}
+
+// Decide evaluates every policy for the principal, action and resource
+func (e *Engine) Decide(ctx context.Context, p *auth.Principal, action string, r Resource) Decision {
+	if p == nil {
+		return Decision{Reason: "no authenticated principal"}
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var allow *Policy
+	for _, set := range [][]Policy{e.static, e.loaded} {
+		for i := range set {
+			policy := &set[i]
+			if !policy.matches(ctx, p, action, r) {
+				continue
+			}
+
+			if policy.Effect == EffectDeny {
+				return Decision{Policy: policy.Name, Reason: "explicitly denied"}
+			}
+			if allow == nil {
+				allow = policy
+			}
+		}
+	}
+
+	if allow == nil {
+		return Decision{Reason: "no policy allows the action"}
+	}
+
+	return Decision{Allowed: true, Policy: allow.Name, Reason: "allowed"}
+}
+
+// Authorize checks whether the principal may perform action on the resource and
+// returns a *DeniedError if not. Every check is recorded as a span.
+func (e *Engine) Authorize(ctx context.Context, p *auth.Principal, action string, r Resource) error {
+	ctx, span := tracer.Start(ctx, "authz.Authorize")
+	defer span.End()
+
+	decision := e.Decide(ctx, p, action, r)
+
+	subject := ""
+	if p != nil {
+		subject = p.Subject
+	}
+	span.SetAttributes(
+		attribute.String("authz.subject", subject),
+		attribute.String("authz.action", action),
+		attribute.String("authz.resource", r.String()),
+		attribute.Bool("authz.allowed", decision.Allowed),
+		attribute.String("authz.policy", decision.Policy),
+		attribute.String("authz.decision", decision.String()),
+	)
+
+	if !decision.Allowed {
+		return &DeniedError{Action: action, Resource: r, Decision: decision}
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"backend/internal/auth"
+	"backend/internal/testdb"
+	"backend/internal/types"
+)
+
+const (
+	alice = "00000000-0000-0000-0000-000000000001"
+	bob   = "00000000-0000-0000-0000-000000000002"
+)
+
+func TestDecide(t *testing.T) {
+	admin := &auth.Principal{Subject: alice, Groups: []string{"admin"}}
+	user := &auth.Principal{Subject: bob}
+
+	engine := NewEngine(DefaultPolicies("admin")...)
+	engine.Replace([]Policy{{
+		Name:         "no-billing-deletes",
+		Effect:       EffectDeny,
+		Actions:      []string{"billing:delete"},
+		ResourceType: "billing",
+	}})
+
+	tests := []struct {
+		name       string
+		principal  *auth.Principal
+		action     string
+		resource   Resource
+		want       bool
+		wantPolicy string
+	}{
+		{name: "AdminAllowed", principal: admin, action: "file:delete", resource: Resource{Type: "file"}, want: true, wantPolicy: "admin"},
+		{name: "DenyWinsOverAdmin", principal: admin, action: "billing:delete", resource: Resource{Type: "billing"}, wantPolicy: "no-billing-deletes"},
+		{name: "DenyWinsOverOwner", principal: user, action: "billing:delete", resource: Resource{Type: "billing", OwnerID: bob}, wantPolicy: "no-billing-deletes"},
+		{name: "OwnerAllowed", principal: user, action: "file:read", resource: Resource{Type: "file", OwnerID: bob}, want: true, wantPolicy: "owner"},
+		{name: "OtherOwner", principal: user, action: "file:read", resource: Resource{Type: "file", OwnerID: alice}},
+		{name: "OwnerCannotCreate", principal: user, action: "file:create", resource: Resource{Type: "file", OwnerID: bob}},
+		{name: "NoPrincipal", action: "file:read", resource: Resource{Type: "file"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := engine.Decide(context.Background(), tt.principal, tt.action, tt.resource)
+			if got.Allowed != tt.want || got.Policy != tt.wantPolicy {
+				t.Fatalf("decision = %s, want allowed %v by %q", got, tt.want, tt.wantPolicy)
+			}
+		})
+	}
+}
+
+func TestAuthorizeReturnsDeniedError(t *testing.T) {
+	engine := NewEngine()
+
+	err := engine.Authorize(context.Background(), &auth.Principal{Subject: alice}, "file:read", Resource{Type: "file", ID: "1"})
+
+	var denied *DeniedError
+	if !errors.As(err, &denied) {
+		t.Fatalf("err = %v, want a *DeniedError", err)
+	}
+	if denied.Action != "file:read" || denied.Resource.String() != "file/1" {
+		t.Fatalf("denied %s on %s", denied.Action, denied.Resource)
+	}
+}
+
+func TestMatchAction(t *testing.T) {
+	tests := []struct {
+		pattern string
+		action  string
+		want    bool
+	}{
+		{pattern: "*", action: "invitation:revoke", want: true},
+		{pattern: "invitation:revoke", action: "invitation:revoke", want: true},
+		{pattern: "invitation:revoke", action: "invitation:create"},
+		{pattern: "invitation:*", action: "invitation:create", want: true},
+		{pattern: "invitation:*", action: "user:create"},
+		{pattern: "invitation:*", action: "invitations:create"},
+		{pattern: "*:read", action: "file:read", want: true},
+		{pattern: "*:read", action: "file:readall"},
+		{pattern: "*:read", action: "file:update"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.action, func(t *testing.T) {
+			if got := matchAction([]string{tt.pattern}, tt.action); got != tt.want {
+				t.Fatalf("matchAction(%q, %q) = %v, want %v", tt.pattern, tt.action, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditions(t *testing.T) {
+	member := &auth.Principal{Subject: alice, Claims: map[string]interface{}{"custom:org_id": "acme"}}
+	outsider := &auth.Principal{Subject: bob}
+
+	tests := []struct {
+		name      string
+		condition Condition
+		principal *auth.Principal
+		resource  Resource
+		want      bool
+	}{
+		{name: "Owner", condition: Owner(), principal: member, resource: Resource{OwnerID: alice}, want: true},
+		{name: "NotOwner", condition: Owner(), principal: outsider, resource: Resource{OwnerID: alice}},
+		{name: "NoOwner", condition: Owner(), principal: &auth.Principal{}, resource: Resource{}},
+		{name: "SameAttribute", condition: SameAttribute("org_id"), principal: member, resource: Resource{Attributes: map[string]string{"org_id": "acme"}}, want: true},
+		{name: "OtherAttribute", condition: SameAttribute("org_id"), principal: member, resource: Resource{Attributes: map[string]string{"org_id": "globex"}}},
+		{name: "MissingClaim", condition: SameAttribute("org_id"), principal: outsider, resource: Resource{Attributes: map[string]string{"org_id": ""}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.condition(context.Background(), tt.principal, tt.resource); got != tt.want {
+				t.Fatalf("condition = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	for _, s := range []string{"owner", " owner ", "same_attribute:org_id"} {
+		if _, err := ParseCondition(s); err != nil {
+			t.Errorf("ParseCondition(%q): %v", s, err)
+		}
+	}
+
+	for _, s := range []string{"", "owners", "same_attribute", "same_attribute:", "role:admin"} {
+		if _, err := ParseCondition(s); err == nil {
+			t.Errorf("ParseCondition(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	record := func(name, effect, conditions string, disabled bool) *PolicyRecord {
+		base, err := types.NewBase()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &PolicyRecord{Base: *base, Name: name, Effect: effect, Actions: "file:read", ResourceType: "file", Conditions: conditions, Disabled: disabled}
+	}
+	user := &auth.Principal{Subject: bob}
+
+	tests := []struct {
+		name    string
+		records []*PolicyRecord
+		wantErr bool
+		want    bool
+	}{
+		{name: "Valid", records: []*PolicyRecord{record("readers", EffectAllow, "", false)}, want: true},
+		{name: "Disabled", records: []*PolicyRecord{record("readers", EffectAllow, "", true)}},
+		{name: "InvalidEffect", records: []*PolicyRecord{record("readers", "permit", "", false)}, wantErr: true},
+		{name: "InvalidCondition", records: []*PolicyRecord{record("readers", EffectAllow, "owner, team", false)}, wantErr: true},
+		{name: "OneInvalid", records: []*PolicyRecord{record("readers", EffectAllow, "", false), record("writers", EffectAllow, "same_attribute", false)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testdb.Open(t, &PolicyRecord{})
+			for _, r := range tt.records {
+				if err := db.Create(r).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			engine := NewEngine()
+			// a failed load keeps the policies loaded before
+			engine.Replace([]Policy{{Name: "previous", Effect: EffectDeny, Actions: []string{Wildcard}, ResourceType: Wildcard}})
+
+			err := engine.Load(context.Background(), db)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+
+			got := engine.Decide(context.Background(), user, "file:read", Resource{Type: "file"})
+			if tt.wantErr {
+				if got.Policy != "previous" {
+					t.Fatalf("decision = %s, want the previous policies to stay", got)
+				}
+				return
+			}
+			if got.Allowed != tt.want {
+				t.Fatalf("decision = %s, want allowed %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"backend/internal/auth"
+)
+
+// Effects a policy can have. A matching deny always wins over an allow.
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Wildcard matches any action or resource type
+const Wildcard = "*"
+
+// Resource is the object an action is performed on
+type Resource struct {
+	Type string
+	ID   string
+	// OwnerID is the subject of the user owning the resource, if any
+	OwnerID    string
+	Attributes map[string]string
+}
+
+func (r Resource) String() string {
+	if r.ID == "" {
+		return r.Type
+	}
+
+	return r.Type + "/" + r.ID
+}
+
+// Condition narrows a policy down to some principals or resources
+type Condition func(ctx context.Context, p *auth.Principal, r Resource) bool
+
+// Policy grants or denies actions on a resource type. A policy matches when the
+// action and resource type match, the principal has one of the roles (or Roles
+// is empty) and every condition holds.
+type Policy struct {
+	Name         string
+	Effect       string
+	Actions      []string
+	ResourceType string
+	Roles        []string
+	Conditions   []Condition
+}
+
+func (p Policy) matches(ctx context.Context, principal *auth.Principal, action string, r Resource) bool {
+	if p.ResourceType != Wildcard && p.ResourceType != r.Type {
+		return false
+	}
+
+	if !matchAction(p.Actions, action) {
+		return false
+	}
+
+	if len(p.Roles) > 0 {
+		member := false
+		for _, role := range p.Roles {
+			if principal.InGroup(role) {
+				member = true
+				break
+			}
+		}
+		if !member {
+			return false
+		}
+	}
+
+	for _, cond := range p.Conditions {
+		if !cond(ctx, principal, r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchAction supports exact names, "*" and "<type>:<verb>" patterns with a
+// wildcard on either side such as "file:*" or "*:read"
+func matchAction(actions []string, action string) bool {
+	for _, a := range actions {
+		switch {
+		case a == Wildcard, a == action:
+			return true
+		case strings.HasSuffix(a, ":*") && strings.HasPrefix(action, strings.TrimSuffix(a, "*")):
+			return true
+		case strings.HasPrefix(a, "*:") && strings.HasSuffix(action, strings.TrimPrefix(a, "*")):
+			return true
+		}
+	}
+
+	return false
+}
+
+// Owner holds when the principal owns the resource
+func Owner() Condition {
+	return func(_ context.Context, p *auth.Principal, r Resource) bool {
+		return r.OwnerID != "" && r.OwnerID == p.Subject
+	}
+}
+
+// SameAttribute holds when the resource attribute equals the principal's
+// "custom:<name>" claim, e.g. SameAttribute("org_id") for members of the same
+// organisation. Custom attributes are only present in ID tokens.
+func SameAttribute(name string) Condition {
+	return func(_ context.Context, p *auth.Principal, r Resource) bool {
+		value, _ := p.Claims["custom:"+name].(string)
+		return value != "" && value == r.Attributes[name]
+	}
+}
+
+// ParseCondition turns the textual form of a condition stored in Postgres into
+// a Condition: "owner" or "same_attribute:<name>".
+func ParseCondition(s string) (Condition, error) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(s), ":")
+	switch name {
+	case "owner":
+		return Owner(), nil
+	case "same_attribute":
+		if arg == "" {
+			return nil, fmt.Errorf("authz: condition %q needs an attribute name", s)
+		}
+		return SameAttribute(arg), nil
+	default:
+		return nil, fmt.Errorf("authz: unknown condition %q", s)
+	}
+}
+
+// DefaultPolicies lets admins do anything and owners read, update and delete
+// their own resources
+func DefaultPolicies(adminGroup string) []Policy {
+	return []Policy{
+		{
+			Name:         "admin",
+			Effect:       EffectAllow,
+			Actions:      []string{Wildcard},
+			ResourceType: Wildcard,
+			Roles:        []string{adminGroup},
+		},
+		{
+			Name:         "owner",
+			Effect:       EffectAllow,
+			Actions:      []string{"*:read", "*:update", "*:delete"},
+			ResourceType: Wildcard,
+			Conditions:   []Condition{Owner()},
+		},
+	}
+}
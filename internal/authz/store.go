@@ -0,0 +1,89 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"backend/internal/types"
+
+	"gorm.io/gorm"
+)
+
+// PolicyRecord is a policy stored in Postgres. Lists are comma separated and
+// conditions use the textual form accepted by ParseCondition.
+type PolicyRecord struct {
+	types.Base
+	Name         string `gorm:"uniqueIndex"`
+	Effect       string
+	Actions      string
+	ResourceType string
+	Roles        string
+	Conditions   string
+	Disabled     bool
+}
+
+func (PolicyRecord) TableName() string {
+	return "authz_policies"
+}
+
+// Policy converts the record into a Policy
+func (r PolicyRecord) Policy() (Policy, error) {
+	if r.Effect != EffectAllow && r.Effect != EffectDeny {
+		return Policy{}, fmt.Errorf("authz: policy %q has invalid effect %q", r.Name, r.Effect)
+	}
+
+	policy := Policy{
+		Name:         r.Name,
+		Effect:       r.Effect,
+		Actions:      splitList(r.Actions),
+		ResourceType: r.ResourceType,
+		Roles:        splitList(r.Roles),
+	}
+	if policy.ResourceType == "" {
+		policy.ResourceType = Wildcard
+	}
+
+	for _, c := range splitList(r.Conditions) {
+		cond, err := ParseCondition(c)
+		if err != nil {
+			return Policy{}, err
+		}
+		policy.Conditions = append(policy.Conditions, cond)
+	}
+
+	return policy, nil
+}
+
+// Load replaces the stored policies of the engine with the enabled ones in db.
+// A policy that can't be parsed fails the whole load so a typo can't silently
+// widen or narrow access.
+func (e *Engine) Load(ctx context.Context, db *gorm.DB) error {
+	var records []PolicyRecord
+	if err := db.WithContext(ctx).Where("disabled = ?", false).Order("name").Find(&records).Error; err != nil {
+		return err
+	}
+
+	policies := make([]Policy, 0, len(records))
+	for _, record := range records {
+		policy, err := record.Policy()
+		if err != nil {
+			return err
+		}
+		policies = append(policies, policy)
+	}
+
+	e.Replace(policies)
+	return nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+
+	return out
+}
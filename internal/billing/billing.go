@@ -0,0 +1,396 @@
+// Package billing keeps the plans users can be on and the subscriptions a
+// Stripe compatible payment provider reports through its webhooks. The plan a
+// user is entitled to is mirrored into the custom:subscription_status
+// attribute of the identity provider, so it ends up in their tokens.
+package billing
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"backend/internal/types"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Statuses of a subscription at the provider
+const (
+	StatusIncomplete        = "incomplete"
+	StatusIncompleteExpired = "incomplete_expired"
+	StatusTrialing          = "trialing"
+	StatusActive            = "active"
+	StatusPastDue           = "past_due"
+	StatusCanceled          = "canceled"
+	StatusUnpaid            = "unpaid"
+	StatusPaused            = "paused"
+)
+
+var (
+	ErrPlanNotFound     = errors.New("plan does not exist")
+	ErrInvalidPlan      = errors.New("plan has to be given as code:price_id")
+	ErrUnknownCustomer  = errors.New("customer is not linked to a user")
+	ErrUnknownPrice     = errors.New("price does not belong to a plan")
+	ErrUnsupportedEvent = errors.New("event type is not handled")
+)
+
+// Plan is what a user can subscribe to. Its code is the value of the
+// custom:subscription_status attribute.
+type Plan struct {
+	types.Base
+	Code string `gorm:"uniqueIndex"`
+	Name string
+	// PriceID is the provider's price the plan is billed with, empty for free plans
+	PriceID string `gorm:"index"`
+	// SelfServe plans can be picked at sign up without paying
+	SelfServe bool
+}
+
+func (Plan) TableName() string {
+	return "plans"
+}
+
+// Customer links a customer of the provider to the subject of a user
+type Customer struct {
+	types.Base
+	CustomerID string `gorm:"uniqueIndex"`
+	Subject    string `gorm:"index"`
+}
+
+func (Customer) TableName() string {
+	return "billing_customers"
+}
+
+// Subscription is the last known state of a subscription at the provider
+type Subscription struct {
+	types.Base
+	ProviderID        string `gorm:"uniqueIndex"`
+	CustomerID        string `gorm:"index"`
+	Subject           string `gorm:"index"`
+	PlanCode          string
+	PriceID           string
+	Status            string
+	CurrentPeriodEnd  *time.Time
+	CancelAtPeriodEnd bool
+	// EventAt is when the provider created the event last applied, events
+	// created before it arrived late and are ignored
+	EventAt time.Time
+}
+
+func (Subscription) TableName() string {
+	return "subscriptions"
+}
+
+// Entitled reports whether the subscription grants its plan. Past due
+// subscriptions keep it while the provider retries the payment.
+func (s Subscription) Entitled() bool {
+	switch s.Status {
+	case StatusActive, StatusTrialing, StatusPastDue:
+		return true
+	default:
+		return false
+	}
+}
+
+// ProcessedEvent remembers a webhook event so redeliveries are skipped
+type ProcessedEvent struct {
+	types.Base
+	EventID string `gorm:"uniqueIndex"`
+	Type    string
+}
+
+func (ProcessedEvent) TableName() string {
+	return "billing_events"
+}
+
+// Models are the tables of the package, for AutoMigrate
+func Models() []interface{} {
+	return []interface{}{&Plan{}, &Customer{}, &Subscription{}, &ProcessedEvent{}}
+}
+
+// ParsePlans returns the default plan, which is free and self serve, followed
+// by the paid plans given as code:price_id
+func ParsePlans(defaultPlan string, specs []string) ([]Plan, error) {
+	plans := []Plan{{Code: defaultPlan, Name: defaultPlan, SelfServe: true}}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		code, priceID, ok := strings.Cut(spec, ":")
+		if !ok || code == "" || priceID == "" {
+			return nil, ErrInvalidPlan
+		}
+		plans = append(plans, Plan{Code: code, Name: code, PriceID: priceID})
+	}
+
+	return plans, nil
+}
+
+// Change is the plan a user is entitled to after an event was applied
+type Change struct {
+	Subject      string
+	Plan         string
+	Subscription *Subscription
+}
+
+// Store keeps plans and subscriptions in Postgres
+type Store struct {
+	DB *gorm.DB
+	// DefaultPlan is the plan of users without an entitled subscription
+	DefaultPlan string
+}
+
+func NewStore(db *gorm.DB, defaultPlan string) *Store {
+	return &Store{DB: db, DefaultPlan: defaultPlan}
+}
+
+// SeedPlans creates the plans that don't exist yet and updates the price of
+// those that do. Everything else about existing plans is left as it is in the
+// database.
+func (s *Store) SeedPlans(ctx context.Context, plans []Plan) error {
+	for _, plan := range plans {
+		base, err := types.NewBase()
+		if err != nil {
+			return err
+		}
+		plan.Base = *base
+
+		err = s.DB.WithContext(ctx).
+			Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "code"}},
+				DoUpdates: clause.AssignmentColumns([]string{"price_id", "updated_at"}),
+			}).
+			Create(&plan).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Plan returns the plan with the given code
+func (s *Store) Plan(ctx context.Context, code string) (*Plan, error) {
+	return s.plan(s.DB.WithContext(ctx), "code = ?", code)
+}
+
+// PlanByPrice returns the plan billed with the given price
+func (s *Store) PlanByPrice(ctx context.Context, priceID string) (*Plan, error) {
+	return s.plan(s.DB.WithContext(ctx), "price_id = ?", priceID)
+}
+
+func (s *Store) plan(db *gorm.DB, query string, arg string) (*Plan, error) {
+	if arg == "" {
+		return nil, ErrPlanNotFound
+	}
+
+	var plan Plan
+	err := db.Where(query, arg).First(&plan).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPlanNotFound
+		}
+		return nil, err
+	}
+
+	return &plan, nil
+}
+
+// Processed reports whether the event was handled before
+func (s *Store) Processed(ctx context.Context, eventID string) (bool, error) {
+	var count int64
+	err := s.DB.WithContext(ctx).Model(&ProcessedEvent{}).Where("event_id = ?", eventID).Count(&count).Error
+	return count > 0, err
+}
+
+// MarkProcessed records that the event was handled. It is called once every
+// side effect of the event went through, so a failed event is retried whole.
+func (s *Store) MarkProcessed(ctx context.Context, eventID, eventType string) error {
+	base, err := types.NewBase()
+	if err != nil {
+		return err
+	}
+
+	return s.DB.WithContext(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "event_id"}}, DoNothing: true}).
+		Create(&ProcessedEvent{Base: *base, EventID: eventID, Type: eventType}).Error
+}
+
+// Link remembers which user a customer of the provider belongs to
+func (s *Store) Link(ctx context.Context, customerID, subject string) error {
+	return s.link(s.DB.WithContext(ctx), customerID, subject)
+}
+
+func (s *Store) link(db *gorm.DB, customerID, subject string) error {
+	base, err := types.NewBase()
+	if err != nil {
+		return err
+	}
+
+	return db.
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "customer_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"subject", "updated_at"}),
+		}).
+		Create(&Customer{Base: *base, CustomerID: customerID, Subject: subject}).Error
+}
+
+func (s *Store) subjectOf(db *gorm.DB, customerID string) (string, error) {
+	var customer Customer
+	err := db.Where("customer_id = ?", customerID).First(&customer).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrUnknownCustomer
+		}
+		return "", err
+	}
+
+	return customer.Subject, nil
+}
+
+func (s *Store) planOf(db *gorm.DB, subject string) (string, error) {
+	var subs []Subscription
+	err := db.Where("subject = ?", subject).Order("event_at DESC").Find(&subs).Error
+	if err != nil {
+		return "", err
+	}
+
+	for _, sub := range subs {
+		if sub.Entitled() {
+			return sub.PlanCode, nil
+		}
+	}
+
+	return s.DefaultPlan, nil
+}
+
+// Apply updates the stored state from a webhook event and returns the plan
+// the affected user is entitled to now. Events that don't change a
+// subscription return no change. Applying an event twice has no further effect.
+func (s *Store) Apply(ctx context.Context, e *Event) (*Change, error) {
+	switch e.Type {
+	case EventCheckoutCompleted:
+		var session CheckoutSession
+		if err := e.decode(&session); err != nil {
+			return nil, err
+		}
+
+		subject := session.ClientReferenceID
+		if subject == "" {
+			subject = session.Metadata[MetadataSubject]
+		}
+		if subject == "" || session.Customer == "" {
+			return nil, nil
+		}
+
+		return nil, s.Link(ctx, session.Customer, subject)
+
+	case EventSubscriptionCreated, EventSubscriptionUpdated, EventSubscriptionDeleted:
+		var sub ProviderSubscription
+		if err := e.decode(&sub); err != nil {
+			return nil, err
+		}
+
+		return s.applySubscription(ctx, &sub, time.Unix(e.Created, 0))
+
+	default:
+		return nil, ErrUnsupportedEvent
+	}
+}
+
+func (s *Store) applySubscription(ctx context.Context, sub *ProviderSubscription, eventAt time.Time) (*Change, error) {
+	var change *Change
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		subject := sub.Metadata[MetadataSubject]
+		if subject != "" && sub.Customer != "" {
+			if err := s.link(tx, sub.Customer, subject); err != nil {
+				return err
+			}
+		}
+		if subject == "" {
+			var err error
+			if subject, err = s.subjectOf(tx, sub.Customer); err != nil {
+				return err
+			}
+		}
+
+		plan, err := s.plan(tx, "price_id = ?", sub.PriceID())
+		if err != nil {
+			if errors.Is(err, ErrPlanNotFound) {
+				return ErrUnknownPrice
+			}
+			return err
+		}
+
+		row := Subscription{
+			ProviderID:        sub.ID,
+			CustomerID:        sub.Customer,
+			Subject:           subject,
+			PlanCode:          plan.Code,
+			PriceID:           plan.PriceID,
+			Status:            sub.Status,
+			CancelAtPeriodEnd: sub.CancelAtPeriodEnd,
+			EventAt:           eventAt,
+		}
+		if sub.CurrentPeriodEnd > 0 {
+			end := time.Unix(sub.CurrentPeriodEnd, 0)
+			row.CurrentPeriodEnd = &end
+		}
+
+		var existing Subscription
+		err = tx.Where("provider_id = ?", sub.ID).First(&existing).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			base, err := types.NewBase()
+			if err != nil {
+				return err
+			}
+			row.Base = *base
+			if err := tx.Create(&row).Error; err != nil {
+				return err
+			}
+
+		case err != nil:
+			return err
+
+		case existing.EventAt.After(eventAt):
+			// a newer event was applied already
+			row = existing
+
+		default:
+			row.Base = existing.Base
+			row.UpdatedAt = time.Now()
+			err := tx.Model(&existing).Updates(map[string]interface{}{
+				"customer_id":          row.CustomerID,
+				"subject":              row.Subject,
+				"plan_code":            row.PlanCode,
+				"price_id":             row.PriceID,
+				"status":               row.Status,
+				"current_period_end":   row.CurrentPeriodEnd,
+				"cancel_at_period_end": row.CancelAtPeriodEnd,
+				"event_at":             row.EventAt,
+				"updated_at":           row.UpdatedAt,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		current, err := s.planOf(tx, subject)
+		if err != nil {
+			return err
+		}
+
+		change = &Change{Subject: subject, Plan: current, Subscription: &row}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
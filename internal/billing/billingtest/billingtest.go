@@ -0,0 +1,173 @@
+// Package billingtest simulates the webhooks of a Stripe compatible payment
+// provider. It builds events shaped like the provider's, signs them with the
+// endpoint secret and delivers them to a running server or an http.Handler,
+// so the billing flow can be exercised without the provider.
+package billingtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"backend/internal/billing"
+	"backend/pkg/webhook"
+)
+
+// Simulator builds and delivers signed webhook events
+type Simulator struct {
+	Secret []byte
+	// URL is the webhook endpoint Send posts to
+	URL    string
+	Client *http.Client
+	// Now is the clock events are created and signed with
+	Now func() time.Time
+
+	mu  sync.Mutex
+	seq int
+}
+
+func New(secret, url string) *Simulator {
+	return &Simulator{
+		Secret: []byte(secret),
+		URL:    url,
+		Client: http.DefaultClient,
+		Now:    time.Now,
+	}
+}
+
+// Subscription describes the state a simulated subscription is reported in
+type Subscription struct {
+	ID       string
+	Customer string
+	// Subject is put in the metadata, leave it empty to rely on the customer link
+	Subject           string
+	PriceID           string
+	Status            string
+	CurrentPeriodEnd  time.Time
+	CancelAtPeriodEnd bool
+}
+
+// CheckoutCompleted is the event of a finished checkout that created customer
+// and subscription for the user with subject
+func (s *Simulator) CheckoutCompleted(subject, customer, subscription string) (*billing.Event, error) {
+	return s.Event(billing.EventCheckoutCompleted, billing.CheckoutSession{
+		ID:                s.NewID("cs"),
+		Object:            "checkout.session",
+		Customer:          customer,
+		Subscription:      subscription,
+		ClientReferenceID: subject,
+	})
+}
+
+// SubscriptionCreated, SubscriptionUpdated and SubscriptionDeleted report sub
+func (s *Simulator) SubscriptionCreated(sub Subscription) (*billing.Event, error) {
+	return s.subscriptionEvent(billing.EventSubscriptionCreated, sub)
+}
+
+func (s *Simulator) SubscriptionUpdated(sub Subscription) (*billing.Event, error) {
+	return s.subscriptionEvent(billing.EventSubscriptionUpdated, sub)
+}
+
+func (s *Simulator) SubscriptionDeleted(sub Subscription) (*billing.Event, error) {
+	if sub.Status == "" {
+		sub.Status = billing.StatusCanceled
+	}
+	return s.subscriptionEvent(billing.EventSubscriptionDeleted, sub)
+}
+
+func (s *Simulator) subscriptionEvent(eventType string, sub Subscription) (*billing.Event, error) {
+	if sub.Status == "" {
+		sub.Status = billing.StatusActive
+	}
+	if sub.CurrentPeriodEnd.IsZero() {
+		sub.CurrentPeriodEnd = s.Now().AddDate(0, 1, 0)
+	}
+
+	object := billing.ProviderSubscription{
+		ID:                sub.ID,
+		Object:            "subscription",
+		Customer:          sub.Customer,
+		Status:            sub.Status,
+		CurrentPeriodEnd:  sub.CurrentPeriodEnd.Unix(),
+		CancelAtPeriodEnd: sub.CancelAtPeriodEnd,
+		Metadata:          map[string]string{},
+		Items: billing.SubscriptionItems{Data: []billing.SubscriptionItem{{
+			ID:    s.NewID("si"),
+			Price: billing.Price{ID: sub.PriceID},
+		}}},
+	}
+	if sub.Subject != "" {
+		object.Metadata[billing.MetadataSubject] = sub.Subject
+	}
+
+	return s.Event(eventType, object)
+}
+
+// Event wraps object into an event of the given type
+func (s *Simulator) Event(eventType string, object interface{}) (*billing.Event, error) {
+	raw, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+
+	return &billing.Event{
+		ID:      s.NewID("evt"),
+		Object:  "event",
+		Type:    eventType,
+		Created: s.Now().Unix(),
+		Data:    billing.EventData{Object: raw},
+	}, nil
+}
+
+// NewID returns an id with the provider's prefix for the kind of object
+func (s *Simulator) NewID(prefix string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	return fmt.Sprintf("%s_test%08d", prefix, s.seq)
+}
+
+// Request returns a signed request delivering e
+func (s *Simulator) Request(ctx context.Context, e *billing.Event) (*http.Request, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(billing.HeaderSignature, webhook.Sign(s.Secret, s.Now(), body))
+
+	return req, nil
+}
+
+// Send delivers e to URL
+func (s *Simulator) Send(ctx context.Context, e *billing.Event) (*http.Response, error) {
+	req, err := s.Request(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Client.Do(req)
+}
+
+// Serve delivers e to h, e.g. the server's Echo instance, and returns the recorded response
+func (s *Simulator) Serve(h http.Handler, e *billing.Event) (*httptest.ResponseRecorder, error) {
+	req, err := s.Request(context.Background(), e)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec, nil
+}
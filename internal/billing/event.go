@@ -0,0 +1,90 @@
+package billing
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// HeaderSignature carries the signature of a webhook event, see package
+// webhook for the scheme
+const HeaderSignature = "Stripe-Signature"
+
+// MetadataSubject is the metadata key checkout sessions and subscriptions
+// carry the subject of the user in, when client_reference_id isn't used
+const MetadataSubject = "subject"
+
+// Event types that are handled, every other type is acknowledged and ignored
+const (
+	EventCheckoutCompleted   = "checkout.session.completed"
+	EventSubscriptionCreated = "customer.subscription.created"
+	EventSubscriptionUpdated = "customer.subscription.updated"
+	EventSubscriptionDeleted = "customer.subscription.deleted"
+)
+
+// Event is a webhook event of the provider. Data.Object is the object the
+// event is about, its shape depends on Type.
+type Event struct {
+	ID       string    `json:"id"`
+	Object   string    `json:"object"`
+	Type     string    `json:"type"`
+	Created  int64     `json:"created"`
+	Livemode bool      `json:"livemode"`
+	Data     EventData `json:"data"`
+}
+
+type EventData struct {
+	Object json.RawMessage `json:"object"`
+}
+
+func (e *Event) decode(v interface{}) error {
+	if err := json.Unmarshal(e.Data.Object, v); err != nil {
+		return fmt.Errorf("%s object: %w", e.Type, err)
+	}
+
+	return nil
+}
+
+// CheckoutSession is the part of a checkout session that links the customer
+// it created to a user
+type CheckoutSession struct {
+	ID                string            `json:"id"`
+	Object            string            `json:"object"`
+	Customer          string            `json:"customer"`
+	Subscription      string            `json:"subscription"`
+	ClientReferenceID string            `json:"client_reference_id"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+// ProviderSubscription is a subscription as the provider sends it
+type ProviderSubscription struct {
+	ID                string            `json:"id"`
+	Object            string            `json:"object"`
+	Customer          string            `json:"customer"`
+	Status            string            `json:"status"`
+	CurrentPeriodEnd  int64             `json:"current_period_end"`
+	CancelAtPeriodEnd bool              `json:"cancel_at_period_end"`
+	Metadata          map[string]string `json:"metadata"`
+	Items             SubscriptionItems `json:"items"`
+}
+
+type SubscriptionItems struct {
+	Data []SubscriptionItem `json:"data"`
+}
+
+type SubscriptionItem struct {
+	ID    string `json:"id"`
+	Price Price  `json:"price"`
+}
+
+type Price struct {
+	ID string `json:"id"`
+}
+
+// PriceID returns the price of the first item, plans are billed with one price
+func (s ProviderSubscription) PriceID() string {
+	if len(s.Items.Data) == 0 {
+		return ""
+	}
+
+	return s.Items.Data[0].Price.ID
+}
@@ -0,0 +1,365 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	authctx "backend/internal/auth"
+	"backend/internal/svc"
+	"backend/internal/types"
+	"backend/pkg/identity"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Profile is the identity provider's view of the user merged with the local profile row
+type Profile struct {
+	identity.User
+	DisplayName string `json:"displayName,omitempty"`
+	Bio         string `json:"bio,omitempty"`
+}
+
+// editableAttributes are the standard attributes users may change themselves
+var editableAttributes = []string{"given_name", "family_name", "locale", "picture"}
+
+// profileColumns maps the form fields kept in the local profile row to their columns
+var profileColumns = map[string]string{"displayName": "display_name", "bio": "bio"}
+
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,8})*$`)
+
+// @Summary Get Profile
+// @Description Returns the signed in user's attributes together with the local profile
+// @Tags Account
+// @Security BearerAuth
+// @Success 200 {object} Profile
+// @Failure 401 {object} auth.ErrorResponse
+// @Router /me [get]
+func GetProfile(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.GetProfile")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+
+		user, err := s.Identity.GetUser(ctx, principal.Token)
+		if err != nil {
+			span.RecordError(err)
+			if errors.Is(err, identity.ErrNotAuthorized) || errors.Is(err, identity.ErrUserNotFound) {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "Access token is no longer valid",
+					"error":   err.Error(),
+				})
+			}
+
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something went wrong while loading the profile",
+				"error":   err.Error(),
+			})
+		}
+
+		profile := Profile{User: *user}
+
+		var row types.Profile
+		err = s.DB.WithContext(ctx).Where("subject = ?", user.Subject).First(&row).Error
+		switch {
+		case err == nil:
+			profile.DisplayName = row.DisplayName
+			profile.Bio = row.Bio
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			span.RecordError(err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something went wrong while loading the profile",
+				"error":   err.Error(),
+			})
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, profile)
+	}
+}
+
+// @Summary Update Profile
+// @Description Changes the signed in user's name, locale, picture, display name or bio. Only the fields sent are changed.
+// @Tags Account
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Param given_name formData string false "Given Name"
+// @Param family_name formData string false "Family Name"
+// @Param locale formData string false "Locale, e.g. en-US"
+// @Param picture formData string false "HTTPS URL of the profile picture"
+// @Param displayName formData string false "Display Name"
+// @Param bio formData string false "Bio"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 400 {object} auth.ErrorResponse
+// @Failure 401 {object} auth.ErrorResponse
+// @Router /me [patch]
+func UpdateProfile(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.UpdateProfile")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+
+		params, err := c.FormParams()
+		if err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			span.RecordError(err)
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Invalid request format",
+				"error":   err.Error(),
+			})
+		}
+
+		attributes := make(map[string]string)
+		for _, name := range editableAttributes {
+			if _, ok := params[name]; !ok {
+				continue
+			}
+
+			value := strings.TrimSpace(params.Get(name))
+			if err := validateAttribute(name, value); err != nil {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Invalid value for " + name,
+					"error":   err.Error(),
+				})
+			}
+			attributes[name] = value
+		}
+
+		fields := make(map[string]string)
+		for name := range profileColumns {
+			if _, ok := params[name]; !ok {
+				continue
+			}
+
+			value := strings.TrimSpace(params.Get(name))
+			if err := validateProfileField(name, value); err != nil {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Invalid value for " + name,
+					"error":   err.Error(),
+				})
+			}
+			fields[name] = value
+		}
+
+		if len(attributes) == 0 && len(fields) == 0 {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "At least one of given_name, family_name, locale, picture, displayName or bio is required",
+			})
+		}
+
+		if len(attributes) > 0 {
+			if err := s.Identity.UpdateAttributes(ctx, principal.Token, attributes); err != nil {
+				return attributeError(c, span, err)
+			}
+			if _, err := s.Users.RefreshSelf(ctx, s.Identity, principal.Token); err != nil {
+				span.RecordError(err)
+			}
+		}
+
+		if len(fields) > 0 {
+			if err := saveProfile(ctx, s, principal.Subject, fields); err != nil {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+				span.RecordError(err)
+				return c.JSON(http.StatusInternalServerError, echo.Map{
+					"message": "Something went wrong while updating the profile",
+					"error":   err.Error(),
+				})
+			}
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "Your profile has been updated",
+		})
+	}
+}
+
+// @Summary Change Password
+// @Description Changes the signed in user's password
+// @Tags Account
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Param currentPassword formData string true "Current Password"
+// @Param newPassword formData string true "New Password"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 400 {object} auth.ErrorResponse
+// @Failure 401 {object} auth.ErrorResponse
+// @Failure 429 {object} auth.ErrorResponse
+// @Router /me/change-password [post]
+func ChangePassword(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.ChangePassword")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+		currentPassword := c.FormValue("currentPassword")
+		newPassword := c.FormValue("newPassword")
+
+		if currentPassword == "" || newPassword == "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Current password and new password are required fields",
+			})
+		}
+
+		user, err := s.Identity.GetUser(ctx, principal.Token)
+		if err != nil {
+			return passwordError(c, span, err)
+		}
+
+		if answered, err := confirmPassword(ctx, s, c, span, user.Username, currentPassword, passwordError); answered {
+			return err
+		}
+
+		if err := s.Identity.ChangePassword(ctx, principal.Token, currentPassword, newPassword); err != nil {
+			return passwordError(c, span, err)
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "Your password has been changed",
+		})
+	}
+}
+
+// saveProfile writes the sent fields to the user's profile row, creating it on the first change
+func saveProfile(ctx context.Context, s *svc.ServiceContext, subject string, fields map[string]string) error {
+	base, err := types.NewBase()
+	if err != nil {
+		return err
+	}
+
+	row := types.Profile{Base: *base, Subject: subject, DisplayName: fields["displayName"], Bio: fields["bio"]}
+	columns := []string{"updated_at"}
+	for name := range fields {
+		columns = append(columns, profileColumns[name])
+	}
+
+	return s.DB.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "subject"}},
+			DoUpdates: clause.AssignmentColumns(columns),
+		}).
+		Create(&row).Error
+}
+
+func attributeError(c echo.Context, span trace.Span, err error) error {
+	span.RecordError(err)
+	switch {
+	case errors.Is(err, identity.ErrNotAuthorized):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"message": "Access token is no longer valid",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, identity.ErrInvalidParameter):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "The attributes could not be updated",
+			"error":   err.Error(),
+		})
+	default:
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "Something went wrong while updating the profile",
+			"error":   err.Error(),
+		})
+	}
+}
+
+// passwordError answers a failed password change. The current password has
+// been confirmed by then, so an authorization error means the token is stale.
+func passwordError(c echo.Context, span trace.Span, err error) error {
+	span.RecordError(err)
+	switch {
+	case errors.Is(err, identity.ErrNotAuthorized), errors.Is(err, identity.ErrUserNotFound):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"message": "Access token is no longer valid",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, identity.ErrInvalidPassword), errors.Is(err, identity.ErrInvalidParameter):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "Password must include uppercase, special-character and number",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, identity.ErrLimitExceeded):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusTooManyRequests))
+		return c.JSON(http.StatusTooManyRequests, echo.Map{
+			"message": "Too many attempts, please try again later",
+			"error":   err.Error(),
+		})
+	default:
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "Something went wrong while changing the password",
+			"error":   err.Error(),
+		})
+	}
+}
+
+func validateProfileField(name, value string) error {
+	switch name {
+	case "displayName":
+		if utf8.RuneCountInString(value) > 64 {
+			return errors.New("must be at most 64 characters")
+		}
+	case "bio":
+		if utf8.RuneCountInString(value) > 1024 {
+			return errors.New("must be at most 1024 characters")
+		}
+	default:
+		return fmt.Errorf("%s cannot be changed", name)
+	}
+
+	return nil
+}
+
+func validateAttribute(name, value string) error {
+	switch name {
+	case "given_name", "family_name":
+		if value == "" {
+			return errors.New("must not be empty")
+		}
+		if utf8.RuneCountInString(value) > 256 {
+			return errors.New("must be at most 256 characters")
+		}
+	case "locale":
+		if !localePattern.MatchString(value) {
+			return errors.New("must be a language tag such as en or en-US")
+		}
+	case "picture":
+		if len(value) > 2048 {
+			return errors.New("must be at most 2048 characters")
+		}
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return errors.New("must be an https URL")
+		}
+	default:
+		return fmt.Errorf("%s cannot be changed", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package account_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"backend/internal/handler/account"
+	"backend/internal/types"
+	"backend/pkg/cognito/cognitotest"
+
+	"github.com/labstack/echo/v4"
+)
+
+func send(e *echo.Echo, method, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func profile(t *testing.T, e *echo.Echo) account.Profile {
+	t.Helper()
+
+	rec := send(e, http.MethodGet, "/me", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var p account.Profile
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestGetProfile(t *testing.T) {
+	e, s := newServer(t)
+
+	p := profile(t, e)
+	if p.Username != username || p.Email != username || p.DisplayName != "" {
+		t.Fatalf("profile = %+v", p)
+	}
+
+	// a profile row is merged into the provider's view
+	base, err := types.NewBase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DB.Create(&types.Profile{Base: *base, Subject: p.Subject, DisplayName: "Al", Bio: "Hi"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if p := profile(t, e); p.DisplayName != "Al" || p.Bio != "Hi" {
+		t.Fatalf("profile = %+v", p)
+	}
+
+	s.Cognito.(*cognitotest.Client).FailNext("GetUser", cognitotest.Error("NotAuthorizedException"))
+	if rec := send(e, http.MethodGet, "/me", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+	}{
+		{name: "Names", form: url.Values{"given_name": {" Alice "}, "family_name": {"Liddell"}}, status: http.StatusOK},
+		{name: "Locale", form: url.Values{"locale": {"en-GB"}}, status: http.StatusOK},
+		{name: "Picture", form: url.Values{"picture": {"https://example.com/alice.png"}}, status: http.StatusOK},
+		{name: "LocalFields", form: url.Values{"displayName": {"Al"}, "bio": {"Down the rabbit hole"}}, status: http.StatusOK},
+		{name: "Nothing", form: url.Values{}, status: http.StatusBadRequest},
+		{name: "OnlyOtherFields", form: url.Values{"email": {"mallory@example.com"}}, status: http.StatusBadRequest},
+		{name: "EmptyName", form: url.Values{"given_name": {"  "}}, status: http.StatusBadRequest},
+		{name: "LongName", form: url.Values{"family_name": {strings.Repeat("x", 257)}}, status: http.StatusBadRequest},
+		{name: "InvalidLocale", form: url.Values{"locale": {"english please"}}, status: http.StatusBadRequest},
+		{name: "PlainHTTPPicture", form: url.Values{"picture": {"http://example.com/alice.png"}}, status: http.StatusBadRequest},
+		{name: "LongDisplayName", form: url.Values{"displayName": {strings.Repeat("x", 65)}}, status: http.StatusBadRequest},
+		{name: "LongBio", form: url.Values{"bio": {strings.Repeat("x", 1025)}}, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, s := newServer(t)
+
+			rec := send(e, http.MethodPatch, "/me", tt.form)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+
+			u, _ := s.Cognito.(*cognitotest.Client).User(username)
+			p := profile(t, e)
+			for name := range tt.form {
+				want := ""
+				if tt.status == http.StatusOK {
+					want = strings.TrimSpace(tt.form.Get(name))
+				}
+
+				var got string
+				switch name {
+				case "displayName":
+					got = p.DisplayName
+				case "bio":
+					got = p.Bio
+				default:
+					got = u.Attributes[name]
+				}
+				if got != want && name != "email" {
+					t.Fatalf("%s = %q, want %q", name, got, want)
+				}
+			}
+			if u.Attributes["email"] != username {
+				t.Fatalf("email = %q", u.Attributes["email"])
+			}
+		})
+	}
+}
+
+// the local fields don't touch the provider, and sending one leaves the other alone
+func TestUpdateProfileKeepsOtherFields(t *testing.T) {
+	e, s := newServer(t)
+
+	if rec := send(e, http.MethodPatch, "/me", url.Values{"displayName": {"Al"}, "bio": {"Hi"}}); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	s.Cognito.(*cognitotest.Client).FailNext("UpdateUserAttributes", cognitotest.Error("InternalErrorException"))
+	if rec := send(e, http.MethodPatch, "/me", url.Values{"bio": {"Bye"}}); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if p := profile(t, e); p.DisplayName != "Al" || p.Bio != "Bye" {
+		t.Fatalf("profile = %+v", p)
+	}
+
+	var count int64
+	if err := s.DB.Model(&types.Profile{}).Count(&count).Error; err != nil || count != 1 {
+		t.Fatalf("profiles = %d, %v", count, err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	tests := []struct {
+		name    string
+		form    url.Values
+		status  int
+		changed bool
+	}{
+		{name: "Changed", form: url.Values{"currentPassword": {password}, "newPassword": {"N3wPassw0rd!"}}, status: http.StatusOK, changed: true},
+		{name: "Missing", form: url.Values{"currentPassword": {password}}, status: http.StatusBadRequest},
+		{name: "WrongPassword", form: url.Values{"currentPassword": {"Wr0ngPassword!"}, "newPassword": {"N3wPassw0rd!"}}, status: http.StatusUnauthorized},
+		{name: "WeakPassword", form: url.Values{"currentPassword": {password}, "newPassword": {"weak"}}, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, s := newServer(t)
+
+			rec := send(e, http.MethodPost, "/me/change-password", tt.form)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+
+			current := password
+			if tt.changed {
+				current = "N3wPassw0rd!"
+			}
+			if err := s.Identity.VerifyPassword(context.Background(), username, current); err != nil {
+				t.Fatalf("the password is not %q: %v", current, err)
+			}
+		})
+	}
+}
+
+// a stolen access token can't be used to guess the password
+func TestChangePasswordLoginGuard(t *testing.T) {
+	e, s := newServer(t)
+	form := url.Values{"currentPassword": {"Wr0ngPassword!"}, "newPassword": {"N3wPassw0rd!"}}
+
+	for i := 0; i < 3; i++ {
+		if rec := send(e, http.MethodPost, "/me/change-password", form); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d: %s", i, rec.Code, rec.Body.String())
+		}
+	}
+
+	form.Set("currentPassword", password)
+	rec := send(e, http.MethodPost, "/me/change-password", form)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if err := s.Identity.VerifyPassword(context.Background(), username, password); err != nil {
+		t.Fatalf("the password was changed while locked: %v", err)
+	}
+}
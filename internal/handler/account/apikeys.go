@@ -0,0 +1,209 @@
+package account
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"backend/internal/apikey"
+	authctx "backend/internal/auth"
+	"backend/internal/svc"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oklog/ulid/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var scopePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:/-]{0,63}$`)
+
+// APIKey is how a key is shown to its owner. The secret is never part of it.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Start      string     `json:"start"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+func apiKeyView(k apikey.APIKey) APIKey {
+	scopes := k.ScopeList()
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	return APIKey{
+		ID:         k.ID.String(),
+		Name:       k.Name,
+		Start:      k.Start,
+		Scopes:     scopes,
+		CreatedAt:  k.CreatedAt,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		RevokedAt:  k.RevokedAt,
+	}
+}
+
+// @Summary Create API Key
+// @Description Creates an API key for machine clients, sent in the X-API-Key header. The key is only returned by this call.
+// @Tags Account
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Param name formData string true "Name"
+// @Param scopes formData string false "Comma separated scopes, none means the key may do whatever you may"
+// @Param expiresAt formData string false "Expiry as RFC 3339 timestamp"
+// @Success 201 {object} auth.SuccessResponse
+// @Failure 400 {object} auth.ErrorResponse
+// @Failure 409 {object} auth.ErrorResponse
+// @Router /me/api-keys [post]
+func CreateAPIKey(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.CreateAPIKey")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+		name := strings.TrimSpace(c.FormValue("name"))
+
+		if name == "" || len(name) > 64 {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Name is required and may be at most 64 characters",
+			})
+		}
+
+		var scopes []string
+		for _, scope := range strings.Split(c.FormValue("scopes"), ",") {
+			scope = strings.TrimSpace(scope)
+			if scope == "" {
+				continue
+			}
+			if !scopePattern.MatchString(scope) {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Scope " + scope + " is not valid",
+				})
+			}
+			scopes = append(scopes, scope)
+		}
+
+		var expiresAt *time.Time
+		if v := c.FormValue("expiresAt"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil || !t.After(time.Now()) {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Expiry must be an RFC 3339 timestamp in the future",
+				})
+			}
+			expiresAt = &t
+		}
+
+		key, secret, err := s.APIKeys.Create(ctx, apikey.Owner{
+			Subject:  principal.Subject,
+			Username: principal.Username,
+			Email:    principal.Email,
+		}, name, scopes, expiresAt)
+		if err != nil {
+			return apiKeyError(c, span, err)
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusCreated))
+		return c.JSON(http.StatusCreated, echo.Map{
+			"message": "Store the key now, it can't be shown again",
+			"key":     secret,
+			"apiKey":  apiKeyView(*key),
+		})
+	}
+}
+
+// @Summary List API Keys
+// @Description Lists your API keys, revoked ones included
+// @Tags Account
+// @Security BearerAuth
+// @Success 200 {array} APIKey
+// @Router /me/api-keys [get]
+func ListAPIKeys(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.ListAPIKeys")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+		keys, err := s.APIKeys.List(ctx, principal.Subject)
+		if err != nil {
+			return apiKeyError(c, span, err)
+		}
+
+		views := make([]APIKey, 0, len(keys))
+		for _, k := range keys {
+			views = append(views, apiKeyView(k))
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, views)
+	}
+}
+
+// @Summary Revoke API Key
+// @Description Revokes one of your API keys, requests made with it are rejected from now on
+// @Tags Account
+// @Security BearerAuth
+// @Param id path string true "API Key ID"
+// @Success 200 {object} APIKey
+// @Failure 404 {object} auth.ErrorResponse
+// @Router /me/api-keys/{id} [delete]
+func RevokeAPIKey(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.RevokeAPIKey")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+		id, err := ulid.ParseStrict(c.Param("id"))
+		if err != nil {
+			// don't tell malformed IDs apart from keys of other users
+			return apiKeyError(c, span, apikey.ErrNotFound)
+		}
+
+		key, err := s.APIKeys.Revoke(ctx, principal.Subject, id)
+		if err != nil {
+			return apiKeyError(c, span, err)
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, apiKeyView(*key))
+	}
+}
+
+func apiKeyError(c echo.Context, span trace.Span, err error) error {
+	span.RecordError(err)
+	switch {
+	case errors.Is(err, apikey.ErrNotFound):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotFound))
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"message": "API key not found",
+		})
+	case errors.Is(err, apikey.ErrRevoked):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusConflict))
+		return c.JSON(http.StatusConflict, echo.Map{
+			"message": "API key has already been revoked",
+		})
+	case errors.Is(err, apikey.ErrLimitReached):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusConflict))
+		return c.JSON(http.StatusConflict, echo.Map{
+			"message": "You have reached the maximum number of API keys, revoke one first",
+		})
+	default:
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "Something went wrong while managing API keys",
+			"error":   err.Error(),
+		})
+	}
+}
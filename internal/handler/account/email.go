@@ -0,0 +1,254 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	authctx "backend/internal/auth"
+	"backend/internal/handler/auth"
+	"backend/internal/svc"
+	"backend/pkg/identity"
+	"backend/pkg/mailer"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// @Summary Change Email
+// @Description Starts an email change by sending a code to the new address. The current password is required and the old address is notified.
+// @Tags Account
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Param email formData string true "New Email"
+// @Param password formData string true "Current Password"
+// @Success 202 {object} auth.SuccessResponse
+// @Failure 400 {object} auth.ErrorResponse
+// @Failure 401 {object} auth.ErrorResponse
+// @Failure 409 {object} auth.ErrorResponse
+// @Failure 429 {object} auth.ErrorResponse
+// @Router /me/email [post]
+func ChangeEmail(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.ChangeEmail")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+		password := c.FormValue("password")
+		newEmail := strings.TrimSpace(c.FormValue("email"))
+
+		if newEmail == "" || password == "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Email and password are required fields",
+			})
+		}
+
+		if addr, err := mail.ParseAddress(newEmail); err != nil || addr.Address != newEmail {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Email is not a valid address",
+			})
+		}
+
+		changer, ok := s.Identity.(identity.EmailChanger)
+		if !ok {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotImplemented))
+			return c.JSON(http.StatusNotImplemented, echo.Map{
+				"message": "Changing the email address is not supported by the identity provider",
+			})
+		}
+
+		user, err := s.Identity.GetUser(ctx, principal.Token)
+		if err != nil {
+			return emailError(c, span, err)
+		}
+
+		if strings.EqualFold(user.Email, newEmail) {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "The new email is the same as the current one",
+			})
+		}
+
+		if answered, err := confirmPassword(ctx, s, c, span, user.Username, password, emailError); answered {
+			return err
+		}
+
+		if err := changer.RequestEmailChange(ctx, principal.Token, newEmail); err != nil {
+			return emailError(c, span, err)
+		}
+
+		notify(ctx, s, span, mailer.Message{
+			To:      user.Email,
+			Subject: "Your email address is being changed",
+			Body: fmt.Sprintf("A change of the email address of your account to %s was requested. "+
+				"If this wasn't you, change your password and sign out on all devices.", newEmail),
+		})
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusAccepted))
+		return c.JSON(http.StatusAccepted, echo.Map{
+			"message": "We have sent a verification code to your new email address",
+		})
+	}
+}
+
+// @Summary Verify Email Change
+// @Description Confirms the code sent to the new address and completes the email change
+// @Tags Account
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Param code formData string true "Verification Code"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 400 {object} auth.ErrorResponse
+// @Failure 401 {object} auth.ErrorResponse
+// @Router /me/email/verify [post]
+func VerifyEmailChange(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.VerifyEmailChange")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+		code := c.FormValue("code")
+
+		if code == "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Code is a required field",
+			})
+		}
+
+		changer, ok := s.Identity.(identity.EmailChanger)
+		if !ok {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotImplemented))
+			return c.JSON(http.StatusNotImplemented, echo.Map{
+				"message": "Changing the email address is not supported by the identity provider",
+			})
+		}
+
+		before, err := s.Identity.GetUser(ctx, principal.Token)
+		if err != nil {
+			return emailError(c, span, err)
+		}
+
+		if err := changer.VerifyEmailChange(ctx, principal.Token, code); err != nil {
+			return emailError(c, span, err)
+		}
+
+		after, err := s.Identity.GetUser(ctx, principal.Token)
+		if err != nil {
+			return emailError(c, span, err)
+		}
+		if _, err := s.Users.SyncUser(ctx, after); err != nil {
+			span.RecordError(err)
+		}
+
+		notify(ctx, s, span, mailer.Message{
+			To:      before.Email,
+			Subject: "Your email address has been changed",
+			Body: fmt.Sprintf("The email address of your account has been changed to %s. "+
+				"If this wasn't you, contact support immediately.", after.Email),
+		})
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "Your email address has been changed",
+			"email":   after.Email,
+		})
+	}
+}
+
+// confirmPassword checks the current password before a change that would let
+// the holder of a stolen access token take over the account. Failures count
+// towards the login guard so the token can't be used to guess the password
+// either. It reports whether it answered the request, errors the guard doesn't
+// know about are answered by fail.
+func confirmPassword(ctx context.Context, s *svc.ServiceContext, c echo.Context, span trace.Span, username, password string,
+	fail func(echo.Context, trace.Span, error) error) (bool, error) {
+	if blocked, err := auth.CheckLoginGuard(s, c, span, username); blocked {
+		return true, err
+	}
+
+	if err := s.Identity.VerifyPassword(ctx, username, password); err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, identity.ErrNotAuthorized):
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+			auth.RecordLoginFailure(s, c, span, username)
+			return true, c.JSON(http.StatusUnauthorized, echo.Map{
+				"message": "Current password is incorrect",
+			})
+		case errors.Is(err, identity.ErrLimitExceeded):
+			return true, auth.TooManyAttempts(c, span, s.Config.Auth.LOGIN.BACKOFF_MAX)
+		}
+		return true, fail(c, span, err)
+	}
+
+	s.LoginGuard.Success(username)
+	return false, nil
+}
+
+// notify sends a security notification. Failing to deliver it doesn't undo the
+// change, so the error is only recorded.
+func notify(ctx context.Context, s *svc.ServiceContext, span trace.Span, msg mailer.Message) {
+	if msg.To == "" {
+		return
+	}
+
+	if err := s.Mailer.Send(ctx, msg); err != nil {
+		span.RecordError(err)
+	}
+}
+
+func emailError(c echo.Context, span trace.Span, err error) error {
+	span.RecordError(err)
+	switch {
+	case errors.Is(err, identity.ErrNotAuthorized), errors.Is(err, identity.ErrUserNotFound):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"message": "Access token is no longer valid",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, identity.ErrUserExists):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusConflict))
+		return c.JSON(http.StatusConflict, echo.Map{
+			"message": "Email address is already in use",
+		})
+	case errors.Is(err, identity.ErrCodeMismatch):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "Invalid code provided, please try again.",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, identity.ErrExpiredCode):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "Code has expired, please request the email change again",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, identity.ErrInvalidParameter):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "The email change could not be completed",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, identity.ErrLimitExceeded):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusTooManyRequests))
+		return c.JSON(http.StatusTooManyRequests, echo.Map{
+			"message": "Too many attempts, please try again later",
+			"error":   err.Error(),
+		})
+	default:
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "Something went wrong while changing the email address",
+			"error":   err.Error(),
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package account_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	authctx "backend/internal/auth"
+	"backend/internal/handler/account"
+	"backend/internal/passkey"
+	"backend/internal/svc"
+	"backend/internal/testdb"
+	"backend/internal/types"
+	"backend/internal/user"
+	"backend/pkg/cognito/cognitotest"
+	"backend/pkg/config"
+	"backend/pkg/identity"
+	"backend/pkg/mailer"
+	"backend/pkg/webauthn"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	username = "alice@example.com"
+	password = "Passw0rd!"
+)
+
+type discard struct{}
+
+func (discard) Send(context.Context, mailer.Message) error { return nil }
+
+func newServer(t *testing.T) (*echo.Echo, *svc.ServiceContext) {
+	t.Helper()
+
+	pool := cognitotest.New()
+	pool.AddUser(username, password, true, map[string]string{"email": username})
+	provider := identity.NewCognitoProvider(pool, "client", "pool", "issuer", nil)
+
+	result, err := provider.SignIn(context.Background(), username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := pool.User(username)
+	principal := &authctx.Principal{Type: authctx.PrincipalUser, Subject: u.Sub, Username: username, Token: result.Tokens.AccessToken}
+
+	cfg := config.Configuration{}
+	cfg.Auth.LOGIN.BACKOFF_MAX = 30 * time.Second
+
+	db := testdb.Open(t, &types.Profile{}, &user.User{}, &passkey.Passkey{}, &passkey.Ceremony{})
+
+	e := echo.New()
+	tracer := otel.Tracer("test")
+	s := &svc.ServiceContext{
+		DB:       db,
+		Config:   cfg,
+		Echo:     e,
+		Tracer:   &tracer,
+		Cognito:  pool,
+		Identity: provider,
+		Mailer:   discard{},
+		Users:    user.NewRepository(db),
+		Passkeys: passkey.NewStore(db, time.Minute, 10),
+		WebAuthn: webauthn.New(webauthn.Config{RPID: "localhost", RPName: "Test", Origins: []string{"http://localhost:3000"}}),
+		LoginGuard: authctx.NewLoginGuard(authctx.LoginGuardOptions{
+			MaxFailures:   3,
+			IPMaxFailures: 50,
+			Lockout:       15 * time.Minute,
+			Window:        15 * time.Minute,
+		}),
+	}
+
+	e.GET("/me", account.GetProfile(s), as(principal))
+	e.PATCH("/me", account.UpdateProfile(s), as(principal))
+	e.POST("/me/change-password", account.ChangePassword(s), as(principal))
+	e.POST("/me/email", account.ChangeEmail(s), as(principal))
+	e.POST("/me/passkeys/begin", account.BeginPasskeyRegistration(s), as(principal))
+
+	return e, s
+}
+
+// as authenticates every request as principal
+func as(principal *authctx.Principal) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authctx.SetPrincipal(c, principal)
+			return next(c)
+		}
+	}
+}
+
+func changeEmail(e *echo.Echo, password string) *httptest.ResponseRecorder {
+	form := url.Values{"email": {"alice@example.org"}, "password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/me/email", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// wrong current passwords count against the account like failed sign ins
+func TestChangeEmailLoginGuard(t *testing.T) {
+	e, _ := newServer(t)
+
+	for i := 0; i < 3; i++ {
+		if rec := changeEmail(e, "Wr0ngPassword!"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d: %s", i, rec.Code, rec.Body.String())
+		}
+	}
+
+	// locked now, even the right password has to wait
+	rec := changeEmail(e, password)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	e, s := newServer(t)
+
+	if rec := changeEmail(e, "Wr0ngPassword!"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := changeEmail(e, password)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// the verified password cleared the failure
+	if wait := s.LoginGuard.Check(username, "192.0.2.1"); wait != 0 {
+		t.Fatalf("still backing off for %s", wait)
+	}
+}
@@ -0,0 +1,302 @@
+package account
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	authctx "backend/internal/auth"
+	"backend/internal/passkey"
+	"backend/internal/svc"
+	"backend/pkg/webauthn"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oklog/ulid/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Passkey is how a passkey is shown to its owner
+type Passkey struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	CredentialID   string     `json:"credentialId"`
+	Transports     []string   `json:"transports"`
+	AAGUID         string     `json:"aaguid"`
+	BackupEligible bool       `json:"backupEligible"`
+	BackedUp       bool       `json:"backedUp"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	LastUsedAt     *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+func passkeyView(p passkey.Passkey) Passkey {
+	transports := p.TransportList()
+	if transports == nil {
+		transports = []string{}
+	}
+
+	return Passkey{
+		ID:             p.ID.String(),
+		Name:           p.Name,
+		CredentialID:   p.CredentialID,
+		Transports:     transports,
+		AAGUID:         p.AAGUID,
+		BackupEligible: p.BackupEligible,
+		BackedUp:       p.BackedUp,
+		CreatedAt:      p.CreatedAt,
+		LastUsedAt:     p.LastUsedAt,
+	}
+}
+
+// PasskeyRegistration is the body of Finish Passkey Registration. Credential is
+// the result of navigator.credentials.create serialized with toJSON().
+type PasskeyRegistration struct {
+	CeremonyID string `json:"ceremonyId"`
+	Name       string `json:"name"`
+	Credential struct {
+		ID       string                       `json:"id"`
+		Type     string                       `json:"type"`
+		Response webauthn.AttestationResponse `json:"response"`
+	} `json:"credential"`
+}
+
+// @Summary Begin Passkey Registration
+// @Description Starts registering a passkey. The current password is required. Pass publicKey to navigator.credentials.create and send the result to Finish Passkey Registration with the ceremonyId.
+// @Tags Account
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Param password formData string true "Current Password"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 400 {object} auth.ErrorResponse
+// @Failure 401 {object} auth.ErrorResponse
+// @Failure 429 {object} auth.ErrorResponse
+// @Router /me/passkeys/begin [post]
+func BeginPasskeyRegistration(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.BeginPasskeyRegistration")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+
+		password := c.FormValue("password")
+		if password == "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Password is a required field",
+			})
+		}
+
+		// a passkey outlives every token, so adding one takes the password and
+		// not just an access token. Finishing needs the ceremony started here.
+		if answered, err := confirmPassword(ctx, s, c, span, principal.Username, password, passkeyError); answered {
+			return err
+		}
+
+		existing, err := s.Passkeys.List(ctx, principal.Subject)
+		if err != nil {
+			return passkeyError(c, span, err)
+		}
+		exclude := make([]webauthn.CredentialDescriptor, 0, len(existing))
+		for _, p := range existing {
+			exclude = append(exclude, p.Descriptor())
+		}
+
+		owner := passkey.Owner{Subject: principal.Subject, Username: principal.Username}
+		ceremony, challenge, err := s.Passkeys.Begin(ctx, passkey.PurposeRegistration, owner)
+		if err != nil {
+			return passkeyError(c, span, err)
+		}
+
+		displayName := principal.Email
+		if displayName == "" {
+			displayName = principal.Username
+		}
+
+		// the subject is the user handle, it is stable and carries no personal data
+		options := s.WebAuthn.CreationOptions(challenge, webauthn.User{
+			ID:          []byte(principal.Subject),
+			Name:        principal.Username,
+			DisplayName: displayName,
+		}, exclude)
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, echo.Map{
+			"ceremonyId": ceremony.ID.String(),
+			"publicKey":  options,
+		})
+	}
+}
+
+// @Summary Finish Passkey Registration
+// @Description Verifies the response of the authenticator and stores the passkey
+// @Tags Account
+// @Security BearerAuth
+// @Accept json
+// @Param body body PasskeyRegistration true "Ceremony ID, name and credential"
+// @Success 201 {object} Passkey
+// @Failure 400 {object} auth.ErrorResponse
+// @Failure 409 {object} auth.ErrorResponse
+// @Router /me/passkeys/finish [post]
+func FinishPasskeyRegistration(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.FinishPasskeyRegistration")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+
+		var req PasskeyRegistration
+		if err := c.Bind(&req); err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			span.RecordError(err)
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Invalid request body",
+				"error":   err.Error(),
+			})
+		}
+
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			name = "Passkey"
+		}
+		if len(name) > 64 {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Name may be at most 64 characters",
+			})
+		}
+
+		id, err := ulid.ParseStrict(req.CeremonyID)
+		if err != nil {
+			return passkeyError(c, span, passkey.ErrCeremonyNotFound)
+		}
+
+		ceremony, err := s.Passkeys.Take(ctx, passkey.PurposeRegistration, id)
+		if err != nil {
+			return passkeyError(c, span, err)
+		}
+		if ceremony.Subject != principal.Subject {
+			return passkeyError(c, span, passkey.ErrCeremonyNotFound)
+		}
+
+		challenge, err := ceremony.ChallengeBytes()
+		if err != nil {
+			return passkeyError(c, span, err)
+		}
+
+		cred, err := s.WebAuthn.VerifyRegistration(challenge, req.Credential.Response)
+		if err == nil && req.Credential.ID != "" && req.Credential.ID != webauthn.Encode(cred.ID) {
+			err = webauthn.ErrMalformed
+		}
+		if err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			span.RecordError(err)
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "The passkey could not be verified",
+				"error":   err.Error(),
+			})
+		}
+
+		owner := passkey.Owner{Subject: principal.Subject, Username: principal.Username}
+		p, err := s.Passkeys.Register(ctx, owner, name, cred)
+		if err != nil {
+			return passkeyError(c, span, err)
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusCreated))
+		return c.JSON(http.StatusCreated, passkeyView(*p))
+	}
+}
+
+// @Summary List Passkeys
+// @Description Lists your passkeys
+// @Tags Account
+// @Security BearerAuth
+// @Success 200 {array} Passkey
+// @Router /me/passkeys [get]
+func ListPasskeys(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.ListPasskeys")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+		passkeys, err := s.Passkeys.List(ctx, principal.Subject)
+		if err != nil {
+			return passkeyError(c, span, err)
+		}
+
+		views := make([]Passkey, 0, len(passkeys))
+		for _, p := range passkeys {
+			views = append(views, passkeyView(p))
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, views)
+	}
+}
+
+// @Summary Delete Passkey
+// @Description Deletes one of your passkeys, it can't be used to sign in anymore
+// @Tags Account
+// @Security BearerAuth
+// @Param id path string true "Passkey ID"
+// @Success 200 {object} Passkey
+// @Failure 404 {object} auth.ErrorResponse
+// @Router /me/passkeys/{id} [delete]
+func DeletePasskey(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.DeletePasskey")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+		id, err := ulid.ParseStrict(c.Param("id"))
+		if err != nil {
+			return passkeyError(c, span, passkey.ErrNotFound)
+		}
+
+		p, err := s.Passkeys.Delete(ctx, principal.Subject, id)
+		if err != nil {
+			return passkeyError(c, span, err)
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, passkeyView(*p))
+	}
+}
+
+func passkeyError(c echo.Context, span trace.Span, err error) error {
+	span.RecordError(err)
+	switch {
+	case errors.Is(err, passkey.ErrNotFound):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotFound))
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"message": "Passkey not found",
+		})
+	case errors.Is(err, passkey.ErrExists):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusConflict))
+		return c.JSON(http.StatusConflict, echo.Map{
+			"message": "This passkey is already registered",
+		})
+	case errors.Is(err, passkey.ErrLimitReached):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusConflict))
+		return c.JSON(http.StatusConflict, echo.Map{
+			"message": "You have reached the maximum number of passkeys, delete one first",
+		})
+	case errors.Is(err, passkey.ErrCeremonyNotFound), errors.Is(err, passkey.ErrCeremonyExpired):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "Passkey registration has expired, please start again",
+			"error":   err.Error(),
+		})
+	default:
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "Something went wrong while managing passkeys",
+			"error":   err.Error(),
+		})
+	}
+}
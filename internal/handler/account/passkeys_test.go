@@ -0,0 +1,54 @@
+package account_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func beginPasskeyRegistration(e *echo.Echo, password string) *httptest.ResponseRecorder {
+	form := url.Values{"password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/me/passkeys/begin", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// an access token alone must not be enough to add a passkey, which outlives it
+func TestBeginPasskeyRegistrationRequiresPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts []string
+		want     int
+		wantBody string
+	}{
+		{name: "MissingPassword", attempts: []string{""}, want: http.StatusBadRequest, wantBody: "Password is a required field"},
+		{name: "WrongPassword", attempts: []string{"Wr0ngPassword!"}, want: http.StatusUnauthorized, wantBody: "Current password is incorrect"},
+		{name: "Locked", attempts: []string{"Wr0ngPassword!", "Wr0ngPassword!", "Wr0ngPassword!", password}, want: http.StatusTooManyRequests},
+		{name: "RightPassword", attempts: []string{password}, want: http.StatusOK, wantBody: `"ceremonyId":"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _ := newServer(t)
+
+			var rec *httptest.ResponseRecorder
+			for _, attempt := range tt.attempts {
+				rec = beginPasskeyRegistration(e, attempt)
+			}
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Fatalf("body %s doesn't mention %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	authctx "backend/internal/auth"
+	"backend/internal/authz"
+	"backend/internal/svc"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// authorize asks the policy engine whether the admin may perform action on r,
+// on top of the group check of the route. When the answer is no the response
+// has been written and denied is true.
+func authorize(ctx context.Context, s *svc.ServiceContext, c echo.Context, span trace.Span, action string, r authz.Resource) (bool, error) {
+	err := s.Authz.Authorize(ctx, authctx.MustPrincipal(c), action, r)
+	if err == nil {
+		return false, nil
+	}
+
+	span.RecordError(err)
+	var denied *authz.DeniedError
+	if errors.As(err, &denied) {
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusForbidden))
+		return true, c.JSON(http.StatusForbidden, echo.Map{
+			"message": "You are not allowed to perform this action",
+			"error":   err.Error(),
+			"code":    "action_denied",
+		})
+	}
+
+	span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+	return true, c.JSON(http.StatusInternalServerError, echo.Map{
+		"message": "Something went wrong while checking permissions",
+		"error":   err.Error(),
+	})
+}
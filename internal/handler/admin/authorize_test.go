@@ -0,0 +1,89 @@
+package admin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authctx "backend/internal/auth"
+	"backend/internal/authz"
+	"backend/internal/handler/admin"
+	"backend/internal/svc"
+	"backend/pkg/cognito/cognitotest"
+	"backend/pkg/identity"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+)
+
+func newAdminServer(t *testing.T, policies ...authz.Policy) (*echo.Echo, *cognitotest.Client) {
+	t.Helper()
+
+	pool := cognitotest.New()
+	pool.AddUser("bob@example.com", "Passw0rd!", true, map[string]string{"email": "bob@example.com"})
+
+	e := echo.New()
+	tracer := otel.Tracer("test")
+	s := &svc.ServiceContext{
+		Echo:     e,
+		Tracer:   &tracer,
+		Identity: identity.NewCognitoProvider(pool, "client", "pool", "issuer", nil),
+		Authz:    authz.NewEngine(policies...),
+	}
+
+	adm := e.Group("/admin", func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authctx.SetPrincipal(c, &authctx.Principal{Type: authctx.PrincipalUser, Subject: "admin", Groups: []string{"admin"}})
+			return next(c)
+		}
+	})
+	adm.GET("/users", admin.ListUsers(s))
+	adm.GET("/users/:username", admin.GetUser(s))
+	adm.DELETE("/users/:username", admin.DeleteUser(s))
+	adm.POST("/invitations", admin.CreateInvitation(s))
+	adm.DELETE("/invitations/:id", admin.RevokeInvitation(s))
+
+	return e, pool
+}
+
+func TestAdminAuthorize(t *testing.T) {
+	// admins may do anything except deleting users and touching invitations
+	policies := append(authz.DefaultPolicies("admin"),
+		authz.Policy{Name: "keep-users", Effect: authz.EffectDeny, Actions: []string{"user:delete"}, ResourceType: "user"},
+		authz.Policy{Name: "no-invitations", Effect: authz.EffectDeny, Actions: []string{"invitation:*"}, ResourceType: "invitation"},
+	)
+
+	tests := []struct {
+		name     string
+		policies []authz.Policy
+		method   string
+		path     string
+		want     int
+	}{
+		{name: "Allowed", policies: policies, method: http.MethodGet, path: "/admin/users/bob@example.com", want: http.StatusOK},
+		{name: "DeleteUserDenied", policies: policies, method: http.MethodDelete, path: "/admin/users/bob@example.com", want: http.StatusForbidden},
+		{name: "CreateInvitationDenied", policies: policies, method: http.MethodPost, path: "/admin/invitations", want: http.StatusForbidden},
+		{name: "RevokeInvitationDenied", policies: policies, method: http.MethodDelete, path: "/admin/invitations/01ARZ3NDEKTSV4RRFFQ69G5FAV", want: http.StatusForbidden},
+		{name: "NoPolicy", method: http.MethodGet, path: "/admin/users", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, pool := newAdminServer(t, tt.policies...)
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.want == http.StatusForbidden && !strings.Contains(rec.Body.String(), "action_denied") {
+				t.Fatalf("body = %s", rec.Body.String())
+			}
+			if _, ok := pool.User("bob@example.com"); !ok {
+				t.Fatal("the user was deleted")
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"backend/internal/audit"
+	authctx "backend/internal/auth"
+	"backend/internal/authz"
+	"backend/internal/svc"
+	"backend/pkg/identity"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// @Summary List User Groups
+// @Description Lists the groups, and therefore roles, of a user
+// @Tags Admin
+// @Security BearerAuth
+// @Param username path string true "Username"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Failure 404 {object} auth.ErrorResponse
+// @Router /admin/users/{username}/groups [get]
+func ListUserGroups(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.ListUserGroups")
+		defer span.End()
+
+		if denied, err := authorize(ctx, s, c, span, "user:read_groups", authz.Resource{Type: "user", ID: c.Param("username")}); denied {
+			return err
+		}
+
+		username := c.Param("username")
+
+		groups, ok := s.Identity.(identity.GroupManager)
+		if !ok {
+			return groupsNotSupported(c, span)
+		}
+
+		list, err := groups.ListGroupsForUser(ctx, username)
+		if err != nil {
+			return groupError(c, span, err)
+		}
+		if list == nil {
+			list = []string{}
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, echo.Map{
+			"username": username,
+			"groups":   list,
+		})
+	}
+}
+
+// @Summary Add User To Group
+// @Description Grants a role by adding the user to the group. It applies to tokens issued afterwards.
+// @Tags Admin
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Param username path string true "Username"
+// @Param group formData string true "Group"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Failure 404 {object} auth.ErrorResponse
+// @Router /admin/users/{username}/groups [post]
+func AddUserToGroup(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.AddUserToGroup")
+		defer span.End()
+
+		if denied, err := authorize(ctx, s, c, span, "user:group_add", authz.Resource{Type: "user", ID: c.Param("username")}); denied {
+			return err
+		}
+
+		principal := authctx.MustPrincipal(c)
+		username := c.Param("username")
+		group := c.FormValue("group")
+		span.SetAttributes(
+			attribute.String("admin.actor", principal.Username),
+			attribute.String("admin.group", group),
+		)
+
+		if group == "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Group is a required field",
+			})
+		}
+
+		groups, ok := s.Identity.(identity.GroupManager)
+		if !ok {
+			return groupsNotSupported(c, span)
+		}
+
+		err := groups.AddUserToGroup(ctx, username, group)
+		record(ctx, s, span, audit.FromRequest(c, "user.group_add", "user", username, err, map[string]interface{}{"group": group}))
+		if err != nil {
+			return groupError(c, span, err)
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "User has been added to the group",
+		})
+	}
+}
+
+// @Summary Remove User From Group
+// @Description Revokes a role by removing the user from the group. Tokens issued before are rejected, so the role is gone once the user refreshes their tokens or signs in again.
+// @Tags Admin
+// @Security BearerAuth
+// @Param username path string true "Username"
+// @Param group path string true "Group"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Failure 404 {object} auth.ErrorResponse
+// @Router /admin/users/{username}/groups/{group} [delete]
+func RemoveUserFromGroup(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.RemoveUserFromGroup")
+		defer span.End()
+
+		if denied, err := authorize(ctx, s, c, span, "user:group_remove", authz.Resource{Type: "user", ID: c.Param("username")}); denied {
+			return err
+		}
+
+		principal := authctx.MustPrincipal(c)
+		username := c.Param("username")
+		group := c.Param("group")
+		span.SetAttributes(
+			attribute.String("admin.actor", principal.Username),
+			attribute.String("admin.group", group),
+		)
+
+		groups, ok := s.Identity.(identity.GroupManager)
+		if !ok {
+			return groupsNotSupported(c, span)
+		}
+
+		// tokens carry the groups they were issued with, the subject's tokens
+		// are rejected afterwards so the role can't outlive the removal
+		users, ok := s.Identity.(identity.UserAdministrator)
+		if !ok {
+			return usersNotSupported(c, span)
+		}
+		user, err := users.GetManagedUser(ctx, username)
+		if err != nil {
+			return groupError(c, span, err)
+		}
+
+		err = groups.RemoveUserFromGroup(ctx, username, group)
+		record(ctx, s, span, audit.FromRequest(c, "user.group_remove", "user", username, err, map[string]interface{}{"group": group}))
+		if err != nil {
+			return groupError(c, span, err)
+		}
+
+		now := time.Now()
+		s.Denylist.RevokeSubject(user.Subject, now, now.Add(s.Config.Auth.MAX_TOKEN_LIFETIME))
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "User has been removed from the group",
+		})
+	}
+}
+
+func groupsNotSupported(c echo.Context, span trace.Span) error {
+	span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotImplemented))
+	return c.JSON(http.StatusNotImplemented, echo.Map{
+		"message": "Groups are not supported by the identity provider",
+	})
+}
+
+func groupError(c echo.Context, span trace.Span, err error) error {
+	span.RecordError(err)
+	switch {
+	case errors.Is(err, identity.ErrUserNotFound):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotFound))
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"message": "User not found",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, identity.ErrNotFound):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotFound))
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"message": "Group not found",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, identity.ErrInvalidParameter):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "Invalid group or username",
+			"error":   err.Error(),
+		})
+	default:
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "Something went wrong while updating the groups",
+			"error":   err.Error(),
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package admin_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	authctx "backend/internal/auth"
+)
+
+func TestUserGroups(t *testing.T) {
+	f := newFixture(t)
+	bob := f.pool.AddUser("bob@example.com", "Passw0rd!", true, map[string]string{"email": "bob@example.com"})
+	f.pool.AddGroup("editors")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		form   url.Values
+		status int
+		body   string
+	}{
+		{name: "Add", method: http.MethodPost, path: "/admin/users/bob@example.com/groups", form: url.Values{"group": {"editors"}}, status: http.StatusOK},
+		{name: "List", method: http.MethodGet, path: "/admin/users/bob@example.com/groups", status: http.StatusOK, body: `"groups":["editors"]`},
+		{name: "AddNoGroup", method: http.MethodPost, path: "/admin/users/bob@example.com/groups", status: http.StatusBadRequest},
+		{name: "AddUnknownGroup", method: http.MethodPost, path: "/admin/users/bob@example.com/groups", form: url.Values{"group": {"owners"}}, status: http.StatusNotFound, body: "Group not found"},
+		{name: "AddUnknownUser", method: http.MethodPost, path: "/admin/users/nobody@example.com/groups", form: url.Values{"group": {"editors"}}, status: http.StatusNotFound, body: "User not found"},
+		{name: "Remove", method: http.MethodDelete, path: "/admin/users/bob@example.com/groups/editors", status: http.StatusOK},
+		{name: "ListAfterRemove", method: http.MethodGet, path: "/admin/users/bob@example.com/groups", status: http.StatusOK, body: `"groups":[]`},
+		{name: "RemoveUnknownUser", method: http.MethodDelete, path: "/admin/users/nobody@example.com/groups/editors", status: http.StatusNotFound, body: "User not found"},
+	}
+
+	// runs in order, each case sees the groups the ones before left
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := f.do(tt.method, tt.path, tt.form)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.body) {
+				t.Fatalf("body = %s, want %s", rec.Body.String(), tt.body)
+			}
+		})
+	}
+
+	if events := f.audited(t, "user.group_add"); len(events) != 3 {
+		t.Fatalf("recorded %d group additions, want 3", len(events))
+	}
+	if events := f.audited(t, "user.group_remove"); len(events) != 1 {
+		t.Fatalf("recorded %d group removals, want 1", len(events))
+	}
+
+	// tokens issued before the removal still carry the group
+	before := &authctx.Principal{Subject: bob.Sub, Groups: []string{"editors"}, IssuedAt: time.Now().Add(-time.Second)}
+	if !f.s.Denylist.IsRevoked(before) {
+		t.Fatal("a token issued before the removal is still accepted")
+	}
+	after := &authctx.Principal{Subject: bob.Sub, IssuedAt: time.Now().Add(time.Second)}
+	if f.s.Denylist.IsRevoked(after) {
+		t.Fatal("a token issued after the removal is rejected")
+	}
+}
@@ -0,0 +1,344 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/audit"
+	authctx "backend/internal/auth"
+	"backend/internal/authz"
+	"backend/internal/invitation"
+	"backend/internal/svc"
+	"backend/pkg/identity"
+	"backend/pkg/mailer"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oklog/ulid/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Invitation is the admin view of an invitation
+type Invitation struct {
+	ID         string     `json:"id"`
+	Username   string     `json:"username"`
+	Email      string     `json:"email"`
+	Delivery   string     `json:"delivery"`
+	InvitedBy  string     `json:"invitedBy"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	AcceptedAt *time.Time `json:"acceptedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+func invitationView(inv invitation.Invitation, now time.Time) Invitation {
+	return Invitation{
+		ID:         inv.ID.String(),
+		Username:   inv.Username,
+		Email:      inv.Email,
+		Delivery:   inv.Delivery,
+		InvitedBy:  inv.InvitedBy,
+		Status:     inv.Status(now),
+		CreatedAt:  inv.CreatedAt,
+		ExpiresAt:  inv.ExpiresAt,
+		AcceptedAt: inv.AcceptedAt,
+		RevokedAt:  inv.RevokedAt,
+	}
+}
+
+// @Summary Invite User
+// @Description Creates an account for someone else. With delivery link (default) the invitee gets a link to set their password, with delivery password the identity provider mails a temporary password they have to replace on first sign in.
+// @Tags Admin
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Param email formData string true "Email of the invitee"
+// @Param firstName formData string false "First Name"
+// @Param lastName formData string false "Last Name"
+// @Param delivery formData string false "link or password"
+// @Success 201 {object} Invitation
+// @Failure 400 {object} auth.ErrorResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Failure 409 {object} auth.ErrorResponse
+// @Router /admin/invitations [post]
+func CreateInvitation(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.CreateInvitation")
+		defer span.End()
+
+		if denied, err := authorize(ctx, s, c, span, "invitation:create", authz.Resource{Type: "invitation"}); denied {
+			return err
+		}
+
+		email := strings.ToLower(strings.TrimSpace(c.FormValue("email")))
+		delivery := c.FormValue("delivery")
+		if delivery == "" {
+			delivery = invitation.DeliveryLink
+		}
+		span.SetAttributes(attribute.String("invitation.delivery", delivery))
+
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Email is not a valid address",
+			})
+		}
+
+		if delivery != invitation.DeliveryLink && delivery != invitation.DeliveryPassword {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Delivery must be link or password",
+			})
+		}
+
+		inviter, ok := s.Identity.(identity.Inviter)
+		if !ok {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotImplemented))
+			return c.JSON(http.StatusNotImplemented, echo.Map{
+				"message": "Invitations are not supported by the identity provider",
+			})
+		}
+
+		input := identity.InviteInput{
+			Username:  email,
+			Email:     email,
+			FirstName: c.FormValue("firstName"),
+			LastName:  c.FormValue("lastName"),
+		}
+
+		var token string
+		if delivery == invitation.DeliveryLink {
+			var err error
+			if token, err = invitation.NewToken(); err != nil {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+				span.RecordError(err)
+				return c.JSON(http.StatusInternalServerError, echo.Map{
+					"message": "Something went wrong while creating the invitation",
+					"error":   err.Error(),
+				})
+			}
+			// the link token is the temporary password, we send it ourselves
+			input.TemporaryPassword = token
+			input.SuppressMessage = true
+		}
+
+		managed, err := inviter.InviteUser(ctx, input)
+		record(ctx, s, span, audit.FromRequest(c, "invitation.create", "user", email, err, map[string]interface{}{
+			"delivery": delivery,
+		}))
+		if err != nil {
+			span.RecordError(err)
+			if errors.Is(err, identity.ErrUserExists) {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusConflict))
+				return c.JSON(http.StatusConflict, echo.Map{
+					"message": "An account with the given email already exists",
+					"error":   err.Error(),
+				})
+			}
+			return userError(c, span, err)
+		}
+		if _, err := s.Users.SyncManagedUser(ctx, managed); err != nil {
+			span.RecordError(err)
+		}
+
+		principal := authctx.MustPrincipal(c)
+		inv, err := s.Invitations.Create(ctx, email, email, delivery, principal.Username, token)
+		if err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			span.RecordError(err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "The account was created but the invitation could not be stored",
+				"error":   err.Error(),
+			})
+		}
+
+		if delivery == invitation.DeliveryLink {
+			link := s.Config.Auth.INVITATION.URL + "?token=" + url.QueryEscape(token)
+			err := s.Mailer.Send(ctx, mailer.Message{
+				To:      email,
+				Subject: "You have been invited",
+				Body: fmt.Sprintf("An account has been created for you. Follow this link to choose your password: %s\n\n"+
+					"The link is valid until %s.", link, inv.ExpiresAt.UTC().Format(time.RFC1123)),
+			})
+			if err != nil {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadGateway))
+				span.RecordError(err)
+				return c.JSON(http.StatusBadGateway, echo.Map{
+					"message":    "The invitation was created but the email could not be sent, revoke it and try again",
+					"error":      err.Error(),
+					"invitation": invitationView(*inv, time.Now()),
+				})
+			}
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusCreated))
+		return c.JSON(http.StatusCreated, invitationView(*inv, time.Now()))
+	}
+}
+
+// @Summary List Invitations
+// @Description Lists invitations newest first
+// @Tags Admin
+// @Security BearerAuth
+// @Param status query string false "pending, accepted, revoked or expired"
+// @Param limit query int false "Page size, at most 100"
+// @Param offset query int false "Number of invitations to skip"
+// @Success 200 {array} Invitation
+// @Failure 400 {object} auth.ErrorResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Router /admin/invitations [get]
+func ListInvitations(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.ListInvitations")
+		defer span.End()
+
+		if denied, err := authorize(ctx, s, c, span, "invitation:list", authz.Resource{Type: "invitation"}); denied {
+			return err
+		}
+
+		status := c.QueryParam("status")
+		switch status {
+		case "", invitation.StatusPending, invitation.StatusAccepted, invitation.StatusRevoked, invitation.StatusExpired:
+		default:
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Status must be pending, accepted, revoked or expired",
+			})
+		}
+
+		limit, offset := 50, 0
+		if v := c.QueryParam("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 100 {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Limit must be between 1 and 100",
+				})
+			}
+			limit = n
+		}
+		if v := c.QueryParam("offset"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Offset must not be negative",
+				})
+			}
+			offset = n
+		}
+
+		invitations, err := s.Invitations.List(ctx, status, limit, offset)
+		if err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			span.RecordError(err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something went wrong while listing invitations",
+				"error":   err.Error(),
+			})
+		}
+
+		now := time.Now()
+		views := make([]Invitation, 0, len(invitations))
+		for _, inv := range invitations {
+			views = append(views, invitationView(inv, now))
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, views)
+	}
+}
+
+// @Summary Revoke Invitation
+// @Description Revokes an invitation that hasn't been accepted. The account created for it is deleted while it still waits for its first password.
+// @Tags Admin
+// @Security BearerAuth
+// @Param id path string true "Invitation ID"
+// @Success 200 {object} Invitation
+// @Failure 400 {object} auth.ErrorResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Failure 404 {object} auth.ErrorResponse
+// @Failure 409 {object} auth.ErrorResponse
+// @Router /admin/invitations/{id} [delete]
+func RevokeInvitation(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.RevokeInvitation")
+		defer span.End()
+
+		if denied, err := authorize(ctx, s, c, span, "invitation:revoke", authz.Resource{Type: "invitation", ID: c.Param("id")}); denied {
+			return err
+		}
+
+		id, err := ulid.ParseStrict(c.Param("id"))
+		if err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Invitation ID is not valid",
+			})
+		}
+
+		inv, err := s.Invitations.Get(ctx, id)
+		if err != nil {
+			return invitationError(c, span, err)
+		}
+
+		err = s.Invitations.Revoke(ctx, inv)
+		record(ctx, s, span, audit.FromRequest(c, "invitation.revoke", "user", inv.Username, err, map[string]interface{}{
+			"invitation": inv.ID.String(),
+		}))
+		if err != nil {
+			return invitationError(c, span, err)
+		}
+
+		// the account only exists because of the invitation as long as the
+		// invitee hasn't chosen a password
+		if users, ok := s.Identity.(identity.UserAdministrator); ok {
+			user, err := users.GetManagedUser(ctx, inv.Username)
+			switch {
+			case err == nil && user.Status == identity.StatusForceChangePassword:
+				if err := users.DeleteUser(ctx, inv.Username); err != nil {
+					span.RecordError(err)
+				} else if err := s.Users.MarkDeleted(ctx, user.Subject); err != nil {
+					span.RecordError(err)
+				}
+			case err != nil && !errors.Is(err, identity.ErrUserNotFound):
+				span.RecordError(err)
+			}
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, invitationView(*inv, time.Now()))
+	}
+}
+
+func invitationError(c echo.Context, span trace.Span, err error) error {
+	span.RecordError(err)
+	switch {
+	case errors.Is(err, invitation.ErrNotFound):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotFound))
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"message": "Invitation not found",
+		})
+	case errors.Is(err, invitation.ErrAccepted), errors.Is(err, invitation.ErrRevoked):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusConflict))
+		return c.JSON(http.StatusConflict, echo.Map{
+			"message": "Only pending invitations can be revoked",
+			"error":   err.Error(),
+		})
+	default:
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "Something went wrong while revoking the invitation",
+			"error":   err.Error(),
+		})
+	}
+}
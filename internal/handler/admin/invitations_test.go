@@ -0,0 +1,379 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/apikey"
+	"backend/internal/audit"
+	authctx "backend/internal/auth"
+	"backend/internal/authz"
+	"backend/internal/handler/admin"
+	"backend/internal/invitation"
+	"backend/internal/passkey"
+	"backend/internal/svc"
+	"backend/internal/testdb"
+	"backend/internal/user"
+	"backend/pkg/cognito/cognitotest"
+	"backend/pkg/config"
+	"backend/pkg/identity"
+	"backend/pkg/mailer"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+)
+
+const adminUsername = "admin@example.com"
+
+// outbox keeps the mails sent instead of delivering them
+type outbox []mailer.Message
+
+func (o *outbox) Send(_ context.Context, msg mailer.Message) error {
+	*o = append(*o, msg)
+	return nil
+}
+
+type fixture struct {
+	echo *echo.Echo
+	s    *svc.ServiceContext
+	pool *cognitotest.Client
+	mail *outbox
+}
+
+// newFixture serves the admin routes to an admin of the default policies, with
+// the stores the handlers write to in a throwaway database
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	db := testdb.Open(t, &audit.Event{}, &user.User{}, &invitation.Invitation{}, &apikey.APIKey{}, &passkey.Passkey{}, &passkey.Ceremony{})
+	pool := cognitotest.New()
+	mail := &outbox{}
+
+	cfg := config.Configuration{}
+	cfg.Auth.MAX_TOKEN_LIFETIME = time.Hour
+	cfg.Auth.INVITATION.URL = "https://app.example.com/invitation"
+
+	e := echo.New()
+	tracer := otel.Tracer("test")
+	s := &svc.ServiceContext{
+		Config:      cfg,
+		DB:          db,
+		Echo:        e,
+		Tracer:      &tracer,
+		Cognito:     pool,
+		Identity:    identity.NewCognitoProvider(pool, "client", "pool", "issuer", nil),
+		Denylist:    authctx.NewMemoryDenylist(),
+		Authz:       authz.NewEngine(authz.DefaultPolicies("admin")...),
+		Mailer:      mail,
+		Audit:       audit.NewDBRecorder(db),
+		Users:       user.NewRepository(db),
+		Invitations: invitation.NewStore(db, time.Hour),
+		APIKeys:     apikey.NewStore(db, 0, time.Hour),
+		Passkeys:    passkey.NewStore(db, time.Minute, 10),
+	}
+
+	adm := e.Group("/admin", func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authctx.SetPrincipal(c, &authctx.Principal{Type: authctx.PrincipalUser, Subject: "admin", Username: adminUsername, Groups: []string{"admin"}})
+			return next(c)
+		}
+	})
+	adm.GET("/invitations", admin.ListInvitations(s))
+	adm.POST("/invitations", admin.CreateInvitation(s))
+	adm.DELETE("/invitations/:id", admin.RevokeInvitation(s))
+	adm.GET("/users", admin.ListUsers(s))
+	adm.GET("/users/:username", admin.GetUser(s))
+	adm.DELETE("/users/:username", admin.DeleteUser(s))
+	adm.POST("/users/:username/disable", admin.DisableUser(s))
+	adm.POST("/users/:username/enable", admin.EnableUser(s))
+	adm.POST("/users/:username/reset-password", admin.ResetUserPassword(s))
+	adm.POST("/users/:username/signout", admin.SignOutUser(s))
+	adm.POST("/users/:username/resend-invitation", admin.ResendInvitation(s))
+	adm.GET("/users/:username/groups", admin.ListUserGroups(s))
+	adm.POST("/users/:username/groups", admin.AddUserToGroup(s))
+	adm.DELETE("/users/:username/groups/:group", admin.RemoveUserFromGroup(s))
+
+	return &fixture{echo: e, s: s, pool: pool, mail: mail}
+}
+
+// do sends a request with form as multipart body
+func (f *fixture) do(method, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+
+	rec := httptest.NewRecorder()
+	f.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+// audited returns the audit events of action, oldest first
+func (f *fixture) audited(t *testing.T, action string) []audit.Event {
+	t.Helper()
+
+	var events []audit.Event
+	if err := f.s.DB.Where("action = ?", action).Order("created_at").Find(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// invite creates an invitation for email through the handler
+func (f *fixture) invite(t *testing.T, email, delivery string) *invitation.Invitation {
+	t.Helper()
+
+	rec := f.do(http.MethodPost, "/admin/invitations", url.Values{"email": {email}, "delivery": {delivery}})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("invite: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	inv, err := f.s.Invitations.Latest(context.Background(), email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inv
+}
+
+func TestResendInvitation(t *testing.T) {
+	t.Run("Password", func(t *testing.T) {
+		f := newFixture(t)
+		inv := f.invite(t, "carol@example.com", invitation.DeliveryPassword)
+		before, _ := f.pool.User("carol@example.com")
+		oldPassword := before.Password
+
+		// the invitation ran out before the invitee got to it
+		if err := f.s.DB.Model(inv).Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		rec := f.do(http.MethodPost, "/admin/users/carol@example.com/resend-invitation", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+
+		after, _ := f.pool.User("carol@example.com")
+		if after.Password == oldPassword {
+			t.Fatal("the temporary password wasn't renewed")
+		}
+		got, err := f.s.Invitations.Get(context.Background(), inv.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := got.Status(time.Now()); status != invitation.StatusPending {
+			t.Fatalf("status = %s, want %s", status, invitation.StatusPending)
+		}
+		if events := f.audited(t, "user.resend_invitation"); len(events) != 1 || events[0].Outcome != audit.OutcomeSuccess {
+			t.Fatalf("audit = %+v", events)
+		}
+	})
+
+	t.Run("Link", func(t *testing.T) {
+		f := newFixture(t)
+		f.invite(t, "carol@example.com", invitation.DeliveryLink)
+		before, _ := f.pool.User("carol@example.com")
+		token := before.Password
+
+		rec := f.do(http.MethodPost, "/admin/users/carol@example.com/resend-invitation", nil)
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+
+		// the link that was mailed still works
+		after, _ := f.pool.User("carol@example.com")
+		if after.Password != token {
+			t.Fatal("the temporary password was replaced")
+		}
+	})
+
+	t.Run("Revoked", func(t *testing.T) {
+		f := newFixture(t)
+		inv := f.invite(t, "carol@example.com", invitation.DeliveryPassword)
+		if err := f.s.Invitations.Revoke(context.Background(), inv); err != nil {
+			t.Fatal(err)
+		}
+
+		rec := f.do(http.MethodPost, "/admin/users/carol@example.com/resend-invitation", nil)
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("NotInvited", func(t *testing.T) {
+		f := newFixture(t)
+		f.pool.AddUser("carol@example.com", "Passw0rd!", true, map[string]string{"email": "carol@example.com"})
+
+		// the identity provider only resends to accounts waiting for their first password
+		rec := f.do(http.MethodPost, "/admin/users/carol@example.com/resend-invitation", nil)
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if events := f.audited(t, "user.resend_invitation"); len(events) != 1 || events[0].Outcome != audit.OutcomeFailure {
+			t.Fatalf("audit = %+v", events)
+		}
+	})
+}
+
+func TestCreateInvitation(t *testing.T) {
+	t.Run("Link", func(t *testing.T) {
+		f := newFixture(t)
+		inv := f.invite(t, "carol@example.com", invitation.DeliveryLink)
+
+		u, ok := f.pool.User("carol@example.com")
+		if !ok || !u.ForceChangePassword {
+			t.Fatalf("user = %+v, want an account waiting for its first password", u)
+		}
+		if len(*f.mail) != 1 {
+			t.Fatalf("sent %d mails, want 1", len(*f.mail))
+		}
+		// the mailed link carries the temporary password as token
+		msg := (*f.mail)[0]
+		if msg.To != "carol@example.com" || !strings.Contains(msg.Body, "?token="+url.QueryEscape(u.Password)) {
+			t.Fatalf("mail = %+v", msg)
+		}
+		if got, err := f.s.Invitations.ByToken(context.Background(), u.Password); err != nil || got.ID != inv.ID {
+			t.Fatalf("ByToken() = %v, %v", got, err)
+		}
+		if events := f.audited(t, "invitation.create"); len(events) != 1 || events[0].ActorUsername != adminUsername {
+			t.Fatalf("audit = %+v", events)
+		}
+	})
+
+	t.Run("Password", func(t *testing.T) {
+		f := newFixture(t)
+		inv := f.invite(t, "carol@example.com", invitation.DeliveryPassword)
+
+		// the identity provider mails the temporary password
+		if len(*f.mail) != 0 {
+			t.Fatalf("sent %d mails, want none", len(*f.mail))
+		}
+		if inv.TokenHash != "" || inv.Delivery != invitation.DeliveryPassword {
+			t.Fatalf("invitation = %+v", inv)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+	}{
+		{name: "InvalidEmail", form: url.Values{"email": {"carol"}}, status: http.StatusBadRequest},
+		{name: "InvalidDelivery", form: url.Values{"email": {"carol@example.com"}, "delivery": {"pigeon"}}, status: http.StatusBadRequest},
+		{name: "Exists", form: url.Values{"email": {"bob@example.com"}}, status: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.pool.AddUser("bob@example.com", "Passw0rd!", true, map[string]string{"email": "bob@example.com"})
+
+			rec := f.do(http.MethodPost, "/admin/invitations", tt.form)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if invitations, _ := f.s.Invitations.List(context.Background(), "", 10, 0); len(invitations) != 0 {
+				t.Fatalf("stored %d invitations", len(invitations))
+			}
+		})
+	}
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	t.Run("Pending", func(t *testing.T) {
+		f := newFixture(t)
+		inv := f.invite(t, "carol@example.com", invitation.DeliveryLink)
+
+		rec := f.do(http.MethodDelete, "/admin/invitations/"+inv.ID.String(), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if _, ok := f.pool.User("carol@example.com"); ok {
+			t.Fatal("the account waiting for its first password was kept")
+		}
+		got, err := f.s.Invitations.Get(context.Background(), inv.ID)
+		if err != nil || got.Status(time.Now()) != invitation.StatusRevoked {
+			t.Fatalf("Get() = %+v, %v", got, err)
+		}
+		if events := f.audited(t, "invitation.revoke"); len(events) != 1 || events[0].Target != "carol@example.com" {
+			t.Fatalf("audit = %+v", events)
+		}
+	})
+
+	t.Run("Accepted", func(t *testing.T) {
+		f := newFixture(t)
+		inv := f.invite(t, "carol@example.com", invitation.DeliveryLink)
+		if err := f.s.Invitations.Accept(context.Background(), inv); err != nil {
+			t.Fatal(err)
+		}
+		f.pool.Update("carol@example.com", func(u *cognitotest.User) { u.ForceChangePassword = false })
+
+		rec := f.do(http.MethodDelete, "/admin/invitations/"+inv.ID.String(), nil)
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if _, ok := f.pool.User("carol@example.com"); !ok {
+			t.Fatal("the account of an accepted invitation was deleted")
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		f := newFixture(t)
+
+		if rec := f.do(http.MethodDelete, "/admin/invitations/01ARZ3NDEKTSV4RRFFQ69G5FAV", nil); rec.Code != http.StatusNotFound {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if rec := f.do(http.MethodDelete, "/admin/invitations/nope", nil); rec.Code != http.StatusBadRequest {
+			t.Fatalf("invalid ID: status = %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestListInvitations(t *testing.T) {
+	f := newFixture(t)
+	f.invite(t, "carol@example.com", invitation.DeliveryLink)
+	revoked := f.invite(t, "dave@example.com", invitation.DeliveryPassword)
+	if err := f.s.Invitations.Revoke(context.Background(), revoked); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query  string
+		status int
+		want   []string
+	}{
+		{query: "", status: http.StatusOK, want: []string{"dave@example.com", "carol@example.com"}},
+		{query: "?status=pending", status: http.StatusOK, want: []string{"carol@example.com"}},
+		{query: "?status=revoked", status: http.StatusOK, want: []string{"dave@example.com"}},
+		{query: "?limit=1&offset=1", status: http.StatusOK, want: []string{"carol@example.com"}},
+		{query: "?status=lost", status: http.StatusBadRequest},
+		{query: "?limit=0", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := f.do(http.MethodGet, "/admin/invitations"+tt.query, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var got []admin.Invitation
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			var usernames []string
+			for _, inv := range got {
+				usernames = append(usernames, inv.Username)
+			}
+			if strings.Join(usernames, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("invitations = %v, want %v", usernames, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"net/http"
+
+	"backend/internal/audit"
+	authctx "backend/internal/auth"
+	"backend/internal/authz"
+	"backend/internal/svc"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// @Summary Unlock Sign In
+// @Description Lifts the brute-force lockout and backoff of a username and/or a client IP
+// @Tags Admin
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Param username formData string false "Username"
+// @Param ip formData string false "Client IP"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 400 {object} auth.ErrorResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Router /admin/login-protection/unlock [post]
+func UnlockLogin(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.UnlockLogin")
+		defer span.End()
+
+		if denied, err := authorize(ctx, s, c, span, "login:unlock", authz.Resource{Type: "login"}); denied {
+			return err
+		}
+
+		principal := authctx.MustPrincipal(c)
+		username := c.FormValue("username")
+		ip := c.FormValue("ip")
+
+		if username == "" && ip == "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Username or ip is required",
+			})
+		}
+
+		unlocked := s.LoginGuard.Unlock(username, ip)
+		record(ctx, s, span, audit.FromRequest(c, "login.unlock", "user", username, nil, map[string]interface{}{
+			"ip":       ip,
+			"unlocked": unlocked,
+		}))
+		span.SetAttributes(
+			attribute.Key("http.status_code").Int(http.StatusOK),
+			attribute.String("admin.actor", principal.Username),
+			attribute.Bool("auth.unlocked", unlocked),
+		)
+		return c.JSON(http.StatusOK, echo.Map{
+			"message":  "Sign in has been unlocked",
+			"unlocked": unlocked,
+		})
+	}
+}
@@ -0,0 +1,418 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/audit"
+	"backend/internal/authz"
+	"backend/internal/invitation"
+	"backend/internal/svc"
+	"backend/pkg/identity"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// searchableAttributes are the attributes the user list can be filtered by
+var searchableAttributes = map[string]bool{
+	"username":            true,
+	"email":               true,
+	"phone_number":        true,
+	"name":                true,
+	"given_name":          true,
+	"family_name":         true,
+	"preferred_username":  true,
+	"sub":                 true,
+	"status":              true,
+	"cognito:user_status": true,
+}
+
+// @Summary List Users
+// @Description Lists users page by page. search filters by email prefix; attribute and value filter by any searchable attribute.
+// @Tags Admin
+// @Security BearerAuth
+// @Param search query string false "Email prefix"
+// @Param attribute query string false "Attribute to filter by"
+// @Param value query string false "Attribute value"
+// @Param match query string false "exact or prefix (default)"
+// @Param limit query int false "Page size, at most 60"
+// @Param pageToken query string false "Token of the next page"
+// @Success 200 {object} identity.UserPage
+// @Failure 400 {object} auth.ErrorResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Router /admin/users [get]
+func ListUsers(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.ListUsers")
+		defer span.End()
+
+		if denied, err := authorize(ctx, s, c, span, "user:list", authz.Resource{Type: "user"}); denied {
+			return err
+		}
+
+		users, ok := s.Identity.(identity.UserAdministrator)
+		if !ok {
+			return usersNotSupported(c, span)
+		}
+
+		input := identity.ListUsersInput{
+			Attribute: c.QueryParam("attribute"),
+			Value:     c.QueryParam("value"),
+			Prefix:    c.QueryParam("match") != "exact",
+			PageToken: c.QueryParam("pageToken"),
+		}
+		if search := c.QueryParam("search"); search != "" {
+			input.Attribute, input.Value, input.Prefix = "email", strings.ToLower(search), true
+		}
+
+		if input.Attribute != "" && !searchableAttributes[input.Attribute] {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Users can't be filtered by " + input.Attribute,
+			})
+		}
+
+		if limit := c.QueryParam("limit"); limit != "" {
+			n, err := strconv.ParseInt(limit, 10, 64)
+			if err != nil || n < 1 || n > 60 {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Limit must be between 1 and 60",
+				})
+			}
+			input.Limit = n
+		}
+
+		page, err := users.ListUsers(ctx, input)
+		if err != nil {
+			return userError(c, span, err)
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, page)
+	}
+}
+
+// @Summary Get User
+// @Description Returns a user with status and attributes
+// @Tags Admin
+// @Security BearerAuth
+// @Param username path string true "Username"
+// @Success 200 {object} identity.ManagedUser
+// @Failure 403 {object} auth.ErrorResponse
+// @Failure 404 {object} auth.ErrorResponse
+// @Router /admin/users/{username} [get]
+func GetUser(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.GetUser")
+		defer span.End()
+
+		if denied, err := authorize(ctx, s, c, span, "user:read", authz.Resource{Type: "user", ID: c.Param("username")}); denied {
+			return err
+		}
+
+		users, ok := s.Identity.(identity.UserAdministrator)
+		if !ok {
+			return usersNotSupported(c, span)
+		}
+
+		user, err := users.GetManagedUser(ctx, c.Param("username"))
+		if err != nil {
+			return userError(c, span, err)
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, user)
+	}
+}
+
+// @Summary Disable User
+// @Description Disables the user and rejects the tokens and API keys already issued to them
+// @Tags Admin
+// @Security BearerAuth
+// @Param username path string true "Username"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Failure 404 {object} auth.ErrorResponse
+// @Router /admin/users/{username}/disable [post]
+func DisableUser(s *svc.ServiceContext) echo.HandlerFunc {
+	return userAction(s, "handler.DisableUser", "user.disable", "User has been disabled", revokeTokens,
+		identity.UserAdministrator.DisableUser)
+}
+
+// @Summary Enable User
+// @Description Enables a disabled user
+// @Tags Admin
+// @Security BearerAuth
+// @Param username path string true "Username"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Failure 404 {object} auth.ErrorResponse
+// @Router /admin/users/{username}/enable [post]
+func EnableUser(s *svc.ServiceContext) echo.HandlerFunc {
+	return userAction(s, "handler.EnableUser", "user.enable", "User has been enabled", revokeNothing,
+		identity.UserAdministrator.EnableUser)
+}
+
+// @Summary Force Password Reset
+// @Description Invalidates the user's password, sends a reset code and revokes the user's API keys
+// @Tags Admin
+// @Security BearerAuth
+// @Param username path string true "Username"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Failure 404 {object} auth.ErrorResponse
+// @Router /admin/users/{username}/reset-password [post]
+func ResetUserPassword(s *svc.ServiceContext) echo.HandlerFunc {
+	return userAction(s, "handler.ResetUserPassword", "user.reset_password", "User has to reset their password", revokeTokens,
+		identity.UserAdministrator.ResetUserPassword)
+}
+
+// @Summary Delete User
+// @Description Deletes the user, rejects the tokens and API keys already issued to them and removes their passkeys
+// @Tags Admin
+// @Security BearerAuth
+// @Param username path string true "Username"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Failure 404 {object} auth.ErrorResponse
+// @Router /admin/users/{username} [delete]
+func DeleteUser(s *svc.ServiceContext) echo.HandlerFunc {
+	return userAction(s, "handler.DeleteUser", "user.delete", "User has been deleted", revokeEverything,
+		identity.UserAdministrator.DeleteUser)
+}
+
+// @Summary Sign Out User
+// @Description Ends every session of the user on all devices and revokes their API keys
+// @Tags Admin
+// @Security BearerAuth
+// @Param username path string true "Username"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Failure 404 {object} auth.ErrorResponse
+// @Router /admin/users/{username}/signout [post]
+func SignOutUser(s *svc.ServiceContext) echo.HandlerFunc {
+	return userAction(s, "handler.SignOutUser", "user.signout", "User has been signed out on all devices", revokeTokens,
+		identity.UserAdministrator.SignOutUser)
+}
+
+// @Summary Resend Invitation
+// @Description Sends a new temporary password to a user who hasn't accepted their invitation yet and restarts the invitation's validity. Link invitations can't be resent, the temporary password is their token: revoke them and invite again.
+// @Tags Admin
+// @Security BearerAuth
+// @Param username path string true "Username"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Failure 404 {object} auth.ErrorResponse
+// @Failure 409 {object} auth.ErrorResponse
+// @Router /admin/users/{username}/resend-invitation [post]
+func ResendInvitation(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.ResendInvitation")
+		defer span.End()
+
+		username := c.Param("username")
+		span.SetAttributes(attribute.String("admin.action", "user.resend_invitation"))
+
+		if denied, err := authorize(ctx, s, c, span, "user:resend_invitation", authz.Resource{Type: "user", ID: username}); denied {
+			return err
+		}
+
+		users, ok := s.Identity.(identity.UserAdministrator)
+		if !ok {
+			return usersNotSupported(c, span)
+		}
+
+		// accounts created before invitations were tracked have no row
+		inv, err := s.Invitations.Latest(ctx, username)
+		switch {
+		case errors.Is(err, invitation.ErrNotFound):
+			inv = nil
+		case err != nil:
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			span.RecordError(err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something went wrong while looking up the invitation",
+				"error":   err.Error(),
+			})
+		}
+
+		if inv != nil {
+			// a new temporary password would break the link the invitee got
+			if inv.Delivery == invitation.DeliveryLink {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusConflict))
+				return c.JSON(http.StatusConflict, echo.Map{
+					"message": "Link invitations can't be sent again, revoke the invitation and invite the user again",
+				})
+			}
+			if err := inv.Usable(time.Now()); err != nil && !errors.Is(err, invitation.ErrExpired) {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusConflict))
+				span.RecordError(err)
+				return c.JSON(http.StatusConflict, echo.Map{
+					"message": "Only pending invitations can be sent again",
+					"error":   err.Error(),
+				})
+			}
+		}
+
+		err = users.ResendInvitation(ctx, username)
+		record(ctx, s, span, audit.FromRequest(c, "user.resend_invitation", "user", username, err, nil))
+		if err != nil {
+			return userError(c, span, err)
+		}
+
+		if inv != nil {
+			if err := s.Invitations.Extend(ctx, inv); err != nil {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+				span.RecordError(err)
+				return c.JSON(http.StatusInternalServerError, echo.Map{
+					"message": "The invitation was sent again but its validity could not be extended",
+					"error":   err.Error(),
+				})
+			}
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "Invitation has been sent again",
+		})
+	}
+}
+
+// revocation says which credentials of a user an admin action takes away
+type revocation int
+
+const (
+	revokeNothing revocation = iota
+	// revokeTokens rejects tokens issued before the action and revokes the
+	// user's API keys, which would outlive the denylist entry
+	revokeTokens
+	// revokeEverything also deletes the user's passkeys, for accounts that are gone
+	revokeEverything
+)
+
+// userAction runs a single user pool operation on the :username path parameter
+// and records it in the audit trail. Credentials already issued to the user are
+// rejected from now on as far as revoke says.
+func userAction(s *svc.ServiceContext, spanName, action, message string, revoke revocation,
+	fn func(identity.UserAdministrator, context.Context, string) error) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), spanName)
+		defer span.End()
+
+		username := c.Param("username")
+		span.SetAttributes(attribute.String("admin.action", action))
+
+		// audit actions are named <type>.<verb>, policy actions <type>:<verb>
+		if denied, err := authorize(ctx, s, c, span, strings.Replace(action, ".", ":", 1), authz.Resource{Type: "user", ID: username}); denied {
+			return err
+		}
+
+		users, ok := s.Identity.(identity.UserAdministrator)
+		if !ok {
+			return usersNotSupported(c, span)
+		}
+
+		var sub string
+		if revoke != revokeNothing {
+			user, err := users.GetManagedUser(ctx, username)
+			if err != nil {
+				return userError(c, span, err)
+			}
+			sub = user.Subject
+		}
+
+		err := fn(users, ctx, username)
+		record(ctx, s, span, audit.FromRequest(c, action, "user", username, err, nil))
+		if err != nil {
+			return userError(c, span, err)
+		}
+
+		if revoke == revokeEverything {
+			if err := s.Users.MarkDeleted(ctx, sub); err != nil {
+				span.RecordError(err)
+			}
+		} else if _, err := s.Users.Refresh(ctx, users, username); err != nil {
+			span.RecordError(err)
+		}
+
+		if sub != "" {
+			now := time.Now()
+			s.Denylist.RevokeSubject(sub, now, now.Add(s.Config.Auth.MAX_TOKEN_LIFETIME))
+			if err := s.APIKeys.RevokeAll(ctx, sub); err != nil {
+				span.RecordError(err)
+			}
+		}
+		if sub != "" && revoke == revokeEverything {
+			if err := s.Passkeys.DeleteAll(ctx, sub); err != nil {
+				span.RecordError(err)
+			}
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": message,
+		})
+	}
+}
+
+// record writes an audit event. A failing audit write doesn't fail the request
+// as the action has already happened.
+func record(ctx context.Context, s *svc.ServiceContext, span trace.Span, event *audit.Event) {
+	if err := s.Audit.Record(ctx, event); err != nil {
+		span.RecordError(err)
+	}
+}
+
+func usersNotSupported(c echo.Context, span trace.Span) error {
+	span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotImplemented))
+	return c.JSON(http.StatusNotImplemented, echo.Map{
+		"message": "User management is not supported by the identity provider",
+	})
+}
+
+func userError(c echo.Context, span trace.Span, err error) error {
+	span.RecordError(err)
+	switch {
+	case errors.Is(err, identity.ErrUserNotFound):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotFound))
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"message": "User not found",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, identity.ErrInvalidState):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusConflict))
+		return c.JSON(http.StatusConflict, echo.Map{
+			"message": "The operation is not possible for this user right now",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, identity.ErrInvalidParameter):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "Invalid request",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, identity.ErrLimitExceeded):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusTooManyRequests))
+		return c.JSON(http.StatusTooManyRequests, echo.Map{
+			"message": "Too many requests, please try again later",
+			"error":   err.Error(),
+		})
+	default:
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "Something went wrong while managing the user",
+			"error":   err.Error(),
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/apikey"
+	"backend/internal/audit"
+	authctx "backend/internal/auth"
+	"backend/internal/passkey"
+	"backend/internal/types"
+	"backend/pkg/cognito/cognitotest"
+	"backend/pkg/identity"
+)
+
+func TestListUsers(t *testing.T) {
+	f := newFixture(t)
+	for _, name := range []string{"Bob", "Carol", "Dave"} {
+		email := strings.ToLower(name) + "@example.com"
+		f.pool.AddUser(email, "Passw0rd!", true, map[string]string{"email": email, "given_name": name})
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   []string
+		next   string
+	}{
+		{name: "All", status: http.StatusOK, want: []string{"bob@example.com", "carol@example.com", "dave@example.com"}},
+		{name: "FirstPage", query: "?limit=2", status: http.StatusOK, want: []string{"bob@example.com", "carol@example.com"}, next: "2"},
+		{name: "NextPage", query: "?limit=2&pageToken=2", status: http.StatusOK, want: []string{"dave@example.com"}},
+		{name: "Search", query: "?search=CAR", status: http.StatusOK, want: []string{"carol@example.com"}},
+		{name: "AttributeExact", query: "?attribute=given_name&value=Dave&match=exact", status: http.StatusOK, want: []string{"dave@example.com"}},
+		{name: "AttributePrefix", query: "?attribute=given_name&value=Ca", status: http.StatusOK, want: []string{"carol@example.com"}},
+		{name: "UnsearchableAttribute", query: "?attribute=custom:plan&value=pro", status: http.StatusBadRequest},
+		{name: "LimitTooLarge", query: "?limit=61", status: http.StatusBadRequest},
+		{name: "InvalidPageToken", query: "?pageToken=nope", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := f.do(http.MethodGet, "/admin/users"+tt.query, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var page identity.UserPage
+			if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+				t.Fatal(err)
+			}
+			var usernames []string
+			for _, u := range page.Users {
+				usernames = append(usernames, u.Username)
+			}
+			if strings.Join(usernames, ",") != strings.Join(tt.want, ",") || page.NextPageToken != tt.next {
+				t.Fatalf("page = %v next %q, want %v next %q", usernames, page.NextPageToken, tt.want, tt.next)
+			}
+		})
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	f := newFixture(t)
+	bob := f.pool.AddUser("bob@example.com", "Passw0rd!", true, map[string]string{"email": "bob@example.com"})
+
+	rec := f.do(http.MethodGet, "/admin/users/bob@example.com", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var got identity.ManagedUser
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != bob.Sub || got.Status != identity.StatusConfirmed || !got.Enabled {
+		t.Fatalf("user = %+v", got)
+	}
+
+	if rec := f.do(http.MethodGet, "/admin/users/nobody@example.com", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown user: status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// credentials are what bob holds before an admin acts on his account
+type credentials struct {
+	sub    string
+	apiKey string
+}
+
+// withCredentials gives bob an API key and a passkey and mirrors his account
+func withCredentials(t *testing.T, f *fixture) credentials {
+	t.Helper()
+	ctx := context.Background()
+
+	bob := f.pool.AddUser("bob@example.com", "Passw0rd!", true, map[string]string{"email": "bob@example.com"})
+	if _, err := f.s.Users.Refresh(ctx, f.s.Identity.(identity.UserAdministrator), "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, secret, err := f.s.APIKeys.Create(ctx, apikey.Owner{Subject: bob.Sub, Username: "bob@example.com"}, "ci", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, err := types.NewBase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.s.DB.Create(&passkey.Passkey{Base: *base, Subject: bob.Sub, Username: "bob@example.com", Name: "laptop", CredentialID: "cred-1"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return credentials{sub: bob.Sub, apiKey: secret}
+}
+
+func TestUserActions(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		method string
+		setup  func(f *fixture)
+		// what is left of bob's credentials afterwards
+		tokensRevoked bool
+		keyRevoked    bool
+		passkeysGone  bool
+		check         func(t *testing.T, f *fixture)
+	}{
+		{
+			name: "Disable", method: http.MethodPost, path: "/admin/users/bob@example.com/disable",
+			tokensRevoked: true, keyRevoked: true,
+			check: func(t *testing.T, f *fixture) {
+				if u, _ := f.pool.User("bob@example.com"); !u.Disabled {
+					t.Fatal("the user is still enabled")
+				}
+				if row, err := f.s.Users.ByUsername(context.Background(), "bob@example.com"); err != nil || row.Enabled {
+					t.Fatalf("mirror = %+v, %v", row, err)
+				}
+			},
+		},
+		{
+			name: "Enable", method: http.MethodPost, path: "/admin/users/bob@example.com/enable",
+			setup: func(f *fixture) {
+				f.pool.Update("bob@example.com", func(u *cognitotest.User) { u.Disabled = true })
+			},
+			check: func(t *testing.T, f *fixture) {
+				if u, _ := f.pool.User("bob@example.com"); u.Disabled {
+					t.Fatal("the user is still disabled")
+				}
+				if row, err := f.s.Users.ByUsername(context.Background(), "bob@example.com"); err != nil || !row.Enabled {
+					t.Fatalf("mirror = %+v, %v", row, err)
+				}
+			},
+		},
+		{
+			name: "ResetPassword", method: http.MethodPost, path: "/admin/users/bob@example.com/reset-password",
+			tokensRevoked: true, keyRevoked: true,
+			check: func(t *testing.T, f *fixture) {
+				if u, _ := f.pool.User("bob@example.com"); u.Password != "" || u.ResetCode == "" {
+					t.Fatalf("user = %+v, want the password replaced by a reset code", u)
+				}
+			},
+		},
+		{
+			name: "SignOut", method: http.MethodPost, path: "/admin/users/bob@example.com/signout",
+			tokensRevoked: true, keyRevoked: true,
+		},
+		{
+			name: "Delete", method: http.MethodDelete, path: "/admin/users/bob@example.com",
+			tokensRevoked: true, keyRevoked: true, passkeysGone: true,
+			check: func(t *testing.T, f *fixture) {
+				if _, ok := f.pool.User("bob@example.com"); ok {
+					t.Fatal("the user still exists")
+				}
+				if row, err := f.s.Users.ByUsername(context.Background(), "bob@example.com"); err != nil || !row.Deleted() {
+					t.Fatalf("mirror = %+v, %v", row, err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := newFixture(t)
+			creds := withCredentials(t, f)
+			if tt.setup != nil {
+				tt.setup(f)
+			}
+
+			rec := f.do(tt.method, tt.path, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			if tt.check != nil {
+				tt.check(t, f)
+			}
+
+			before := &authctx.Principal{Subject: creds.sub, IssuedAt: time.Now().Add(-time.Second)}
+			if revoked := f.s.Denylist.IsRevoked(before); revoked != tt.tokensRevoked {
+				t.Fatalf("token issued before revoked = %v, want %v", revoked, tt.tokensRevoked)
+			}
+			if _, err := f.s.APIKeys.Authenticate(ctx, creds.apiKey); (err != nil) != tt.keyRevoked {
+				t.Fatalf("API key: %v, want revoked %v", err, tt.keyRevoked)
+			}
+			if keys, err := f.s.Passkeys.List(ctx, creds.sub); err != nil || (len(keys) == 0) != tt.passkeysGone {
+				t.Fatalf("passkeys = %d, %v, want gone %v", len(keys), err, tt.passkeysGone)
+			}
+
+			action := "user." + strings.ToLower(tt.name)
+			switch tt.name {
+			case "ResetPassword":
+				action = "user.reset_password"
+			case "SignOut":
+				action = "user.signout"
+			}
+			events := f.audited(t, action)
+			if len(events) != 1 || events[0].Outcome != audit.OutcomeSuccess || events[0].Target != "bob@example.com" || events[0].ActorUsername != adminUsername {
+				t.Fatalf("audit = %+v", events)
+			}
+		})
+	}
+}
+
+func TestUserActionUnknownUser(t *testing.T) {
+	f := newFixture(t)
+
+	for _, path := range []string{"/admin/users/nobody@example.com/disable", "/admin/users/nobody@example.com/enable"} {
+		if rec := f.do(http.MethodPost, path, nil); rec.Code != http.StatusNotFound {
+			t.Fatalf("%s: status = %d: %s", path, rec.Code, rec.Body.String())
+		}
+	}
+
+	// the failed enable is on record, the disable didn't get past the lookup
+	events := f.audited(t, "user.enable")
+	if len(events) != 1 || events[0].Outcome != audit.OutcomeFailure {
+		t.Fatalf("audit = %+v", events)
+	}
+}
@@ -1,14 +1,16 @@
 package auth
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
+	"backend/internal/billing"
+	"backend/internal/signup"
 	"backend/internal/svc"
-	cognito "backend/pkg/cognito"
+	"backend/pkg/identity"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
 	"github.com/labstack/echo/v4"
 	"go.opentelemetry.io/otel/attribute"
 )
@@ -19,6 +21,7 @@ type UserAttributes struct {
 	LastName           string `form:"lastName"`
 	Password           string `form:"password"`
 	SubscriptionStatus string `form:"subscriptionStatus"`
+	CaptchaToken       string `form:"captchaToken"`
 }
 
 type ErrorResponse struct {
@@ -42,8 +45,13 @@ type SuccessResponse struct {
 // @Param password formData string true "Password"
 // @Param email formData string true "Email"
 // @Param photo formData file true "Profile Photo"
+// @Param subscriptionStatus formData string false "Plan to start on, one of the self serve plans, the default plan when empty"
+// @Param captchaToken formData string false "CAPTCHA token, required when CAPTCHA is on"
 // @Success 200 {object} SuccessResponse
 // @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
 // @Router /signup [post]
 func SignUp(s *svc.ServiceContext) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -51,6 +59,14 @@ func SignUp(s *svc.ServiceContext) echo.HandlerFunc {
 		_, span := tracer.Start(c.Request().Context(), "handler.SignUp")
 		defer span.End()
 
+		if !s.Config.Auth.SIGNUP.OPEN {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusForbidden))
+			return c.JSON(http.StatusForbidden, echo.Map{
+				"message": "Sign up is by invitation only",
+				"code":    "signup_closed",
+			})
+		}
+
 		var user UserAttributes
 		err := c.Bind(&user)
 
@@ -63,75 +79,133 @@ func SignUp(s *svc.ServiceContext) echo.HandlerFunc {
 			})
 		}
 
-		if user.Username == "" || user.Password == "" || user.SubscriptionStatus == "" || user.FirstName == "" || user.LastName == "" {
+		missing := signup.FieldErrors{}
+		for field, value := range map[string]string{
+			"username":  user.Username,
+			"password":  user.Password,
+			"firstName": user.FirstName,
+			"lastName":  user.LastName,
+		} {
+			if value == "" {
+				missing[field] = "Is a required field"
+			}
+		}
+		if len(missing) > 0 {
 			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
 			return c.JSON(http.StatusBadRequest, echo.Map{
 				"message": "Username, password, first name and last name are required fields",
+				"errors":  missing,
+			})
+		}
+
+		// RealIP only honours X-Forwarded-For from trusted proxies, see middlewares.IPExtractor
+		if ok, retryAfter := s.SignUpThrottle.Allow(c.RealIP()); !ok {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusTooManyRequests))
+			c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+			return c.JSON(http.StatusTooManyRequests, echo.Map{
+				"message":    "Too many sign ups from your network, please try again later",
+				"retryAfter": seconds,
+			})
+		}
+
+		// the Turnstile widget posts its token as cf-turnstile-response
+		captchaToken := user.CaptchaToken
+		if captchaToken == "" {
+			captchaToken = c.FormValue("cf-turnstile-response")
+		}
+
+		err = s.SignUpPolicy.Check(c.Request().Context(), signup.Input{
+			Email:        user.Username,
+			CaptchaToken: captchaToken,
+			RemoteIP:     c.RealIP(),
+		})
+		if err != nil {
+			span.RecordError(err)
+
+			var fields signup.FieldErrors
+			if errors.As(err, &fields) {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Sign up was refused, please check the highlighted fields",
+					"code":    "signup_policy",
+					"errors":  fields,
+				})
+			}
+
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusServiceUnavailable))
+			return c.JSON(http.StatusServiceUnavailable, echo.Map{
+				"message": "Sign up is unavailable right now, please try again later",
+				"error":   err.Error(),
+			})
+		}
+
+		// paid plans are only granted by the payment provider's webhooks
+		plan := user.SubscriptionStatus
+		if plan == "" {
+			plan = s.Billing.DefaultPlan
+		}
+		selected, err := s.Billing.Plan(c.Request().Context(), plan)
+		if err != nil && !errors.Is(err, billing.ErrPlanNotFound) {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			span.RecordError(err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something wen't wrong while sign up",
+				"error":   err.Error(),
+			})
+		}
+		if selected == nil || !selected.SelfServe {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Sign up was refused, please check the highlighted fields",
+				"code":    "signup_policy",
+				"errors":  signup.FieldErrors{"subscriptionStatus": "Is not a plan you can sign up for"},
 			})
 		}
 
-		cognito, _ := cognito.NewCognitoClient()
-		input := &cognitoidentityprovider.SignUpInput{
-			ClientId: aws.String(s.Config.AWS.COGNITO.CLIENT_ID),
-			Username: aws.String(user.Username),
-			Password: aws.String(user.Password),
-			UserAttributes: []*cognitoidentityprovider.AttributeType{
-				{
-					Name:  aws.String("email"),
-					Value: aws.String(user.Username),
-				},
-				{
-					Name:  aws.String("given_name"),
-					Value: aws.String(user.FirstName),
-				},
-				{
-					Name:  aws.String("family_name"),
-					Value: aws.String(user.LastName),
-				},
-				{
-					Name:  aws.String("custom:subscription_status"),
-					Value: aws.String("free"),
-				},
-			},
-		}
-
-		_, err = cognito.SignUp(input)
+		err = s.Identity.SignUp(c.Request().Context(), identity.SignUpInput{
+			Username:           user.Username,
+			Password:           user.Password,
+			Email:              user.Username,
+			FirstName:          user.FirstName,
+			LastName:           user.LastName,
+			SubscriptionStatus: selected.Code,
+		})
 		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				switch aerr.Code() {
-				case cognitoidentityprovider.ErrCodeInvalidParameterException:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadGateway))
-					span.RecordError(err)
-					return c.JSON(http.StatusBadGateway, echo.Map{
-						"message": "Email and Password is required arguments",
-						"error":   err.Error(),
-					})
-
-				case cognitoidentityprovider.ErrCodeUsernameExistsException:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusConflict))
-					span.RecordError(err)
-					return c.JSON(http.StatusConflict, echo.Map{
-						"message": "An account with the given email already exists",
-						"error":   err.Error(),
-					})
-
-				case cognitoidentityprovider.ErrCodeInvalidPasswordException:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
-					span.RecordError(err)
-					return c.JSON(http.StatusBadRequest, echo.Map{
-						"message": "Password must include uppercase, special-character and number",
-						"error":   err.Error(),
-					})
-
-				default:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
-					span.RecordError(err)
-					return c.JSON(http.StatusInternalServerError, echo.Map{
-						"message": "Something wen't wrong while sign up",
-						"error":   err.Error(),
-					})
-				}
-			} else {
+			switch {
+			case errors.Is(err, identity.ErrInvalidParameter):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadGateway))
+				span.RecordError(err)
+				return c.JSON(http.StatusBadGateway, echo.Map{
+					"message": "Email and Password is required arguments",
+					"error":   err.Error(),
+				})
+
+			case errors.Is(err, identity.ErrUserExists):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusConflict))
+				span.RecordError(err)
+				return c.JSON(http.StatusConflict, echo.Map{
+					"message": "An account with the given email already exists",
+					"error":   err.Error(),
+				})
+
+			case errors.Is(err, identity.ErrInvalidPassword):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				span.RecordError(err)
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Password must include uppercase, special-character and number",
+					"error":   err.Error(),
+				})
+
+			case errors.Is(err, identity.ErrRejected):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				span.RecordError(err)
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Sign up is not allowed for this account",
+					"error":   err.Error(),
+				})
+
+			default:
 				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
 				span.RecordError(err)
 				return c.JSON(http.StatusInternalServerError, echo.Map{
@@ -148,13 +222,15 @@ func SignUp(s *svc.ServiceContext) echo.HandlerFunc {
 }
 
 // @Summary Sign In
-// @Description Endpoint for signing in a user
+// @Description Endpoint for signing in a user. A 202 means the user has to answer a challenge at /auth/signin/challenge
 // @Tags Auth
 // @Accept multipart/form-data
 // @Param username formData string true "Username"
 // @Param password formData string true "Password"
 // @Success 200 {object} SuccessResponse
+// @Success 202 {object} SuccessResponse
 // @Failure 400 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
 // @Router /signin [post]
 func SignIn(s *svc.ServiceContext) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -181,45 +257,35 @@ func SignIn(s *svc.ServiceContext) echo.HandlerFunc {
 			})
 		}
 
-		cognito, _ := cognito.NewCognitoClient()
-
-		authInput := &cognitoidentityprovider.InitiateAuthInput{
-			AuthFlow: aws.String(cognitoidentityprovider.AuthFlowTypeUserPasswordAuth),
-			ClientId: aws.String(s.Config.AWS.COGNITO.CLIENT_ID),
-			AuthParameters: map[string]*string{
-				"USERNAME": aws.String(user.Username),
-				"PASSWORD": aws.String(user.Password),
-			},
+		if blocked, err := CheckLoginGuard(s, c, span, user.Username); blocked {
+			return err
 		}
 
-		authOutput, err := cognito.InitateAuth(authInput)
+		result, err := s.Identity.SignIn(c.Request().Context(), user.Username, user.Password)
 		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				switch aerr.Code() {
-				case cognitoidentityprovider.ErrCodeUserNotConfirmedException:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
-					span.RecordError(err)
-					return c.JSON(http.StatusUnauthorized, echo.Map{
-						"message": "Email is not confirmed.",
-						"error":   err.Error(),
-					})
-				case cognitoidentityprovider.ErrCodeNotAuthorizedException:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
-					span.RecordError(err)
-					return c.JSON(http.StatusUnauthorized, echo.Map{
-						"message": "Incorrect email or password.",
-						"error":   err.Error(),
-					})
-
-				default:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
-					span.RecordError(err)
-					return c.JSON(http.StatusInternalServerError, echo.Map{
-						"message": "Something wen't wrong while sign up",
-						"error":   err.Error(),
-					})
-				}
-			} else {
+			switch {
+			case errors.Is(err, identity.ErrUserNotConfirmed):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				span.RecordError(err)
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "Email is not confirmed.",
+					"error":   err.Error(),
+					"code":    "user_not_confirmed",
+					"resend":  "/auth/verify/resend",
+				})
+			case errors.Is(err, identity.ErrNotAuthorized), errors.Is(err, identity.ErrUserNotFound):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				span.RecordError(err)
+				RecordLoginFailure(s, c, span, user.Username)
+				// the same answer for unknown users and wrong passwords
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "Incorrect email or password.",
+				})
+			case errors.Is(err, identity.ErrLimitExceeded):
+				span.RecordError(err)
+				return TooManyAttempts(c, span, s.Config.Auth.LOGIN.BACKOFF_MAX)
+
+			default:
 				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
 				span.RecordError(err)
 				return c.JSON(http.StatusInternalServerError, echo.Map{
@@ -229,15 +295,11 @@ func SignIn(s *svc.ServiceContext) echo.HandlerFunc {
 			}
 		}
 
-		cookie := new(http.Cookie)
-		cookie.Name = "token"
-		cookie.Value = *authOutput.AuthenticationResult.IdToken
-		c.SetCookie(cookie)
-		return c.JSON(http.StatusOK, echo.Map{
-			"message":      "You have successfully signed in!",
-			"refreshToken": authOutput.AuthenticationResult.RefreshToken,
-			"token":        authOutput.AuthenticationResult.IdToken,
-		})
+		if result.Challenge == nil {
+			// failed MFA codes keep counting until the sign in completes
+			s.LoginGuard.Success(user.Username)
+		}
+		return signInResponse(s, c, span, user.Username, result)
 	}
 }
 
@@ -258,39 +320,26 @@ func VerifyEmail(s *svc.ServiceContext) echo.HandlerFunc {
 		username := c.FormValue("username")
 		code := c.FormValue("code")
 
-		cognito, _ := cognito.NewCognitoClient()
-
-		ConfirmSignUpInput := &cognitoidentityprovider.ConfirmSignUpInput{
-			ClientId:         aws.String(s.Config.AWS.COGNITO.CLIENT_ID),
-			Username:         aws.String(username),
-			ConfirmationCode: aws.String(code),
-		}
-		_, err := cognito.ConfirmSignUp(ConfirmSignUpInput)
+		err := s.Identity.ConfirmSignUp(c.Request().Context(), username, code)
 		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				switch aerr.Code() {
-				case cognitoidentityprovider.ErrCodeCodeMismatchException:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
-					span.RecordError(err)
-					return c.JSON(http.StatusUnauthorized, echo.Map{
-						"message": "Invalid verification code provided, please try again.",
-						"error":   err.Error(),
-					})
-				case cognitoidentityprovider.ErrCodeExpiredCodeException:
-					span.RecordError(err)
-					return c.JSON(http.StatusUnauthorized, echo.Map{
-						"message": "Verification code provided is expired please try again from start..",
-						"error":   err.Error(),
-					})
-
-				default:
-					span.RecordError(err)
-					return c.JSON(http.StatusInternalServerError, echo.Map{
-						"message": "Something wen't wrong while sign up",
-						"error":   err.Error(),
-					})
-				}
-			} else {
+			switch {
+			case errors.Is(err, identity.ErrCodeMismatch):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				span.RecordError(err)
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "Invalid verification code provided, please try again.",
+					"error":   err.Error(),
+				})
+			case errors.Is(err, identity.ErrExpiredCode):
+				span.RecordError(err)
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "Verification code provided is expired, please request a new one.",
+					"error":   err.Error(),
+					"code":    "code_expired",
+					"resend":  "/auth/verify/resend",
+				})
+
+			default:
 				span.RecordError(err)
 				return c.JSON(http.StatusInternalServerError, echo.Map{
 					"message": "Something wen't wrong while sign up",
@@ -299,6 +348,13 @@ func VerifyEmail(s *svc.ServiceContext) echo.HandlerFunc {
 			}
 		}
 
+		// the account exists for good now, mirror it into the users table
+		if user, err := s.Identity.LookupUser(c.Request().Context(), username); err != nil {
+			span.RecordError(err)
+		} else if _, err := s.Users.SyncUser(c.Request().Context(), user); err != nil {
+			span.RecordError(err)
+		}
+
 		return c.JSON(http.StatusOK, echo.Map{
 			"message": "Email verification successful!",
 		})
@@ -320,19 +376,25 @@ func ForgotPassword(s *svc.ServiceContext) echo.HandlerFunc {
 		defer span.End()
 		span.SetAttributes(attribute.String("http.method", "POST"), attribute.String("http.route", "/auth/forgotpassword"))
 		username := c.FormValue("username")
-		cognito, _ := cognito.NewCognitoClient()
 
-		ForgotPasswordInput := &cognitoidentityprovider.ForgotPasswordInput{
-			ClientId: aws.String(s.Config.AWS.COGNITO.CLIENT_ID),
-			Username: aws.String(username),
+		if blocked, err := CheckLoginGuard(s, c, span, username); blocked {
+			return err
 		}
-		_, err := cognito.ForgotPassword(ForgotPasswordInput)
+
+		err := s.Identity.ForgotPassword(c.Request().Context(), username)
 		if err != nil {
 			span.RecordError(err)
-			return c.JSON(http.StatusBadRequest, echo.Map{
-				"message": "Something went wrong!",
-				"error":   err.Error(),
-			})
+			switch {
+			case errors.Is(err, identity.ErrUserNotFound):
+				// answer like a known user so accounts can't be enumerated
+			case errors.Is(err, identity.ErrLimitExceeded):
+				return TooManyAttempts(c, span, s.Config.Auth.LOGIN.BACKOFF_MAX)
+			default:
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Something went wrong!",
+					"error":   err.Error(),
+				})
+			}
 		}
 
 		return c.JSON(http.StatusOK, echo.Map{
@@ -369,47 +431,32 @@ func RefreshToken(s *svc.ServiceContext) echo.HandlerFunc {
 			})
 		}
 
+		if refreshTokenReq.RefreshToken == "" {
+			refreshTokenReq.RefreshToken = s.Sessions.RefreshToken(c)
+		}
+
 		if refreshTokenReq.RefreshToken == "" {
 			return c.JSON(http.StatusBadRequest, echo.Map{
 				"message": "Refresh token is required",
 			})
 		}
 
-		cognito, _ := cognito.NewCognitoClient()
-
-		// Use the AWS Cognito SDK to refresh the token
-		refreshInput := &cognitoidentityprovider.InitiateAuthInput{
-			AuthFlow: aws.String(cognitoidentityprovider.AuthFlowTypeRefreshToken),
-			ClientId: aws.String(s.Config.AWS.COGNITO.CLIENT_ID),
-			AuthParameters: map[string]*string{
-				"REFRESH_TOKEN": aws.String(refreshTokenReq.RefreshToken),
-			},
-		}
-
-		authOutput, err := cognito.InitateAuth(refreshInput)
+		tokens, err := s.Identity.Refresh(c.Request().Context(), refreshTokenReq.RefreshToken)
 		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				switch aerr.Code() {
-				case cognitoidentityprovider.ErrCodeNotAuthorizedException:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
-					span.RecordError(err)
-					return c.JSON(http.StatusUnauthorized, echo.Map{
-						"message": "Refresh token is invalid or expired",
-					})
-				case cognitoidentityprovider.ErrCodeUserNotConfirmedException:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
-					span.RecordError(err)
-					return c.JSON(http.StatusUnauthorized, echo.Map{
-						"message": "User email is not confirmed",
-					})
-				default:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
-					span.RecordError(err)
-					return c.JSON(http.StatusInternalServerError, echo.Map{
-						"message": "Something went wrong while refreshing the token",
-					})
-				}
-			} else {
+			switch {
+			case errors.Is(err, identity.ErrNotAuthorized):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				span.RecordError(err)
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "Refresh token is invalid or expired",
+				})
+			case errors.Is(err, identity.ErrUserNotConfirmed):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				span.RecordError(err)
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "User email is not confirmed",
+				})
+			default:
 				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
 				span.RecordError(err)
 				return c.JSON(http.StatusInternalServerError, echo.Map{
@@ -418,12 +465,30 @@ func RefreshToken(s *svc.ServiceContext) echo.HandlerFunc {
 			}
 		}
 
+		if err := s.Sessions.SetTokens(c, tokens); err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			span.RecordError(err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something went wrong while refreshing the token",
+			})
+		}
+
+		if s.Sessions.CookieMode() {
+			return c.JSON(http.StatusOK, echo.Map{
+				"message": "Token refreshed successfully",
+			})
+		}
+
 		// Set the new access token in the response
-		token := *authOutput.AuthenticationResult.IdToken
-		return c.JSON(http.StatusOK, echo.Map{
+		response := echo.Map{
 			"message": "Token refreshed successfully",
-			"token":   token,
-		})
+			"token":   tokens.IDToken,
+		}
+		if tokens.RefreshToken != "" {
+			// the provider rotated the refresh token, the old one no longer works
+			response["refreshToken"] = tokens.RefreshToken
+		}
+		return c.JSON(http.StatusOK, response)
 	}
 }
 
@@ -453,45 +518,43 @@ func ResetPassword(s *svc.ServiceContext) echo.HandlerFunc {
 			})
 		}
 
-		cognito, _ := cognito.NewCognitoClient()
+		// the password is checked before the account is looked up, otherwise
+		// the answer to a weak password would tell which accounts exist
+		if err := identity.ValidatePassword(newPassword); err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			span.RecordError(err)
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Password must include uppercase, special-character and number",
+				"error":   err.Error(),
+			})
+		}
 
-		resetInput := &cognitoidentityprovider.ConfirmForgotPasswordInput{
-			ClientId:         aws.String(s.Config.AWS.COGNITO.CLIENT_ID),
-			Username:         aws.String(username),
-			ConfirmationCode: aws.String(code),
-			Password:         aws.String(newPassword),
+		if blocked, err := CheckLoginGuard(s, c, span, username); blocked {
+			return err
 		}
 
-		_, err := cognito.ConfirmForgotPassword(resetInput)
+		err := s.Identity.ConfirmForgotPassword(c.Request().Context(), username, code, newPassword)
 		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				switch aerr.Code() {
-				case cognitoidentityprovider.ErrCodeCodeMismatchException:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
-					span.RecordError(err)
-					return c.JSON(http.StatusUnauthorized, echo.Map{
-						"message": "Invalid verification code",
-					})
-				case cognitoidentityprovider.ErrCodeExpiredCodeException:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
-					span.RecordError(err)
-					return c.JSON(http.StatusUnauthorized, echo.Map{
-						"message": "Verification code has expired",
-					})
-				case cognitoidentityprovider.ErrCodeUserNotFoundException:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotFound))
-					span.RecordError(err)
-					return c.JSON(http.StatusNotFound, echo.Map{
-						"message": "User not found",
-					})
-				default:
-					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
-					span.RecordError(err)
-					return c.JSON(http.StatusInternalServerError, echo.Map{
-						"message": "Something went wrong while resetting the password",
-					})
-				}
-			} else {
+			switch {
+			case errors.Is(err, identity.ErrCodeMismatch), errors.Is(err, identity.ErrExpiredCode), errors.Is(err, identity.ErrUserNotFound):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				span.RecordError(err)
+				RecordLoginFailure(s, c, span, username)
+				// one answer for all three so the endpoint doesn't reveal which accounts exist
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "Invalid or expired verification code",
+				})
+			case errors.Is(err, identity.ErrInvalidPassword):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				span.RecordError(err)
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Password must include uppercase, special-character and number",
+					"error":   err.Error(),
+				})
+			case errors.Is(err, identity.ErrLimitExceeded):
+				span.RecordError(err)
+				return TooManyAttempts(c, span, s.Config.Auth.LOGIN.BACKOFF_MAX)
+			default:
 				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
 				span.RecordError(err)
 				return c.JSON(http.StatusInternalServerError, echo.Map{
@@ -500,6 +563,7 @@ func ResetPassword(s *svc.ServiceContext) echo.HandlerFunc {
 			}
 		}
 
+		s.LoginGuard.Success(username)
 		return c.JSON(http.StatusOK, echo.Map{
 			"message": "Password reset successfully!",
 		})
@@ -0,0 +1,1263 @@
+package auth_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/audit"
+	authctx "backend/internal/auth"
+	"backend/internal/authz"
+	"backend/internal/billing"
+	"backend/internal/handler/admin"
+	"backend/internal/handler/auth"
+	"backend/internal/invitation"
+	"backend/internal/middlewares"
+	"backend/internal/signup"
+	"backend/internal/svc"
+	"backend/internal/testdb"
+	"backend/internal/user"
+	"backend/pkg/captcha"
+	"backend/pkg/cognito/cognitotest"
+	"backend/pkg/config"
+	"backend/pkg/identity"
+
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	username = "alice@example.com"
+	password = "Passw0rd!"
+	code     = "123456"
+)
+
+type fixture struct {
+	echo *echo.Echo
+	pool *cognitotest.Client
+	s    *svc.ServiceContext
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	db := testdb.Open(t, append(billing.Models(), &user.User{}, &invitation.Invitation{})...)
+
+	plans := billing.NewStore(db, "free")
+	seed, err := billing.ParsePlans("free", []string{"pro:price_pro"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plans.SeedPlans(context.Background(), seed); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := cognitotest.New()
+	pool.AddUser(username, password, true, map[string]string{"email": username})
+
+	cfg := config.Configuration{}
+	cfg.Auth.SIGNUP.OPEN = true
+	cfg.Auth.LOGIN.BACKOFF_MAX = 30 * time.Second
+	cfg.Session.MODE = "token"
+
+	e := echo.New()
+	// like main.go without trusted proxies
+	extract, err := middlewares.IPExtractor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.IPExtractor = extract
+
+	tracer := otel.Tracer("test")
+	s := &svc.ServiceContext{
+		Config:         cfg,
+		DB:             db,
+		Echo:           e,
+		Tracer:         &tracer,
+		Cognito:        pool,
+		Identity:       identity.NewCognitoProvider(pool, "client", "pool", "issuer", nil),
+		Denylist:       authctx.NewMemoryDenylist(),
+		Sessions:       authctx.NewSessionCookies(cfg.Session),
+		Users:          user.NewRepository(db),
+		Invitations:    invitation.NewStore(db, time.Hour),
+		Billing:        plans,
+		SignUpPolicy:   signup.NewPolicy(signup.Options{}),
+		SignUpThrottle: authctx.NewThrottle(0, 0, time.Hour),
+		ResendThrottle: authctx.NewThrottle(time.Minute, 5, time.Hour),
+		LoginGuard: authctx.NewLoginGuard(authctx.LoginGuardOptions{
+			MaxFailures:   5,
+			IPMaxFailures: 50,
+			BackoffBase:   time.Second,
+			BackoffMax:    cfg.Auth.LOGIN.BACKOFF_MAX,
+			Lockout:       15 * time.Minute,
+			Window:        15 * time.Minute,
+		}),
+	}
+
+	g := e.Group("/auth")
+	g.POST("/signup", auth.SignUp(s))
+	g.POST("/signin", auth.SignIn(s))
+	g.POST("/verify", auth.VerifyEmail(s))
+	g.POST("/password-forgot", auth.ForgotPassword(s))
+	g.POST("/reset-password", auth.ResetPassword(s))
+	g.POST("/refresh-token", auth.RefreshToken(s))
+	g.POST("/signin/challenge", auth.SignInChallenge(s))
+	g.POST("/verify/resend", auth.ResendVerificationCode(s))
+	g.GET("/verify/status", auth.VerificationStatus(s), bearer)
+	g.GET("/invitations", auth.GetInvitation(s))
+	g.POST("/invitations/accept", auth.AcceptInvitation(s))
+
+	// the fake's access tokens are opaque, they are taken as they come
+	mfa := g.Group("/mfa", middlewares.UnlessFormValue("session", bearer))
+	mfa.POST("/setup", auth.MFASetup(s))
+	mfa.POST("/verify", auth.MFAVerify(s))
+
+	return &fixture{echo: e, pool: pool, s: s}
+}
+
+// bearer authenticates the seeded user by the access token in the header
+func bearer(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		if token == "" {
+			return c.JSON(http.StatusUnauthorized, echo.Map{"message": "Token is required"})
+		}
+
+		authctx.SetPrincipal(c, &authctx.Principal{Type: authctx.PrincipalUser, Username: username, Token: token})
+		return next(c)
+	}
+}
+
+func (f *fixture) post(path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+
+	rec := httptest.NewRecorder()
+	f.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+// postWithToken posts form with the access token in the Authorization header
+func (f *fixture) postWithToken(path, token string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+
+	rec := httptest.NewRecorder()
+	f.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+// challenge signs the seeded user in and returns the session of the challenge
+// the sign in stopped at
+func (f *fixture) challenge(t *testing.T, name string) string {
+	t.Helper()
+
+	result, err := f.s.Identity.SignIn(context.Background(), username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Challenge == nil || result.Challenge.Name != name {
+		t.Fatalf("sign in result = %+v, want the %s challenge", result, name)
+	}
+
+	return result.Challenge.Session
+}
+
+// mfaCode is the TOTP code the fake accepts for the seeded user
+func (f *fixture) mfaCode(t *testing.T) string {
+	t.Helper()
+
+	u, ok := f.pool.User(username)
+	if !ok {
+		t.Fatal("user is missing from the pool")
+	}
+
+	return u.MFACode
+}
+
+// refreshToken signs the seeded user in and returns their refresh token
+func (f *fixture) refreshToken(t *testing.T) string {
+	t.Helper()
+
+	result, err := f.s.Identity.SignIn(context.Background(), username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result.Tokens.RefreshToken
+}
+
+// fail makes the next call of method to the user pool fail with the Cognito exception code
+func fail(method, code string) func(t *testing.T, f *fixture, form url.Values) {
+	return func(t *testing.T, f *fixture, form url.Values) {
+		f.pool.FailNext(method, cognitotest.Error(code))
+	}
+}
+
+type routeTest struct {
+	name  string
+	path  string
+	form  url.Values
+	setup func(t *testing.T, f *fixture, form url.Values)
+	// status and body are the expected status code and a substring of the body
+	status int
+	body   string
+}
+
+func runRouteTests(t *testing.T, tests []routeTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			form := url.Values{}
+			for k, v := range tt.form {
+				form[k] = append([]string(nil), v...)
+			}
+			if tt.setup != nil {
+				tt.setup(t, f, form)
+			}
+
+			rec := f.post(tt.path, form)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.body) {
+				t.Fatalf("body = %s, want it to contain %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestSignUp(t *testing.T) {
+	form := url.Values{
+		"username":  {"bob@example.com"},
+		"password":  {password},
+		"firstName": {"Bob"},
+		"lastName":  {"Builder"},
+	}
+
+	runRouteTests(t, []routeTest{
+		{
+			name:   "Success",
+			path:   "/auth/signup",
+			form:   form,
+			status: http.StatusOK,
+			body:   "You have successfully signed up!",
+		},
+		{
+			name:   "MissingFields",
+			path:   "/auth/signup",
+			form:   url.Values{"username": {"bob@example.com"}},
+			status: http.StatusBadRequest,
+			body:   `"password":"Is a required field"`,
+		},
+		{
+			name: "Closed",
+			path: "/auth/signup",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				f.s.Config.Auth.SIGNUP.OPEN = false
+			},
+			status: http.StatusForbidden,
+			body:   `"code":"signup_closed"`,
+		},
+		{
+			name: "InvalidEmail",
+			path: "/auth/signup",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				form.Set("username", "Bob <bob@example.com>")
+			},
+			status: http.StatusBadRequest,
+			body:   `"code":"signup_policy","errors":{"username":"Must be a valid email address"}`,
+		},
+		{
+			name: "DeniedDomain",
+			path: "/auth/signup",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				f.s.SignUpPolicy = signup.NewPolicy(signup.Options{DeniedDomains: []string{"example.com"}})
+			},
+			status: http.StatusBadRequest,
+			body:   `"errors":{"username":"Sign up with example.com addresses is not allowed"}`,
+		},
+		{
+			name: "DisposableDomain",
+			path: "/auth/signup",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				f.s.SignUpPolicy = signup.NewPolicy(signup.Options{BlockDisposable: true})
+				form.Set("username", "bob@10minutemail.com")
+			},
+			status: http.StatusBadRequest,
+			body:   `"errors":{"username":"Disposable email addresses are not allowed"}`,
+		},
+		{
+			name: "MissingCaptcha",
+			path: "/auth/signup",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				f.s.SignUpPolicy = signup.NewPolicy(signup.Options{Captcha: captcha.Fake{Token: "pass"}})
+			},
+			status: http.StatusBadRequest,
+			body:   `"errors":{"captchaToken":"Please complete the CAPTCHA"}`,
+		},
+		{
+			name: "TurnstileWidgetToken",
+			path: "/auth/signup",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				f.s.SignUpPolicy = signup.NewPolicy(signup.Options{Captcha: captcha.Fake{Token: "pass"}})
+				form.Set("cf-turnstile-response", "pass")
+			},
+			status: http.StatusOK,
+			body:   "You have successfully signed up!",
+		},
+		{
+			name: "CaptchaUnavailable",
+			path: "/auth/signup",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				f.s.SignUpPolicy = signup.NewPolicy(signup.Options{Captcha: &captcha.Turnstile{URL: "http://127.0.0.1:1", HTTPClient: http.DefaultClient}})
+				form.Set("captchaToken", "pass")
+			},
+			status: http.StatusServiceUnavailable,
+			body:   "Sign up is unavailable right now",
+		},
+		{
+			name: "PaidPlan",
+			path: "/auth/signup",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				form.Set("subscriptionStatus", "pro")
+			},
+			status: http.StatusBadRequest,
+			body:   "Is not a plan you can sign up for",
+		},
+		{
+			name: "UsernameExists",
+			path: "/auth/signup",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				form.Set("username", username)
+			},
+			status: http.StatusConflict,
+			body:   "An account with the given email already exists",
+		},
+		{
+			name:   "InvalidPassword",
+			path:   "/auth/signup",
+			form:   form,
+			setup:  fail("SignUp", cognitoidentityprovider.ErrCodeInvalidPasswordException),
+			status: http.StatusBadRequest,
+			body:   "Password must include uppercase, special-character and number",
+		},
+		{
+			name:   "InvalidParameter",
+			path:   "/auth/signup",
+			form:   form,
+			setup:  fail("SignUp", cognitoidentityprovider.ErrCodeInvalidParameterException),
+			status: http.StatusBadGateway,
+			body:   "Email and Password is required arguments",
+		},
+		{
+			name:   "Rejected",
+			path:   "/auth/signup",
+			form:   form,
+			setup:  fail("SignUp", cognitoidentityprovider.ErrCodeUserLambdaValidationException),
+			status: http.StatusBadRequest,
+			body:   "Sign up is not allowed for this account",
+		},
+		{
+			name:   "Unexpected",
+			path:   "/auth/signup",
+			form:   form,
+			setup:  fail("SignUp", cognitoidentityprovider.ErrCodeInternalErrorException),
+			status: http.StatusInternalServerError,
+			body:   "Something wen't wrong while sign up",
+		},
+	})
+}
+
+func TestSignIn(t *testing.T) {
+	form := url.Values{"username": {username}, "password": {password}}
+
+	runRouteTests(t, []routeTest{
+		{
+			name:   "Success",
+			path:   "/auth/signin",
+			form:   form,
+			status: http.StatusOK,
+			body:   `"refreshToken":"`,
+		},
+		{
+			name:   "MissingFields",
+			path:   "/auth/signin",
+			form:   url.Values{"username": {username}},
+			status: http.StatusBadRequest,
+			body:   "Username and Password are required fields",
+		},
+		{
+			name: "NotAuthorized",
+			path: "/auth/signin",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				form.Set("password", "Wr0ngPassword!")
+			},
+			status: http.StatusUnauthorized,
+			body:   "Incorrect email or password.",
+		},
+		{
+			name: "UnknownUser",
+			path: "/auth/signin",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				form.Set("username", "nobody@example.com")
+			},
+			status: http.StatusUnauthorized,
+			body:   "Incorrect email or password.",
+		},
+		{
+			name: "UserNotConfirmed",
+			path: "/auth/signin",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				f.pool.Update(username, func(u *cognitotest.User) { u.Confirmed = false })
+			},
+			status: http.StatusUnauthorized,
+			body:   `"code":"user_not_confirmed"`,
+		},
+		{
+			name:   "LimitExceeded",
+			path:   "/auth/signin",
+			form:   form,
+			setup:  fail("InitateAuth", cognitoidentityprovider.ErrCodeLimitExceededException),
+			status: http.StatusTooManyRequests,
+			body:   `"code":"too_many_attempts"`,
+		},
+		{
+			name: "GuardBackoff",
+			path: "/auth/signin",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				f.post("/auth/signin", url.Values{"username": {username}, "password": {"Wr0ngPassword!"}})
+			},
+			status: http.StatusTooManyRequests,
+			body:   `"code":"too_many_attempts"`,
+		},
+		{
+			name:   "Unexpected",
+			path:   "/auth/signin",
+			form:   form,
+			setup:  fail("InitateAuth", cognitoidentityprovider.ErrCodeInternalErrorException),
+			status: http.StatusInternalServerError,
+			body:   "Something wen't wrong while sign up",
+		},
+	})
+}
+
+func TestSignInLockedUntilUnlocked(t *testing.T) {
+	f := newFixture(t)
+	if err := f.s.DB.AutoMigrate(&audit.Event{}); err != nil {
+		t.Fatal(err)
+	}
+	f.s.Audit = audit.NewDBRecorder(f.s.DB)
+	f.s.Authz = authz.NewEngine(authz.DefaultPolicies("admin")...)
+	// no backoff worth waiting for, only the lockout
+	f.s.LoginGuard = authctx.NewLoginGuard(authctx.LoginGuardOptions{
+		MaxFailures:   3,
+		IPMaxFailures: 50,
+		BackoffBase:   time.Nanosecond,
+		BackoffMax:    time.Nanosecond,
+		Lockout:       15 * time.Minute,
+		Window:        15 * time.Minute,
+	})
+	f.echo.POST("/admin/login-protection/unlock", admin.UnlockLogin(f.s), func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authctx.SetPrincipal(c, &authctx.Principal{Type: authctx.PrincipalUser, Subject: "admin", Username: "admin@example.com", Groups: []string{"admin"}})
+			return next(c)
+		}
+	})
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond)
+		if rec := f.post("/auth/signin", url.Values{"username": {username}, "password": {"Wr0ngPassword!"}}); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: status = %d: %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+
+	// the right password doesn't help while the account is locked
+	time.Sleep(time.Millisecond)
+	rec := f.post("/auth/signin", url.Values{"username": {username}, "password": {password}})
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("locked: got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = f.post("/admin/login-protection/unlock", url.Values{"username": {username}})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"unlocked":true`) {
+		t.Fatalf("unlock: got %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := f.post("/auth/signin", url.Values{"username": {username}, "password": {password}}); rec.Code != http.StatusOK {
+		t.Fatalf("after unlocking: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var events []audit.Event
+	if err := f.s.DB.Where("action = ?", "login.unlock").Find(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Target != username || events[0].ActorUsername != "admin@example.com" {
+		t.Fatalf("audit = %+v", events)
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	form := url.Values{"username": {username}, "code": {code}}
+	unconfirmed := func(t *testing.T, f *fixture, form url.Values) {
+		f.pool.Update(username, func(u *cognitotest.User) {
+			u.Confirmed = false
+			u.ConfirmationCode = code
+		})
+	}
+
+	runRouteTests(t, []routeTest{
+		{
+			name:   "Success",
+			path:   "/auth/verify",
+			form:   form,
+			setup:  unconfirmed,
+			status: http.StatusOK,
+			body:   "Email verification successful!",
+		},
+		{
+			name: "CodeMismatch",
+			path: "/auth/verify",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				unconfirmed(t, f, form)
+				form.Set("code", "654321")
+			},
+			status: http.StatusUnauthorized,
+			body:   "Invalid verification code provided, please try again.",
+		},
+		{
+			name: "ExpiredCode",
+			path: "/auth/verify",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				unconfirmed(t, f, form)
+				f.pool.ExpireCodes(username)
+			},
+			status: http.StatusUnauthorized,
+			body:   `"code":"code_expired"`,
+		},
+		{
+			name:   "Unexpected",
+			path:   "/auth/verify",
+			form:   form,
+			setup:  fail("ConfirmSignUp", cognitoidentityprovider.ErrCodeInternalErrorException),
+			status: http.StatusInternalServerError,
+			body:   "Something wen't wrong while sign up",
+		},
+	})
+}
+
+func TestResendVerificationCode(t *testing.T) {
+	runRouteTests(t, []routeTest{
+		{
+			name: "Unconfirmed",
+			path: "/auth/verify/resend",
+			form: url.Values{"username": {"bob@example.com"}},
+			setup: func(_ *testing.T, f *fixture, _ url.Values) {
+				f.pool.AddUser("bob@example.com", password, false, nil)
+			},
+			status: http.StatusOK,
+			body:   "If the account exists, a new verification code has been sent",
+		},
+		{
+			// confirmed and unknown accounts are answered like a resend
+			name:   "Confirmed",
+			path:   "/auth/verify/resend",
+			form:   url.Values{"username": {username}},
+			status: http.StatusOK,
+			body:   "If the account exists, a new verification code has been sent",
+		},
+		{
+			name:   "Unknown",
+			path:   "/auth/verify/resend",
+			form:   url.Values{"username": {"nobody@example.com"}},
+			status: http.StatusOK,
+			body:   "If the account exists, a new verification code has been sent",
+		},
+		{
+			name:   "MissingUsername",
+			path:   "/auth/verify/resend",
+			status: http.StatusBadRequest,
+			body:   "Username is a required field",
+		},
+		{
+			name: "Throttled",
+			path: "/auth/verify/resend",
+			form: url.Values{"username": {username}},
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				if rec := f.post("/auth/verify/resend", form); rec.Code != http.StatusOK {
+					t.Fatalf("first resend: status = %d: %s", rec.Code, rec.Body.String())
+				}
+			},
+			status: http.StatusTooManyRequests,
+			body:   "please wait before requesting another one",
+		},
+		{
+			name:   "Unexpected",
+			path:   "/auth/verify/resend",
+			form:   url.Values{"username": {username}},
+			setup:  fail("ResendConfirmationCode", cognitoidentityprovider.ErrCodeInternalErrorException),
+			status: http.StatusInternalServerError,
+			body:   "Something went wrong while sending the verification code",
+		},
+	})
+}
+
+func TestVerificationStatus(t *testing.T) {
+	f := newFixture(t)
+
+	get := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auth/verify/status?username=bob@example.com", nil)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		f.echo.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// the status of other accounts can't be asked for
+	if rec := get(""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without a token: got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec := get("access-token")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"username":"`+username+`"`) || !strings.Contains(rec.Body.String(), `"confirmed":true`) {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = get("access-token")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("checked again right away: got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestForgotPassword(t *testing.T) {
+	form := url.Values{"username": {username}}
+
+	runRouteTests(t, []routeTest{
+		{
+			name:   "Success",
+			path:   "/auth/password-forgot",
+			form:   form,
+			status: http.StatusOK,
+			body:   "Forgot password process initiated successfully!",
+		},
+		{
+			// unknown users get the same answer so accounts can't be enumerated
+			name:   "UnknownUser",
+			path:   "/auth/password-forgot",
+			form:   url.Values{"username": {"nobody@example.com"}},
+			status: http.StatusOK,
+			body:   "Forgot password process initiated successfully!",
+		},
+		{
+			name:   "LimitExceeded",
+			path:   "/auth/password-forgot",
+			form:   form,
+			setup:  fail("ForgotPassword", cognitoidentityprovider.ErrCodeLimitExceededException),
+			status: http.StatusTooManyRequests,
+			body:   `"code":"too_many_attempts"`,
+		},
+		{
+			name:   "Unexpected",
+			path:   "/auth/password-forgot",
+			form:   form,
+			setup:  fail("ForgotPassword", cognitoidentityprovider.ErrCodeInternalErrorException),
+			status: http.StatusBadRequest,
+			body:   "Something went wrong!",
+		},
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	form := url.Values{"username": {username}, "code": {code}, "newPassword": {"N3wPassword!"}}
+	requested := func(t *testing.T, f *fixture, form url.Values) {
+		f.pool.Update(username, func(u *cognitotest.User) { u.ResetCode = code })
+	}
+
+	runRouteTests(t, []routeTest{
+		{
+			name:   "Success",
+			path:   "/auth/reset-password",
+			form:   form,
+			setup:  requested,
+			status: http.StatusOK,
+			body:   "Password reset successfully!",
+		},
+		{
+			name:   "MissingFields",
+			path:   "/auth/reset-password",
+			form:   url.Values{"username": {username}},
+			status: http.StatusBadRequest,
+			body:   "Username, code, and newPassword are required fields",
+		},
+		{
+			name: "CodeMismatch",
+			path: "/auth/reset-password",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				requested(t, f, form)
+				form.Set("code", "654321")
+			},
+			status: http.StatusUnauthorized,
+			body:   "Invalid or expired verification code",
+		},
+		{
+			name: "ExpiredCode",
+			path: "/auth/reset-password",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				requested(t, f, form)
+				f.pool.ExpireCodes(username)
+			},
+			status: http.StatusUnauthorized,
+			body:   "Invalid or expired verification code",
+		},
+		{
+			name:   "UnknownUser",
+			path:   "/auth/reset-password",
+			form:   url.Values{"username": {"nobody@example.com"}, "code": {code}, "newPassword": {"N3wPassword!"}},
+			status: http.StatusUnauthorized,
+			body:   "Invalid or expired verification code",
+		},
+		{
+			name: "InvalidPassword",
+			path: "/auth/reset-password",
+			form: form,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				requested(t, f, form)
+				form.Set("newPassword", "weak")
+			},
+			status: http.StatusBadRequest,
+			body:   "Password must include uppercase, special-character and number",
+		},
+		{
+			// a weak password gets the same answer whether or not the account exists
+			name:   "InvalidPasswordUnknownUser",
+			path:   "/auth/reset-password",
+			form:   url.Values{"username": {"nobody@example.com"}, "code": {code}, "newPassword": {"weak"}},
+			status: http.StatusBadRequest,
+			body:   "Password must include uppercase, special-character and number",
+		},
+		{
+			name:   "LimitExceeded",
+			path:   "/auth/reset-password",
+			form:   form,
+			setup:  fail("ConfirmForgotPassword", cognitoidentityprovider.ErrCodeLimitExceededException),
+			status: http.StatusTooManyRequests,
+			body:   `"code":"too_many_attempts"`,
+		},
+		{
+			name:   "Unexpected",
+			path:   "/auth/reset-password",
+			form:   form,
+			setup:  fail("ConfirmForgotPassword", cognitoidentityprovider.ErrCodeInternalErrorException),
+			status: http.StatusInternalServerError,
+			body:   "Something went wrong while resetting the password",
+		},
+	})
+}
+
+func TestRefreshToken(t *testing.T) {
+	withToken := func(t *testing.T, f *fixture, form url.Values) {
+		form.Set("refreshToken", f.refreshToken(t))
+	}
+
+	runRouteTests(t, []routeTest{
+		{
+			name:   "Success",
+			path:   "/auth/refresh-token",
+			setup:  withToken,
+			status: http.StatusOK,
+			body:   "Token refreshed successfully",
+		},
+		{
+			name:   "MissingToken",
+			path:   "/auth/refresh-token",
+			status: http.StatusBadRequest,
+			body:   "Refresh token is required",
+		},
+		{
+			name:   "NotAuthorized",
+			path:   "/auth/refresh-token",
+			form:   url.Values{"refreshToken": {"not-a-refresh-token"}},
+			status: http.StatusUnauthorized,
+			body:   "Refresh token is invalid or expired",
+		},
+		{
+			name: "UserNotConfirmed",
+			path: "/auth/refresh-token",
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				withToken(t, f, form)
+				fail("InitateAuth", cognitoidentityprovider.ErrCodeUserNotConfirmedException)(t, f, form)
+			},
+			status: http.StatusUnauthorized,
+			body:   "User email is not confirmed",
+		},
+		{
+			name: "Unexpected",
+			path: "/auth/refresh-token",
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				withToken(t, f, form)
+				fail("InitateAuth", cognitoidentityprovider.ErrCodeInternalErrorException)(t, f, form)
+			},
+			status: http.StatusInternalServerError,
+			body:   "Something went wrong while refreshing the token",
+		},
+	})
+}
+
+func TestSignInChallenge(t *testing.T) {
+	const totp = "654321"
+	withMFA := func(t *testing.T, f *fixture, form url.Values) {
+		f.pool.Update(username, func(u *cognitotest.User) {
+			u.MFASecret = "SECRET"
+			u.MFACode = totp
+			u.MFAEnabled = true
+		})
+		form.Set("session", f.challenge(t, identity.ChallengeSoftwareTokenMFA))
+	}
+	withNewPassword := func(t *testing.T, f *fixture, form url.Values) {
+		f.pool.Update(username, func(u *cognitotest.User) { u.ForceChangePassword = true })
+		form.Set("session", f.challenge(t, identity.ChallengeNewPasswordRequired))
+	}
+	mfaForm := url.Values{"username": {username}, "challenge": {identity.ChallengeSoftwareTokenMFA}, "code": {totp}}
+	passwordForm := url.Values{"username": {username}, "challenge": {identity.ChallengeNewPasswordRequired}, "newPassword": {"N3wPassword!"}}
+
+	runRouteTests(t, []routeTest{
+		{
+			name:   "SignInStopsAtMFA",
+			path:   "/auth/signin",
+			form:   url.Values{"username": {username}, "password": {password}},
+			setup:  func(t *testing.T, f *fixture, form url.Values) { withMFA(t, f, url.Values{}) },
+			status: http.StatusAccepted,
+			body:   `"challenge":"SOFTWARE_TOKEN_MFA"`,
+		},
+		{
+			name:   "MFASuccess",
+			path:   "/auth/signin/challenge",
+			form:   mfaForm,
+			setup:  withMFA,
+			status: http.StatusOK,
+			body:   `"refreshToken":"`,
+		},
+		{
+			name: "MFAWrongCode",
+			path: "/auth/signin/challenge",
+			form: mfaForm,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				withMFA(t, f, form)
+				form.Set("code", "000000")
+			},
+			status: http.StatusUnauthorized,
+			body:   "Invalid code provided, please try again.",
+		},
+		{
+			name: "MFAUnknownSession",
+			path: "/auth/signin/challenge",
+			form: mfaForm,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				withMFA(t, f, form)
+				form.Set("session", "session-unknown")
+			},
+			status: http.StatusUnauthorized,
+			body:   "The sign in session has expired, please sign in again.",
+		},
+		{
+			name: "MFASessionUsedTwice",
+			path: "/auth/signin/challenge",
+			form: mfaForm,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				withMFA(t, f, form)
+				if rec := f.post("/auth/signin/challenge", form); rec.Code != http.StatusOK {
+					t.Fatalf("first answer: status = %d: %s", rec.Code, rec.Body.String())
+				}
+			},
+			status: http.StatusUnauthorized,
+			body:   "The sign in session has expired, please sign in again.",
+		},
+		{
+			name:   "MissingFields",
+			path:   "/auth/signin/challenge",
+			form:   url.Values{"username": {username}, "challenge": {identity.ChallengeSoftwareTokenMFA}},
+			status: http.StatusBadRequest,
+			body:   "Username, challenge and session are required fields",
+		},
+		{
+			name:   "MissingCode",
+			path:   "/auth/signin/challenge",
+			form:   url.Values{"username": {username}, "challenge": {identity.ChallengeSoftwareTokenMFA}, "session": {"session-1"}},
+			status: http.StatusBadRequest,
+			body:   "The answer to the challenge is missing",
+		},
+		{
+			name:   "MissingNewPassword",
+			path:   "/auth/signin/challenge",
+			form:   url.Values{"username": {username}, "challenge": {identity.ChallengeNewPasswordRequired}, "session": {"session-1"}, "code": {totp}},
+			status: http.StatusBadRequest,
+			body:   "The answer to the challenge is missing",
+		},
+		{
+			name: "UnsupportedChallenge",
+			path: "/auth/signin/challenge",
+			form: mfaForm,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				withMFA(t, f, form)
+				form.Set("challenge", "SELECT_MFA_TYPE")
+			},
+			status: http.StatusBadRequest,
+			body:   "Challenge answer is not valid",
+		},
+		{
+			name:   "NewPasswordSuccess",
+			path:   "/auth/signin/challenge",
+			form:   passwordForm,
+			setup:  withNewPassword,
+			status: http.StatusOK,
+			body:   `"refreshToken":"`,
+		},
+		{
+			name: "NewPasswordThenMFA",
+			path: "/auth/signin/challenge",
+			form: passwordForm,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				f.pool.Update(username, func(u *cognitotest.User) {
+					u.MFASecret = "SECRET"
+					u.MFACode = totp
+					u.MFAEnabled = true
+				})
+				withNewPassword(t, f, form)
+			},
+			status: http.StatusAccepted,
+			body:   `"challenge":"SOFTWARE_TOKEN_MFA"`,
+		},
+		{
+			name: "NewPasswordTooWeak",
+			path: "/auth/signin/challenge",
+			form: passwordForm,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				withNewPassword(t, f, form)
+				form.Set("newPassword", "weak")
+			},
+			status: http.StatusBadRequest,
+			body:   "Password must include uppercase, special-character and number",
+		},
+		{
+			name: "Unexpected",
+			path: "/auth/signin/challenge",
+			form: mfaForm,
+			setup: func(t *testing.T, f *fixture, form url.Values) {
+				withMFA(t, f, form)
+				fail("RespondToAuthChallenge", cognitoidentityprovider.ErrCodeInternalErrorException)(t, f, form)
+			},
+			status: http.StatusInternalServerError,
+			body:   "Something went wrong while answering the challenge",
+		},
+	})
+}
+
+func TestMFA(t *testing.T) {
+	// accessToken signs the seeded user in and returns their access token
+	accessToken := func(t *testing.T, f *fixture) string {
+		t.Helper()
+
+		result, err := f.s.Identity.SignIn(context.Background(), username, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Tokens.AccessToken
+	}
+
+	t.Run("Enrol", func(t *testing.T) {
+		f := newFixture(t)
+		token := accessToken(t, f)
+
+		rec := f.postWithToken("/auth/mfa/setup", token, nil)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "otpauth://totp/") {
+			t.Fatalf("setup: got %d %s", rec.Code, rec.Body.String())
+		}
+
+		rec = f.postWithToken("/auth/mfa/verify", token, url.Values{"code": {"000000"}})
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "Invalid code provided") {
+			t.Fatalf("wrong code: got %d %s", rec.Code, rec.Body.String())
+		}
+
+		rec = f.postWithToken("/auth/mfa/verify", token, url.Values{"code": {f.mfaCode(t)}})
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "MFA has been enabled!") {
+			t.Fatalf("verify: got %d %s", rec.Code, rec.Body.String())
+		}
+
+		rec = f.post("/auth/signin", url.Values{"username": {username}, "password": {password}})
+		if rec.Code != http.StatusAccepted || !strings.Contains(rec.Body.String(), identity.ChallengeSoftwareTokenMFA) {
+			t.Fatalf("sign in after enrolment: got %d %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("VerifyMissingCode", func(t *testing.T) {
+		f := newFixture(t)
+
+		rec := f.postWithToken("/auth/mfa/verify", accessToken(t, f), nil)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "Code is a required field") {
+			t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("VerifyBeforeSetup", func(t *testing.T) {
+		f := newFixture(t)
+
+		rec := f.postWithToken("/auth/mfa/verify", accessToken(t, f), url.Values{"code": {"000000"}})
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("SetupWithRevokedToken", func(t *testing.T) {
+		f := newFixture(t)
+
+		rec := f.postWithToken("/auth/mfa/setup", "access-unknown", nil)
+		if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "Access token is not valid for MFA setup") {
+			t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+// a pool that requires MFA stops users without TOTP at MFA_SETUP, they enrol
+// with the session of the challenge and get their tokens from the verify step
+func TestMFASetupChallenge(t *testing.T) {
+	newRequiredFixture := func(t *testing.T) (*fixture, string) {
+		t.Helper()
+
+		f := newFixture(t)
+		f.pool.MFARequired = true
+
+		rec := f.post("/auth/signin", url.Values{"username": {username}, "password": {password}})
+		if rec.Code != http.StatusAccepted || !strings.Contains(rec.Body.String(), `"challenge":"MFA_SETUP"`) {
+			t.Fatalf("sign in: got %d %s", rec.Code, rec.Body.String())
+		}
+
+		return f, f.challenge(t, identity.ChallengeMFASetup)
+	}
+
+	// setup starts enrolment with the session of the challenge and returns the
+	// session to verify the code with
+	setup := func(t *testing.T, f *fixture, session string) string {
+		t.Helper()
+
+		rec := f.post("/auth/mfa/setup", url.Values{"username": {username}, "session": {session}})
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "otpauth://totp/") {
+			t.Fatalf("setup: got %d %s", rec.Code, rec.Body.String())
+		}
+
+		next := strings.SplitN(strings.SplitN(rec.Body.String(), `"session":"`, 2)[1], `"`, 2)[0]
+		if next == "" || next == session {
+			t.Fatalf("setup: no new session in %s", rec.Body.String())
+		}
+		return next
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		f, session := newRequiredFixture(t)
+		next := setup(t, f, session)
+
+		rec := f.post("/auth/mfa/verify", url.Values{"username": {username}, "session": {next}, "code": {f.mfaCode(t)}})
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"refreshToken":"`) {
+			t.Fatalf("verify: got %d %s", rec.Code, rec.Body.String())
+		}
+
+		rec = f.post("/auth/signin", url.Values{"username": {username}, "password": {password}})
+		if rec.Code != http.StatusAccepted || !strings.Contains(rec.Body.String(), identity.ChallengeSoftwareTokenMFA) {
+			t.Fatalf("sign in after enrolment: got %d %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("WrongCode", func(t *testing.T) {
+		f, session := newRequiredFixture(t)
+		next := setup(t, f, session)
+
+		rec := f.post("/auth/mfa/verify", url.Values{"username": {username}, "session": {next}, "code": {"000000"}})
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "Invalid code provided") {
+			t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+		}
+
+		// the failure counts like a wrong password
+		rec = f.post("/auth/mfa/verify", url.Values{"username": {username}, "session": {next}, "code": {f.mfaCode(t)}})
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("retry: got %d %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("SetupMissingUsername", func(t *testing.T) {
+		f, session := newRequiredFixture(t)
+
+		rec := f.post("/auth/mfa/setup", url.Values{"session": {session}})
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "Username is required with a session") {
+			t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("SetupUnknownSession", func(t *testing.T) {
+		f, _ := newRequiredFixture(t)
+
+		rec := f.post("/auth/mfa/setup", url.Values{"username": {username}, "session": {"session-unknown"}})
+		if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "The sign in session has expired") {
+			t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("VerifyWithSetupSession", func(t *testing.T) {
+		// the session of the challenge itself is spent by setup
+		f, session := newRequiredFixture(t)
+		setup(t, f, session)
+
+		rec := f.post("/auth/mfa/verify", url.Values{"username": {username}, "session": {session}, "code": {f.mfaCode(t)}})
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("NoTokenWithoutSession", func(t *testing.T) {
+		f, _ := newRequiredFixture(t)
+
+		rec := f.post("/auth/mfa/setup", url.Values{"username": {username}})
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+// VerifyEmail mirrors the confirmed account into the users table
+func TestVerifyEmailMirrorsUser(t *testing.T) {
+	f := newFixture(t)
+	f.pool.Update(username, func(u *cognitotest.User) {
+		u.Confirmed = false
+		u.ConfirmationCode = code
+	})
+
+	rec := f.post("/auth/verify", url.Values{"username": {username}, "code": {code}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	u, ok := f.pool.User(username)
+	if !ok {
+		t.Fatal("user is missing from the pool")
+	}
+	mirrored, err := f.s.Users.Get(context.Background(), u.Sub)
+	if err != nil {
+		t.Fatalf("users mirror: %v", err)
+	}
+	if mirrored.Username != username {
+		t.Fatalf("mirrored username = %q, want %q", mirrored.Username, username)
+	}
+}
+
+// sentCodes keeps the last code the local provider sent for every purpose
+type sentCodes map[string]string
+
+func (s sentCodes) SendCode(_ context.Context, _, purpose, code string) error {
+	s[purpose] = code
+	return nil
+}
+
+// providers without an admin API are mirrored too
+func TestVerifyEmailMirrorsLocalUser(t *testing.T) {
+	f := newFixture(t)
+
+	signer, err := identity.NewSigner("http://localhost:8080", "local", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := sentCodes{}
+	local := identity.NewLocalProvider(testdb.Open(t, identity.LocalModels()...), signer, identity.LocalOptions{Sender: sent})
+	f.s.Identity = local
+
+	const email = "bob@example.com"
+	err = local.SignUp(context.Background(), identity.SignUpInput{Username: email, Email: email, Password: password, FirstName: "Bob", LastName: "Doe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := f.post("/auth/verify", url.Values{"username": {email}, "code": {sent[identity.CodePurposeConfirmSignUp]}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	u, err := local.LookupUser(context.Background(), email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirrored, err := f.s.Users.Get(context.Background(), u.Subject)
+	if err != nil {
+		t.Fatalf("users mirror: %v", err)
+	}
+	if mirrored.Username != email || mirrored.GivenName != "Bob" {
+		t.Fatalf("mirrored user = %+v", mirrored)
+	}
+}
+
+// remoteIPs records the client IPs the CAPTCHA is verified for
+type remoteIPs []string
+
+func (r *remoteIPs) Verify(ctx context.Context, token, remoteIP string) error {
+	*r = append(*r, remoteIP)
+	return nil
+}
+
+// a forged X-Forwarded-For must neither reset the sign up throttle nor reach
+// the CAPTCHA service as the client IP
+func TestSignUpIgnoresForwardedFor(t *testing.T) {
+	f := newFixture(t)
+	verified := &remoteIPs{}
+	f.s.SignUpThrottle = authctx.NewThrottle(0, 1, time.Hour)
+	f.s.SignUpPolicy = signup.NewPolicy(signup.Options{Captcha: verified})
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		form := url.Values{
+			"username":     {fmt.Sprintf("bob%d@example.com", i)},
+			"password":     {password},
+			"firstName":    {"Bob"},
+			"lastName":     {"Builder"},
+			"captchaToken": {"token"},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/auth/signup", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.Header.Set(echo.HeaderXForwardedFor, fmt.Sprintf("198.51.100.%d", i+1))
+		req.Header.Set(echo.HeaderXRealIP, fmt.Sprintf("198.51.100.%d", i+1))
+		req.RemoteAddr = "203.0.113.7:4711"
+
+		rec := httptest.NewRecorder()
+		f.echo.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("sign up %d: status = %d, want %d: %s", i, rec.Code, want, rec.Body.String())
+		}
+	}
+
+	if len(*verified) != 1 || (*verified)[0] != "203.0.113.7" {
+		t.Fatalf("CAPTCHA verified for %v, want [203.0.113.7]", *verified)
+	}
+}
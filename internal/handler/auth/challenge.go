@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"backend/internal/invitation"
+	"backend/internal/svc"
+	"backend/pkg/identity"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// @Summary Sign In Challenge
+// @Description Answers the challenge returned by sign in: a TOTP or SMS code for MFA, or a new password for NEW_PASSWORD_REQUIRED
+// @Tags Auth
+// @Accept multipart/form-data
+// @Param username formData string true "Username"
+// @Param challenge formData string true "Challenge name"
+// @Param session formData string true "Session returned with the challenge"
+// @Param code formData string false "MFA code"
+// @Param newPassword formData string false "New Password"
+// @Success 200 {object} SuccessResponse
+// @Success 202 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /signin/challenge [post]
+func SignInChallenge(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		_, span := tracer.Start(c.Request().Context(), "handler.SignInChallenge")
+		defer span.End()
+
+		input := identity.ChallengeResponse{
+			Name:        c.FormValue("challenge"),
+			Session:     c.FormValue("session"),
+			Username:    c.FormValue("username"),
+			Code:        c.FormValue("code"),
+			NewPassword: c.FormValue("newPassword"),
+		}
+		span.SetAttributes(attribute.String("auth.challenge", input.Name))
+
+		if input.Name == "" || input.Session == "" || input.Username == "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Username, challenge and session are required fields",
+			})
+		}
+
+		if input.Name == identity.ChallengeNewPasswordRequired && input.NewPassword == "" ||
+			input.Name != identity.ChallengeNewPasswordRequired && input.Code == "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "The answer to the challenge is missing",
+			})
+		}
+
+		if blocked, err := CheckLoginGuard(s, c, span, input.Username); blocked {
+			return err
+		}
+
+		var inv *invitation.Invitation
+		if input.Name == identity.ChallengeNewPasswordRequired {
+			found, blocked, err := checkInvitation(s, c, span, input.Username)
+			if blocked {
+				return err
+			}
+			inv = found
+		}
+
+		result, err := s.Identity.RespondToChallenge(c.Request().Context(), input)
+		if err != nil {
+			span.RecordError(err)
+			switch {
+			case errors.Is(err, identity.ErrCodeMismatch):
+				RecordLoginFailure(s, c, span, input.Username)
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "Invalid code provided, please try again.",
+					"error":   err.Error(),
+				})
+			case errors.Is(err, identity.ErrNotAuthorized), errors.Is(err, identity.ErrExpiredCode):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "The sign in session has expired, please sign in again.",
+					"error":   err.Error(),
+				})
+			case errors.Is(err, identity.ErrInvalidPassword):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Password must include uppercase, special-character and number",
+					"error":   err.Error(),
+				})
+			case errors.Is(err, identity.ErrInvalidParameter):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Challenge answer is not valid",
+					"error":   err.Error(),
+				})
+			case errors.Is(err, identity.ErrNotSupported):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotImplemented))
+				return c.JSON(http.StatusNotImplemented, echo.Map{
+					"message": "Sign in challenges are not supported by the identity provider",
+				})
+			default:
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+				return c.JSON(http.StatusInternalServerError, echo.Map{
+					"message": "Something went wrong while answering the challenge",
+					"error":   err.Error(),
+				})
+			}
+		}
+
+		if result.Challenge == nil {
+			s.LoginGuard.Success(input.Username)
+		}
+		if inv != nil {
+			// the temporary password is gone once a new one is set
+			if err := s.Invitations.Accept(c.Request().Context(), inv); err != nil {
+				span.RecordError(err)
+			}
+		}
+		return signInResponse(s, c, span, input.Username, result)
+	}
+}
+
+// signInResponse writes either the tokens of a completed sign in or the next
+// challenge the client has to answer.
+func signInResponse(s *svc.ServiceContext, c echo.Context, span trace.Span, username string, result *identity.AuthResult) error {
+	if result.Challenge != nil {
+		span.SetAttributes(
+			attribute.Key("http.status_code").Int(http.StatusAccepted),
+			attribute.String("auth.challenge", result.Challenge.Name),
+		)
+		return c.JSON(http.StatusAccepted, echo.Map{
+			"message":   "Additional verification is required",
+			"challenge": result.Challenge.Name,
+			"session":   result.Challenge.Session,
+			"username":  username,
+		})
+	}
+
+	if err := s.Sessions.SetTokens(c, result.Tokens); err != nil {
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+		span.RecordError(err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "Something went wrong while starting the session",
+			"error":   err.Error(),
+		})
+	}
+
+	span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+	if s.Sessions.CookieMode() {
+		// tokens stay in HttpOnly cookies, out of reach of scripts
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "You have successfully signed in!",
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"message":      "You have successfully signed in!",
+		"refreshToken": result.Tokens.RefreshToken,
+		"token":        result.Tokens.IDToken,
+	})
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/internal/svc"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CheckLoginGuard answers 429 when username or the client IP is in backoff or
+// locked. The response is the same whether or not the account exists.
+func CheckLoginGuard(s *svc.ServiceContext, c echo.Context, span trace.Span, username string) (bool, error) {
+	wait := s.LoginGuard.Check(username, c.RealIP())
+	if wait <= 0 {
+		return false, nil
+	}
+
+	return true, TooManyAttempts(c, span, wait)
+}
+
+// RecordLoginFailure counts a failed credential check against username and the client IP
+func RecordLoginFailure(s *svc.ServiceContext, c echo.Context, span trace.Span, username string) {
+	if s.LoginGuard.Failure(username, c.RealIP()) {
+		span.SetAttributes(attribute.Bool("auth.locked", true))
+	}
+}
+
+// TooManyAttempts answers 429 with a Retry-After of wait
+func TooManyAttempts(c echo.Context, span trace.Span, wait time.Duration) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusTooManyRequests))
+	c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+	return c.JSON(http.StatusTooManyRequests, echo.Map{
+		"message":    "Too many failed attempts, please try again later",
+		"code":       "too_many_attempts",
+		"retryAfter": seconds,
+	})
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"backend/internal/invitation"
+	"backend/internal/svc"
+	"backend/pkg/identity"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// @Summary Get Invitation
+// @Description Returns the email and expiry of the invitation a link token belongs to
+// @Tags Auth
+// @Param token query string true "Invitation token"
+// @Success 200 {object} SuccessResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 410 {object} ErrorResponse
+// @Router /invitations [get]
+func GetInvitation(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.GetInvitation")
+		defer span.End()
+
+		inv, err := s.Invitations.ByToken(ctx, c.QueryParam("token"))
+		if err == nil {
+			err = inv.Usable(time.Now())
+		}
+		if err != nil {
+			return invitationError(c, span, err)
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, echo.Map{
+			"email":     inv.Email,
+			"expiresAt": inv.ExpiresAt,
+		})
+	}
+}
+
+// @Summary Accept Invitation
+// @Description Sets the password of an invited account and signs the invitee in
+// @Tags Auth
+// @Accept multipart/form-data
+// @Param token formData string true "Invitation token"
+// @Param newPassword formData string true "New Password"
+// @Success 200 {object} SuccessResponse
+// @Success 202 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 410 {object} ErrorResponse
+// @Router /invitations/accept [post]
+func AcceptInvitation(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.AcceptInvitation")
+		defer span.End()
+
+		token := c.FormValue("token")
+		newPassword := c.FormValue("newPassword")
+
+		if token == "" || newPassword == "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Token and new password are required fields",
+			})
+		}
+
+		inv, err := s.Invitations.ByToken(ctx, token)
+		if err == nil {
+			err = inv.Usable(time.Now())
+		}
+		if err != nil {
+			return invitationError(c, span, err)
+		}
+
+		// the token is the temporary password, signing in with it stops at
+		// NEW_PASSWORD_REQUIRED as long as the invitee hasn't chosen one
+		result, err := s.Identity.SignIn(ctx, inv.Username, token)
+		if err == nil && (result.Challenge == nil || result.Challenge.Name != identity.ChallengeNewPasswordRequired) {
+			err = invitation.ErrAccepted
+		}
+		if err != nil {
+			if errors.Is(err, identity.ErrNotAuthorized) || errors.Is(err, identity.ErrUserNotFound) {
+				// the temporary password was replaced or the account is gone
+				err = invitation.ErrRevoked
+			}
+			return invitationError(c, span, err)
+		}
+
+		result, err = s.Identity.RespondToChallenge(ctx, identity.ChallengeResponse{
+			Name:        identity.ChallengeNewPasswordRequired,
+			Session:     result.Challenge.Session,
+			Username:    inv.Username,
+			NewPassword: newPassword,
+		})
+		if err != nil {
+			span.RecordError(err)
+			if errors.Is(err, identity.ErrInvalidPassword) {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Password must include uppercase, special-character and number",
+					"error":   err.Error(),
+				})
+			}
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something went wrong while accepting the invitation",
+				"error":   err.Error(),
+			})
+		}
+
+		if err := s.Invitations.Accept(ctx, inv); err != nil {
+			span.RecordError(err)
+		}
+
+		return signInResponse(s, c, span, inv.Username, result)
+	}
+}
+
+// checkInvitation rejects completing NEW_PASSWORD_REQUIRED for an account whose
+// invitation expired or was revoked. Accounts that weren't invited pass.
+func checkInvitation(s *svc.ServiceContext, c echo.Context, span trace.Span, username string) (*invitation.Invitation, bool, error) {
+	inv, err := s.Invitations.Latest(c.Request().Context(), username)
+	if err != nil {
+		if !errors.Is(err, invitation.ErrNotFound) {
+			span.RecordError(err)
+		}
+		return nil, false, nil
+	}
+
+	if err := inv.Usable(time.Now()); err != nil {
+		return nil, true, invitationError(c, span, err)
+	}
+
+	return inv, false, nil
+}
+
+func invitationError(c echo.Context, span trace.Span, err error) error {
+	span.RecordError(err)
+	switch {
+	case errors.Is(err, invitation.ErrNotFound):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotFound))
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"message": "Invitation not found",
+		})
+	case errors.Is(err, invitation.ErrExpired):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusGone))
+		return c.JSON(http.StatusGone, echo.Map{
+			"message": "The invitation has expired, please ask for a new one",
+			"code":    "invitation_expired",
+		})
+	case errors.Is(err, invitation.ErrRevoked):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusGone))
+		return c.JSON(http.StatusGone, echo.Map{
+			"message": "The invitation is no longer valid",
+			"code":    "invitation_revoked",
+		})
+	case errors.Is(err, invitation.ErrAccepted):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusGone))
+		return c.JSON(http.StatusGone, echo.Map{
+			"message": "The invitation has already been accepted, you can sign in",
+			"code":    "invitation_accepted",
+		})
+	default:
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "Something went wrong while loading the invitation",
+			"error":   err.Error(),
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/invitation"
+	"backend/pkg/cognito/cognitotest"
+	"backend/pkg/identity"
+)
+
+const invitee = "carol@example.com"
+
+// revoke revokes inv like an admin would
+func revoke(t *testing.T, f *fixture, inv *invitation.Invitation) {
+	t.Helper()
+
+	if err := f.s.Invitations.Revoke(context.Background(), inv); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// invite creates the account of a link invitation the way the admin handler
+// does and returns the invitation with its token
+func (f *fixture) invite(t *testing.T) (*invitation.Invitation, string) {
+	t.Helper()
+
+	token, err := invitation.NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.s.Identity.(identity.Inviter).InviteUser(context.Background(), identity.InviteInput{
+		Username:          invitee,
+		Email:             invitee,
+		TemporaryPassword: token,
+		SuppressMessage:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inv, err := f.s.Invitations.Create(context.Background(), invitee, invitee, invitation.DeliveryLink, "admin@example.com", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inv, token
+}
+
+// expire lets the validity of inv run out
+func expire(t *testing.T, f *fixture, inv *invitation.Invitation) {
+	t.Helper()
+
+	if err := f.s.DB.Model(inv).Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetInvitation(t *testing.T) {
+	f := newFixture(t)
+	_, token := f.invite(t)
+
+	rec := httptest.NewRecorder()
+	f.echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/invitations?token="+url.QueryEscape(token), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Email != invitee {
+		t.Fatalf("body = %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	f.echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/invitations?token=Inv1_unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown token: status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, f *fixture, inv *invitation.Invitation)
+		status int
+		code   string
+	}{
+		{name: "Pending", status: http.StatusOK},
+		{name: "Expired", setup: expire, status: http.StatusGone, code: "invitation_expired"},
+		{name: "Revoked", setup: revoke, status: http.StatusGone, code: "invitation_revoked"},
+		{name: "Accepted", setup: func(t *testing.T, f *fixture, inv *invitation.Invitation) {
+			if err := f.s.Invitations.Accept(context.Background(), inv); err != nil {
+				t.Fatal(err)
+			}
+		}, status: http.StatusGone, code: "invitation_accepted"},
+		{name: "PasswordReplaced", setup: func(t *testing.T, f *fixture, inv *invitation.Invitation) {
+			f.pool.Update(invitee, func(u *cognitotest.User) { u.Password = "Temp-000000!" })
+		}, status: http.StatusGone, code: "invitation_revoked"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			inv, token := f.invite(t)
+			if tt.setup != nil {
+				tt.setup(t, f, inv)
+			}
+
+			rec := f.post("/auth/invitations/accept", url.Values{"token": {token}, "newPassword": {"N3w-Passw0rd!"}})
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.code != "" && !strings.Contains(rec.Body.String(), tt.code) {
+				t.Fatalf("body = %s, want code %s", rec.Body.String(), tt.code)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			got, err := f.s.Invitations.Get(context.Background(), inv.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status(time.Now()) != invitation.StatusAccepted {
+				t.Fatalf("invitation status = %s", got.Status(time.Now()))
+			}
+			if _, err := f.s.Identity.SignIn(context.Background(), invitee, "N3w-Passw0rd!"); err != nil {
+				t.Fatalf("sign in with the new password: %v", err)
+			}
+		})
+	}
+}
+
+func TestSignInChallengeChecksInvitation(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, f *fixture, inv *invitation.Invitation)
+		status int
+	}{
+		{name: "Pending", status: http.StatusOK},
+		{name: "Expired", setup: expire, status: http.StatusGone},
+		{name: "Revoked", setup: revoke, status: http.StatusGone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			inv, token := f.invite(t)
+
+			// the invitee signs in with the temporary password instead of the link
+			result, err := f.s.Identity.SignIn(context.Background(), invitee, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Challenge == nil || result.Challenge.Name != identity.ChallengeNewPasswordRequired {
+				t.Fatalf("sign in result = %+v", result)
+			}
+			if tt.setup != nil {
+				tt.setup(t, f, inv)
+			}
+
+			rec := f.post("/auth/signin/challenge", url.Values{
+				"username":    {invitee},
+				"challenge":   {identity.ChallengeNewPasswordRequired},
+				"session":     {result.Challenge.Session},
+				"newPassword": {"N3w-Passw0rd!"},
+			})
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+
+			got, err := f.s.Invitations.Get(context.Background(), inv.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if accepted := got.AcceptedAt != nil; accepted != (tt.status == http.StatusOK) {
+				t.Fatalf("accepted = %v after status %d", accepted, rec.Code)
+			}
+		})
+	}
+}
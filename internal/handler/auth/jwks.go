@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"net/http"
+
+	"backend/internal/svc"
+	"backend/pkg/identity"
+
+	"github.com/labstack/echo/v4"
+)
+
+// @Summary JSON Web Key Set
+// @Description Public keys for tokens signed by the local identity provider
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} ErrorResponse
+// @Router /.well-known/jwks.json [get]
+func JWKS(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		_, span := tracer.Start(c.Request().Context(), "handler.JWKS")
+		defer span.End()
+
+		publisher, ok := s.Identity.(identity.KeySetPublisher)
+		if !ok {
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"message": "Keys are published by the identity provider",
+			})
+		}
+
+		set, err := publisher.KeySet()
+		if err != nil {
+			span.RecordError(err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something went wrong while loading the signing keys",
+				"error":   err.Error(),
+			})
+		}
+
+		c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age=3600")
+		return c.JSON(http.StatusOK, set)
+	}
+}
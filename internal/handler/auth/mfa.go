@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	authctx "backend/internal/auth"
+	"backend/internal/svc"
+	"backend/pkg/identity"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// @Summary MFA Setup
+// @Description Starts TOTP enrolment and returns the secret together with an otpauth URI for authenticator apps. A sign in stopped at the MFA_SETUP challenge sends its username and session instead of a token and gets the session to verify the code with.
+// @Tags Auth
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Param username formData string false "Username, with session"
+// @Param session formData string false "Session of the MFA_SETUP challenge"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /mfa/setup [post]
+func MFASetup(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		_, span := tracer.Start(c.Request().Context(), "handler.MFASetup")
+		defer span.End()
+
+		mfa, ok := s.Identity.(identity.MFAProvider)
+		if !ok {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotImplemented))
+			return c.JSON(http.StatusNotImplemented, echo.Map{
+				"message": "MFA is not supported by the identity provider",
+			})
+		}
+
+		if session := c.FormValue("session"); session != "" {
+			return mfaSetupSession(s, c, span, mfa, session)
+		}
+
+		principal := authctx.MustPrincipal(c)
+		secret, err := mfa.AssociateSoftwareToken(c.Request().Context(), principal.Token)
+		if err != nil {
+			span.RecordError(err)
+			if errors.Is(err, identity.ErrNotAuthorized) {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "Access token is not valid for MFA setup",
+					"error":   err.Error(),
+				})
+			}
+
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something went wrong while setting up MFA",
+				"error":   err.Error(),
+			})
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{
+			"message":    "Scan the code with your authenticator app and verify it",
+			"secretCode": secret,
+			"otpauthUri": otpauthURI(s.Config.Auth.MFA_ISSUER, principal.Username, secret),
+		})
+	}
+}
+
+// @Summary MFA Verify
+// @Description Verifies the first TOTP code of an authenticator app and enables it as the preferred factor. With the username and the session returned by MFA setup it completes a sign in stopped at MFA_SETUP and returns its tokens.
+// @Tags Auth
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Param code formData string true "TOTP code"
+// @Param deviceName formData string false "Device Name"
+// @Param username formData string false "Username, with session"
+// @Param session formData string false "Session returned by MFA setup"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /mfa/verify [post]
+func MFAVerify(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		_, span := tracer.Start(c.Request().Context(), "handler.MFAVerify")
+		defer span.End()
+
+		code := c.FormValue("code")
+		deviceName := c.FormValue("deviceName")
+
+		if code == "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Code is a required field",
+			})
+		}
+
+		mfa, ok := s.Identity.(identity.MFAProvider)
+		if !ok {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotImplemented))
+			return c.JSON(http.StatusNotImplemented, echo.Map{
+				"message": "MFA is not supported by the identity provider",
+			})
+		}
+
+		if session := c.FormValue("session"); session != "" {
+			return mfaVerifySession(s, c, span, mfa, session, code, deviceName)
+		}
+
+		principal := authctx.MustPrincipal(c)
+		err := mfa.VerifySoftwareToken(c.Request().Context(), principal.Token, code, deviceName)
+		if err != nil {
+			span.RecordError(err)
+			switch {
+			case errors.Is(err, identity.ErrCodeMismatch):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": "Invalid code provided, please try again.",
+					"error":   err.Error(),
+				})
+			case errors.Is(err, identity.ErrNotAuthorized):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "Access token is not valid for MFA setup",
+					"error":   err.Error(),
+				})
+			default:
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+				return c.JSON(http.StatusInternalServerError, echo.Map{
+					"message": "Something went wrong while verifying MFA",
+					"error":   err.Error(),
+				})
+			}
+		}
+
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "MFA has been enabled!",
+		})
+	}
+}
+
+// mfaSetupSession starts enrolment for a sign in stopped at MFA_SETUP
+func mfaSetupSession(s *svc.ServiceContext, c echo.Context, span trace.Span, mfa identity.MFAProvider, session string) error {
+	username := c.FormValue("username")
+	if username == "" {
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "Username is required with a session",
+		})
+	}
+
+	secret, next, err := mfa.AssociateSoftwareTokenSession(c.Request().Context(), session)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, identity.ErrNotAuthorized) {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+			return c.JSON(http.StatusUnauthorized, echo.Map{
+				"message": "The sign in session has expired, please sign in again.",
+				"error":   err.Error(),
+			})
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "Something went wrong while setting up MFA",
+			"error":   err.Error(),
+		})
+	}
+
+	span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+	return c.JSON(http.StatusOK, echo.Map{
+		"message":    "Scan the code with your authenticator app and verify it",
+		"secretCode": secret,
+		"otpauthUri": otpauthURI(s.Config.Auth.MFA_ISSUER, username, secret),
+		"session":    next,
+		"username":   username,
+	})
+}
+
+// mfaVerifySession verifies the first code of a sign in stopped at MFA_SETUP
+// and answers the challenge with it. Wrong codes count against the login
+// guard like those of the MFA challenge.
+func mfaVerifySession(s *svc.ServiceContext, c echo.Context, span trace.Span, mfa identity.MFAProvider, session, code, deviceName string) error {
+	username := c.FormValue("username")
+	if username == "" {
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "Username is required with a session",
+		})
+	}
+
+	if blocked, err := CheckLoginGuard(s, c, span, username); blocked {
+		return err
+	}
+
+	next, err := mfa.VerifySoftwareTokenSession(c.Request().Context(), session, code, deviceName)
+	if err != nil {
+		return mfaSessionError(s, c, span, username, err)
+	}
+
+	result, err := s.Identity.RespondToChallenge(c.Request().Context(), identity.ChallengeResponse{
+		Name:     identity.ChallengeMFASetup,
+		Session:  next,
+		Username: username,
+	})
+	if err != nil {
+		return mfaSessionError(s, c, span, username, err)
+	}
+
+	if result.Challenge == nil {
+		s.LoginGuard.Success(username)
+	}
+	return signInResponse(s, c, span, username, result)
+}
+
+func mfaSessionError(s *svc.ServiceContext, c echo.Context, span trace.Span, username string, err error) error {
+	span.RecordError(err)
+	switch {
+	case errors.Is(err, identity.ErrCodeMismatch):
+		RecordLoginFailure(s, c, span, username)
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "Invalid code provided, please try again.",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, identity.ErrNotAuthorized):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"message": "The sign in session has expired, please sign in again.",
+			"error":   err.Error(),
+		})
+	default:
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "Something went wrong while verifying MFA",
+			"error":   err.Error(),
+		})
+	}
+}
+
+// otpauthURI builds the key URI format understood by authenticator apps
+func otpauthURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
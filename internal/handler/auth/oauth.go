@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	authctx "backend/internal/auth"
+	"backend/internal/svc"
+	"backend/pkg/identity"
+	"backend/pkg/oauth"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// providerAliases maps friendly path names to the Cognito identity provider names
+var providerAliases = map[string]string{
+	"google":   "Google",
+	"apple":    "SignInWithApple",
+	"facebook": "Facebook",
+	"amazon":   "LoginWithAmazon",
+	"cognito":  "COGNITO",
+}
+
+// @Summary Start Federated Sign In
+// @Description Redirects to the hosted UI of the user pool to sign in with the given identity provider, e.g. google, apple or a SAML IdP configured in the pool
+// @Tags Auth
+// @Param provider path string true "Identity provider"
+// @Success 302
+// @Failure 404 {object} ErrorResponse
+// @Failure 501 {object} ErrorResponse
+// @Router /oauth/{provider}/start [get]
+func OAuthStart(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		_, span := tracer.Start(c.Request().Context(), "handler.OAuthStart")
+		defer span.End()
+
+		if s.OAuth == nil {
+			return oauthNotConfigured(c, span)
+		}
+
+		provider, ok := resolveProvider(s.Config.OAuth.PROVIDERS, c.Param("provider"))
+		if !ok {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotFound))
+			return c.JSON(http.StatusNotFound, echo.Map{
+				"message": "Unknown identity provider",
+			})
+		}
+		span.SetAttributes(attribute.String("auth.oauth_provider", provider))
+
+		state := authctx.OAuthState{Provider: provider}
+		var err error
+		if state.State, err = oauth.RandomString(24); err == nil {
+			if state.Nonce, err = oauth.RandomString(24); err == nil {
+				state.Verifier, err = oauth.NewVerifier()
+			}
+		}
+		if err == nil {
+			err = s.Sessions.SetOAuthState(c, s.Config.OAuth.STATE_COOKIE, state, s.Config.OAuth.STATE_TTL)
+		}
+		if err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			span.RecordError(err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something went wrong while starting the sign in",
+				"error":   err.Error(),
+			})
+		}
+
+		if provider == "COGNITO" {
+			// no identity_provider shows the hosted sign in page itself
+			provider = ""
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusFound))
+		return c.Redirect(http.StatusFound, s.OAuth.AuthCodeURL(oauth.AuthRequest{
+			State:            state.State,
+			Nonce:            state.Nonce,
+			CodeChallenge:    oauth.Challenge(state.Verifier),
+			IdentityProvider: provider,
+		}))
+	}
+}
+
+// @Summary Federated Sign In Callback
+// @Description Completes a federated sign in. The hosted UI redirects here with an authorization code, which is exchanged for tokens that are returned like Sign In does.
+// @Tags Auth
+// @Param code query string false "Authorization code"
+// @Param state query string true "State"
+// @Param error query string false "Error reported by the authorization server"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 502 {object} ErrorResponse
+// @Router /oauth/callback [get]
+func OAuthCallback(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.OAuthCallback")
+		defer span.End()
+
+		if s.OAuth == nil {
+			return oauthNotConfigured(c, span)
+		}
+
+		state, err := s.Sessions.TakeOAuthState(c, s.Config.OAuth.STATE_COOKIE)
+		if err != nil || subtle.ConstantTimeCompare([]byte(state.State), []byte(c.QueryParam("state"))) != 1 {
+			if err != nil {
+				span.RecordError(err)
+			}
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "The sign in session is invalid or has expired, please start again",
+				"code":    "oauth_state_invalid",
+			})
+		}
+		span.SetAttributes(attribute.String("auth.oauth_provider", state.Provider))
+
+		if errCode := c.QueryParam("error"); errCode != "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+			span.RecordError(errors.New("oauth: " + errCode))
+			return c.JSON(http.StatusUnauthorized, echo.Map{
+				"message": "Sign in with the identity provider failed",
+				"error":   strings.TrimSpace(errCode + " " + c.QueryParam("error_description")),
+			})
+		}
+
+		code := c.QueryParam("code")
+		if code == "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Authorization code is missing",
+			})
+		}
+
+		token, err := s.OAuth.Exchange(ctx, code, state.Verifier)
+		if err != nil {
+			span.RecordError(err)
+			var oauthErr *oauth.Error
+			if errors.As(err, &oauthErr) && oauthErr.Code == "invalid_grant" {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "The authorization code is invalid or has expired, please start again",
+					"error":   err.Error(),
+				})
+			}
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadGateway))
+			return c.JSON(http.StatusBadGateway, echo.Map{
+				"message": "Something went wrong while exchanging the authorization code",
+				"error":   err.Error(),
+			})
+		}
+
+		claims, err := s.OAuth.VerifyIDToken(ctx, token.IDToken, state.Nonce)
+		if err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+			span.RecordError(err)
+			return c.JSON(http.StatusUnauthorized, echo.Map{
+				"message": "The identity token could not be verified",
+				"error":   err.Error(),
+			})
+		}
+
+		username, _ := claims["cognito:username"].(string)
+		return signInResponse(s, c, span, username, &identity.AuthResult{
+			Tokens: &identity.Tokens{
+				AccessToken:  token.AccessToken,
+				IDToken:      token.IDToken,
+				RefreshToken: token.RefreshToken,
+				ExpiresIn:    token.ExpiresIn,
+			},
+		})
+	}
+}
+
+// resolveProvider finds the configured identity provider a path segment names
+func resolveProvider(allowed []string, name string) (string, bool) {
+	if alias, ok := providerAliases[strings.ToLower(name)]; ok {
+		name = alias
+	}
+
+	for _, provider := range allowed {
+		if strings.EqualFold(provider, name) {
+			return provider, true
+		}
+	}
+
+	return "", false
+}
+
+func oauthNotConfigured(c echo.Context, span trace.Span) error {
+	span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotImplemented))
+	return c.JSON(http.StatusNotImplemented, echo.Map{
+		"message": "Federated sign in is not configured",
+	})
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"backend/internal/passkey"
+	"backend/internal/svc"
+	"backend/pkg/identity"
+	"backend/pkg/webauthn"
+
+	"github.com/labstack/echo/v4"
+	"github.com/oklog/ulid/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PasskeyAssertion is the body of Finish Passkey Sign In. Credential is the
+// result of navigator.credentials.get serialized with toJSON().
+type PasskeyAssertion struct {
+	CeremonyID string `json:"ceremonyId"`
+	Credential struct {
+		ID       string                     `json:"id"`
+		Type     string                     `json:"type"`
+		Response webauthn.AssertionResponse `json:"response"`
+	} `json:"credential"`
+}
+
+// @Summary Begin Passkey Sign In
+// @Description Starts signing in with a passkey. Without a username the authenticator offers its discoverable passkeys. Pass publicKey to navigator.credentials.get and send the result to Finish Passkey Sign In with the ceremonyId.
+// @Tags Auth
+// @Accept multipart/form-data
+// @Param username formData string false "Username"
+// @Success 200 {object} SuccessResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 501 {object} ErrorResponse
+// @Router /passkey/begin [post]
+func PasskeyBegin(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.PasskeyBegin")
+		defer span.End()
+
+		if _, ok := s.Identity.(identity.CustomAuthenticator); !ok || !s.Passwordless.Enabled() {
+			return passkeyNotSupported(c, span)
+		}
+
+		// every start stores a ceremony, RealIP only honours X-Forwarded-For
+		// from trusted proxies, see middlewares.IPExtractor
+		if ok, retryAfter := s.PasskeyThrottle.Allow("passkey:" + c.RealIP()); !ok {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusTooManyRequests))
+			c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+			return c.JSON(http.StatusTooManyRequests, echo.Map{
+				"message":    "Too many sign in attempts from your network, please try again later",
+				"retryAfter": seconds,
+			})
+		}
+
+		username := c.FormValue("username")
+
+		// an unknown username gets the same answer as a user without passkeys
+		var allow []webauthn.CredentialDescriptor
+		if username != "" {
+			passkeys, err := s.Passkeys.ByUsername(ctx, username)
+			if err != nil {
+				return passkeySignInError(c, span, err)
+			}
+			for _, p := range passkeys {
+				allow = append(allow, p.Descriptor())
+			}
+		}
+
+		ceremony, challenge, err := s.Passkeys.Begin(ctx, passkey.PurposeAuthentication, passkey.Owner{Username: username})
+		if err != nil {
+			return passkeySignInError(c, span, err)
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, echo.Map{
+			"ceremonyId": ceremony.ID.String(),
+			"publicKey":  s.WebAuthn.RequestOptions(challenge, allow),
+		})
+	}
+}
+
+// @Summary Finish Passkey Sign In
+// @Description Verifies the assertion of the authenticator and signs in as the owner of the passkey. Responds like Sign In.
+// @Tags Auth
+// @Accept json
+// @Param body body PasskeyAssertion true "Ceremony ID and credential"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 501 {object} ErrorResponse
+// @Router /passkey/finish [post]
+func PasskeyFinish(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.PasskeyFinish")
+		defer span.End()
+
+		authenticator, ok := s.Identity.(identity.CustomAuthenticator)
+		if !ok || !s.Passwordless.Enabled() {
+			return passkeyNotSupported(c, span)
+		}
+
+		var req PasskeyAssertion
+		if err := c.Bind(&req); err != nil || req.Credential.ID == "" {
+			if err == nil {
+				err = webauthn.ErrMalformed
+			}
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			span.RecordError(err)
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Ceremony ID and credential are required",
+				"error":   err.Error(),
+			})
+		}
+
+		id, err := ulid.ParseStrict(req.CeremonyID)
+		if err != nil {
+			return passkeySignInError(c, span, passkey.ErrCeremonyNotFound)
+		}
+
+		ceremony, err := s.Passkeys.Take(ctx, passkey.PurposeAuthentication, id)
+		if err != nil {
+			return passkeySignInError(c, span, err)
+		}
+
+		p, err := s.Passkeys.ByCredentialID(ctx, req.Credential.ID)
+		if err != nil {
+			return passkeySignInError(c, span, err)
+		}
+
+		// the passkey has to belong to the user the ceremony was started for,
+		// and to the user the authenticator stored it for
+		userHandle := req.Credential.Response.UserHandle
+		if ceremony.Username != "" && ceremony.Username != p.Username {
+			return passkeySignInError(c, span, passkey.ErrNotFound)
+		}
+		if userHandle != "" {
+			handle, err := webauthn.Decode(userHandle)
+			if err != nil || string(handle) != p.Subject {
+				return passkeySignInError(c, span, passkey.ErrNotFound)
+			}
+		}
+
+		if blocked, err := CheckLoginGuard(s, c, span, p.Username); blocked {
+			return err
+		}
+
+		challenge, err := ceremony.ChallengeBytes()
+		if err != nil {
+			return passkeySignInError(c, span, err)
+		}
+
+		assertion, err := s.WebAuthn.VerifyAssertion(challenge, p.PublicKey, uint32(p.SignCount), req.Credential.Response)
+		if err == nil {
+			err = s.Passkeys.Used(ctx, p, assertion)
+		}
+		if err != nil {
+			if !errors.Is(err, webauthn.ErrInvalidClientData) && !errors.Is(err, webauthn.ErrInvalidOrigin) {
+				RecordLoginFailure(s, c, span, p.Username)
+			}
+			return passkeySignInError(c, span, err)
+		}
+
+		proof, err := s.Passwordless.ProofFor(p.Username, p.ID.String())
+		if err != nil {
+			return passkeySignInError(c, span, err)
+		}
+
+		result, err := authenticator.SignInWithCustomChallenge(ctx, p.Username, proof)
+		if err != nil {
+			span.RecordError(err)
+			switch {
+			case errors.Is(err, identity.ErrNotAuthorized), errors.Is(err, identity.ErrUserNotFound):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "Passkey sign in failed",
+				})
+			case errors.Is(err, identity.ErrLimitExceeded):
+				return TooManyAttempts(c, span, s.Config.Auth.LOGIN.BACKOFF_MAX)
+			default:
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+				return c.JSON(http.StatusInternalServerError, echo.Map{
+					"message": "Something went wrong while signing in",
+					"error":   err.Error(),
+				})
+			}
+		}
+
+		if result.Challenge == nil {
+			s.LoginGuard.Success(p.Username)
+		}
+		return signInResponse(s, c, span, p.Username, result)
+	}
+}
+
+func passkeySignInError(c echo.Context, span trace.Span, err error) error {
+	span.RecordError(err)
+	switch {
+	case errors.Is(err, passkey.ErrCeremonyNotFound), errors.Is(err, passkey.ErrCeremonyExpired):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"message": "Passkey sign in has expired, please start again",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, webauthn.ErrSignCountRegressed), errors.Is(err, passkey.ErrSignCount):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"message": "This passkey may have been cloned, sign in another way and replace it",
+			"code":    "passkey_counter",
+		})
+	case errors.Is(err, passkey.ErrNotFound), errors.Is(err, webauthn.ErrInvalidSignature),
+		errors.Is(err, webauthn.ErrInvalidClientData), errors.Is(err, webauthn.ErrInvalidOrigin),
+		errors.Is(err, webauthn.ErrInvalidRPID), errors.Is(err, webauthn.ErrUserNotPresent),
+		errors.Is(err, webauthn.ErrUserNotVerified), errors.Is(err, webauthn.ErrMalformed),
+		errors.Is(err, webauthn.ErrUnsupportedKey):
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"message": "Passkey sign in failed",
+		})
+	default:
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"message": "Something went wrong while signing in with a passkey",
+			"error":   err.Error(),
+		})
+	}
+}
+
+func passkeyNotSupported(c echo.Context, span trace.Span) error {
+	span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotImplemented))
+	return c.JSON(http.StatusNotImplemented, echo.Map{
+		"message": "Passkey sign in is not available",
+	})
+}
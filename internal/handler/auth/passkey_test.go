@@ -0,0 +1,46 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	authctx "backend/internal/auth"
+	"backend/internal/handler/auth"
+	"backend/internal/passkey"
+	"backend/internal/passwordless"
+	"backend/internal/testdb"
+	"backend/pkg/webauthn"
+)
+
+func TestPasskeyBeginThrottle(t *testing.T) {
+	f := newFixture(t)
+	db := testdb.Open(t, &passkey.Passkey{}, &passkey.Ceremony{}, &passwordless.Challenge{})
+	f.s.Passwordless = passwordless.NewStore(db, time.Minute, 5, "secret")
+	f.s.Passkeys = passkey.NewStore(db, time.Minute, 10)
+	f.s.WebAuthn = webauthn.New(webauthn.Config{RPID: "localhost", RPName: "Test", Origins: []string{"http://localhost:3000"}})
+	f.s.PasskeyThrottle = authctx.NewThrottle(0, 2, time.Hour)
+	f.echo.POST("/auth/passkey/begin", auth.PasskeyBegin(f.s))
+
+	for i := 0; i < 2; i++ {
+		rec := f.post("/auth/passkey/begin", url.Values{})
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"ceremonyId":"`) {
+			t.Fatalf("begin %d: got %d %s", i, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := f.post("/auth/passkey/begin", url.Values{"username": {username}})
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("third begin: got %d %s", rec.Code, rec.Body.String())
+	}
+
+	var ceremonies int64
+	if err := db.Model(&passkey.Ceremony{}).Count(&ceremonies).Error; err != nil {
+		t.Fatal(err)
+	}
+	if ceremonies != 2 {
+		t.Fatalf("%d ceremonies stored, want 2", ceremonies)
+	}
+}
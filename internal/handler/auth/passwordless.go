@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"backend/internal/passwordless"
+	"backend/internal/svc"
+	"backend/pkg/identity"
+	"backend/pkg/mailer"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// @Summary Start Passwordless Sign In
+// @Description Emails a one time code (delivery code, default) or sign in link (delivery link). The answer is the same whether or not the account exists.
+// @Tags Auth
+// @Accept multipart/form-data
+// @Param username formData string true "Username"
+// @Param delivery formData string false "code or link"
+// @Success 202 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 501 {object} ErrorResponse
+// @Router /passwordless/start [post]
+func PasswordlessStart(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.PasswordlessStart")
+		defer span.End()
+
+		username := c.FormValue("username")
+		delivery := c.FormValue("delivery")
+		if delivery == "" {
+			delivery = passwordless.DeliveryCode
+		}
+		span.SetAttributes(attribute.String("auth.passwordless_delivery", delivery))
+
+		if username == "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Username is a required field",
+			})
+		}
+
+		if delivery != passwordless.DeliveryCode && delivery != passwordless.DeliveryLink {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Delivery must be code or link",
+			})
+		}
+
+		if _, ok := s.Identity.(identity.CustomAuthenticator); !ok || !s.Passwordless.Enabled() {
+			return passwordlessNotSupported(c, span)
+		}
+
+		if ok, retryAfter := s.ResendThrottle.Allow("passwordless:" + username); !ok {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusTooManyRequests))
+			c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+			return c.JSON(http.StatusTooManyRequests, echo.Map{
+				"message":    "A code was sent recently, please wait before requesting another one",
+				"retryAfter": seconds,
+			})
+		}
+
+		if err := sendPasswordlessSecret(ctx, s, username, delivery); err != nil {
+			span.RecordError(err)
+			if !errors.Is(err, identity.ErrUserNotFound) && !errors.Is(err, identity.ErrUserNotConfirmed) {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+				return c.JSON(http.StatusInternalServerError, echo.Map{
+					"message": "Something went wrong while sending the sign in code",
+					"error":   err.Error(),
+				})
+			}
+			// answer like a successful start so accounts can't be enumerated
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusAccepted))
+		return c.JSON(http.StatusAccepted, echo.Map{
+			"message":  "If the account exists, we have sent you a way to sign in",
+			"delivery": delivery,
+			"username": username,
+		})
+	}
+}
+
+// @Summary Complete Passwordless Sign In
+// @Description Signs in with the code sent by Start Passwordless Sign In, or with the token of a sign in link. Responds like Sign In.
+// @Tags Auth
+// @Accept multipart/form-data
+// @Param username formData string false "Username, required with code"
+// @Param code formData string false "One time code"
+// @Param token formData string false "Token of the sign in link"
+// @Success 200 {object} SuccessResponse
+// @Success 202 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Router /passwordless/complete [post]
+func PasswordlessComplete(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.PasswordlessComplete")
+		defer span.End()
+
+		username := c.FormValue("username")
+		code := c.FormValue("code")
+		token := c.FormValue("token")
+
+		if token == "" && (username == "" || code == "") {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Username and code, or the token of a sign in link are required",
+			})
+		}
+
+		authenticator, ok := s.Identity.(identity.CustomAuthenticator)
+		if !ok || !s.Passwordless.Enabled() {
+			return passwordlessNotSupported(c, span)
+		}
+
+		if username != "" {
+			if blocked, err := CheckLoginGuard(s, c, span, username); blocked {
+				return err
+			}
+		}
+
+		var ch *passwordless.Challenge
+		var err error
+		if token != "" {
+			ch, err = s.Passwordless.VerifyLink(ctx, token)
+		} else {
+			ch, err = s.Passwordless.VerifyCode(ctx, username, code)
+		}
+		if err != nil {
+			span.RecordError(err)
+			switch {
+			case errors.Is(err, passwordless.ErrTooManyAttempts):
+				RecordLoginFailure(s, c, span, username)
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusTooManyRequests))
+				return c.JSON(http.StatusTooManyRequests, echo.Map{
+					"message": "Too many wrong codes, please request a new one",
+					"code":    "too_many_attempts",
+				})
+			case errors.Is(err, passwordless.ErrMismatch):
+				RecordLoginFailure(s, c, span, username)
+				fallthrough
+			case errors.Is(err, passwordless.ErrNotFound), errors.Is(err, passwordless.ErrExpired), errors.Is(err, passwordless.ErrUsed):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "Invalid or expired sign in code",
+				})
+			default:
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+				return c.JSON(http.StatusInternalServerError, echo.Map{
+					"message": "Something went wrong while checking the sign in code",
+					"error":   err.Error(),
+				})
+			}
+		}
+		username = ch.Username
+
+		proof, err := s.Passwordless.Proof(ch)
+		if err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			span.RecordError(err)
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something went wrong while signing in",
+				"error":   err.Error(),
+			})
+		}
+
+		result, err := authenticator.SignInWithCustomChallenge(ctx, username, proof)
+		if err != nil {
+			span.RecordError(err)
+			switch {
+			case errors.Is(err, identity.ErrNotAuthorized), errors.Is(err, identity.ErrUserNotFound):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "Invalid or expired sign in code",
+				})
+			case errors.Is(err, identity.ErrLimitExceeded):
+				return TooManyAttempts(c, span, s.Config.Auth.LOGIN.BACKOFF_MAX)
+			default:
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+				return c.JSON(http.StatusInternalServerError, echo.Map{
+					"message": "Something went wrong while signing in",
+					"error":   err.Error(),
+				})
+			}
+		}
+
+		if result.Challenge == nil {
+			s.LoginGuard.Success(username)
+		}
+		return signInResponse(s, c, span, username, result)
+	}
+}
+
+// sendPasswordlessSecret issues a code or link for a confirmed account and mails it
+func sendPasswordlessSecret(ctx context.Context, s *svc.ServiceContext, username, delivery string) error {
+	confirmed, err := s.Identity.IsConfirmed(ctx, username)
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		return identity.ErrUserNotConfirmed
+	}
+
+	// usernames are email addresses unless the provider knows better
+	email := username
+	if users, ok := s.Identity.(identity.UserAdministrator); ok {
+		user, err := users.GetManagedUser(ctx, username)
+		if err != nil {
+			return err
+		}
+		if user.Email != "" {
+			email = user.Email
+		}
+	}
+
+	_, secret, err := s.Passwordless.Start(ctx, username, delivery)
+	if err != nil {
+		return err
+	}
+
+	minutes := int(s.Config.Auth.PASSWORDLESS.CODE_TTL.Minutes())
+	msg := mailer.Message{
+		To:      email,
+		Subject: "Your sign in code",
+		Body:    fmt.Sprintf("Your sign in code is %s. It is valid for %d minutes and can only be used once.", secret, minutes),
+	}
+	if delivery == passwordless.DeliveryLink {
+		link := s.Config.Auth.PASSWORDLESS.LINK_URL + "?token=" + url.QueryEscape(secret)
+		msg.Subject = "Your sign in link"
+		msg.Body = fmt.Sprintf("Follow this link to sign in: %s\n\nIt is valid for %d minutes and can only be used once.", link, minutes)
+	}
+
+	return s.Mailer.Send(ctx, msg)
+}
+
+func passwordlessNotSupported(c echo.Context, span trace.Span) error {
+	span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotImplemented))
+	return c.JSON(http.StatusNotImplemented, echo.Map{
+		"message": "Passwordless sign in is not available",
+	})
+}
@@ -0,0 +1,143 @@
+package auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	authctx "backend/internal/auth"
+	"backend/internal/middlewares"
+	"backend/internal/testdb"
+	"backend/pkg/config"
+	"backend/pkg/identity"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newCookieFixture runs the fixture in cookie session mode against the local
+// provider, which rotates refresh tokens
+func newCookieFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	f := newFixture(t)
+
+	session := config.Session{
+		MODE:            authctx.SessionModeCookie,
+		COOKIE_PATH:     "/",
+		COOKIE_SAMESITE: "lax",
+		ID_COOKIE:       "token",
+		ACCESS_COOKIE:   "access_token",
+		REFRESH_COOKIE:  "refresh_token",
+		REFRESH_MAX_AGE: time.Hour,
+		CSRF_COOKIE:     "csrf_token",
+		CSRF_HEADER:     "X-CSRF-Token",
+	}
+	f.s.Config.Session = session
+	f.s.Sessions = authctx.NewSessionCookies(session)
+	f.echo.Use(middlewares.CSRF(f.s))
+	useLocalProvider(t, f)
+
+	return f
+}
+
+// useLocalProvider replaces the fake user pool of f by the local provider,
+// which issues signed tokens, and signs the seeded user up with it
+func useLocalProvider(t *testing.T, f *fixture) *identity.LocalProvider {
+	t.Helper()
+
+	signer, err := identity.NewSigner("http://localhost:8080", "local", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := sentCodes{}
+	local := identity.NewLocalProvider(testdb.Open(t, identity.LocalModels()...), signer, identity.LocalOptions{Sender: sent})
+	f.s.Identity = local
+
+	ctx := context.Background()
+	err = local.SignUp(ctx, identity.SignUpInput{Username: username, Email: username, Password: password, FirstName: "Alice", LastName: "Doe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := local.ConfirmSignUp(ctx, username, sent[identity.CodePurposeConfirmSignUp]); err != nil {
+		t.Fatal(err)
+	}
+
+	return local
+}
+
+// cookies returns the cookies a response set by name
+func cookies(rec *httptest.ResponseRecorder) map[string]*http.Cookie {
+	set := map[string]*http.Cookie{}
+	for _, c := range rec.Result().Cookies() {
+		set[c.Name] = c
+	}
+
+	return set
+}
+
+func (f *fixture) postWithCookies(path string, jar map[string]*http.Cookie, csrfHeader string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(""))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	for _, c := range jar {
+		req.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	}
+	if csrfHeader != "" {
+		req.Header.Set("X-CSRF-Token", csrfHeader)
+	}
+
+	rec := httptest.NewRecorder()
+	f.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRefreshTokenRotatesCookie(t *testing.T) {
+	f := newCookieFixture(t)
+
+	rec := f.post("/auth/signin", url.Values{"username": {username}, "password": {password}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("sign in: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "refreshToken") {
+		t.Fatalf("sign in: tokens are in the body in cookie mode: %s", rec.Body.String())
+	}
+	jar := cookies(rec)
+	for _, name := range []string{"token", "access_token", "refresh_token", "csrf_token"} {
+		if jar[name] == nil || jar[name].Value == "" {
+			t.Fatalf("sign in: cookie %s is missing", name)
+		}
+	}
+	if !jar["refresh_token"].HttpOnly || jar["csrf_token"].HttpOnly {
+		t.Fatal("sign in: only the CSRF cookie may be readable by scripts")
+	}
+	csrf := jar["csrf_token"].Value
+
+	for _, header := range []string{"", "guessed"} {
+		rec = f.postWithCookies("/auth/refresh-token", jar, header)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("refresh with CSRF header %q: status = %d, want 403: %s", header, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec = f.postWithCookies("/auth/refresh-token", jar, csrf)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("refresh: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	rotated := cookies(rec)
+	if rotated["refresh_token"] == nil || rotated["refresh_token"].Value == jar["refresh_token"].Value {
+		t.Fatal("refresh: the refresh cookie was not rotated")
+	}
+	if rotated["access_token"] == nil || rotated["access_token"].Value == "" {
+		t.Fatal("refresh: the access cookie was not renewed")
+	}
+
+	// the spent refresh token no longer works
+	jar["csrf_token"] = rotated["csrf_token"]
+	rec = f.postWithCookies("/auth/refresh-token", jar, rotated["csrf_token"].Value)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh with the old cookie: status = %d, want 401: %s", rec.Code, rec.Body.String())
+	}
+}
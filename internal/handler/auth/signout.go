@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	authctx "backend/internal/auth"
+	"backend/internal/svc"
+	"backend/pkg/identity"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// @Summary Sign Out
+// @Description Ends the current session: revokes the refresh token, rejects the presented token from now on and clears the session cookies
+// @Tags Auth
+// @Security BearerAuth
+// @Accept multipart/form-data
+// @Param refreshToken formData string false "Refresh Token"
+// @Success 200 {object} SuccessResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /signout [post]
+func SignOut(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		_, span := tracer.Start(c.Request().Context(), "handler.SignOut")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+		refreshToken := c.FormValue("refreshToken")
+		if refreshToken == "" {
+			refreshToken = s.Sessions.RefreshToken(c)
+		}
+
+		if revoker, ok := s.Identity.(identity.SessionRevoker); ok && refreshToken != "" {
+			err := revoker.RevokeToken(c.Request().Context(), refreshToken)
+			if err != nil && !errors.Is(err, identity.ErrNotAuthorized) {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+				span.RecordError(err)
+				return c.JSON(http.StatusInternalServerError, echo.Map{
+					"message": "Something went wrong while signing out",
+					"error":   err.Error(),
+				})
+			}
+		}
+
+		if principal.SessionID != "" {
+			// tokens refreshed from the same sign in share the origin_jti
+			s.Denylist.Revoke(principal.SessionID, time.Now().Add(s.Config.Auth.MAX_TOKEN_LIFETIME))
+		} else {
+			s.Denylist.Revoke(principal.TokenID, principal.ExpiresAt)
+		}
+
+		s.Sessions.Clear(c)
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "You have successfully signed out!",
+		})
+	}
+}
+
+// @Summary Sign Out Everywhere
+// @Description Ends every session of the user on all devices and revokes their API keys
+// @Tags Auth
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /signout-all [post]
+func SignOutAll(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		_, span := tracer.Start(c.Request().Context(), "handler.SignOutAll")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+
+		if revoker, ok := s.Identity.(identity.SessionRevoker); ok {
+			err := revoker.GlobalSignOut(c.Request().Context(), principal.Token)
+			if err != nil {
+				span.RecordError(err)
+				if errors.Is(err, identity.ErrNotAuthorized) {
+					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+					return c.JSON(http.StatusUnauthorized, echo.Map{
+						"message": "Access token is no longer valid",
+						"error":   err.Error(),
+					})
+				}
+
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+				return c.JSON(http.StatusInternalServerError, echo.Map{
+					"message": "Something went wrong while signing out",
+					"error":   err.Error(),
+				})
+			}
+		}
+
+		now := time.Now()
+		s.Denylist.RevokeSubject(principal.Subject, now, now.Add(s.Config.Auth.MAX_TOKEN_LIFETIME))
+
+		// the denylist entry lapses, keys outlive it and are revoked for good
+		if err := s.APIKeys.RevokeAll(c.Request().Context(), principal.Subject); err != nil {
+			span.RecordError(err)
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something went wrong while revoking your API keys",
+				"error":   err.Error(),
+			})
+		}
+
+		s.Sessions.Clear(c)
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "You have been signed out on all devices!",
+		})
+	}
+}
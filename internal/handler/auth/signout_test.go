@@ -0,0 +1,156 @@
+package auth_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/apikey"
+	authctx "backend/internal/auth"
+	"backend/internal/handler/auth"
+	"backend/internal/middlewares"
+	"backend/internal/testdb"
+	"backend/pkg/identity"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newSignOutFixture serves the sign out routes behind AuthValidator, with the
+// local provider so tokens are signed and verified like in production. GET
+// /whoami answers the subject of any accepted credential.
+func newSignOutFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	f := newFixture(t)
+	useLocalProvider(t, f)
+	// revocations are kept this long, the zero value would drop them right away
+	f.s.Config.Auth.MAX_TOKEN_LIFETIME = time.Hour
+	f.s.APIKeys = apikey.NewStore(testdb.Open(t, &apikey.APIKey{}), 0, time.Hour)
+
+	f.echo.POST("/auth/signout", auth.SignOut(f.s), middlewares.AuthValidator(f.s, middlewares.TokenUseAccess, middlewares.TokenUseID))
+	f.echo.POST("/auth/signout-all", auth.SignOutAll(f.s), middlewares.AuthValidator(f.s, middlewares.TokenUseAccess))
+	f.echo.GET("/whoami", func(c echo.Context) error {
+		return c.String(http.StatusOK, authctx.MustPrincipal(c).Subject)
+	}, middlewares.AuthValidator(f.s))
+
+	return f
+}
+
+// signIn returns the tokens of a new session of the seeded user
+func (f *fixture) signIn(t *testing.T) *identity.Tokens {
+	t.Helper()
+
+	result, err := f.s.Identity.SignIn(context.Background(), username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Tokens
+}
+
+// whoami sends GET /whoami with the token, or the API key when it has the key prefix
+func (f *fixture) whoami(credential string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+	if strings.HasPrefix(credential, apikey.Prefix) {
+		req.Header.Set(middlewares.HeaderAPIKey, credential)
+	} else {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+credential)
+	}
+
+	rec := httptest.NewRecorder()
+	f.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSignOutAllRevokesAPIKeys(t *testing.T) {
+	f := newSignOutFixture(t)
+	tokens := f.signIn(t)
+
+	rec := f.whoami(tokens.AccessToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("whoami: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	subject := rec.Body.String()
+
+	_, secret, err := f.s.APIKeys.Create(context.Background(), apikey.Owner{Subject: subject, Username: username}, "ci", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := f.whoami(secret); rec.Code != http.StatusOK {
+		t.Fatalf("key before signing out: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = f.postWithToken("/auth/signout-all", tokens.AccessToken, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signout-all: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// the key stays revoked once the denylist entry is gone
+	f.s.Denylist = authctx.NewMemoryDenylist()
+	rec = f.whoami(secret)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), middlewares.ReasonAPIKeyRevoked) {
+		t.Fatalf("key after signing out: got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// rejected asserts that the middleware turns the token away as revoked
+func (f *fixture) rejected(t *testing.T, name, token string) {
+	t.Helper()
+
+	rec := f.whoami(token)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), middlewares.ReasonTokenRevoked) {
+		t.Fatalf("%s: got %d %s, want it revoked", name, rec.Code, rec.Body.String())
+	}
+}
+
+func TestSignOutRevokesSession(t *testing.T) {
+	f := newSignOutFixture(t)
+	tokens := f.signIn(t)
+	other := f.signIn(t)
+
+	for name, token := range map[string]string{"access token": tokens.AccessToken, "ID token": tokens.IDToken} {
+		if rec := f.whoami(token); rec.Code != http.StatusOK {
+			t.Fatalf("%s before signing out: status = %d: %s", name, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := f.postWithToken("/auth/signout", tokens.AccessToken, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signout: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// the ID token of the same sign in shares its session
+	f.rejected(t, "access token", tokens.AccessToken)
+	f.rejected(t, "ID token", tokens.IDToken)
+
+	// signing out ends this session only
+	if rec := f.whoami(other.AccessToken); rec.Code != http.StatusOK {
+		t.Fatalf("other session: status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestSignOutAllRevokesEverySession(t *testing.T) {
+	f := newSignOutFixture(t)
+	tokens := f.signIn(t)
+	other := f.signIn(t)
+
+	// revocations by subject have whole seconds, tokens of the second they
+	// are made in still count as issued after them
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+
+	rec := f.postWithToken("/auth/signout-all", tokens.AccessToken, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signout-all: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	f.rejected(t, "access token", tokens.AccessToken)
+	f.rejected(t, "other access token", other.AccessToken)
+	f.rejected(t, "other ID token", other.IDToken)
+
+	// signing in again afterwards works
+	if rec := f.whoami(f.signIn(t).AccessToken); rec.Code != http.StatusOK {
+		t.Fatalf("new session: status = %d: %s", rec.Code, rec.Body.String())
+	}
+}
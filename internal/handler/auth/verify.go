@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	authctx "backend/internal/auth"
+	"backend/internal/svc"
+	"backend/pkg/identity"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// @Summary Resend Verification Code
+// @Description Sends a new sign up confirmation code. Requests are throttled per username. Unknown and already confirmed accounts get the same answer.
+// @Tags Auth
+// @Accept multipart/form-data
+// @Param username formData string true "Username"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Router /verify/resend [post]
+func ResendVerificationCode(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		_, span := tracer.Start(c.Request().Context(), "handler.ResendVerificationCode")
+		defer span.End()
+		username := c.FormValue("username")
+
+		if username == "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Username is a required field",
+			})
+		}
+
+		if ok, retryAfter := s.ResendThrottle.Allow(username); !ok {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusTooManyRequests))
+			c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+			return c.JSON(http.StatusTooManyRequests, echo.Map{
+				"message":    "A code was sent recently, please wait before requesting another one",
+				"retryAfter": seconds,
+			})
+		}
+
+		err := s.Identity.ResendConfirmationCode(c.Request().Context(), username)
+		if err != nil {
+			span.RecordError(err)
+			switch {
+			case errors.Is(err, identity.ErrUserNotFound), errors.Is(err, identity.ErrAlreadyConfirmed):
+				// answer like a successful resend so accounts can't be enumerated
+			case errors.Is(err, identity.ErrLimitExceeded):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusTooManyRequests))
+				return c.JSON(http.StatusTooManyRequests, echo.Map{
+					"message": "Too many attempts, please try again later",
+					"error":   err.Error(),
+				})
+			default:
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+				return c.JSON(http.StatusInternalServerError, echo.Map{
+					"message": "Something went wrong while sending the verification code",
+					"error":   err.Error(),
+				})
+			}
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "If the account exists, a new verification code has been sent",
+		})
+	}
+}
+
+// @Summary Verification Status
+// @Description Reports whether the signed in account has confirmed its email. Requests are throttled like resending a code.
+// @Tags Auth
+// @Security BearerAuth
+// @Success 200 {object} SuccessResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Router /verify/status [get]
+func VerificationStatus(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		_, span := tracer.Start(c.Request().Context(), "handler.VerificationStatus")
+		defer span.End()
+
+		// only the account's own status is reported, anyone could ask about
+		// others to find out which accounts exist
+		username := authctx.MustPrincipal(c).Username
+
+		if ok, retryAfter := s.ResendThrottle.Allow("status:" + username); !ok {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusTooManyRequests))
+			c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+			return c.JSON(http.StatusTooManyRequests, echo.Map{
+				"message":    "The status was checked recently, please wait before checking again",
+				"retryAfter": seconds,
+			})
+		}
+
+		confirmed, err := s.Identity.IsConfirmed(c.Request().Context(), username)
+		if err != nil {
+			span.RecordError(err)
+			if errors.Is(err, identity.ErrUserNotFound) {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+				return c.JSON(http.StatusUnauthorized, echo.Map{
+					"message": "Access token is no longer valid",
+					"error":   err.Error(),
+				})
+			}
+
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something went wrong while checking the verification status",
+				"error":   err.Error(),
+			})
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, echo.Map{
+			"username":  username,
+			"confirmed": confirmed,
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package billing
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	billingstore "backend/internal/billing"
+	"backend/internal/svc"
+	"backend/internal/user"
+	"backend/pkg/identity"
+	"backend/pkg/webhook"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxEventSize bounds the body of a webhook event
+const maxEventSize = 512 << 10
+
+// @Summary Receive Billing Webhook
+// @Description Receives the webhook events of a Stripe compatible payment provider, signed in the Stripe-Signature header. Subscription events update the stored subscription and the user's custom:subscription_status attribute. Anything but a 2xx makes the provider deliver the event again later.
+// @Tags Billing
+// @Accept json
+// @Param Stripe-Signature header string true "t=<unix seconds>,v1=<hex HMAC-SHA256 of t.body>"
+// @Success 200 {object} auth.SuccessResponse
+// @Failure 400 {object} auth.ErrorResponse
+// @Failure 401 {object} auth.ErrorResponse
+// @Failure 409 {object} auth.ErrorResponse
+// @Failure 422 {object} auth.ErrorResponse
+// @Failure 501 {object} auth.ErrorResponse
+// @Failure 502 {object} auth.ErrorResponse
+// @Router /billing/webhook [post]
+func Webhook(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.BillingWebhook")
+		defer span.End()
+
+		secret := s.Config.Billing.WEBHOOK_SECRET
+		attributes, ok := s.Identity.(identity.AttributeAdministrator)
+		if secret == "" || !ok {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotImplemented))
+			return c.JSON(http.StatusNotImplemented, echo.Map{
+				"message": "Billing webhooks are not configured",
+			})
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxEventSize))
+		if err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			span.RecordError(err)
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Event could not be read",
+				"error":   err.Error(),
+			})
+		}
+
+		header := c.Request().Header.Get(billingstore.HeaderSignature)
+		if err := webhook.Verify([]byte(secret), header, body, time.Now(), s.Config.Billing.WEBHOOK_TOLERANCE); err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+			span.RecordError(err)
+			return c.JSON(http.StatusUnauthorized, echo.Map{
+				"message": "Event signature is not valid",
+				"error":   err.Error(),
+			})
+		}
+
+		var event billingstore.Event
+		if err := json.Unmarshal(body, &event); err != nil || event.ID == "" {
+			if err == nil {
+				err = errors.New("event has no id")
+			}
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			span.RecordError(err)
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Event is not valid",
+				"error":   err.Error(),
+			})
+		}
+		span.SetAttributes(
+			attribute.String("billing.event_id", event.ID),
+			attribute.String("billing.event_type", event.Type),
+		)
+
+		processed, err := s.Billing.Processed(ctx, event.ID)
+		if err != nil {
+			return webhookError(c, span, err)
+		}
+		if processed {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+			return c.JSON(http.StatusOK, echo.Map{
+				"message": "Event was processed before",
+			})
+		}
+
+		change, err := s.Billing.Apply(ctx, &event)
+		if err != nil {
+			span.RecordError(err)
+
+			switch {
+			case errors.Is(err, billingstore.ErrUnsupportedEvent):
+				if err := s.Billing.MarkProcessed(ctx, event.ID, event.Type); err != nil {
+					return webhookError(c, span, err)
+				}
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+				return c.JSON(http.StatusOK, echo.Map{
+					"message": "Event type is ignored",
+				})
+
+			case errors.Is(err, billingstore.ErrUnknownCustomer):
+				// the checkout event linking the customer may still be on its way
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusConflict))
+				return c.JSON(http.StatusConflict, echo.Map{
+					"message": "Customer is not linked to a user yet",
+					"error":   err.Error(),
+				})
+
+			case errors.Is(err, billingstore.ErrUnknownPrice):
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnprocessableEntity))
+				return c.JSON(http.StatusUnprocessableEntity, echo.Map{
+					"message": "Subscription is billed with a price no plan uses",
+					"error":   err.Error(),
+				})
+
+			default:
+				return webhookError(c, span, err)
+			}
+		}
+
+		if change != nil {
+			span.SetAttributes(
+				attribute.String("user.id", change.Subject),
+				attribute.String("billing.plan", change.Plan),
+			)
+
+			u, err := s.Users.Get(ctx, change.Subject)
+			if err != nil {
+				if errors.Is(err, user.ErrNotFound) {
+					span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusConflict))
+					span.RecordError(err)
+					return c.JSON(http.StatusConflict, echo.Map{
+						"message": "Subscriber is not a known user yet",
+						"error":   err.Error(),
+					})
+				}
+				return webhookError(c, span, err)
+			}
+
+			err = attributes.AdminUpdateAttributes(ctx, u.Username, map[string]string{
+				"custom:subscription_status": change.Plan,
+			})
+			if err != nil {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadGateway))
+				span.RecordError(err)
+				return c.JSON(http.StatusBadGateway, echo.Map{
+					"message": "Subscription status could not be updated",
+					"error":   err.Error(),
+				})
+			}
+		}
+
+		if err := s.Billing.MarkProcessed(ctx, event.ID, event.Type); err != nil {
+			return webhookError(c, span, err)
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, echo.Map{
+			"message": "Event was processed",
+		})
+	}
+}
+
+func webhookError(c echo.Context, span trace.Span, err error) error {
+	span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+	span.RecordError(err)
+	return c.JSON(http.StatusInternalServerError, echo.Map{
+		"message": "Something went wrong while handling the event",
+		"error":   err.Error(),
+	})
+}
@@ -0,0 +1,248 @@
+package billing_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	billingstore "backend/internal/billing"
+	"backend/internal/billing/billingtest"
+	"backend/internal/handler/billing"
+	"backend/internal/svc"
+	"backend/internal/testdb"
+	"backend/internal/user"
+	"backend/pkg/cognito/cognitotest"
+	"backend/pkg/config"
+	"backend/pkg/identity"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	secret   = "whsec_test"
+	subject  = "00000000-0000-0000-0000-000000000001"
+	username = "alice@example.com"
+	proPrice = "price_pro"
+)
+
+type fixture struct {
+	echo    *echo.Echo
+	pool    *cognitotest.Client
+	billing *billingstore.Store
+	sim     *billingtest.Simulator
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	db := testdb.Open(t, append(billingstore.Models(), &user.User{})...)
+
+	users := user.NewRepository(db)
+	if err := users.Ensure(context.Background(), subject, username, username); err != nil {
+		t.Fatal(err)
+	}
+
+	store := billingstore.NewStore(db, "free")
+	plans, err := billingstore.ParsePlans("free", []string{"pro:" + proPrice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SeedPlans(context.Background(), plans); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := cognitotest.New()
+	pool.AddUser(username, "Passw0rd!", true, map[string]string{"custom:subscription_status": "free"})
+
+	cfg := config.Configuration{}
+	cfg.Billing.WEBHOOK_SECRET = secret
+	cfg.Billing.WEBHOOK_TOLERANCE = 5 * time.Minute
+
+	e := echo.New()
+	tracer := otel.Tracer("test")
+	s := &svc.ServiceContext{
+		Config:   cfg,
+		DB:       db,
+		Echo:     e,
+		Tracer:   &tracer,
+		Cognito:  pool,
+		Identity: identity.NewCognitoProvider(pool, "client", "pool", "issuer", nil),
+		Users:    users,
+		Billing:  store,
+	}
+	e.POST("/billing/webhook", billing.Webhook(s))
+
+	return &fixture{echo: e, pool: pool, billing: store, sim: billingtest.New(secret, "/billing/webhook")}
+}
+
+// built fails the test when the simulator couldn't build an event
+func built(t *testing.T) func(*billingstore.Event, error) *billingstore.Event {
+	return func(e *billingstore.Event, err error) *billingstore.Event {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+}
+
+func (f *fixture) deliver(t *testing.T, e *billingstore.Event) (int, string) {
+	t.Helper()
+
+	rec, err := f.sim.Serve(f.echo, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rec.Code, rec.Body.String()
+}
+
+func (f *fixture) status(t *testing.T) string {
+	t.Helper()
+
+	u, ok := f.pool.User(username)
+	if !ok {
+		t.Fatal("user is missing from the pool")
+	}
+
+	return u.Attributes["custom:subscription_status"]
+}
+
+func TestWebhookUpgradesAfterCheckout(t *testing.T) {
+	f := newFixture(t)
+	ev := built(t)
+
+	code, body := f.deliver(t, ev(f.sim.CheckoutCompleted(subject, "cus_1", "sub_1")))
+	if code != http.StatusOK {
+		t.Fatalf("checkout: got %d %s", code, body)
+	}
+
+	code, body = f.deliver(t, ev(f.sim.SubscriptionCreated(billingtest.Subscription{ID: "sub_1", Customer: "cus_1", PriceID: proPrice})))
+	if code != http.StatusOK {
+		t.Fatalf("created: got %d %s", code, body)
+	}
+	if got := f.status(t); got != "pro" {
+		t.Fatalf("subscription status = %q, want pro", got)
+	}
+
+	code, body = f.deliver(t, ev(f.sim.SubscriptionDeleted(billingtest.Subscription{ID: "sub_1", Customer: "cus_1", PriceID: proPrice})))
+	if code != http.StatusOK {
+		t.Fatalf("deleted: got %d %s", code, body)
+	}
+	if got := f.status(t); got != "free" {
+		t.Fatalf("subscription status = %q, want free", got)
+	}
+}
+
+func TestWebhookRejectsBadSignature(t *testing.T) {
+	f := newFixture(t)
+	ev := built(t)
+	f.sim.Secret = []byte("whsec_other")
+
+	code, body := f.deliver(t, ev(f.sim.SubscriptionCreated(billingtest.Subscription{ID: "sub_1", Customer: "cus_1", Subject: subject, PriceID: proPrice})))
+	if code != http.StatusUnauthorized || !strings.Contains(body, "webhook signature is not valid") {
+		t.Fatalf("got %d %s", code, body)
+	}
+	if got := f.status(t); got != "free" {
+		t.Fatalf("subscription status = %q, want free", got)
+	}
+}
+
+func TestWebhookSkipsDuplicateEvent(t *testing.T) {
+	f := newFixture(t)
+	ev := built(t)
+
+	event, err := f.sim.SubscriptionCreated(billingtest.Subscription{ID: "sub_1", Customer: "cus_1", Subject: subject, PriceID: proPrice})
+	code, body := f.deliver(t, ev(event, err))
+	if code != http.StatusOK || !strings.Contains(body, "Event was processed") {
+		t.Fatalf("first delivery: got %d %s", code, body)
+	}
+
+	// an admin downgrades by hand, a redelivery must not undo it
+	f.pool.Update(username, func(u *cognitotest.User) { u.Attributes["custom:subscription_status"] = "free" })
+
+	code, body = f.deliver(t, ev(event, nil))
+	if code != http.StatusOK || !strings.Contains(body, "Event was processed before") {
+		t.Fatalf("redelivery: got %d %s", code, body)
+	}
+	if got := f.status(t); got != "free" {
+		t.Fatalf("subscription status = %q, want free", got)
+	}
+}
+
+func TestWebhookIgnoresOutOfOrderUpdate(t *testing.T) {
+	f := newFixture(t)
+	ev := built(t)
+	sub := billingtest.Subscription{ID: "sub_1", Customer: "cus_1", Subject: subject, PriceID: proPrice}
+
+	now := time.Now()
+	f.sim.Now = func() time.Time { return now.Add(-time.Minute) }
+	active := sub
+	older, olderErr := f.sim.SubscriptionUpdated(active)
+
+	f.sim.Now = func() time.Time { return now }
+	canceled := sub
+	canceled.Status = billingstore.StatusCanceled
+	code, body := f.deliver(t, ev(f.sim.SubscriptionUpdated(canceled)))
+	if code != http.StatusOK {
+		t.Fatalf("newer update: got %d %s", code, body)
+	}
+
+	// the provider retries the older event after the newer one went through
+	code, body = f.deliver(t, ev(older, olderErr))
+	if code != http.StatusOK {
+		t.Fatalf("older update: got %d %s", code, body)
+	}
+	if got := f.status(t); got != "free" {
+		t.Fatalf("subscription status = %q, want free", got)
+	}
+}
+
+func TestWebhookUnknownCustomer(t *testing.T) {
+	f := newFixture(t)
+	ev := built(t)
+
+	code, body := f.deliver(t, ev(f.sim.SubscriptionCreated(billingtest.Subscription{ID: "sub_1", Customer: "cus_unknown", PriceID: proPrice})))
+	if code != http.StatusConflict || !strings.Contains(body, billingstore.ErrUnknownCustomer.Error()) {
+		t.Fatalf("got %d %s", code, body)
+	}
+
+	// once the checkout linked the customer the retry goes through
+	code, body = f.deliver(t, ev(f.sim.CheckoutCompleted(subject, "cus_unknown", "sub_1")))
+	if code != http.StatusOK {
+		t.Fatalf("checkout: got %d %s", code, body)
+	}
+	code, body = f.deliver(t, ev(f.sim.SubscriptionCreated(billingtest.Subscription{ID: "sub_1", Customer: "cus_unknown", PriceID: proPrice})))
+	if code != http.StatusOK {
+		t.Fatalf("retry: got %d %s", code, body)
+	}
+	if got := f.status(t); got != "pro" {
+		t.Fatalf("subscription status = %q, want pro", got)
+	}
+}
+
+func TestWebhookUnknownPrice(t *testing.T) {
+	f := newFixture(t)
+	ev := built(t)
+
+	code, body := f.deliver(t, ev(f.sim.SubscriptionCreated(billingtest.Subscription{ID: "sub_1", Customer: "cus_1", Subject: subject, PriceID: "price_unknown"})))
+	if code != http.StatusUnprocessableEntity || !strings.Contains(body, billingstore.ErrUnknownPrice.Error()) {
+		t.Fatalf("got %d %s", code, body)
+	}
+	if got := f.status(t); got != "free" {
+		t.Fatalf("subscription status = %q, want free", got)
+	}
+}
+
+func TestWebhookIgnoresOtherEventTypes(t *testing.T) {
+	f := newFixture(t)
+	ev := built(t)
+
+	code, body := f.deliver(t, ev(f.sim.Event("invoice.paid", map[string]string{"id": "in_1"})))
+	if code != http.StatusOK || !strings.Contains(body, "Event type is ignored") {
+		t.Fatalf("got %d %s", code, body)
+	}
+}
@@ -0,0 +1,75 @@
+// Package machine holds the routes meant for machine clients. They accept API
+// keys and client credentials tokens besides the tokens of signed in users.
+package machine
+
+import (
+	"errors"
+	"net/http"
+
+	authctx "backend/internal/auth"
+	"backend/internal/svc"
+	"backend/internal/user"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// @Summary Who Am I
+// @Description Returns the principal the credential authenticates as, so clients can check an API key or token before using it
+// @Tags Machine
+// @Security BearerAuth
+// @Param X-API-Key header string false "API key"
+// @Success 200 {object} authctx.Principal
+// @Failure 401 {object} auth.ErrorResponse
+// @Router /machine/whoami [get]
+func WhoAmI(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		_, span := tracer.Start(c.Request().Context(), "handler.WhoAmI")
+		defer span.End()
+
+		principal := authctx.MustPrincipal(c)
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, principal)
+	}
+}
+
+// @Summary Get User
+// @Description Returns the local copy of a user for machine clients holding the users scope
+// @Tags Machine
+// @Security BearerAuth
+// @Param subject path string true "Subject"
+// @Success 200 {object} user.User
+// @Failure 401 {object} auth.ErrorResponse
+// @Failure 403 {object} auth.ErrorResponse
+// @Failure 404 {object} auth.ErrorResponse
+// @Router /machine/users/{subject} [get]
+func GetUser(s *svc.ServiceContext) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.machine.GetUser")
+		defer span.End()
+
+		u, err := s.Users.Get(ctx, c.Param("subject"))
+		if err != nil {
+			span.RecordError(err)
+			if errors.Is(err, user.ErrNotFound) {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotFound))
+				return c.JSON(http.StatusNotFound, echo.Map{
+					"message": "User not found",
+					"error":   err.Error(),
+				})
+			}
+
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something went wrong while loading the user",
+				"error":   err.Error(),
+			})
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, u)
+	}
+}
@@ -0,0 +1,134 @@
+package machine_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/handler"
+	"backend/internal/middlewares"
+	"backend/internal/svc"
+	"backend/internal/testdb"
+	"backend/internal/user"
+	"backend/pkg/config"
+	"backend/pkg/identity"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	issuer   = "https://issuer.example.com"
+	clientID = "web"
+	subject  = "00000000-0000-0000-0000-000000000001"
+)
+
+// keys is an identity provider that only verifies tokens signed with key
+type keys struct {
+	identity.Provider
+	key *rsa.PrivateKey
+}
+
+func (p keys) Issuer() string   { return issuer }
+func (p keys) Audience() string { return clientID }
+
+func (p keys) Keyfunc(context.Context) jwt.Keyfunc {
+	return func(*jwt.Token) (interface{}, error) { return &p.key.PublicKey, nil }
+}
+
+func newServer(t *testing.T) (*echo.Echo, func(jwt.MapClaims) string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Configuration{}
+	cfg.Auth.MACHINE_CLIENT_IDS = []string{"reporting"}
+	cfg.Auth.MACHINE_USERS_SCOPE = "users/read"
+
+	tracer := otel.Tracer("test")
+	s := &svc.ServiceContext{
+		Config:   cfg,
+		Echo:     echo.New(),
+		Tracer:   &tracer,
+		Identity: keys{key: key},
+		Denylist: auth.NewMemoryDenylist(),
+		Users:    user.NewRepository(testdb.Open(t, &user.User{})),
+	}
+	handler.RegisterHandlers(s)
+
+	if err := s.Users.Ensure(context.Background(), subject, "alice", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(claims jwt.MapClaims) string {
+		all := jwt.MapClaims{
+			"iss":       issuer,
+			"token_use": "access",
+			"iat":       time.Now().Unix(),
+			"exp":       time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range claims {
+			all[k] = v
+		}
+
+		token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, all).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	return s.Echo, sign
+}
+
+func TestGetUser(t *testing.T) {
+	e, sign := newServer(t)
+
+	client := func(scope string) string {
+		return sign(jwt.MapClaims{"sub": "reporting", "client_id": "reporting", "scope": scope})
+	}
+	userToken := sign(jwt.MapClaims{"sub": subject, "username": "alice", "client_id": clientID})
+
+	tests := []struct {
+		name     string
+		path     string
+		header   string
+		value    string
+		want     int
+		wantBody string
+	}{
+		{name: "ClientWithScope", path: "/machine/users/" + subject, header: "Authorization", value: "Bearer " + client("users/read"), want: http.StatusOK, wantBody: "alice@example.com"},
+		{name: "UnknownSubject", path: "/machine/users/nobody", header: "Authorization", value: "Bearer " + client("users/read"), want: http.StatusNotFound},
+		{name: "ClientWithoutScope", path: "/machine/users/" + subject, header: "Authorization", value: "Bearer " + client("reports/write"), want: http.StatusForbidden, wantBody: "scope_missing"},
+		{name: "UserToken", path: "/machine/users/" + subject, header: "Authorization", value: "Bearer " + userToken, want: http.StatusUnauthorized, wantBody: middlewares.ReasonTokenUseInvalid},
+		{name: "APIKey", path: "/machine/users/" + subject, header: middlewares.HeaderAPIKey, value: "gbk_secret", want: http.StatusUnauthorized, wantBody: middlewares.ReasonTokenUseInvalid},
+		{name: "WhoAmIClient", path: "/machine/whoami", header: "Authorization", value: "Bearer " + client(""), want: http.StatusOK, wantBody: `"type":"client"`},
+		{name: "WhoAmIUser", path: "/machine/whoami", header: "Authorization", value: "Bearer " + userToken, want: http.StatusOK, wantBody: `"username":"alice"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set(tt.header, tt.value)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Fatalf("body %s doesn't mention %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -1,8 +1,15 @@
 package handler
 
 import (
+	"backend/internal/handler/account"
+	"backend/internal/handler/admin"
 	"backend/internal/handler/auth"
+	"backend/internal/handler/billing"
+	"backend/internal/handler/machine"
+	"backend/internal/handler/triggers"
+	"backend/internal/middlewares"
 	"backend/internal/svc"
+	"backend/pkg/trigger"
 )
 
 func RegisterHandlers(s *svc.ServiceContext) {
@@ -10,8 +17,85 @@ func RegisterHandlers(s *svc.ServiceContext) {
 	authz := s.Echo.Group("/auth")
 	authz.POST("/signup", auth.SignUp(s))
 	authz.POST("/signin", auth.SignIn(s))
+	authz.POST("/signin/challenge", auth.SignInChallenge(s))
+	authz.POST("/passwordless/start", auth.PasswordlessStart(s))
+	authz.POST("/passwordless/complete", auth.PasswordlessComplete(s))
+	authz.POST("/passkey/begin", auth.PasskeyBegin(s))
+	authz.POST("/passkey/finish", auth.PasskeyFinish(s))
+	authz.GET("/oauth/:provider/start", auth.OAuthStart(s))
+	authz.GET("/oauth/callback", auth.OAuthCallback(s))
 	authz.POST("/password-forgot", auth.ForgotPassword(s))
 	authz.POST("/reset-password", auth.ResetPassword(s))
 	authz.POST("/verify", auth.VerifyEmail(s))
+	authz.POST("/verify/resend", auth.ResendVerificationCode(s))
+	authz.GET("/verify/status", auth.VerificationStatus(s), middlewares.AuthValidator(s, middlewares.TokenUseAccess, middlewares.TokenUseID))
+	authz.GET("/invitations", auth.GetInvitation(s))
+	authz.POST("/invitations/accept", auth.AcceptInvitation(s))
 	authz.POST("/refresh-token", auth.RefreshToken(s))
+	authz.POST("/signout", auth.SignOut(s), middlewares.AuthValidator(s, middlewares.TokenUseAccess, middlewares.TokenUseID))
+	authz.POST("/signout-all", auth.SignOutAll(s), middlewares.AuthValidator(s, middlewares.TokenUseAccess))
+
+	// a sign in stopped at MFA_SETUP enrols with its session instead of a token
+	mfa := authz.Group("/mfa", middlewares.UnlessFormValue("session", middlewares.AuthValidator(s, middlewares.TokenUseAccess)))
+	mfa.POST("/setup", auth.MFASetup(s))
+	mfa.POST("/verify", auth.MFAVerify(s))
+
+	// === Account Routes ===
+	me := s.Echo.Group("/me", middlewares.AuthValidator(s, middlewares.TokenUseAccess))
+	me.GET("", account.GetProfile(s))
+	me.PATCH("", account.UpdateProfile(s))
+	me.POST("/change-password", account.ChangePassword(s))
+	me.POST("/email", account.ChangeEmail(s))
+	me.POST("/email/verify", account.VerifyEmailChange(s))
+	me.GET("/api-keys", account.ListAPIKeys(s))
+	me.POST("/api-keys", account.CreateAPIKey(s))
+	me.DELETE("/api-keys/:id", account.RevokeAPIKey(s))
+	me.GET("/passkeys", account.ListPasskeys(s))
+	me.POST("/passkeys/begin", account.BeginPasskeyRegistration(s))
+	me.POST("/passkeys/finish", account.FinishPasskeyRegistration(s))
+	me.DELETE("/passkeys/:id", account.DeletePasskey(s))
+
+	// === Admin Routes ===
+	adm := s.Echo.Group("/admin", middlewares.AuthValidator(s, middlewares.TokenUseAccess, middlewares.TokenUseID), middlewares.RequireRole(s.Config.Auth.ADMIN_GROUP))
+	adm.POST("/login-protection/unlock", admin.UnlockLogin(s))
+	adm.GET("/invitations", admin.ListInvitations(s))
+	adm.POST("/invitations", admin.CreateInvitation(s))
+	adm.DELETE("/invitations/:id", admin.RevokeInvitation(s))
+	adm.GET("/users", admin.ListUsers(s))
+	adm.GET("/users/:username", admin.GetUser(s))
+	adm.DELETE("/users/:username", admin.DeleteUser(s))
+	adm.POST("/users/:username/disable", admin.DisableUser(s))
+	adm.POST("/users/:username/enable", admin.EnableUser(s))
+	adm.POST("/users/:username/reset-password", admin.ResetUserPassword(s))
+	adm.POST("/users/:username/signout", admin.SignOutUser(s))
+	adm.POST("/users/:username/resend-invitation", admin.ResendInvitation(s))
+	adm.GET("/users/:username/groups", admin.ListUserGroups(s))
+	adm.POST("/users/:username/groups", admin.AddUserToGroup(s))
+	adm.DELETE("/users/:username/groups/:group", admin.RemoveUserFromGroup(s))
+
+	// === Machine Routes ===
+	// The default AuthValidator also takes API keys and client credentials tokens
+	mach := s.Echo.Group("/machine", middlewares.AuthValidator(s))
+	mach.GET("/whoami", machine.WhoAmI(s))
+	// users only go to machine clients granted the scope, RequireScope would let any user token through
+	clients := s.Echo.Group("/machine/users", middlewares.AuthValidator(s, middlewares.TokenUseClient), middlewares.RequireScope(s.Config.Auth.MACHINE_USERS_SCOPE))
+	clients.GET("/:subject", machine.GetUser(s))
+
+	// === Cognito Trigger Routes ===
+	// Signed with the shared secret by the Lambda that forwards the triggers
+	hooks := s.Echo.Group("/triggers/cognito")
+	hooks.POST("/pre-sign-up", triggers.Receive(s, trigger.KindPreSignUp))
+	hooks.POST("/post-confirmation", triggers.Receive(s, trigger.KindPostConfirmation))
+	hooks.POST("/pre-token-generation", triggers.Receive(s, trigger.KindPreTokenGeneration))
+	hooks.POST("/custom-message", triggers.Receive(s, trigger.KindCustomMessage))
+	hooks.POST("/define-auth-challenge", triggers.Receive(s, trigger.KindDefineAuthChallenge))
+	hooks.POST("/create-auth-challenge", triggers.Receive(s, trigger.KindCreateAuthChallenge))
+	hooks.POST("/verify-auth-challenge-response", triggers.Receive(s, trigger.KindVerifyAuthChallengeResponse))
+
+	// === Billing Routes ===
+	// Signed with the endpoint secret by the payment provider
+	s.Echo.POST("/billing/webhook", billing.Webhook(s))
+
+	// Public signing keys of the local identity provider
+	s.Echo.GET("/.well-known/jwks.json", auth.JWKS(s))
 }
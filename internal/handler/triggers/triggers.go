@@ -0,0 +1,105 @@
+package triggers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"backend/internal/svc"
+	"backend/pkg/trigger"
+	"backend/pkg/webhook"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxEventSize bounds the body of a forwarded event, Cognito events are small
+const maxEventSize = 256 << 10
+
+// @Summary Receive Cognito Trigger
+// @Description Receives a Cognito Lambda trigger event forwarded by the trigger Lambda, signed in the X-Trigger-Signature header. Answers with the event and its response filled in, or with a message the Lambda throws to fail the operation.
+// @Tags Triggers
+// @Accept json
+// @Param X-Trigger-Signature header string true "t=<unix seconds>,v1=<hex HMAC-SHA256 of t.body>"
+// @Success 200 {object} trigger.Event
+// @Failure 400 {object} auth.ErrorResponse
+// @Failure 401 {object} auth.ErrorResponse
+// @Failure 501 {object} auth.ErrorResponse
+// @Router /triggers/cognito/{trigger} [post]
+func Receive(s *svc.ServiceContext, kind string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tracer := *s.Tracer
+		ctx, span := tracer.Start(c.Request().Context(), "handler.ReceiveTrigger")
+		defer span.End()
+
+		span.SetAttributes(attribute.String("trigger.kind", kind))
+
+		secret := s.Config.Auth.TRIGGERS.SECRET
+		if secret == "" {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusNotImplemented))
+			return c.JSON(http.StatusNotImplemented, echo.Map{
+				"message": "Trigger receiver is not configured",
+			})
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxEventSize))
+		if err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			span.RecordError(err)
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Event could not be read",
+				"error":   err.Error(),
+			})
+		}
+
+		header := c.Request().Header.Get(trigger.HeaderSignature)
+		if err := webhook.Verify([]byte(secret), header, body, time.Now(), s.Config.Auth.TRIGGERS.TOLERANCE); err != nil {
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
+			span.RecordError(err)
+			return c.JSON(http.StatusUnauthorized, echo.Map{
+				"message": "Event signature is not valid",
+				"error":   err.Error(),
+			})
+		}
+
+		var event trigger.Event
+		if err := json.Unmarshal(body, &event); err != nil || event.Kind() != kind {
+			if err == nil {
+				err = trigger.ErrUnknownTrigger
+			}
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+			span.RecordError(err)
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"message": "Event is not a " + kind + " trigger",
+				"error":   err.Error(),
+			})
+		}
+		span.SetAttributes(
+			attribute.String("trigger.source", event.TriggerSource),
+			attribute.String("user.id", event.UserName),
+		)
+
+		if err := s.Triggers.Dispatch(ctx, &event); err != nil {
+			span.RecordError(err)
+
+			var rejection *trigger.Rejection
+			if errors.As(err, &rejection) {
+				span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
+				return c.JSON(http.StatusBadRequest, echo.Map{
+					"message": rejection.Message,
+				})
+			}
+
+			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"message": "Something went wrong while handling the trigger",
+				"error":   err.Error(),
+			})
+		}
+
+		span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusOK))
+		return c.JSON(http.StatusOK, event)
+	}
+}
@@ -0,0 +1,355 @@
+package triggers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/handler/triggers"
+	"backend/internal/hooks"
+	"backend/internal/passwordless"
+	"backend/internal/signup"
+	"backend/internal/svc"
+	"backend/internal/testdb"
+	"backend/internal/user"
+	"backend/pkg/config"
+	"backend/pkg/identity"
+	"backend/pkg/trigger"
+	"backend/pkg/webhook"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	secret      = "trigger_test"
+	proofSecret = "proof_test"
+	subject     = "00000000-0000-0000-0000-000000000001"
+	username    = "alice@example.com"
+)
+
+type fixture struct {
+	echo   *echo.Echo
+	users  *user.Repository
+	proofs *passwordless.Store
+	// now is the time the hooks check proofs at
+	now time.Time
+}
+
+func newFixture(t *testing.T, signUpOpen bool) *fixture {
+	t.Helper()
+
+	db := testdb.Open(t, &user.User{})
+	f := &fixture{
+		users:  user.NewRepository(db),
+		proofs: passwordless.NewStore(db, time.Minute, 5, proofSecret),
+		now:    time.Now(),
+	}
+
+	cfg := config.Configuration{}
+	cfg.Auth.TRIGGERS.SECRET = secret
+	cfg.Auth.TRIGGERS.TOLERANCE = 5 * time.Minute
+
+	e := echo.New()
+	tracer := otel.Tracer("test")
+	s := &svc.ServiceContext{
+		Config: cfg,
+		DB:     db,
+		Echo:   e,
+		Tracer: &tracer,
+		Users:  f.users,
+		Triggers: hooks.New(hooks.Options{
+			SignUpOpen:   signUpOpen,
+			SignUpPolicy: signup.NewPolicy(signup.Options{AllowedDomains: []string{"example.com"}}),
+			AppName:      "Test",
+			ProofSecret:  []byte(proofSecret),
+			Users:        f.users,
+			Now:          func() time.Time { return f.now },
+		}),
+	}
+
+	for _, kind := range []string{
+		trigger.KindPreSignUp,
+		trigger.KindPreTokenGeneration,
+		trigger.KindDefineAuthChallenge,
+		trigger.KindVerifyAuthChallengeResponse,
+	} {
+		e.POST("/triggers/"+kind, triggers.Receive(s, kind))
+	}
+	f.echo = e
+
+	return f
+}
+
+// event builds the body of a forwarded trigger event
+func event(t *testing.T, source string, request interface{}) []byte {
+	t.Helper()
+
+	raw, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(trigger.Event{
+		Version:       "1",
+		TriggerSource: source,
+		UserPoolID:    "pool",
+		UserName:      username,
+		Request:       raw,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body
+}
+
+// post sends body to the route of kind with the given signature header
+func (f *fixture) post(kind string, body []byte, signature string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/triggers/"+kind, strings.NewReader(string(body)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if signature != "" {
+		req.Header.Set(trigger.HeaderSignature, signature)
+	}
+	rec := httptest.NewRecorder()
+	f.echo.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// send signs body and posts it to the route of kind
+func (f *fixture) send(kind string, body []byte) *httptest.ResponseRecorder {
+	return f.post(kind, body, webhook.Sign([]byte(secret), time.Now(), body))
+}
+
+// response decodes the response of an answered event into res
+func response(t *testing.T, rec *httptest.ResponseRecorder, res interface{}) {
+	t.Helper()
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+
+	var e trigger.Event
+	if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(e.Response, res); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReceiveChecksSignature(t *testing.T) {
+	body := event(t, "PreSignUp_SignUp", trigger.PreSignUpRequest{
+		UserAttributes: map[string]string{"email": username},
+	})
+
+	tests := []struct {
+		name      string
+		signature string
+		wantBody  string
+	}{
+		{name: "Missing", wantBody: webhook.ErrSignatureMissing.Error()},
+		{name: "WrongSecret", signature: webhook.Sign([]byte("other"), time.Now(), body), wantBody: webhook.ErrSignatureInvalid.Error()},
+		{name: "OtherBody", signature: webhook.Sign([]byte(secret), time.Now(), []byte("{}")), wantBody: webhook.ErrSignatureInvalid.Error()},
+		{name: "Stale", signature: webhook.Sign([]byte(secret), time.Now().Add(-time.Hour), body), wantBody: webhook.ErrSignatureExpired.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t, true)
+
+			rec := f.post(trigger.KindPreSignUp, body, tt.signature)
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want 401: %s", rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Fatalf("body %s doesn't mention %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestReceiveRejectsOtherTrigger(t *testing.T) {
+	f := newFixture(t, true)
+
+	body := event(t, "TokenGeneration_HostedAuth", trigger.PreTokenGenerationRequest{})
+	rec := f.send(trigger.KindPreSignUp, body)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "not a PreSignUp trigger") {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPreSignUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		open     bool
+		source   string
+		email    string
+		want     int
+		wantBody string
+	}{
+		{name: "Allowed", open: true, source: "PreSignUp_SignUp", email: username, want: http.StatusOK},
+		{name: "Closed", source: "PreSignUp_SignUp", email: username, want: http.StatusBadRequest, wantBody: "Sign up is by invitation only"},
+		{name: "DomainNotAllowed", open: true, source: "PreSignUp_SignUp", email: "mallory@example.org", want: http.StatusBadRequest, wantBody: "Sign up with example.org addresses is not allowed"},
+		{name: "AdminCreateUserWhileClosed", source: "PreSignUp_AdminCreateUser", email: "bob@example.org", want: http.StatusOK},
+		{name: "FederatedDomainNotAllowed", open: true, source: "PreSignUp_ExternalProvider", email: "mallory@example.org", want: http.StatusBadRequest, wantBody: "not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t, tt.open)
+
+			rec := f.send(trigger.KindPreSignUp, event(t, tt.source, trigger.PreSignUpRequest{
+				UserAttributes: map[string]string{"email": tt.email},
+			}))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Fatalf("body %s doesn't mention %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestVerifyAuthChallenge(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer func(t *testing.T, f *fixture) string
+		want   bool
+	}{
+		{
+			name:   "Valid",
+			answer: proof(username),
+			want:   true,
+		},
+		{
+			name:   "Tampered",
+			answer: func(t *testing.T, f *fixture) string { return proof(username)(t, f) + "x" },
+		},
+		{
+			name:   "OtherUser",
+			answer: proof("mallory@example.com"),
+		},
+		{
+			name: "Expired",
+			answer: func(t *testing.T, f *fixture) string {
+				f.now = time.Now().Add(time.Hour)
+				return proof(username)(t, f)
+			},
+		},
+		{
+			name:   "Garbage",
+			answer: func(*testing.T, *fixture) string { return "not-a-proof" },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t, true)
+
+			rec := f.send(trigger.KindVerifyAuthChallengeResponse, event(t, "VerifyAuthChallengeResponse_Authentication", trigger.VerifyAuthChallengeRequest{
+				UserAttributes:  map[string]string{"email": username},
+				ChallengeAnswer: tt.answer(t, f),
+			}))
+
+			var res trigger.VerifyAuthChallengeResponse
+			response(t, rec, &res)
+			if res.AnswerCorrect != tt.want {
+				t.Fatalf("answerCorrect = %v, want %v", res.AnswerCorrect, tt.want)
+			}
+		})
+	}
+}
+
+// proof returns an answer made for name the way the passwordless flow makes it
+func proof(name string) func(t *testing.T, f *fixture) string {
+	return func(t *testing.T, f *fixture) string {
+		t.Helper()
+
+		answer, err := f.proofs.ProofFor(name, "ref")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return answer
+	}
+}
+
+func TestDefineAuthChallenge(t *testing.T) {
+	tests := []struct {
+		name string
+		req  trigger.DefineAuthChallengeRequest
+		want trigger.DefineAuthChallengeResponse
+	}{
+		{
+			name: "FirstStep",
+			want: trigger.DefineAuthChallengeResponse{ChallengeName: identity.ChallengeCustom},
+		},
+		{
+			name: "UserNotFound",
+			req:  trigger.DefineAuthChallengeRequest{UserNotFound: true},
+			want: trigger.DefineAuthChallengeResponse{FailAuthentication: true},
+		},
+		{
+			name: "AnsweredCorrectly",
+			req: trigger.DefineAuthChallengeRequest{Session: []trigger.ChallengeResult{
+				{ChallengeName: identity.ChallengeCustom, ChallengeResult: true},
+			}},
+			want: trigger.DefineAuthChallengeResponse{IssueTokens: true},
+		},
+		{
+			name: "AnsweredWrong",
+			req: trigger.DefineAuthChallengeRequest{Session: []trigger.ChallengeResult{
+				{ChallengeName: identity.ChallengeCustom, ChallengeResult: false},
+			}},
+			want: trigger.DefineAuthChallengeResponse{FailAuthentication: true},
+		},
+		{
+			name: "OtherChallenge",
+			req: trigger.DefineAuthChallengeRequest{Session: []trigger.ChallengeResult{
+				{ChallengeName: "PASSWORD_VERIFIER", ChallengeResult: true},
+			}},
+			want: trigger.DefineAuthChallengeResponse{FailAuthentication: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t, true)
+
+			rec := f.send(trigger.KindDefineAuthChallenge, event(t, "DefineAuthChallenge_Authentication", tt.req))
+
+			var res trigger.DefineAuthChallengeResponse
+			response(t, rec, &res)
+			if res != tt.want {
+				t.Fatalf("response = %+v, want %+v", res, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreTokenGenerationAddsUserID(t *testing.T) {
+	f := newFixture(t, true)
+
+	rec := f.send(trigger.KindPreTokenGeneration, event(t, "TokenGeneration_Authentication", trigger.PreTokenGenerationRequest{
+		UserAttributes: map[string]string{"sub": subject, "email": username},
+	}))
+
+	var res trigger.PreTokenGenerationResponse
+	response(t, rec, &res)
+
+	u, err := f.users.Get(context.Background(), subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ClaimsOverrideDetails == nil {
+		t.Fatal("claimsOverrideDetails is missing")
+	}
+	if got := res.ClaimsOverrideDetails.ClaimsToAddOrOverride[hooks.ClaimUserID]; got != u.ID.String() {
+		t.Fatalf("%s = %q, want %q", hooks.ClaimUserID, got, u.ID.String())
+	}
+}
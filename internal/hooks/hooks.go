@@ -0,0 +1,192 @@
+// Package hooks holds what this service does when the user pool runs one of
+// its Lambda triggers. The triggers are forwarded by a thin Lambda and
+// dispatched here by pkg/trigger.
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"backend/internal/passwordless"
+	"backend/internal/signup"
+	"backend/internal/user"
+	"backend/pkg/identity"
+	"backend/pkg/trigger"
+)
+
+// Trigger sources the hooks tell apart
+const (
+	sourceSignUp          = "PreSignUp_SignUp"
+	sourceAdminCreateUser = "PreSignUp_AdminCreateUser"
+	sourceConfirmSignUp   = "PostConfirmation_ConfirmSignUp"
+
+	messageSignUp              = "CustomMessage_SignUp"
+	messageResendCode          = "CustomMessage_ResendCode"
+	messageForgotPassword      = "CustomMessage_ForgotPassword"
+	messageAdminCreateUser     = "CustomMessage_AdminCreateUser"
+	messageUpdateUserAttribute = "CustomMessage_UpdateUserAttribute"
+	messageVerifyUserAttribute = "CustomMessage_VerifyUserAttribute"
+)
+
+// ClaimUserID carries the ID of the user's row in the users table
+const ClaimUserID = "user_id"
+
+type Options struct {
+	// SignUpOpen allows self sign up, otherwise only admins create accounts
+	SignUpOpen bool
+	// SignUpPolicy decides which email addresses may sign up
+	SignUpPolicy *signup.Policy
+	// AppName is used in the messages Cognito sends
+	AppName string
+	// ProofSecret verifies the answers of the custom auth challenge
+	ProofSecret []byte
+	Users       *user.Repository
+	// Now returns the time proofs are checked at, time.Now when nil
+	Now func() time.Time
+}
+
+// New returns the hooks of this service
+func New(opts Options) *trigger.Hooks {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	h := &handler{opts: opts}
+
+	return &trigger.Hooks{
+		PreSignUp:                   h.preSignUp,
+		PostConfirmation:            h.postConfirmation,
+		PreTokenGeneration:          h.preTokenGeneration,
+		CustomMessage:               h.customMessage,
+		DefineAuthChallenge:         h.defineAuthChallenge,
+		CreateAuthChallenge:         h.createAuthChallenge,
+		VerifyAuthChallengeResponse: h.verifyAuthChallenge,
+	}
+}
+
+type handler struct {
+	opts Options
+}
+
+// preSignUp enforces the sign up settings at the user pool, so accounts can't
+// be created around the API by calling Cognito directly
+func (h *handler) preSignUp(_ context.Context, e *trigger.Event, req *trigger.PreSignUpRequest, _ *trigger.PreSignUpResponse) error {
+	if e.TriggerSource == sourceAdminCreateUser {
+		return nil
+	}
+
+	if e.TriggerSource == sourceSignUp && !h.opts.SignUpOpen {
+		return trigger.Reject("Sign up is by invitation only")
+	}
+
+	if msg := h.opts.SignUpPolicy.CheckEmail(req.UserAttributes["email"]); msg != "" {
+		return trigger.Reject("%s", msg)
+	}
+
+	return nil
+}
+
+// postConfirmation mirrors a newly confirmed account into the users table
+func (h *handler) postConfirmation(ctx context.Context, e *trigger.Event, req *trigger.PostConfirmationRequest, _ *trigger.PostConfirmationResponse) error {
+	if e.TriggerSource != sourceConfirmSignUp {
+		return nil
+	}
+
+	_, err := h.opts.Users.SyncManagedUser(ctx, &identity.ManagedUser{
+		Subject:    req.UserAttributes["sub"],
+		Username:   e.UserName,
+		Email:      req.UserAttributes["email"],
+		Status:     identity.StatusConfirmed,
+		Enabled:    true,
+		Attributes: req.UserAttributes,
+	})
+	return err
+}
+
+// preTokenGeneration adds the ID of the user's row to the ID token so
+// application data can be keyed by it without a lookup
+func (h *handler) preTokenGeneration(ctx context.Context, e *trigger.Event, req *trigger.PreTokenGenerationRequest, res *trigger.PreTokenGenerationResponse) error {
+	subject := req.UserAttributes["sub"]
+	if err := h.opts.Users.Ensure(ctx, subject, e.UserName, req.UserAttributes["email"]); err != nil {
+		return err
+	}
+
+	u, err := h.opts.Users.Get(ctx, subject)
+	if err != nil {
+		return err
+	}
+
+	if res.ClaimsOverrideDetails == nil {
+		res.ClaimsOverrideDetails = &trigger.ClaimsOverrideDetails{}
+	}
+	if res.ClaimsOverrideDetails.ClaimsToAddOrOverride == nil {
+		res.ClaimsOverrideDetails.ClaimsToAddOrOverride = map[string]string{}
+	}
+	res.ClaimsOverrideDetails.ClaimsToAddOrOverride[ClaimUserID] = u.ID.String()
+
+	return nil
+}
+
+// customMessage words the emails Cognito sends. The code parameter is a
+// placeholder Cognito replaces, it has to appear in the message as is.
+func (h *handler) customMessage(_ context.Context, e *trigger.Event, req *trigger.CustomMessageRequest, res *trigger.CustomMessageResponse) error {
+	app := h.opts.AppName
+	code := req.CodeParameter
+
+	switch e.TriggerSource {
+	case messageSignUp, messageResendCode:
+		res.EmailSubject = fmt.Sprintf("Confirm your %s account", app)
+		res.EmailMessage = fmt.Sprintf("Welcome to %s!<br><br>Your confirmation code is <b>%s</b>.", app, code)
+	case messageForgotPassword:
+		res.EmailSubject = fmt.Sprintf("Reset your %s password", app)
+		res.EmailMessage = fmt.Sprintf("Your password reset code is <b>%s</b>.<br><br>"+
+			"If you didn't ask to reset your password, you can ignore this email.", code)
+	case messageAdminCreateUser:
+		res.EmailSubject = fmt.Sprintf("You have been invited to %s", app)
+		res.EmailMessage = fmt.Sprintf("You have been invited to %s.<br><br>Sign in as <b>%s</b> with the temporary password <b>%s</b> "+
+			"and choose your own password.", app, req.UsernameParameter, code)
+	case messageUpdateUserAttribute, messageVerifyUserAttribute:
+		res.EmailSubject = fmt.Sprintf("Verify your new %s email address", app)
+		res.EmailMessage = fmt.Sprintf("Your verification code is <b>%s</b>.", code)
+	}
+
+	return nil
+}
+
+// defineAuthChallenge runs custom authentication as a single challenge whose
+// answer is a proof made by this service after it verified the user another way
+func (h *handler) defineAuthChallenge(_ context.Context, _ *trigger.Event, req *trigger.DefineAuthChallengeRequest, res *trigger.DefineAuthChallengeResponse) error {
+	switch {
+	case req.UserNotFound:
+		res.FailAuthentication = true
+	case len(req.Session) == 0:
+		res.ChallengeName = identity.ChallengeCustom
+	default:
+		last := req.Session[len(req.Session)-1]
+		res.IssueTokens = last.ChallengeName == identity.ChallengeCustom && last.ChallengeResult
+		res.FailAuthentication = !res.IssueTokens
+	}
+
+	return nil
+}
+
+func (h *handler) createAuthChallenge(_ context.Context, _ *trigger.Event, _ *trigger.CreateAuthChallengeRequest, res *trigger.CreateAuthChallengeResponse) error {
+	res.PublicChallengeParameters = map[string]string{"type": "proof"}
+	res.PrivateChallengeParameters = map[string]string{}
+	res.ChallengeMetadata = "PROOF"
+	return nil
+}
+
+// verifyAuthChallenge checks the proof. It was made for the name the sign in
+// was started with, which is the username or, with email aliases, the email.
+func (h *handler) verifyAuthChallenge(_ context.Context, e *trigger.Event, req *trigger.VerifyAuthChallengeRequest, res *trigger.VerifyAuthChallengeResponse) error {
+	now := h.opts.Now()
+	for _, name := range []string{e.UserName, req.UserAttributes["email"]} {
+		if name != "" && passwordless.VerifyProof(h.opts.ProofSecret, name, req.ChallengeAnswer, now) == nil {
+			res.AnswerCorrect = true
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package invitation
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/internal/types"
+	"backend/pkg/secrets"
+
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+// Ways an invitee receives their credentials
+const (
+	// DeliveryLink mails a link with a one time token the invitee accepts the
+	// invitation with
+	DeliveryLink = "link"
+	// DeliveryPassword lets the identity provider mail a temporary password
+	DeliveryPassword = "password"
+)
+
+// Statuses of an invitation
+const (
+	StatusPending  = "pending"
+	StatusAccepted = "accepted"
+	StatusRevoked  = "revoked"
+	StatusExpired  = "expired"
+)
+
+var (
+	ErrNotFound = errors.New("invitation does not exist")
+	ErrExpired  = errors.New("invitation has expired")
+	ErrRevoked  = errors.New("invitation has been revoked")
+	ErrAccepted = errors.New("invitation has already been accepted")
+)
+
+// Invitation tracks an account an admin created on behalf of someone until the
+// invitee sets their own password
+type Invitation struct {
+	types.Base
+	Username  string `gorm:"index"`
+	Email     string
+	Delivery  string
+	InvitedBy string
+	// TokenHash is the SHA-256 of the link token, empty for password deliveries
+	TokenHash  string `gorm:"index"`
+	ExpiresAt  time.Time
+	AcceptedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+func (Invitation) TableName() string {
+	return "invitations"
+}
+
+// Status derives the state of the invitation at now
+func (i Invitation) Status(now time.Time) string {
+	switch {
+	case i.AcceptedAt != nil:
+		return StatusAccepted
+	case i.RevokedAt != nil:
+		return StatusRevoked
+	case !now.Before(i.ExpiresAt):
+		return StatusExpired
+	default:
+		return StatusPending
+	}
+}
+
+// Usable returns why the invitation can't be accepted at now, if it can't
+func (i Invitation) Usable(now time.Time) error {
+	switch i.Status(now) {
+	case StatusAccepted:
+		return ErrAccepted
+	case StatusRevoked:
+		return ErrRevoked
+	case StatusExpired:
+		return ErrExpired
+	default:
+		return nil
+	}
+}
+
+// Store keeps invitations in Postgres
+type Store struct {
+	DB  *gorm.DB
+	TTL time.Duration
+}
+
+func NewStore(db *gorm.DB, ttl time.Duration) *Store {
+	return &Store{DB: db, TTL: ttl}
+}
+
+// Create stores a new invitation. The link token of link deliveries is only
+// kept hashed and can't be recovered later.
+func (s *Store) Create(ctx context.Context, username, email, delivery, invitedBy, token string) (*Invitation, error) {
+	base, err := types.NewBase()
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &Invitation{
+		Base:      *base,
+		Username:  username,
+		Email:     email,
+		Delivery:  delivery,
+		InvitedBy: invitedBy,
+		ExpiresAt: base.CreatedAt.Add(s.TTL),
+	}
+	if token != "" {
+		inv.TokenHash = secrets.Hash(token)
+	}
+
+	if err := s.DB.WithContext(ctx).Create(inv).Error; err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+// Get finds an invitation by ID
+func (s *Store) Get(ctx context.Context, id ulid.ULID) (*Invitation, error) {
+	return s.first(s.DB.WithContext(ctx).Where("id = ?", id))
+}
+
+// ByToken finds the invitation a link token was issued for
+func (s *Store) ByToken(ctx context.Context, token string) (*Invitation, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+
+	return s.first(s.DB.WithContext(ctx).Where("token_hash = ?", secrets.Hash(token)))
+}
+
+// Latest finds the most recent invitation of username
+func (s *Store) Latest(ctx context.Context, username string) (*Invitation, error) {
+	return s.first(s.DB.WithContext(ctx).Where("username = ?", username).Order("created_at DESC"))
+}
+
+// List returns invitations newest first, optionally only those in status
+func (s *Store) List(ctx context.Context, status string, limit, offset int) ([]Invitation, error) {
+	now := time.Now()
+	q := s.DB.WithContext(ctx).Order("created_at DESC").Limit(limit).Offset(offset)
+
+	switch status {
+	case StatusAccepted:
+		q = q.Where("accepted_at IS NOT NULL")
+	case StatusRevoked:
+		q = q.Where("accepted_at IS NULL AND revoked_at IS NOT NULL")
+	case StatusExpired:
+		q = q.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at <= ?", now)
+	case StatusPending:
+		q = q.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", now)
+	}
+
+	var invitations []Invitation
+	if err := q.Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// Accept marks a pending invitation as accepted
+func (s *Store) Accept(ctx context.Context, inv *Invitation) error {
+	now := time.Now()
+	if err := inv.Usable(now); err != nil {
+		return err
+	}
+
+	inv.AcceptedAt = &now
+	return s.DB.WithContext(ctx).Model(inv).Updates(map[string]interface{}{
+		"accepted_at": now,
+		"updated_at":  now,
+	}).Error
+}
+
+// Extend restarts the validity of an invitation that hasn't been accepted or
+// revoked, for when the invitee is sent new credentials
+func (s *Store) Extend(ctx context.Context, inv *Invitation) error {
+	now := time.Now()
+	if err := inv.Usable(now); err != nil && !errors.Is(err, ErrExpired) {
+		return err
+	}
+
+	inv.ExpiresAt = now.Add(s.TTL)
+	return s.DB.WithContext(ctx).Model(inv).Updates(map[string]interface{}{
+		"expires_at": inv.ExpiresAt,
+		"updated_at": now,
+	}).Error
+}
+
+// Revoke marks a pending invitation as revoked
+func (s *Store) Revoke(ctx context.Context, inv *Invitation) error {
+	now := time.Now()
+	if err := inv.Usable(now); err != nil && !errors.Is(err, ErrExpired) {
+		return err
+	}
+
+	inv.RevokedAt = &now
+	return s.DB.WithContext(ctx).Model(inv).Updates(map[string]interface{}{
+		"revoked_at": now,
+		"updated_at": now,
+	}).Error
+}
+
+func (s *Store) first(q *gorm.DB) (*Invitation, error) {
+	var inv Invitation
+	if err := q.First(&inv).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &inv, nil
+}
+
+// NewToken generates a link token. It doubles as the temporary password of the
+// invited account, so it carries every character class password policies ask for.
+func NewToken() (string, error) {
+	token, err := secrets.Token()
+	if err != nil {
+		return "", err
+	}
+
+	return "Inv1_" + token, nil
+}
@@ -1,21 +1,22 @@
 package middlewares
 
 import (
-	"context"
 	"errors"
 	"net/http"
 	"strings"
-	"time"
 
-	"fmt"
-
-	"backend/pkg/config"
+	"backend/internal/apikey"
+	"backend/internal/auth"
+	"backend/internal/svc"
+	"backend/internal/user"
+	"backend/pkg/identity"
+	"backend/pkg/jwks"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/labstack/echo/v4"
-	"github.com/lestrrat/go-jwx/jwk"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // JWK represents the JSON Web Key structure
@@ -28,94 +29,209 @@ type JWK struct {
 	Use string `json:"use"`
 }
 
-// GetCognitoPublicKeys retrieves the public keys from Cognito using the region and user pool ID
-func GetCognitoPublicKeys(ctx context.Context, region, userPoolID string) jwt.Keyfunc {
-	return func(token *jwt.Token) (interface{}, error) {
-		_, span := tracer.Start(ctx, "helper.GetCognitoPublicKeys")
-		jwksURL := fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s/.well-known/jwks.json", region, userPoolID)
-		set, err := jwk.FetchHTTP(jwksURL)
-		if err != nil {
-			span.RecordError(err)
-			return nil, err
+var tracer = otel.GetTracerProvider().Tracer("middleware.AuthValidator")
+
+// HeaderAPIKey carries the API key of machine clients
+const HeaderAPIKey = "X-API-Key"
+
+// AuthValidator verifies the bearer token, or the session cookie in cookie session
+// mode, against the identity provider's keys and validates its claims. tokenUse restricts which token types the route accepts;
+// access and ID tokens, API keys and machine client tokens are accepted when it is empty.
+func AuthValidator(s *svc.ServiceContext, tokenUse ...string) echo.MiddlewareFunc {
+	if len(tokenUse) == 0 {
+		tokenUse = []string{TokenUseAccess, TokenUseID, TokenUseAPIKey, TokenUseClient}
+	}
+
+	validator := ClaimsValidator{
+		Issuer:           s.Identity.Issuer(),
+		ClientID:         s.Identity.Audience(),
+		TokenUse:         tokenUse,
+		MachineClientIDs: s.Config.Auth.MACHINE_CLIENT_IDS,
+		ClockSkew:        s.Config.Auth.CLOCK_SKEW,
+	}
+	parser := &jwt.Parser{
+		ValidMethods:         []string{jwt.SigningMethodRS256.Alg()},
+		SkipClaimsValidation: true,
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx, span := tracer.Start(c.Request().Context(), "middelware.AuthValidator")
+			defer span.End()
+
+			authHeader := c.Request().Header.Get("Authorization")
+			if key := c.Request().Header.Get(HeaderAPIKey); key != "" && authHeader == "" {
+				if !contains(tokenUse, TokenUseAPIKey) {
+					return unauthorized(c, span, &AuthError{Code: ReasonTokenUseInvalid, Message: "API keys are not accepted for this route"})
+				}
+				return apiKeyAuth(s, c, span, key, next)
+			}
+
+			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+			if authHeader == "" {
+				// browser clients in cookie session mode send no header
+				tokenString = s.Sessions.Credential(c, contains(tokenUse, TokenUseAccess))
+			}
+			if tokenString == "" {
+				return unauthorized(c, span, &AuthError{Code: ReasonTokenMissing, Message: "Token is required"})
+			}
+
+			token, err := parser.Parse(tokenString, s.Identity.Keyfunc(ctx))
+			if err != nil {
+				span.RecordError(err)
+				return unauthorized(c, span, parseError(err))
+			}
+
+			claims, ok := token.Claims.(jwt.MapClaims)
+			if !ok {
+				return unauthorized(c, span, &AuthError{Code: ReasonClaimsInvalid, Message: "Failed to get token claims"})
+			}
+
+			if authErr := validator.Validate(claims); authErr != nil {
+				return unauthorized(c, span, authErr)
+			}
+
+			principal := auth.NewPrincipal(claims, tokenString)
+			if s.Denylist.IsRevoked(principal) {
+				return unauthorized(c, span, &AuthError{Code: ReasonTokenRevoked, Message: "Token has been revoked"})
+			}
+
+			if principal.Type == auth.PrincipalUser {
+				// the first request of a user creates their row in the users table,
+				// a failure doesn't fail the request as the token is valid
+				if err := s.Users.Ensure(ctx, principal.Subject, principal.Username, principal.Email); err != nil {
+					span.RecordError(err)
+				}
+			}
+
+			span.SetAttributes(
+				attribute.Key("user.id").String(principal.Username),
+				attribute.Key("user.sub").String(principal.Subject),
+				attribute.Key("auth.token_use").String(principal.TokenType),
+				attribute.Key("auth.principal_type").String(principal.Type),
+			)
+			auth.SetPrincipal(c, principal)
+			return next(c)
 		}
+	}
+}
 
-		keyID, ok := token.Header["kid"].(string)
-		if !ok {
-			return nil, errors.New("expecting JWT header to have string kid")
+// UnlessFormValue skips mw for requests that carry the form field name, like
+// the session of a sign in challenge that stands in for a token. The handler
+// has to authenticate those requests itself.
+func UnlessFormValue(name string, mw echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		guarded := mw(next)
+		return func(c echo.Context) error {
+			if c.FormValue(name) != "" {
+				return next(c)
+			}
+			return guarded(c)
 		}
+	}
+}
 
-		if key := set.LookupKeyID(keyID); len(key) == 1 {
-			return key[0].Materialize()
+// apiKeyAuth authenticates a request by API key. The principal looks like the
+// one of the key's owner, without groups so admin routes stay token only.
+func apiKeyAuth(s *svc.ServiceContext, c echo.Context, span trace.Span, secret string, next echo.HandlerFunc) error {
+	key, err := s.APIKeys.Authenticate(c.Request().Context(), secret)
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, apikey.ErrNotFound):
+			return unauthorized(c, span, &AuthError{Code: ReasonAPIKeyInvalid, Message: "API key is not valid"})
+		case errors.Is(err, apikey.ErrExpired):
+			return unauthorized(c, span, &AuthError{Code: ReasonAPIKeyExpired, Message: "API key has expired"})
+		case errors.Is(err, apikey.ErrRevoked):
+			return unauthorized(c, span, &AuthError{Code: ReasonAPIKeyRevoked, Message: "API key has been revoked"})
+		default:
+			return unauthorized(c, span, &AuthError{Code: ReasonAPIKeyUnavailable, Message: "API key could not be checked"})
 		}
+	}
 
-		return nil, errors.New("unable to find key")
+	principal := &auth.Principal{
+		Type:      auth.PrincipalUser,
+		Subject:   key.Subject,
+		Username:  key.Username,
+		Email:     key.Email,
+		TokenType: TokenUseAPIKey,
+		Scopes:    key.ScopeList(),
+		TokenID:   key.ID.String(),
+		IssuedAt:  key.CreatedAt,
+	}
+	if key.ExpiresAt != nil {
+		principal.ExpiresAt = *key.ExpiresAt
 	}
-}
 
-var tracer = otel.GetTracerProvider().Tracer("middleware.AuthValidator")
+	// keys live longer than tokens, so they go through the same revocation
+	// as the owner's tokens and stop working once the owner is disabled
+	if s.Denylist.IsRevoked(principal) {
+		return unauthorized(c, span, &AuthError{Code: ReasonTokenRevoked, Message: "Token has been revoked"})
+	}
+	owner, err := s.Users.Get(c.Request().Context(), key.Subject)
+	if errors.Is(err, user.ErrNotFound) {
+		// the mirror has no row for owners who never signed in since it was
+		// added, their account is looked up at the provider instead
+		owner, err = refreshOwner(s, c, key)
+	}
+	switch {
+	case errors.Is(err, identity.ErrUserNotFound):
+		return unauthorized(c, span, &AuthError{Code: ReasonAPIKeyOwnerDisabled, Message: "The owner of the API key is disabled"})
+	case err != nil:
+		span.RecordError(err)
+		return unauthorized(c, span, &AuthError{Code: ReasonAPIKeyUnavailable, Message: "API key could not be checked"})
+	case !owner.Enabled || owner.Deleted() || owner.Subject != key.Subject:
+		return unauthorized(c, span, &AuthError{Code: ReasonAPIKeyOwnerDisabled, Message: "The owner of the API key is disabled"})
+	}
 
-func AuthValidator(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		authHeader := c.Request().Header.Get("Authorization")
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		ctx, span := tracer.Start(c.Request().Context(), "middelware.AuthValidator")
-		defer span.End()
-
-		if tokenString == "" {
-			span.RecordError(errors.New("token couldn't be parsed"))
-			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
-			return c.JSON(http.StatusBadRequest, echo.Map{
-				"message": "Something went wrong",
-				"error":   errors.New("token is required"),
-			})
-		}
+	span.SetAttributes(
+		attribute.Key("user.id").String(principal.Username),
+		attribute.Key("user.sub").String(principal.Subject),
+		attribute.Key("auth.token_use").String(principal.TokenType),
+		attribute.Key("auth.api_key_id").String(principal.TokenID),
+	)
+	auth.SetPrincipal(c, principal)
+	return next(c)
+}
 
-		cfg := config.InitConfig()
-		keyFunc := GetCognitoPublicKeys(ctx, cfg.AWS.REGIONS, cfg.AWS.COGNITO.USERPOOL_ID)
-		token, err := jwt.Parse(tokenString, keyFunc)
-
-		if err != nil {
-			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
-			span.RecordError(err)
-			return c.JSON(http.StatusInternalServerError, echo.Map{
-				"message": "Something went wrong",
-				"error":   err.Error(),
-			})
-		}
+// refreshOwner syncs the owner of key from the identity provider. It fails
+// when the provider can't look up accounts, so unchecked keys are rejected.
+func refreshOwner(s *svc.ServiceContext, c echo.Context, key *apikey.APIKey) (*user.User, error) {
+	users, ok := s.Identity.(identity.UserAdministrator)
+	if !ok {
+		return nil, errors.New("identity provider can't look up the owner of an API key")
+	}
 
-		if !token.Valid {
-			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusInternalServerError))
-			return c.JSON(http.StatusUnauthorized, echo.Map{
-				"message": "Invalid access token",
-			})
-		}
+	return s.Users.Refresh(c.Request().Context(), users, key.Username)
+}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
-			return c.JSON(http.StatusBadRequest, echo.Map{
-				"message": "Failed to get token claims",
-			})
-		}
+func parseError(err error) *AuthError {
+	var ve *jwt.ValidationError
+	if !errors.As(err, &ve) {
+		return &AuthError{Code: ReasonTokenMalformed, Message: "Token couldn't be parsed"}
+	}
 
-		userId, ok := claims["cognito:username"].(string)
-		if !ok {
-			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusBadRequest))
-			return c.JSON(http.StatusBadRequest, echo.Map{
-				"message": "Failed to get subject claim",
-			})
+	switch {
+	case ve.Errors&jwt.ValidationErrorMalformed != 0:
+		return &AuthError{Code: ReasonTokenMalformed, Message: "Token couldn't be parsed"}
+	case ve.Errors&jwt.ValidationErrorUnverifiable != 0:
+		if errors.Is(ve.Inner, jwks.ErrKeyNotFound) || errors.Is(ve.Inner, jwks.ErrMissingKeyID) {
+			return &AuthError{Code: ReasonSignatureInvalid, Message: "Token was signed with an unknown key"}
 		}
+		return &AuthError{Code: ReasonKeySetUnavailable, Message: "Signing keys could not be loaded"}
+	default:
+		return &AuthError{Code: ReasonSignatureInvalid, Message: "Token signature is invalid"}
+	}
+}
 
-		expirationTime := time.Unix(int64(claims["exp"].(float64)), 0)
-		if time.Now().After(expirationTime) {
-			span.SetAttributes(attribute.Key("http.status_code").Int(http.StatusUnauthorized))
-			return c.JSON(http.StatusUnauthorized, echo.Map{
-				"message": "Access token has expired",
-			})
-		}
+func unauthorized(c echo.Context, span trace.Span, authErr *AuthError) error {
+	span.SetAttributes(
+		attribute.Key("http.status_code").Int(http.StatusUnauthorized),
+		attribute.Key("auth.failure_reason").String(authErr.Code),
+	)
 
-		span.SetAttributes(attribute.Key("user.id").String(userId))
-		c.Request().Header.Add("user.id", userId)
-		return next(c)
-	}
+	return c.JSON(http.StatusUnauthorized, echo.Map{
+		"message": authErr.Message,
+		"code":    authErr.Code,
+	})
 }
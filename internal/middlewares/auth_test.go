@@ -0,0 +1,400 @@
+package middlewares_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/apikey"
+	"backend/internal/auth"
+	"backend/internal/middlewares"
+	"backend/internal/svc"
+	"backend/internal/testdb"
+	"backend/internal/user"
+	"backend/pkg/cognito/cognitotest"
+	"backend/pkg/config"
+	"backend/pkg/identity"
+	"backend/pkg/jwks"
+
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	issuer   = "https://issuer.example.com"
+	clientID = "web"
+	subject  = "00000000-0000-0000-0000-000000000001"
+)
+
+// keys is an identity provider that only verifies tokens signed with key
+type keys struct {
+	identity.Provider
+	key *rsa.PrivateKey
+}
+
+func (p keys) Issuer() string   { return issuer }
+func (p keys) Audience() string { return clientID }
+
+func (p keys) Keyfunc(context.Context) jwt.Keyfunc {
+	return func(*jwt.Token) (interface{}, error) { return &p.key.PublicKey, nil }
+}
+
+// signer signs tokens with a test key. The claims of an access token of
+// subject are filled in, claims set to nil are left out.
+type signer struct {
+	key *rsa.PrivateKey
+}
+
+func newSigner(t *testing.T) signer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer{key: key}
+}
+
+func (s signer) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	now := time.Now()
+	all := jwt.MapClaims{
+		"iss":              issuer,
+		"sub":              subject,
+		"client_id":        clientID,
+		"username":         "alice",
+		"cognito:username": "alice",
+		"token_use":        "access",
+		"jti":              "jti-1",
+		"origin_jti":       "origin-1",
+		"iat":              now.Unix(),
+		"exp":              now.Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(all, k)
+			continue
+		}
+		all[k] = v
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, all).SignedString(s.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// newTokenServer serves the subject of the principal on / behind the CSRF
+// check and AuthValidator, the way the routes are set up in main
+func newTokenServer(t *testing.T, session config.Session) (*echo.Echo, *svc.ServiceContext, signer) {
+	t.Helper()
+
+	tokens := newSigner(t)
+	s := &svc.ServiceContext{
+		Config:   config.Configuration{Session: session},
+		Identity: keys{key: tokens.key},
+		Denylist: auth.NewMemoryDenylist(),
+		Sessions: auth.NewSessionCookies(session),
+		Users:    user.NewRepository(testdb.Open(t, &user.User{})),
+	}
+
+	e := echo.New()
+	e.Use(middlewares.CSRF(s))
+	whoami := func(c echo.Context) error {
+		return c.String(http.StatusOK, auth.MustPrincipal(c).Subject)
+	}
+	e.GET("/", whoami, middlewares.AuthValidator(s))
+	e.POST("/", whoami, middlewares.AuthValidator(s))
+
+	return e, s, tokens
+}
+
+func newAPIKeyServer(t *testing.T) (*echo.Echo, *svc.ServiceContext, string) {
+	t.Helper()
+
+	db := testdb.Open(t, &apikey.APIKey{}, &user.User{})
+	pool := cognitotest.New()
+	pool.AddUser("alice", "Passw0rd!", true, map[string]string{"email": "alice@example.com"})
+	s := &svc.ServiceContext{
+		Cognito:  pool,
+		Identity: identity.NewCognitoProvider(pool, "client", "pool", "issuer", nil),
+		Denylist: auth.NewMemoryDenylist(),
+		Users:    user.NewRepository(db),
+		APIKeys:  apikey.NewStore(db, 0, time.Hour),
+	}
+
+	_, secret, err := s.APIKeys.Create(context.Background(), apikey.Owner{Subject: subject, Username: "alice"}, "ci", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, auth.MustPrincipal(c).Subject)
+	}, middlewares.AuthValidator(s))
+
+	return e, s, secret
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, s *svc.ServiceContext)
+		want     int
+		wantBody string
+	}{
+		{
+			name: "NoMirrorRow",
+			setup: func(t *testing.T, s *svc.ServiceContext) {
+				t.Cleanup(func() {
+					// the owner was looked up at the provider and mirrored
+					if _, err := s.Users.Get(context.Background(), subject); err != nil {
+						t.Errorf("owner row: %v", err)
+					}
+				})
+			},
+			want:     http.StatusOK,
+			wantBody: subject,
+		},
+		{
+			name: "NoMirrorRowOwnerDisabled",
+			setup: func(_ *testing.T, s *svc.ServiceContext) {
+				s.Cognito.(*cognitotest.Client).Update("alice", func(u *cognitotest.User) { u.Disabled = true })
+			},
+			want:     http.StatusUnauthorized,
+			wantBody: middlewares.ReasonAPIKeyOwnerDisabled,
+		},
+		{
+			name: "NoMirrorRowOwnerDeleted",
+			setup: func(t *testing.T, s *svc.ServiceContext) {
+				users := s.Identity.(identity.UserAdministrator)
+				if err := users.DeleteUser(context.Background(), "alice"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:     http.StatusUnauthorized,
+			wantBody: middlewares.ReasonAPIKeyOwnerDisabled,
+		},
+		{
+			// a new account that took over the username is not the owner
+			name: "NoMirrorRowUsernameReused",
+			setup: func(t *testing.T, s *svc.ServiceContext) {
+				pool := s.Cognito.(*cognitotest.Client)
+				if err := s.Identity.(identity.UserAdministrator).DeleteUser(context.Background(), "alice"); err != nil {
+					t.Fatal(err)
+				}
+				pool.AddUser("alice", "Passw0rd!", true, nil)
+			},
+			want:     http.StatusUnauthorized,
+			wantBody: middlewares.ReasonAPIKeyOwnerDisabled,
+		},
+		{
+			name: "NoMirrorRowProviderDown",
+			setup: func(_ *testing.T, s *svc.ServiceContext) {
+				s.Cognito.(*cognitotest.Client).FailNext("AdminGetUser", cognitotest.Error(cognitoidentityprovider.ErrCodeInternalErrorException))
+			},
+			want:     http.StatusUnauthorized,
+			wantBody: middlewares.ReasonAPIKeyUnavailable,
+		},
+		{
+			name: "NoMirrorRowProviderCantLookUp",
+			setup: func(_ *testing.T, s *svc.ServiceContext) {
+				s.Identity = keys{}
+			},
+			want:     http.StatusUnauthorized,
+			wantBody: middlewares.ReasonAPIKeyUnavailable,
+		},
+		{
+			name: "KeysRevokedBySignOutAll",
+			setup: func(t *testing.T, s *svc.ServiceContext) {
+				if err := s.APIKeys.RevokeAll(context.Background(), subject); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:     http.StatusUnauthorized,
+			wantBody: middlewares.ReasonAPIKeyRevoked,
+		},
+		{
+			name: "OwnerEnabled",
+			setup: func(t *testing.T, s *svc.ServiceContext) {
+				if err := s.Users.Ensure(context.Background(), subject, "alice", ""); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:     http.StatusOK,
+			wantBody: subject,
+		},
+		{
+			name: "OwnerDisabled",
+			setup: func(t *testing.T, s *svc.ServiceContext) {
+				_, err := s.Users.SyncManagedUser(context.Background(), &identity.ManagedUser{Subject: subject, Username: "alice", Enabled: false})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:     http.StatusUnauthorized,
+			wantBody: middlewares.ReasonAPIKeyOwnerDisabled,
+		},
+		{
+			name: "OwnerDeleted",
+			setup: func(t *testing.T, s *svc.ServiceContext) {
+				if err := s.Users.Ensure(context.Background(), subject, "alice", ""); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.Users.MarkDeleted(context.Background(), subject); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:     http.StatusUnauthorized,
+			wantBody: middlewares.ReasonAPIKeyOwnerDisabled,
+		},
+		{
+			name: "SubjectRevoked",
+			setup: func(_ *testing.T, s *svc.ServiceContext) {
+				// revocations have whole seconds, the key was made in an earlier one
+				later := time.Now().Add(time.Second)
+				s.Denylist.RevokeSubject(subject, later, later.Add(time.Hour))
+			},
+			want:     http.StatusUnauthorized,
+			wantBody: middlewares.ReasonTokenRevoked,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, s, secret := newAPIKeyServer(t)
+			tt.setup(t, s)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(middlewares.HeaderAPIKey, secret)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Fatalf("body %s doesn't mention %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAuthValidatorDenylist(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(s *svc.ServiceContext)
+		want   int
+	}{
+		{
+			name:   "NotRevoked",
+			revoke: func(*svc.ServiceContext) {},
+			want:   http.StatusOK,
+		},
+		{
+			name:   "TokenRevoked",
+			revoke: func(s *svc.ServiceContext) { s.Denylist.Revoke("jti-1", time.Now().Add(time.Hour)) },
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "SessionRevoked",
+			revoke: func(s *svc.ServiceContext) { s.Denylist.Revoke("origin-1", time.Now().Add(time.Hour)) },
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "OtherTokenRevoked",
+			revoke: func(s *svc.ServiceContext) { s.Denylist.Revoke("jti-2", time.Now().Add(time.Hour)) },
+			want:   http.StatusOK,
+		},
+		{
+			name: "SignedOutEverywhereBefore",
+			revoke: func(s *svc.ServiceContext) {
+				later := time.Now().Add(time.Second)
+				s.Denylist.RevokeSubject(subject, later, later.Add(time.Hour))
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			// a sign in right after signing out everywhere gets an iat of the same second
+			name: "SignedInAgainAfterSignOutAll",
+			revoke: func(s *svc.ServiceContext) {
+				now := time.Now()
+				s.Denylist.RevokeSubject(subject, now, now.Add(time.Hour))
+			},
+			want: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, s, tokens := newTokenServer(t, config.Session{})
+			tt.revoke(s)
+			token := tokens.sign(t, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.want == http.StatusUnauthorized && !strings.Contains(rec.Body.String(), middlewares.ReasonTokenRevoked) {
+				t.Fatalf("body %s doesn't mention %q", rec.Body.String(), middlewares.ReasonTokenRevoked)
+			}
+		})
+	}
+}
+
+// keySet is an identity provider that verifies tokens with a key set cache
+type keySet struct {
+	identity.Provider
+	cache *jwks.Cache
+}
+
+func (p keySet) Issuer() string   { return issuer }
+func (p keySet) Audience() string { return clientID }
+
+func (p keySet) Keyfunc(ctx context.Context) jwt.Keyfunc { return p.cache.Keyfunc(ctx) }
+
+func TestAuthValidatorKeySetUnavailable(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(down.Close)
+
+	e, s, tokens := newTokenServer(t, config.Session{})
+	s.Identity = keySet{cache: jwks.New(down.URL, jwks.Options{RefreshCooldown: time.Hour})}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": issuer, "sub": subject, "client_id": clientID, "username": "alice", "token_use": "access",
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(tokens.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first request fails to fetch the set, the next one waits out the
+	// cooldown; neither may blame the token
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+signed)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), middlewares.ReasonKeySetUnavailable) {
+			t.Fatalf("request %d: got %d %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+}
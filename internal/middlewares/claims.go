@@ -0,0 +1,176 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"time"
+
+	"backend/internal/auth"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Token use values Cognito writes into the "token_use" claim
+const (
+	TokenUseAccess = "access"
+	TokenUseID     = "id"
+	// TokenUseAPIKey marks principals authenticated with an API key instead of a token
+	TokenUseAPIKey = "api_key"
+	// TokenUseClient selects access tokens machine clients obtained with the
+	// client credentials grant
+	TokenUseClient = "client_credentials"
+)
+
+// Reason codes returned in the "code" field of a 401 response
+const (
+	ReasonTokenMissing        = "token_missing"
+	ReasonTokenMalformed      = "token_malformed"
+	ReasonSignatureInvalid    = "token_signature_invalid"
+	ReasonTokenExpired        = "token_expired"
+	ReasonTokenNotYetValid    = "token_not_yet_valid"
+	ReasonIssuerInvalid       = "token_issuer_invalid"
+	ReasonAudienceInvalid     = "token_audience_invalid"
+	ReasonTokenUseInvalid     = "token_use_invalid"
+	ReasonClaimsInvalid       = "token_claims_invalid"
+	ReasonSubjectMissing      = "token_subject_missing"
+	ReasonKeySetUnavailable   = "token_keys_unavailable"
+	ReasonTokenRevoked        = "token_revoked"
+	ReasonAPIKeyInvalid       = "api_key_invalid"
+	ReasonAPIKeyExpired       = "api_key_expired"
+	ReasonAPIKeyRevoked       = "api_key_revoked"
+	ReasonAPIKeyUnavailable   = "api_key_unavailable"
+	ReasonAPIKeyOwnerDisabled = "api_key_owner_disabled"
+)
+
+// AuthError describes why a token was rejected
+type AuthError struct {
+	Code    string
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// ClaimsValidator checks the registered and Cognito specific claims of a token
+// whose signature has already been verified.
+type ClaimsValidator struct {
+	Issuer   string
+	ClientID string
+	TokenUse []string
+	// MachineClientIDs are the app clients whose client credentials tokens are
+	// accepted when TokenUse contains TokenUseClient
+	MachineClientIDs []string
+	ClockSkew        time.Duration
+	Now              func() time.Time
+}
+
+func (v ClaimsValidator) Validate(claims jwt.MapClaims) *AuthError {
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	t := now()
+
+	exp, ok, err := numericDate(claims, "exp")
+	if err != nil || !ok {
+		return &AuthError{Code: ReasonClaimsInvalid, Message: "Token has no valid exp claim"}
+	}
+	if t.After(exp.Add(v.ClockSkew)) {
+		return &AuthError{Code: ReasonTokenExpired, Message: "Access token has expired"}
+	}
+
+	nbf, ok, err := numericDate(claims, "nbf")
+	if err != nil {
+		return &AuthError{Code: ReasonClaimsInvalid, Message: "Token has an invalid nbf claim"}
+	}
+	if ok && t.Add(v.ClockSkew).Before(nbf) {
+		return &AuthError{Code: ReasonTokenNotYetValid, Message: "Token is not valid yet"}
+	}
+
+	iat, ok, err := numericDate(claims, "iat")
+	if err != nil {
+		return &AuthError{Code: ReasonClaimsInvalid, Message: "Token has an invalid iat claim"}
+	}
+	if ok && t.Add(v.ClockSkew).Before(iat) {
+		return &AuthError{Code: ReasonTokenNotYetValid, Message: "Token was issued in the future"}
+	}
+
+	if iss, _ := claims["iss"].(string); iss != v.Issuer {
+		return &AuthError{Code: ReasonIssuerInvalid, Message: "Token was not issued by this user pool"}
+	}
+
+	tokenUse, _ := claims["token_use"].(string)
+	if auth.IsClientCredentials(claims) {
+		if !contains(v.TokenUse, TokenUseClient) {
+			return &AuthError{Code: ReasonTokenUseInvalid, Message: "Machine client tokens are not accepted for this route"}
+		}
+		if clientID, _ := claims["client_id"].(string); !contains(v.MachineClientIDs, clientID) {
+			return &AuthError{Code: ReasonAudienceInvalid, Message: "Token was issued for an unknown machine client"}
+		}
+	} else if !contains(v.TokenUse, tokenUse) {
+		return &AuthError{Code: ReasonTokenUseInvalid, Message: "Token type is not accepted for this route"}
+	}
+
+	switch tokenUse {
+	case TokenUseID:
+		if !audienceContains(claims["aud"], v.ClientID) {
+			return &AuthError{Code: ReasonAudienceInvalid, Message: "Token was issued for another client"}
+		}
+	case TokenUseAccess:
+		if clientID, _ := claims["client_id"].(string); clientID != v.ClientID && !auth.IsClientCredentials(claims) {
+			return &AuthError{Code: ReasonAudienceInvalid, Message: "Token was issued for another client"}
+		}
+	}
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return &AuthError{Code: ReasonSubjectMissing, Message: "Token has no subject"}
+	}
+
+	return nil
+}
+
+// numericDate reads a NumericDate claim, reporting whether it was present
+func numericDate(claims jwt.MapClaims, key string) (time.Time, bool, error) {
+	raw, ok := claims[key]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	switch v := raw.(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true, nil
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return time.Time{}, true, err
+		}
+		return time.Unix(n, 0), true, nil
+	default:
+		return time.Time{}, true, jwt.NewValidationError(key+" claim is not a number", jwt.ValidationErrorClaimsInvalid)
+	}
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,83 @@
+package middlewares_test
+
+import (
+	"testing"
+	"time"
+
+	"backend/internal/middlewares"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestClaimsValidator(t *testing.T) {
+	now := time.Now()
+	machine := jwt.MapClaims{"username": nil, "cognito:username": nil, "client_id": "worker"}
+	idToken := jwt.MapClaims{"token_use": "id", "aud": clientID, "client_id": nil}
+
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		tokenUse []string
+		want     string
+	}{
+		{name: "AccessToken"},
+		{name: "IDToken", claims: idToken},
+		{name: "IDTokenAudienceList", claims: jwt.MapClaims{"token_use": "id", "aud": []string{"other", clientID}, "client_id": nil}},
+		{name: "MachineClient", claims: machine, tokenUse: []string{middlewares.TokenUseClient}},
+		{name: "WrongIssuer", claims: jwt.MapClaims{"iss": "https://other.example.com"}, want: middlewares.ReasonIssuerInvalid},
+		{name: "NoIssuer", claims: jwt.MapClaims{"iss": nil}, want: middlewares.ReasonIssuerInvalid},
+		{name: "WrongAudience", claims: jwt.MapClaims{"token_use": "id", "aud": "other", "client_id": nil}, want: middlewares.ReasonAudienceInvalid},
+		{name: "WrongClientID", claims: jwt.MapClaims{"client_id": "other"}, want: middlewares.ReasonAudienceInvalid},
+		{name: "UnknownMachineClient", claims: jwt.MapClaims{"username": nil, "client_id": "other"}, tokenUse: []string{middlewares.TokenUseClient}, want: middlewares.ReasonAudienceInvalid},
+		{name: "IDTokenOnAccessRoute", claims: idToken, tokenUse: []string{middlewares.TokenUseAccess}, want: middlewares.ReasonTokenUseInvalid},
+		{name: "AccessTokenOnIDRoute", tokenUse: []string{middlewares.TokenUseID}, want: middlewares.ReasonTokenUseInvalid},
+		{name: "MachineClientNotAccepted", claims: machine, tokenUse: []string{middlewares.TokenUseAccess}, want: middlewares.ReasonTokenUseInvalid},
+		{name: "RefreshTokenUse", claims: jwt.MapClaims{"token_use": "refresh"}, want: middlewares.ReasonTokenUseInvalid},
+		{name: "Expired", claims: jwt.MapClaims{"exp": now.Add(-2 * time.Minute).Unix()}, want: middlewares.ReasonTokenExpired},
+		{name: "ExpiredWithinSkew", claims: jwt.MapClaims{"exp": now.Add(-30 * time.Second).Unix()}},
+		{name: "FutureNotBefore", claims: jwt.MapClaims{"nbf": now.Add(2 * time.Minute).Unix()}, want: middlewares.ReasonTokenNotYetValid},
+		{name: "NotBeforeWithinSkew", claims: jwt.MapClaims{"nbf": now.Add(30 * time.Second).Unix()}},
+		{name: "FutureIssuedAt", claims: jwt.MapClaims{"iat": now.Add(2 * time.Minute).Unix()}, want: middlewares.ReasonTokenNotYetValid},
+		{name: "IssuedAtWithinSkew", claims: jwt.MapClaims{"iat": now.Add(30 * time.Second).Unix()}},
+		{name: "NoExpiry", claims: jwt.MapClaims{"exp": nil}, want: middlewares.ReasonClaimsInvalid},
+		{name: "ExpiryNotANumber", claims: jwt.MapClaims{"exp": "tomorrow"}, want: middlewares.ReasonClaimsInvalid},
+		{name: "NotBeforeNotANumber", claims: jwt.MapClaims{"nbf": "now"}, want: middlewares.ReasonClaimsInvalid},
+		{name: "NoSubject", claims: jwt.MapClaims{"sub": nil}, want: middlewares.ReasonSubjectMissing},
+	}
+
+	tokens := newSigner(t)
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the claims go through signing and parsing like the claims of a real token
+			token, err := parser.Parse(tokens.sign(t, tt.claims), func(*jwt.Token) (interface{}, error) {
+				return &tokens.key.PublicKey, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tokenUse := tt.tokenUse
+			if tokenUse == nil {
+				tokenUse = []string{middlewares.TokenUseAccess, middlewares.TokenUseID}
+			}
+			validator := middlewares.ClaimsValidator{
+				Issuer:           issuer,
+				ClientID:         clientID,
+				TokenUse:         tokenUse,
+				MachineClientIDs: []string{"worker"},
+				ClockSkew:        time.Minute,
+				Now:              func() time.Time { return now },
+			}
+
+			got := validator.Validate(token.Claims.(jwt.MapClaims))
+			switch {
+			case tt.want == "" && got != nil:
+				t.Fatalf("Validate() = %v, want nil", got)
+			case tt.want != "" && (got == nil || got.Code != tt.want):
+				t.Fatalf("Validate() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
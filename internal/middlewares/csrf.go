@@ -0,0 +1,36 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"backend/internal/svc"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// CSRF applies the double submit check to requests authenticated by session
+// cookies: unsafe methods have to repeat the CSRF cookie in the CSRF header.
+// Requests with a bearer token and token session mode are not affected.
+func CSRF(s *svc.ServiceContext) echo.MiddlewareFunc {
+	cfg := s.Config.Session
+
+	return middleware.CSRFWithConfig(middleware.CSRFConfig{
+		Skipper: func(c echo.Context) bool {
+			return !s.Sessions.HasSession(c)
+		},
+		TokenLookup:    "header:" + cfg.CSRF_HEADER,
+		CookieName:     cfg.CSRF_COOKIE,
+		CookiePath:     cfg.COOKIE_PATH,
+		CookieDomain:   cfg.COOKIE_DOMAIN,
+		CookieSecure:   cfg.COOKIE_SECURE || s.Sessions.SameSite() == http.SameSiteNoneMode,
+		CookieSameSite: s.Sessions.SameSite(),
+		CookieMaxAge:   int(cfg.REFRESH_MAX_AGE.Seconds()),
+		// a missing token fails the check like a wrong one, echo answers it with a 400
+		ErrorHandler: func(err error, c echo.Context) error {
+			return c.JSON(http.StatusForbidden, echo.Map{
+				"message": "CSRF token is missing or invalid",
+			})
+		},
+	})
+}
@@ -0,0 +1,80 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/internal/auth"
+	"backend/pkg/config"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+// cookieSession is the cookie session mode with the default cookie names
+var cookieSession = config.Session{
+	MODE:            auth.SessionModeCookie,
+	COOKIE_PATH:     "/",
+	COOKIE_SAMESITE: "lax",
+	ID_COOKIE:       "token",
+	ACCESS_COOKIE:   "access_token",
+	REFRESH_COOKIE:  "refresh_token",
+	REFRESH_MAX_AGE: time.Hour,
+	CSRF_COOKIE:     "csrf_token",
+	CSRF_HEADER:     "X-CSRF-Token",
+}
+
+func TestCookieSession(t *testing.T) {
+	const csrf = "csrf-secret"
+
+	tests := []struct {
+		name    string
+		session config.Session
+		method  string
+		// cookies maps cookie names to token_use of the token they carry, or
+		// to the value itself for the CSRF cookie
+		cookies map[string]string
+		bearer  bool
+		header  string
+		want    int
+	}{
+		{name: "AccessCookie", session: cookieSession, method: http.MethodGet, cookies: map[string]string{"access_token": "access"}, want: http.StatusOK},
+		{name: "IDCookie", session: cookieSession, method: http.MethodGet, cookies: map[string]string{"token": "id"}, want: http.StatusOK},
+		{name: "NoCookie", session: cookieSession, method: http.MethodGet, want: http.StatusUnauthorized},
+		{name: "CookiesIgnoredInTokenMode", session: config.Session{MODE: auth.SessionModeToken, ACCESS_COOKIE: "access_token"}, method: http.MethodGet, cookies: map[string]string{"access_token": "access"}, want: http.StatusUnauthorized},
+		{name: "PostWithoutCSRFHeader", session: cookieSession, method: http.MethodPost, cookies: map[string]string{"access_token": "access", "csrf_token": csrf}, want: http.StatusForbidden},
+		{name: "PostWithWrongCSRFHeader", session: cookieSession, method: http.MethodPost, cookies: map[string]string{"access_token": "access", "csrf_token": csrf}, header: "guessed", want: http.StatusForbidden},
+		{name: "PostWithoutCSRFCookie", session: cookieSession, method: http.MethodPost, cookies: map[string]string{"access_token": "access"}, header: csrf, want: http.StatusForbidden},
+		{name: "PostWithCSRFHeader", session: cookieSession, method: http.MethodPost, cookies: map[string]string{"access_token": "access", "csrf_token": csrf}, header: csrf, want: http.StatusOK},
+		{name: "PostWithBearer", session: cookieSession, method: http.MethodPost, bearer: true, want: http.StatusOK},
+		{name: "PostWithBearerAndCookies", session: cookieSession, method: http.MethodPost, cookies: map[string]string{"access_token": "access", "csrf_token": csrf}, bearer: true, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _, tokens := newTokenServer(t, tt.session)
+
+			req := httptest.NewRequest(tt.method, "/", nil)
+			for name, value := range tt.cookies {
+				if name != cookieSession.CSRF_COOKIE {
+					value = tokens.sign(t, jwt.MapClaims{"token_use": value, "aud": clientID})
+				}
+				req.AddCookie(&http.Cookie{Name: name, Value: value})
+			}
+			if tt.bearer {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+tokens.sign(t, nil))
+			}
+			if tt.header != "" {
+				req.Header.Set(cookieSession.CSRF_HEADER, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
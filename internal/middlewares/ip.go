@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// IPExtractor returns how c.RealIP() finds the client IP. Without trusted
+// proxies it is the address of the connection and forwarding headers are
+// ignored, so clients can't pick the IP rate limits and lockouts are keyed on.
+// With them X-Forwarded-For is read up to the first hop outside the ranges.
+func IPExtractor(trustedProxies []string) (echo.IPExtractor, error) {
+	var options []echo.TrustOption
+	for _, cidr := range trustedProxies {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", cidr, err)
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+
+	if len(options) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	// only the configured ranges are trusted, not every private network
+	options = append(options, echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false))
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
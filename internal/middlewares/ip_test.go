@@ -0,0 +1,64 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/internal/middlewares"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestIPExtractor(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted []string
+		remote  string
+		xff     string
+		want    string
+	}{
+		{name: "direct", remote: "203.0.113.7:4711", want: "203.0.113.7"},
+		{name: "spoofed header without proxies", remote: "203.0.113.7:4711", xff: "198.51.100.1", want: "203.0.113.7"},
+		{name: "private peer without proxies", remote: "10.0.0.5:4711", xff: "198.51.100.1", want: "10.0.0.5"},
+		{name: "through a trusted proxy", trusted: []string{"10.0.0.0/8"}, remote: "10.0.0.5:4711", xff: "198.51.100.1", want: "198.51.100.1"},
+		{name: "spoofed hop before a trusted proxy", trusted: []string{"10.0.0.0/8"}, remote: "10.0.0.5:4711", xff: "192.0.2.66, 198.51.100.1", want: "198.51.100.1"},
+		{name: "header from an untrusted peer", trusted: []string{"10.0.0.0/8"}, remote: "203.0.113.7:4711", xff: "198.51.100.1", want: "203.0.113.7"},
+		{name: "private networks are not trusted by default", trusted: []string{"10.0.0.0/8"}, remote: "192.168.1.5:4711", xff: "198.51.100.1", want: "192.168.1.5"},
+		{name: "blank entries are skipped", trusted: []string{" ", ""}, remote: "203.0.113.7:4711", xff: "198.51.100.1", want: "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extract, err := middlewares.IPExtractor(tt.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e := echo.New()
+			e.IPExtractor = extract
+			var got string
+			e.GET("/", func(c echo.Context) error {
+				got = c.RealIP()
+				return c.NoContent(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				req.Header.Set(echo.HeaderXForwardedFor, tt.xff)
+			}
+			e.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Fatalf("RealIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPExtractorInvalidRange(t *testing.T) {
+	if _, err := middlewares.IPExtractor([]string{"10.0.0.0/33"}); err == nil {
+		t.Fatal("invalid CIDR was accepted")
+	}
+}
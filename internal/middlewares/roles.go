@@ -0,0 +1,71 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"backend/internal/auth"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RequireRole only lets principals through that belong to every one of the
+// roles, which are the Cognito groups in the "cognito:groups" claim. It has to
+// run after AuthValidator.
+func RequireRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, ok := auth.PrincipalFrom(c)
+			if !ok {
+				return missingPrincipal(c)
+			}
+
+			var missing []string
+			for _, role := range roles {
+				if !principal.InGroup(role) {
+					missing = append(missing, role)
+				}
+			}
+			if len(missing) > 0 {
+				return forbidden(c, missing)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// RequireAnyRole only lets principals through that belong to at least one of
+// the roles. It has to run after AuthValidator.
+func RequireAnyRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, ok := auth.PrincipalFrom(c)
+			if !ok {
+				return missingPrincipal(c)
+			}
+
+			for _, role := range roles {
+				if principal.InGroup(role) {
+					return next(c)
+				}
+			}
+
+			return forbidden(c, roles)
+		}
+	}
+}
+
+func missingPrincipal(c echo.Context) error {
+	return c.JSON(http.StatusUnauthorized, echo.Map{
+		"message": "Token is required",
+		"code":    ReasonTokenMissing,
+	})
+}
+
+func forbidden(c echo.Context, missing []string) error {
+	return c.JSON(http.StatusForbidden, echo.Map{
+		"message":      "You are not allowed to access this resource",
+		"code":         "role_missing",
+		"missingRoles": missing,
+	})
+}
@@ -0,0 +1,72 @@
+package middlewares_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"backend/internal/auth"
+	"backend/internal/middlewares"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRequireRole(t *testing.T) {
+	editor := &auth.Principal{Groups: []string{"editors"}}
+	admin := &auth.Principal{Groups: []string{"editors", "admin"}}
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		require   echo.MiddlewareFunc
+		want      int
+		code      string
+		missing   []string
+	}{
+		{name: "AllRolesNoPrincipal", require: middlewares.RequireRole("admin"), want: http.StatusUnauthorized, code: middlewares.ReasonTokenMissing},
+		{name: "AllRoles", principal: admin, require: middlewares.RequireRole("admin", "editors"), want: http.StatusOK},
+		{name: "AllRolesOneMissing", principal: editor, require: middlewares.RequireRole("admin", "editors"), want: http.StatusForbidden, code: "role_missing", missing: []string{"admin"}},
+		{name: "AllRolesNoGroups", principal: &auth.Principal{}, require: middlewares.RequireRole("admin", "editors"), want: http.StatusForbidden, code: "role_missing", missing: []string{"admin", "editors"}},
+		{name: "AnyRoleNoPrincipal", require: middlewares.RequireAnyRole("admin"), want: http.StatusUnauthorized, code: middlewares.ReasonTokenMissing},
+		{name: "AnyRole", principal: editor, require: middlewares.RequireAnyRole("admin", "editors"), want: http.StatusOK},
+		{name: "AnyRoleNone", principal: editor, require: middlewares.RequireAnyRole("admin", "owners"), want: http.StatusForbidden, code: "role_missing", missing: []string{"admin", "owners"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+			rec := c.Response().Writer.(*httptest.ResponseRecorder)
+			if tt.principal != nil {
+				auth.SetPrincipal(c, tt.principal)
+			}
+
+			handler := tt.require(func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+			if err := handler(c); err != nil {
+				t.Fatal(err)
+			}
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Code         string   `json:"code"`
+				MissingRoles []string `json:"missingRoles"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.code || !reflect.DeepEqual(body.MissingRoles, tt.missing) {
+				t.Fatalf("body = %s, want code %s and missing roles %v", rec.Body.String(), tt.code, tt.missing)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"backend/internal/auth"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RequireSubscription only lets principals through whose
+// "custom:subscription_status" claim is one of the plans. Cognito only puts
+// custom attributes into ID tokens, so the routes have to accept them, and a
+// plan change shows up once the user's tokens are refreshed. It has to run
+// after AuthValidator.
+func RequireSubscription(plans ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, ok := auth.PrincipalFrom(c)
+			if !ok {
+				return missingPrincipal(c)
+			}
+
+			for _, plan := range plans {
+				if principal.SubscriptionStatus != "" && principal.SubscriptionStatus == plan {
+					return next(c)
+				}
+			}
+
+			return c.JSON(http.StatusForbidden, echo.Map{
+				"message":       "Your plan does not include this feature",
+				"code":          "subscription_required",
+				"requiredPlans": plans,
+			})
+		}
+	}
+}
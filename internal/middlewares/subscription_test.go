@@ -0,0 +1,70 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/internal/auth"
+	"backend/internal/middlewares"
+	"backend/internal/svc"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRequireSubscription(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		plans     []string
+		want      int
+		wantBody  string
+	}{
+		{name: "no principal", plans: []string{"pro"}, want: http.StatusUnauthorized, wantBody: middlewares.ReasonTokenMissing},
+		{name: "on the plan", principal: &auth.Principal{SubscriptionStatus: "pro"}, plans: []string{"pro"}, want: http.StatusOK},
+		{name: "on one of the plans", principal: &auth.Principal{SubscriptionStatus: "enterprise"}, plans: []string{"pro", "enterprise"}, want: http.StatusOK},
+		{name: "on another plan", principal: &auth.Principal{SubscriptionStatus: "free"}, plans: []string{"pro"}, want: http.StatusForbidden, wantBody: "subscription_required"},
+		{name: "without the claim", principal: &auth.Principal{}, plans: []string{"pro"}, want: http.StatusForbidden, wantBody: "subscription_required"},
+		{name: "empty plan is no plan", principal: &auth.Principal{}, plans: []string{""}, want: http.StatusForbidden, wantBody: "subscription_required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+			rec := c.Response().Writer.(*httptest.ResponseRecorder)
+			if tt.principal != nil {
+				auth.SetPrincipal(c, tt.principal)
+			}
+
+			handler := middlewares.RequireSubscription(tt.plans...)(func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+			if err := handler(c); err != nil {
+				t.Fatal(err)
+			}
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Fatalf("body %s doesn't mention %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+// Routes of paid features check the plan after AuthValidator. Cognito only
+// puts custom attributes into ID tokens, so the group has to accept them.
+func ExampleRequireSubscription() {
+	var s *svc.ServiceContext // the service context built in main
+
+	reports := s.Echo.Group("/reports",
+		middlewares.AuthValidator(s, middlewares.TokenUseID),
+		middlewares.RequireSubscription("pro", "enterprise"),
+	)
+	reports.GET("/export", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+}
@@ -0,0 +1,326 @@
+// Package passkey stores WebAuthn credentials and the state of the ceremonies
+// that register and use them. The cryptography lives in pkg/webauthn.
+package passkey
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"backend/internal/types"
+	"backend/pkg/webauthn"
+
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+// Purposes of a ceremony
+const (
+	PurposeRegistration   = "registration"
+	PurposeAuthentication = "authentication"
+)
+
+var (
+	ErrNotFound         = errors.New("passkey does not exist")
+	ErrExists           = errors.New("passkey is already registered")
+	ErrLimitReached     = errors.New("passkey limit reached")
+	ErrCeremonyNotFound = errors.New("passkey ceremony does not exist or has already been used")
+	ErrCeremonyExpired  = errors.New("passkey ceremony has expired")
+	ErrSignCount        = errors.New("passkey signature counter did not increase")
+)
+
+// Passkey is a WebAuthn credential registered by a user
+type Passkey struct {
+	types.Base
+	Subject  string `gorm:"index"`
+	Username string
+	Name     string
+	// CredentialID is the base64url credential ID the authenticator reports
+	CredentialID string `gorm:"uniqueIndex"`
+	// PublicKey is the COSE encoded credential public key
+	PublicKey []byte
+	Algorithm int64
+	SignCount int64
+	// Transports is a comma separated list of hints like usb, nfc or internal
+	Transports     string
+	AAGUID         string
+	BackupEligible bool
+	BackedUp       bool
+	LastUsedAt     *time.Time
+}
+
+func (Passkey) TableName() string {
+	return "passkeys"
+}
+
+// TransportList returns the transports of the passkey
+func (p Passkey) TransportList() []string {
+	if p.Transports == "" {
+		return nil
+	}
+
+	return strings.Split(p.Transports, ",")
+}
+
+// Descriptor identifies the passkey in ceremony options
+func (p Passkey) Descriptor() webauthn.CredentialDescriptor {
+	return webauthn.CredentialDescriptor{
+		Type:       "public-key",
+		ID:         p.CredentialID,
+		Transports: p.TransportList(),
+	}
+}
+
+// Ceremony is a registration or authentication that has been started but not
+// finished. It can only be finished once.
+type Ceremony struct {
+	types.Base
+	Purpose string
+	// Challenge is the base64url challenge handed to the authenticator
+	Challenge string
+	// Subject and Username are set for registrations and for authentications
+	// that were started for a known user
+	Subject   string
+	Username  string
+	ExpiresAt time.Time `gorm:"index"`
+	UsedAt    *time.Time
+}
+
+func (Ceremony) TableName() string {
+	return "passkey_ceremonies"
+}
+
+// ChallengeBytes returns the raw challenge
+func (c Ceremony) ChallengeBytes() ([]byte, error) {
+	return webauthn.Decode(c.Challenge)
+}
+
+// Owner is who a passkey is registered for
+type Owner struct {
+	Subject  string
+	Username string
+}
+
+// Store keeps passkeys and ceremonies in Postgres
+type Store struct {
+	DB *gorm.DB
+	// TTL is how long a ceremony may take
+	TTL time.Duration
+	// MaxPerUser bounds the passkeys of a user, zero means no bound
+	MaxPerUser int
+}
+
+func NewStore(db *gorm.DB, ttl time.Duration, maxPerUser int) *Store {
+	return &Store{DB: db, TTL: ttl, MaxPerUser: maxPerUser}
+}
+
+// Begin starts a ceremony for owner, whose fields may be empty for
+// authentications of a user that is not known yet
+func (s *Store) Begin(ctx context.Context, purpose string, owner Owner) (*Ceremony, []byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	base, err := types.NewBase()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ceremony := &Ceremony{
+		Base:      *base,
+		Purpose:   purpose,
+		Challenge: webauthn.Encode(challenge),
+		Subject:   owner.Subject,
+		Username:  owner.Username,
+		ExpiresAt: base.CreatedAt.Add(s.TTL),
+	}
+
+	if err := s.DB.WithContext(ctx).Create(ceremony).Error; err != nil {
+		return nil, nil, err
+	}
+
+	// ceremonies that can't be finished anymore go as new ones come in
+	if err := s.Purge(ctx, base.CreatedAt); err != nil {
+		return nil, nil, err
+	}
+
+	return ceremony, challenge, nil
+}
+
+// Purge deletes the ceremonies that expired before now, used or not
+func (s *Store) Purge(ctx context.Context, now time.Time) error {
+	return s.DB.WithContext(ctx).Where("expires_at <= ?", now).Delete(&Ceremony{}).Error
+}
+
+// Take marks a ceremony as used and returns it. The conditional update makes
+// sure a response can't be replayed against the same ceremony.
+func (s *Store) Take(ctx context.Context, purpose string, id ulid.ULID) (*Ceremony, error) {
+	var ceremony Ceremony
+	err := s.DB.WithContext(ctx).Where("id = ? AND purpose = ?", id, purpose).First(&ceremony).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCeremonyNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	res := s.DB.WithContext(ctx).Model(&Ceremony{}).
+		Where("id = ? AND used_at IS NULL", ceremony.ID).
+		Update("used_at", now)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrCeremonyNotFound
+	}
+
+	if !now.Before(ceremony.ExpiresAt) {
+		return nil, ErrCeremonyExpired
+	}
+
+	ceremony.UsedAt = &now
+	return &ceremony, nil
+}
+
+// Register stores a credential verified by a registration ceremony
+func (s *Store) Register(ctx context.Context, owner Owner, name string, cred *webauthn.Credential) (*Passkey, error) {
+	base, err := types.NewBase()
+	if err != nil {
+		return nil, err
+	}
+
+	passkey := &Passkey{
+		Base:           *base,
+		Subject:        owner.Subject,
+		Username:       owner.Username,
+		Name:           name,
+		CredentialID:   webauthn.Encode(cred.ID),
+		PublicKey:      cred.PublicKey,
+		Algorithm:      cred.Algorithm,
+		SignCount:      int64(cred.SignCount),
+		Transports:     strings.Join(cred.Transports, ","),
+		AAGUID:         hex.EncodeToString(cred.AAGUID),
+		BackupEligible: cred.BackupEligible,
+		BackedUp:       cred.BackedUp,
+	}
+
+	err = s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing int64
+		if err := tx.Model(&Passkey{}).Where("credential_id = ?", passkey.CredentialID).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return ErrExists
+		}
+
+		if s.MaxPerUser > 0 {
+			var count int64
+			if err := tx.Model(&Passkey{}).Where("subject = ?", owner.Subject).Count(&count).Error; err != nil {
+				return err
+			}
+			if count >= int64(s.MaxPerUser) {
+				return ErrLimitReached
+			}
+		}
+
+		return tx.Create(passkey).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return passkey, nil
+}
+
+// List returns the passkeys of subject newest first
+func (s *Store) List(ctx context.Context, subject string) ([]Passkey, error) {
+	var passkeys []Passkey
+	err := s.DB.WithContext(ctx).Where("subject = ?", subject).Order("created_at DESC").Find(&passkeys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return passkeys, nil
+}
+
+// ByUsername returns the passkeys of username, which authentication
+// ceremonies started for a known user allow
+func (s *Store) ByUsername(ctx context.Context, username string) ([]Passkey, error) {
+	var passkeys []Passkey
+	err := s.DB.WithContext(ctx).Where("username = ?", username).Find(&passkeys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return passkeys, nil
+}
+
+// ByCredentialID returns the passkey an authenticator answered with
+func (s *Store) ByCredentialID(ctx context.Context, credentialID string) (*Passkey, error) {
+	var passkey Passkey
+	err := s.DB.WithContext(ctx).Where("credential_id = ?", credentialID).First(&passkey).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &passkey, nil
+}
+
+// Used records a verified assertion. The update only applies while the stored
+// counter is still below the asserted one, so two concurrent assertions with
+// the same counter can't both succeed.
+func (s *Store) Used(ctx context.Context, passkey *Passkey, assertion *webauthn.Assertion) error {
+	now := time.Now()
+	query := s.DB.WithContext(ctx).Model(&Passkey{}).Where("id = ?", passkey.ID)
+	if assertion.SignCount != 0 {
+		query = query.Where("sign_count < ?", assertion.SignCount)
+	}
+
+	res := query.Updates(map[string]interface{}{
+		"sign_count":   int64(assertion.SignCount),
+		"backed_up":    assertion.BackedUp,
+		"last_used_at": now,
+		"updated_at":   now,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrSignCount
+	}
+
+	passkey.SignCount = int64(assertion.SignCount)
+	passkey.BackedUp = assertion.BackedUp
+	passkey.LastUsedAt = &now
+	return nil
+}
+
+// Delete removes a passkey of subject
+func (s *Store) Delete(ctx context.Context, subject string, id ulid.ULID) (*Passkey, error) {
+	var passkey Passkey
+	err := s.DB.WithContext(ctx).Where("id = ? AND subject = ?", id, subject).First(&passkey).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if err := s.DB.WithContext(ctx).Delete(&passkey).Error; err != nil {
+		return nil, err
+	}
+
+	return &passkey, nil
+}
+
+// DeleteAll removes every passkey of subject, e.g. when the account is deleted
+func (s *Store) DeleteAll(ctx context.Context, subject string) error {
+	return s.DB.WithContext(ctx).Where("subject = ?", subject).Delete(&Passkey{}).Error
+}
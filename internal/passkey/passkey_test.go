@@ -0,0 +1,54 @@
+package passkey_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"backend/internal/passkey"
+	"backend/internal/testdb"
+)
+
+func TestBeginPurgesExpiredCeremonies(t *testing.T) {
+	ctx := context.Background()
+	db := testdb.Open(t, &passkey.Ceremony{})
+
+	expired := passkey.NewStore(db, -time.Second, 0)
+	stale, _, err := expired.Begin(ctx, passkey.PurposeAuthentication, passkey.Owner{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := passkey.NewStore(db, time.Minute, 0)
+	used, _, err := store.Begin(ctx, passkey.PurposeAuthentication, passkey.Owner{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Take(ctx, passkey.PurposeAuthentication, used.ID); err != nil {
+		t.Fatal(err)
+	}
+	pending, _, err := store.Begin(ctx, passkey.PurposeAuthentication, passkey.Owner{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Take(ctx, passkey.PurposeAuthentication, stale.ID); !errors.Is(err, passkey.ErrCeremonyNotFound) {
+		t.Fatalf("expired ceremony: err = %v, want it purged", err)
+	}
+	if _, err := store.Take(ctx, passkey.PurposeAuthentication, pending.ID); err != nil {
+		t.Fatalf("pending ceremony: %v", err)
+	}
+
+	// used ceremonies go too once they expire
+	if err := store.Purge(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	var left int64
+	if err := db.Model(&passkey.Ceremony{}).Count(&left).Error; err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Fatalf("%d ceremonies left after they all expired", left)
+	}
+}
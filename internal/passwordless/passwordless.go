@@ -0,0 +1,282 @@
+// Package passwordless issues the one time codes and links of passwordless
+// sign in. This service is the authority over them: once a code checks out it
+// hands the identity provider a short lived proof as the answer to the custom
+// auth challenge, which the VerifyAuthChallengeResponse trigger of the user
+// pool checks with VerifyProof and the shared secret.
+package passwordless
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/types"
+	"backend/pkg/secrets"
+
+	"gorm.io/gorm"
+)
+
+// Ways the one time secret reaches the user
+const (
+	DeliveryCode = "code"
+	DeliveryLink = "link"
+)
+
+// proofTTL is how long a proof is accepted, it is used right after it is made
+const proofTTL = time.Minute
+
+var (
+	ErrNotFound         = errors.New("no pending sign in code")
+	ErrExpired          = errors.New("sign in code has expired")
+	ErrUsed             = errors.New("sign in code has already been used")
+	ErrMismatch         = errors.New("sign in code is not valid")
+	ErrTooManyAttempts  = errors.New("too many wrong sign in codes")
+	ErrProofInvalid     = errors.New("passwordless proof is not valid")
+	ErrProofExpired     = errors.New("passwordless proof has expired")
+	ErrSecretNotDefined = errors.New("passwordless secret is not configured")
+)
+
+// Challenge is one passwordless sign in attempt
+type Challenge struct {
+	types.Base
+	Username string `gorm:"index"`
+	Delivery string
+	// SecretHash is the SHA-256 of the link token or the HMAC of the code under
+	// the store's Secret
+	SecretHash string `gorm:"index"`
+	ExpiresAt  time.Time
+	Attempts   int
+	UsedAt     *time.Time
+}
+
+func (Challenge) TableName() string {
+	return "passwordless_challenges"
+}
+
+// Store keeps challenges in Postgres
+type Store struct {
+	DB          *gorm.DB
+	TTL         time.Duration
+	MaxAttempts int
+	// Secret signs the proofs handed to the identity provider and keys the
+	// hashes of codes
+	Secret []byte
+}
+
+func NewStore(db *gorm.DB, ttl time.Duration, maxAttempts int, secret string) *Store {
+	return &Store{DB: db, TTL: ttl, MaxAttempts: maxAttempts, Secret: []byte(secret)}
+}
+
+// Enabled reports whether a secret to sign proofs with is configured
+func (s *Store) Enabled() bool {
+	return len(s.Secret) > 0
+}
+
+// Start issues a code or link token for username. Earlier pending challenges of
+// the user stop working, so only the latest code is valid.
+func (s *Store) Start(ctx context.Context, username, delivery string) (*Challenge, string, error) {
+	var secret, hash string
+	var err error
+	if delivery == DeliveryLink {
+		secret, err = secrets.Token()
+		hash = secrets.Hash(secret)
+	} else {
+		secret, err = secrets.Code()
+		hash = secrets.HashCode(s.Secret, secret)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	base, err := types.NewBase()
+	if err != nil {
+		return nil, "", err
+	}
+
+	ch := &Challenge{
+		Base:       *base,
+		Username:   username,
+		Delivery:   delivery,
+		SecretHash: hash,
+		ExpiresAt:  base.CreatedAt.Add(s.TTL),
+	}
+
+	err = s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Challenge{}).
+			Where("username = ? AND used_at IS NULL", username).
+			Update("used_at", base.CreatedAt).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(ch).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return ch, secret, nil
+}
+
+// VerifyCode checks a code typed in by username. Every wrong code counts
+// against the attempt limit of the pending challenge.
+func (s *Store) VerifyCode(ctx context.Context, username, code string) (*Challenge, error) {
+	var ch Challenge
+	err := s.DB.WithContext(ctx).
+		Where("username = ? AND delivery = ? AND used_at IS NULL", username, DeliveryCode).
+		Order("created_at DESC").
+		First(&ch).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if !time.Now().Before(ch.ExpiresAt) {
+		return nil, ErrExpired
+	}
+
+	if s.MaxAttempts > 0 && ch.Attempts >= s.MaxAttempts {
+		return nil, ErrTooManyAttempts
+	}
+
+	if subtle.ConstantTimeCompare([]byte(ch.SecretHash), []byte(secrets.HashCode(s.Secret, code))) != 1 {
+		return nil, s.countAttempt(ctx, &ch)
+	}
+
+	return s.use(ctx, &ch)
+}
+
+// VerifyLink checks the token of a sign in link
+func (s *Store) VerifyLink(ctx context.Context, token string) (*Challenge, error) {
+	var ch Challenge
+	err := s.DB.WithContext(ctx).
+		Where("secret_hash = ? AND delivery = ?", secrets.Hash(token), DeliveryLink).
+		First(&ch).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if ch.UsedAt != nil {
+		return nil, ErrUsed
+	}
+	if !time.Now().Before(ch.ExpiresAt) {
+		return nil, ErrExpired
+	}
+
+	return s.use(ctx, &ch)
+}
+
+// countAttempt records a wrong code and burns the challenge once it reached the
+// limit. Both updates are conditional so concurrent guesses can't take more
+// attempts than allowed, the user has to request a new code after that.
+func (s *Store) countAttempt(ctx context.Context, ch *Challenge) error {
+	count := s.DB.WithContext(ctx).Model(&Challenge{}).Where("id = ? AND used_at IS NULL", ch.ID)
+	if s.MaxAttempts > 0 {
+		count = count.Where("attempts < ?", s.MaxAttempts)
+	}
+	res := count.Update("attempts", gorm.Expr("attempts + 1"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		// another request used the code or its last attempt in the meantime
+		return ErrTooManyAttempts
+	}
+
+	if s.MaxAttempts == 0 {
+		return ErrMismatch
+	}
+	res = s.DB.WithContext(ctx).Model(&Challenge{}).
+		Where("id = ? AND used_at IS NULL AND attempts >= ?", ch.ID, s.MaxAttempts).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return ErrTooManyAttempts
+	}
+
+	return ErrMismatch
+}
+
+// use marks a challenge as used. The conditional update makes sure concurrent
+// requests with the same code can't both succeed.
+func (s *Store) use(ctx context.Context, ch *Challenge) (*Challenge, error) {
+	now := time.Now()
+	res := s.DB.WithContext(ctx).Model(&Challenge{}).
+		Where("id = ? AND used_at IS NULL", ch.ID).
+		Update("used_at", now)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrUsed
+	}
+
+	ch.UsedAt = &now
+	return ch, nil
+}
+
+// Proof is the answer to the custom auth challenge of a verified challenge
+func (s *Store) Proof(ch *Challenge) (string, error) {
+	return s.ProofFor(ch.Username, ch.ID.String())
+}
+
+// ProofFor is the answer to the custom auth challenge of username after some
+// other check this service is the authority over, like a passkey assertion,
+// succeeded. ref identifies what was checked and must not contain dots.
+func (s *Store) ProofFor(username, ref string) (string, error) {
+	if !s.Enabled() {
+		return "", ErrSecretNotDefined
+	}
+
+	exp := time.Now().Add(proofTTL).Unix()
+	payload := fmt.Sprintf("%s.%d", ref, exp)
+	return payload + "." + sign(s.Secret, username, payload), nil
+}
+
+// VerifyProof checks an answer made by Proof for username. It is what the
+// VerifyAuthChallengeResponse trigger of the user pool runs.
+func VerifyProof(secret []byte, username, answer string, now time.Time) error {
+	if len(secret) == 0 {
+		return ErrSecretNotDefined
+	}
+
+	parts := strings.Split(answer, ".")
+	if len(parts) != 3 {
+		return ErrProofInvalid
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(sign(secret, username, payload))) {
+		return ErrProofInvalid
+	}
+
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ErrProofInvalid
+	}
+	if now.Unix() >= exp {
+		return ErrProofExpired
+	}
+
+	return nil
+}
+
+func sign(secret []byte, username, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(username + "|" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
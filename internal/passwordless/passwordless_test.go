@@ -0,0 +1,119 @@
+package passwordless_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/internal/passwordless"
+	"backend/internal/testdb"
+
+	"gorm.io/gorm"
+)
+
+const username = "alice@example.com"
+
+func newStore(t *testing.T, maxAttempts int) *passwordless.Store {
+	t.Helper()
+
+	db := testdb.Open(t, &passwordless.Challenge{})
+	return passwordless.NewStore(db, time.Hour, maxAttempts, "secret")
+}
+
+// wrongCode is never the code of ch, codes are six digits
+const wrongCode = "wrong"
+
+func TestVerifyCodeAttempts(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t, 3)
+
+	_, code, err := s.Start(ctx, username, passwordless.DeliveryCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []error{passwordless.ErrMismatch, passwordless.ErrMismatch, passwordless.ErrTooManyAttempts} {
+		if _, err := s.VerifyCode(ctx, username, wrongCode); !errors.Is(err, want) {
+			t.Fatalf("attempt %d: err = %v, want %v", i, err, want)
+		}
+	}
+
+	// the burnt code is gone, even when it is the right one
+	if _, err := s.VerifyCode(ctx, username, code); !errors.Is(err, passwordless.ErrNotFound) {
+		t.Fatalf("right code after the limit: err = %v, want %v", err, passwordless.ErrNotFound)
+	}
+}
+
+// guesses that all read the challenge before any of them writes must not take
+// more attempts than allowed
+func TestVerifyCodeConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t, 3)
+
+	ch, code, err := s.Start(ctx, username, passwordless.DeliveryCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const guesses = 5
+	var read sync.WaitGroup
+	read.Add(guesses)
+	err = s.DB.Callback().Query().After("gorm:query").Register("test:barrier", func(*gorm.DB) {
+		read.Done()
+		read.Wait()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = s.VerifyCode(ctx, username, wrongCode)
+		}()
+	}
+	wg.Wait()
+	if err := s.DB.Callback().Query().Remove("test:barrier"); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored passwordless.Challenge
+	if err := s.DB.Where("id = ?", ch.ID).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Attempts != 3 || stored.UsedAt == nil {
+		t.Fatalf("attempts = %d, used = %v, want 3 attempts and a burnt code", stored.Attempts, stored.UsedAt != nil)
+	}
+
+	if _, err := s.VerifyCode(ctx, username, code); err == nil {
+		t.Fatal("the right code was accepted after the limit")
+	}
+}
+
+func TestVerifyCode(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t, 3)
+
+	_, code, err := s.Start(ctx, username, passwordless.DeliveryCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.VerifyCode(ctx, username, wrongCode); !errors.Is(err, passwordless.ErrMismatch) {
+		t.Fatalf("err = %v, want %v", err, passwordless.ErrMismatch)
+	}
+	ch, err := s.VerifyCode(ctx, username, code)
+	if err != nil {
+		t.Fatalf("right code: %v", err)
+	}
+	if ch.UsedAt == nil {
+		t.Fatal("the challenge wasn't marked as used")
+	}
+	if _, err := s.VerifyCode(ctx, username, code); !errors.Is(err, passwordless.ErrNotFound) {
+		t.Fatalf("code used twice: err = %v, want %v", err, passwordless.ErrNotFound)
+	}
+}
@@ -0,0 +1,172 @@
+// Package signup decides whether a self sign up may go ahead: the email has
+// to be well formed and from a permitted domain, and the CAPTCHA has to pass.
+package signup
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"net/mail"
+	"sort"
+	"strings"
+
+	"backend/pkg/captcha"
+)
+
+//go:embed disposable_domains.txt
+var disposableList string
+
+// disposableDomains is the bundled list of disposable email services
+var disposableDomains = parseDomains(disposableList)
+
+// Fields the errors of a check refer to, named like the form fields
+const (
+	FieldUsername     = "username"
+	FieldCaptchaToken = "captchaToken"
+)
+
+// FieldErrors maps form fields to what is wrong with them
+type FieldErrors map[string]string
+
+func (f FieldErrors) Error() string {
+	parts := make([]string, 0, len(f))
+	for field, msg := range f {
+		parts = append(parts, field+": "+msg)
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, "; ")
+}
+
+type Options struct {
+	// AllowedDomains restricts sign up to these email domains, empty allows any
+	AllowedDomains []string
+	// DeniedDomains are refused even when they are allowed
+	DeniedDomains []string
+	// BlockDisposable refuses the domains of the bundled disposable list
+	BlockDisposable bool
+	// Captcha verifies the bot check, nil turns it off
+	Captcha captcha.Verifier
+}
+
+// Policy holds the sign up rules
+type Policy struct {
+	allowed         map[string]bool
+	denied          map[string]bool
+	blockDisposable bool
+	captcha         captcha.Verifier
+}
+
+func NewPolicy(opts Options) *Policy {
+	return &Policy{
+		allowed:         parseDomains(strings.Join(opts.AllowedDomains, "\n")),
+		denied:          parseDomains(strings.Join(opts.DeniedDomains, "\n")),
+		blockDisposable: opts.BlockDisposable,
+		captcha:         opts.Captcha,
+	}
+}
+
+// Input is what a sign up is checked on
+type Input struct {
+	Email        string
+	CaptchaToken string
+	RemoteIP     string
+}
+
+// Check runs every rule and returns FieldErrors for the ones that failed.
+// Other errors mean a rule couldn't be checked, e.g. the CAPTCHA service is down.
+func (p *Policy) Check(ctx context.Context, in Input) error {
+	errs := FieldErrors{}
+
+	if msg := p.CheckEmail(in.Email); msg != "" {
+		errs[FieldUsername] = msg
+	}
+
+	if p.captcha != nil {
+		err := p.captcha.Verify(ctx, in.CaptchaToken, in.RemoteIP)
+		switch {
+		case errors.Is(err, captcha.ErrMissing):
+			errs[FieldCaptchaToken] = "Please complete the CAPTCHA"
+		case errors.Is(err, captcha.ErrInvalid):
+			errs[FieldCaptchaToken] = "The CAPTCHA could not be verified, please try again"
+		case err != nil:
+			return err
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// CheckEmail returns why email may not sign up, or an empty string. It is
+// also what the user pool's PreSignUp trigger runs.
+func (p *Policy) CheckEmail(email string) string {
+	domain, ok := Domain(email)
+	if !ok {
+		return "Must be a valid email address"
+	}
+
+	if (len(p.allowed) > 0 && !matches(domain, p.allowed)) || matches(domain, p.denied) {
+		return "Sign up with " + domain + " addresses is not allowed"
+	}
+
+	if p.blockDisposable && matches(domain, disposableDomains) {
+		return "Disposable email addresses are not allowed"
+	}
+
+	return ""
+}
+
+// Domain returns the lower cased domain of a bare email address. Display
+// names, comments and addresses without a dotted domain are rejected.
+func Domain(email string) (string, bool) {
+	if email == "" || len(email) > 254 {
+		return "", false
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return "", false
+	}
+
+	at := strings.LastIndex(email, "@")
+	domain := strings.ToLower(email[at+1:])
+	if at == 0 || !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", false
+	}
+
+	return domain, true
+}
+
+// matches reports whether domain or one of its parent domains is in set
+func matches(domain string, set map[string]bool) bool {
+	for {
+		if set[domain] {
+			return true
+		}
+
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			return false
+		}
+		domain = domain[dot+1:]
+	}
+}
+
+func parseDomains(list string) map[string]bool {
+	domains := map[string]bool{}
+
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains[strings.TrimPrefix(line, "@")] = true
+	}
+
+	return domains
+}
@@ -0,0 +1,133 @@
+package signup_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"backend/internal/signup"
+	"backend/pkg/captcha"
+)
+
+func TestDomain(t *testing.T) {
+	tests := []struct {
+		email  string
+		domain string
+		ok     bool
+	}{
+		{email: "alice@example.com", domain: "example.com", ok: true},
+		{email: "alice@Mail.Example.COM", domain: "mail.example.com", ok: true},
+		{email: "alice+tag@example.com", domain: "example.com", ok: true},
+		{email: ""},
+		{email: "alice"},
+		{email: "@example.com"},
+		{email: "alice@localhost"},
+		{email: "alice@example."},
+		{email: "alice@.example.com"},
+		{email: "Alice <alice@example.com>"},
+		{email: "alice@example.com (work)"},
+		{email: " alice@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			domain, ok := signup.Domain(tt.email)
+			if domain != tt.domain || ok != tt.ok {
+				t.Fatalf("Domain(%q) = %q, %v, want %q, %v", tt.email, domain, ok, tt.domain, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCheckEmail(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  signup.Options
+		email string
+		want  string
+	}{
+		{name: "AnyDomain", email: "alice@example.com"},
+		{name: "Invalid", email: "alice", want: "Must be a valid email address"},
+		{name: "Allowed", opts: signup.Options{AllowedDomains: []string{"example.com"}}, email: "alice@example.com"},
+		{name: "AllowedParent", opts: signup.Options{AllowedDomains: []string{"example.com"}}, email: "alice@eu.example.com"},
+		{name: "AllowedWithAt", opts: signup.Options{AllowedDomains: []string{"@Example.com"}}, email: "alice@example.com"},
+		{name: "NotAllowed", opts: signup.Options{AllowedDomains: []string{"example.com"}}, email: "alice@example.org", want: "Sign up with example.org addresses is not allowed"},
+		{name: "SuffixIsNoParent", opts: signup.Options{AllowedDomains: []string{"example.com"}}, email: "alice@badexample.com", want: "Sign up with badexample.com addresses is not allowed"},
+		{name: "Denied", opts: signup.Options{DeniedDomains: []string{"example.org"}}, email: "alice@example.org", want: "Sign up with example.org addresses is not allowed"},
+		{name: "DeniedParent", opts: signup.Options{DeniedDomains: []string{"example.org"}}, email: "alice@mail.example.org", want: "Sign up with mail.example.org addresses is not allowed"},
+		{name: "DeniedWinsOverAllowed", opts: signup.Options{AllowedDomains: []string{"example.com"}, DeniedDomains: []string{"sales.example.com"}}, email: "bob@sales.example.com", want: "Sign up with sales.example.com addresses is not allowed"},
+		{name: "Disposable", opts: signup.Options{BlockDisposable: true}, email: "alice@10minutemail.com", want: "Disposable email addresses are not allowed"},
+		{name: "DisposableSubdomain", opts: signup.Options{BlockDisposable: true}, email: "alice@x.10minutemail.com", want: "Disposable email addresses are not allowed"},
+		{name: "DisposableNotBlocked", email: "alice@10minutemail.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signup.NewPolicy(tt.opts).CheckEmail(tt.email); got != tt.want {
+				t.Fatalf("CheckEmail(%q) = %q, want %q", tt.email, got, tt.want)
+			}
+		})
+	}
+}
+
+// broken is a CAPTCHA service that can't be reached
+type broken struct{}
+
+func (broken) Verify(context.Context, string, string) error { return errors.New("connection refused") }
+
+func TestCheck(t *testing.T) {
+	fake := captcha.Fake{Token: "pass"}
+
+	tests := []struct {
+		name    string
+		captcha captcha.Verifier
+		in      signup.Input
+		want    signup.FieldErrors
+		failure bool
+	}{
+		{name: "NoCaptcha", in: signup.Input{Email: "alice@example.com"}},
+		{name: "Passed", captcha: fake, in: signup.Input{Email: "alice@example.com", CaptchaToken: "pass"}},
+		{name: "MissingToken", captcha: fake, in: signup.Input{Email: "alice@example.com"},
+			want: signup.FieldErrors{signup.FieldCaptchaToken: "Please complete the CAPTCHA"}},
+		{name: "WrongToken", captcha: fake, in: signup.Input{Email: "alice@example.com", CaptchaToken: "fail"},
+			want: signup.FieldErrors{signup.FieldCaptchaToken: "The CAPTCHA could not be verified, please try again"}},
+		{name: "EveryField", captcha: fake, in: signup.Input{Email: "alice"}, want: signup.FieldErrors{
+			signup.FieldUsername:     "Must be a valid email address",
+			signup.FieldCaptchaToken: "Please complete the CAPTCHA",
+		}},
+		{name: "CaptchaDown", captcha: broken{}, in: signup.Input{Email: "alice@example.com", CaptchaToken: "pass"}, failure: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := signup.NewPolicy(signup.Options{Captcha: tt.captcha}).Check(context.Background(), tt.in)
+
+			var fields signup.FieldErrors
+			switch {
+			case tt.failure:
+				if err == nil || errors.As(err, &fields) {
+					t.Fatalf("Check() = %v, want an error that isn't about the fields", err)
+				}
+			case tt.want == nil:
+				if err != nil {
+					t.Fatalf("Check() = %v, want nil", err)
+				}
+			default:
+				if !errors.As(err, &fields) || !reflect.DeepEqual(fields, tt.want) {
+					t.Fatalf("Check() = %v, want %v", err, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFieldErrorsError(t *testing.T) {
+	err := signup.FieldErrors{"username": "Is a required field", "captchaToken": "Please complete the CAPTCHA"}
+
+	// sorted so logs of the same refusal look the same
+	const want = "captchaToken: Please complete the CAPTCHA; username: Is a required field"
+	if got := err.Error(); got != want {
+		t.Fatalf("Error() = %q, want %q", got, want)
+	}
+}
@@ -1,7 +1,31 @@
 package svc
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"backend/internal/apikey"
+	"backend/internal/audit"
+	"backend/internal/auth"
+	"backend/internal/authz"
+	"backend/internal/billing"
+	"backend/internal/hooks"
+	"backend/internal/invitation"
+	"backend/internal/passkey"
+	"backend/internal/passwordless"
+	"backend/internal/signup"
+	"backend/internal/user"
+	"backend/pkg/captcha"
+	cognito "backend/pkg/cognito"
 	"backend/pkg/config"
+	"backend/pkg/identity"
+	"backend/pkg/jwks"
+	"backend/pkg/mailer"
+	"backend/pkg/oauth"
+	"backend/pkg/trigger"
+	"backend/pkg/webauthn"
 
 	"go.opentelemetry.io/otel/trace"
 
@@ -11,17 +35,213 @@ import (
 )
 
 type ServiceContext struct {
-	Config config.Configuration
-	DB     *gorm.DB
-	Echo   *echo.Echo
-	Tracer *trace.Tracer
+	Config   config.Configuration
+	DB       *gorm.DB
+	Echo     *echo.Echo
+	Tracer   *trace.Tracer
+	JWKS     *jwks.Cache
+	Cognito  cognito.Client
+	Identity identity.Provider
+	Denylist auth.Denylist
+	Authz    *authz.Engine
+	Sessions *auth.SessionCookies
+	Mailer   mailer.Mailer
+	Audit    audit.Recorder
+	// Users mirrors the identity provider's accounts into Postgres
+	Users *user.Repository
+	// Invitations tracks accounts admins created until the invitee takes them over
+	Invitations *invitation.Store
+	// Passwordless issues the one time codes and links of passwordless sign in
+	Passwordless *passwordless.Store
+	// OAuth runs federated sign in through the hosted UI, nil when it isn't configured
+	OAuth *oauth.Client
+	// APIKeys stores the keys machine clients authenticate with
+	APIKeys *apikey.Store
+	// Passkeys stores WebAuthn credentials and their pending ceremonies
+	Passkeys *passkey.Store
+	// WebAuthn verifies passkey registrations and assertions
+	WebAuthn *webauthn.RelyingParty
+	// SignUpPolicy decides which self sign ups may go ahead
+	SignUpPolicy *signup.Policy
+	// SignUpThrottle limits sign ups per client IP
+	SignUpThrottle *auth.Throttle
+	// Triggers are the hooks the user pool's Lambda triggers are dispatched to
+	Triggers *trigger.Hooks
+	// Billing keeps plans and the subscriptions reported by the payment provider
+	Billing *billing.Store
+	// LoginGuard tracks failed sign in and password reset attempts
+	LoginGuard *auth.LoginGuard
+	// ResendThrottle limits how often a confirmation code can be resent per user
+	ResendThrottle *auth.Throttle
+	// PasskeyThrottle limits the passkey sign ins started per client IP
+	PasskeyThrottle *auth.Throttle
 }
 
 func NewServiceContext(c config.Configuration, d *gorm.DB, e *echo.Echo, t *trace.Tracer) *ServiceContext {
+	keys := jwks.New(c.AWS.CognitoJWKSURL(), jwks.Options{
+		RefreshInterval: c.Auth.JWKS.REFRESH_INTERVAL,
+		RefreshCooldown: c.Auth.JWKS.REFRESH_COOLDOWN,
+	})
+
+	var client cognito.Client
+	if c.Auth.PROVIDER != identity.ProviderLocal {
+		cognitoClient, err := cognito.NewCognitoClient()
+		if err != nil {
+			log.Fatal(err)
+		}
+		client = cognitoClient
+	}
+
+	mail := mailer.NewMailer(c)
+	provider, err := newIdentityProvider(c, d, client, keys, mail)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var oauthClient *oauth.Client
+	if c.OAuth.Enabled() {
+		oauthClient = newOAuthClient(c, provider)
+	}
+
+	users := user.NewRepository(d)
+	policy := signup.NewPolicy(signup.Options{
+		AllowedDomains:  c.Auth.SIGNUP.ALLOWED_DOMAINS,
+		DeniedDomains:   c.Auth.SIGNUP.DENIED_DOMAINS,
+		BlockDisposable: c.Auth.SIGNUP.BLOCK_DISPOSABLE,
+		Captcha:         captcha.NewVerifier(c),
+	})
+
 	return &ServiceContext{
-		Config: c,
-		DB:     d,
-		Echo:   e,
-		Tracer: t,
+		Config:      c,
+		DB:          d,
+		Echo:        e,
+		Tracer:      t,
+		JWKS:        keys,
+		Cognito:     client,
+		Identity:    provider,
+		Users:       users,
+		Denylist:    auth.NewMemoryDenylist(),
+		Authz:       authz.NewEngine(authz.DefaultPolicies(c.Auth.ADMIN_GROUP)...),
+		Sessions:    auth.NewSessionCookies(c.Session),
+		Mailer:      mail,
+		Audit:       audit.NewDBRecorder(d),
+		Invitations: invitation.NewStore(d, c.Auth.INVITATION.TTL),
+		OAuth:       oauthClient,
+		Passwordless: passwordless.NewStore(d, c.Auth.PASSWORDLESS.CODE_TTL,
+			c.Auth.PASSWORDLESS.MAX_ATTEMPTS, c.Auth.PASSWORDLESS.SECRET),
+		APIKeys:  apikey.NewStore(d, c.Auth.APIKEY.MAX_PER_USER, c.Auth.APIKEY.TOUCH_INTERVAL),
+		Passkeys: passkey.NewStore(d, c.Auth.PASSKEY.TIMEOUT, c.Auth.PASSKEY.MAX_PER_USER),
+		WebAuthn: webauthn.New(webauthn.Config{
+			RPID:             c.Auth.PASSKEY.RP_ID,
+			RPName:           c.Auth.PASSKEY.RP_NAME,
+			Origins:          c.Auth.PASSKEY.ORIGINS,
+			UserVerification: c.Auth.PASSKEY.USER_VERIFICATION,
+			Timeout:          c.Auth.PASSKEY.TIMEOUT,
+		}),
+		SignUpPolicy:   policy,
+		SignUpThrottle: auth.NewThrottle(0, c.Auth.SIGNUP.IP_LIMIT, c.Auth.SIGNUP.IP_WINDOW),
+		Triggers: hooks.New(hooks.Options{
+			SignUpOpen:   c.Auth.SIGNUP.OPEN,
+			SignUpPolicy: policy,
+			AppName:      c.Auth.TRIGGERS.APP_NAME,
+			ProofSecret:  []byte(c.Auth.PASSWORDLESS.SECRET),
+			Users:        users,
+		}),
+		Billing: billing.NewStore(d, c.Billing.DEFAULT_PLAN),
+		LoginGuard: auth.NewLoginGuard(auth.LoginGuardOptions{
+			MaxFailures:   c.Auth.LOGIN.MAX_FAILURES,
+			IPMaxFailures: c.Auth.LOGIN.IP_MAX_FAILURES,
+			BackoffBase:   c.Auth.LOGIN.BACKOFF_BASE,
+			BackoffMax:    c.Auth.LOGIN.BACKOFF_MAX,
+			Lockout:       c.Auth.LOGIN.LOCKOUT,
+			Window:        c.Auth.LOGIN.WINDOW,
+		}),
+		ResendThrottle: auth.NewThrottle(
+			c.Auth.RESEND.INTERVAL,
+			c.Auth.RESEND.LIMIT,
+			c.Auth.RESEND.WINDOW,
+		),
+		PasskeyThrottle: auth.NewThrottle(0, c.Auth.PASSKEY.IP_LIMIT, c.Auth.PASSKEY.IP_WINDOW),
+	}
+}
+
+func newIdentityProvider(c config.Configuration, d *gorm.DB, client cognito.Client, keys *jwks.Cache, mail mailer.Mailer) (identity.Provider, error) {
+	switch c.Auth.PROVIDER {
+	case identity.ProviderLocal:
+		if c.Auth.LOCAL.SIGNING_KEY == "" {
+			if !c.DevMode {
+				// a throwaway key would sign everyone out on every restart
+				return nil, errors.New("identity: AUTH_LOCAL_SIGNING_KEY is required outside dev mode")
+			}
+			log.Println("identity: AUTH_LOCAL_SIGNING_KEY is empty, tokens are signed with a throwaway key")
+		}
+
+		signer, err := identity.NewSigner(c.Auth.LOCAL.ISSUER, c.Auth.LOCAL.CLIENT_ID, c.Auth.LOCAL.SIGNING_KEY, c.Auth.LOCAL.ACCESS_TOKEN_TTL)
+		if err != nil {
+			return nil, err
+		}
+
+		return identity.NewLocalProvider(d, signer, identity.LocalOptions{
+			RefreshTokenTTL: c.Auth.LOCAL.REFRESH_TOKEN_TTL,
+			CodeTTL:         c.Auth.LOCAL.CODE_TTL,
+			Sender:          mailCodeSender{mailer: mail},
+		}), nil
+	default:
+		return identity.NewCognitoProvider(client, c.AWS.COGNITO.CLIENT_ID, c.AWS.COGNITO.USERPOOL_ID, c.AWS.CognitoIssuer(), keys), nil
+	}
+}
+
+func newOAuthClient(c config.Configuration, provider identity.Provider) *oauth.Client {
+	clientID := c.OAuth.CLIENT_ID
+	if clientID == "" {
+		clientID = c.AWS.COGNITO.CLIENT_ID
+	}
+
+	keyfunc := provider.Keyfunc
+	if c.OAuth.JWKS_URL != "" {
+		keyfunc = jwks.New(c.OAuth.JWKS_URL, jwks.Options{
+			RefreshInterval: c.Auth.JWKS.REFRESH_INTERVAL,
+			RefreshCooldown: c.Auth.JWKS.REFRESH_COOLDOWN,
+		}).Keyfunc
 	}
+
+	issuer := c.OAuth.ISSUER
+	if issuer == "" {
+		issuer = provider.Issuer()
+	}
+
+	return oauth.New(oauth.Config{
+		AuthorizeURL: c.OAuth.AuthorizeURL(),
+		TokenURL:     c.OAuth.TokenURL(),
+		ClientID:     clientID,
+		ClientSecret: c.OAuth.CLIENT_SECRET,
+		RedirectURL:  c.OAuth.REDIRECT_URL,
+		Scopes:       c.OAuth.SCOPES,
+		Issuer:       issuer,
+		Keyfunc:      keyfunc,
+	})
+}
+
+// mailCodeSender delivers the one-time codes of the local provider by email
+type mailCodeSender struct {
+	mailer mailer.Mailer
+}
+
+var codeSubjects = map[string]string{
+	identity.CodePurposeConfirmSignUp: "Confirm your email address",
+	identity.CodePurposeResetPassword: "Reset your password",
+	identity.CodePurposeChangeEmail:   "Confirm your new email address",
+}
+
+func (m mailCodeSender) SendCode(ctx context.Context, email, purpose, code string) error {
+	subject, ok := codeSubjects[purpose]
+	if !ok {
+		subject = "Your verification code"
+	}
+
+	return m.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: subject,
+		Body:    fmt.Sprintf("Your verification code is %s. If you didn't ask for it, you can ignore this email.", code),
+	})
 }
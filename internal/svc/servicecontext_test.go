@@ -0,0 +1,49 @@
+package svc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"backend/pkg/config"
+	"backend/pkg/identity"
+	"backend/pkg/mailer"
+)
+
+type recordingMailer []mailer.Message
+
+func (m *recordingMailer) Send(_ context.Context, msg mailer.Message) error {
+	*m = append(*m, msg)
+	return nil
+}
+
+func TestNewIdentityProviderSigningKey(t *testing.T) {
+	c := config.Configuration{}
+	c.Auth.PROVIDER = identity.ProviderLocal
+
+	if _, err := newIdentityProvider(c, nil, nil, nil, &recordingMailer{}); err == nil {
+		t.Fatal("an empty signing key was accepted outside dev mode")
+	}
+
+	c.DevMode = true
+	if _, err := newIdentityProvider(c, nil, nil, nil, &recordingMailer{}); err != nil {
+		t.Fatalf("dev mode: %v", err)
+	}
+}
+
+func TestMailCodeSender(t *testing.T) {
+	sent := &recordingMailer{}
+	sender := mailCodeSender{mailer: sent}
+
+	if err := sender.SendCode(context.Background(), "alice@example.com", identity.CodePurposeResetPassword, "123456"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(*sent))
+	}
+	msg := (*sent)[0]
+	if msg.To != "alice@example.com" || msg.Subject != "Reset your password" || !strings.Contains(msg.Body, "123456") {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+}
@@ -14,7 +14,8 @@ type Base struct {
 }
 
 func NewBase() (*Base, error) {
-	id, err := ulid.New(ulid.Now(), nil)
+	// without entropy two IDs made in the same millisecond are equal
+	id, err := ulid.New(ulid.Now(), ulid.DefaultEntropy())
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,24 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// rows created in the same millisecond used to get the same ID
+func TestNewBaseUniqueIDs(t *testing.T) {
+	const n = 1000
+	seen := make(map[ulid.ULID]bool, n)
+
+	for i := 0; i < n; i++ {
+		b, err := NewBase()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[b.ID] {
+			t.Fatalf("ID %s was handed out twice after %d calls", b.ID, i)
+		}
+		seen[b.ID] = true
+	}
+}
@@ -0,0 +1,10 @@
+package types
+
+// Profile holds application data about a user that the identity provider does
+// not store. It is keyed by the provider's subject.
+type Profile struct {
+	Base
+	Subject     string `gorm:"uniqueIndex"`
+	DisplayName string
+	Bio         string
+}
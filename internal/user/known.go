@@ -0,0 +1,52 @@
+package user
+
+import (
+	"container/list"
+	"sync"
+)
+
+// knownSubjects is the number of subjects Repository remembers having a row
+const knownSubjects = 10000
+
+// subjectSet is a set of subjects that forgets the least recently used one
+// once it holds more than size
+type subjectSet struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+func newSubjectSet(size int) *subjectSet {
+	return &subjectSet{size: size, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Has reports whether subject is in the set and marks it as used
+func (s *subjectSet) Has(subject string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.items[subject]
+	if ok {
+		s.order.MoveToFront(e)
+	}
+	return ok
+}
+
+// Add puts subject into the set, evicting the least recently used subject when full
+func (s *subjectSet) Add(subject string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.items[subject]; ok {
+		s.order.MoveToFront(e)
+		return
+	}
+
+	s.items[subject] = s.order.PushFront(subject)
+	if s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(string))
+	}
+}
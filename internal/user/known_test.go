@@ -0,0 +1,30 @@
+package user
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSubjectSet(t *testing.T) {
+	s := newSubjectSet(2)
+
+	s.Add("a")
+	s.Add("b")
+	if !s.Has("a") || !s.Has("b") {
+		t.Fatal("lost a subject before the set was full")
+	}
+
+	// a was used last, so b makes room for c
+	s.Has("a")
+	s.Add("c")
+	if !s.Has("a") || s.Has("b") || !s.Has("c") {
+		t.Fatalf("after adding c: a %v, b %v, c %v", s.Has("a"), s.Has("b"), s.Has("c"))
+	}
+
+	for i := 0; i < 100; i++ {
+		s.Add(fmt.Sprint(i))
+	}
+	if len(s.items) != 2 || s.order.Len() != 2 {
+		t.Fatalf("set holds %d subjects, %d in order", len(s.items), s.order.Len())
+	}
+}
@@ -0,0 +1,215 @@
+// Package user mirrors the accounts of the identity provider into Postgres so
+// application data can reference users by foreign key. The identity provider
+// stays the source of truth, rows are refreshed whenever this service learns
+// about a change.
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/internal/types"
+	"backend/pkg/identity"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrNotFound = errors.New("user does not exist")
+
+// User is the local copy of an account, keyed by the provider's subject
+type User struct {
+	types.Base
+	Subject       string `gorm:"uniqueIndex"`
+	Username      string `gorm:"index"`
+	Email         string `gorm:"index"`
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+	Locale        string
+	Picture       string
+	// Status is the provider's account status, empty until an admin view was synced
+	Status  string
+	Enabled bool
+	// SyncedAt is when the row was last refreshed from the provider
+	SyncedAt time.Time
+}
+
+func (User) TableName() string {
+	return "users"
+}
+
+// Deleted reports whether the account was deleted at the provider. The row is
+// kept so references to it stay valid.
+func (u User) Deleted() bool {
+	return !u.DeletedAt.IsZero()
+}
+
+// Repository reads and writes the users table
+type Repository struct {
+	DB *gorm.DB
+
+	// known remembers recently seen subjects that have a row so Ensure doesn't
+	// hit the database on every request
+	known *subjectSet
+}
+
+func NewRepository(db *gorm.DB) *Repository {
+	return &Repository{DB: db, known: newSubjectSet(knownSubjects)}
+}
+
+// Get returns the user with the given subject
+func (r *Repository) Get(ctx context.Context, subject string) (*User, error) {
+	return r.first(ctx, "subject = ?", subject)
+}
+
+// ByUsername returns the user with the given username
+func (r *Repository) ByUsername(ctx context.Context, username string) (*User, error) {
+	return r.first(ctx, "username = ?", username)
+}
+
+// ByEmail returns the user with the given email address
+func (r *Repository) ByEmail(ctx context.Context, email string) (*User, error) {
+	return r.first(ctx, "email = ?", email)
+}
+
+func (r *Repository) first(ctx context.Context, query string, arg string) (*User, error) {
+	var u User
+	err := r.DB.WithContext(ctx).Where(query, arg).First(&u).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// Ensure creates a minimal row for an authenticated user that has none yet,
+// e.g. one who signed up before the table existed. Existing rows are left alone.
+func (r *Repository) Ensure(ctx context.Context, subject, username, email string) error {
+	if subject == "" {
+		return nil
+	}
+	if r.known.Has(subject) {
+		return nil
+	}
+
+	base, err := types.NewBase()
+	if err != nil {
+		return err
+	}
+
+	u := &User{
+		Base:     *base,
+		Subject:  subject,
+		Username: username,
+		Email:    email,
+		Enabled:  true,
+		SyncedAt: base.CreatedAt,
+	}
+	err = r.DB.WithContext(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "subject"}}, DoNothing: true}).
+		Create(u).Error
+	if err != nil {
+		return err
+	}
+
+	r.known.Add(subject)
+	return nil
+}
+
+// SyncUser refreshes the row from the provider's view of the signed in user
+func (r *Repository) SyncUser(ctx context.Context, u *identity.User) (*User, error) {
+	row := fromAttributes(u.Subject, u.Username, u.Email, u.Attributes)
+	row.Enabled = true
+	return r.upsert(ctx, row, "username", "email", "email_verified", "given_name", "family_name", "locale", "picture", "enabled")
+}
+
+// SyncManagedUser refreshes the row from the admin view of an account
+func (r *Repository) SyncManagedUser(ctx context.Context, u *identity.ManagedUser) (*User, error) {
+	row := fromAttributes(u.Subject, u.Username, u.Email, u.Attributes)
+	row.Status = u.Status
+	row.Enabled = u.Enabled
+	return r.upsert(ctx, row, "username", "email", "email_verified", "given_name", "family_name", "locale", "picture", "status", "enabled")
+}
+
+// Refresh loads an account from the provider's admin API and syncs its row
+func (r *Repository) Refresh(ctx context.Context, users identity.UserAdministrator, username string) (*User, error) {
+	managed, err := users.GetManagedUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.SyncManagedUser(ctx, managed)
+}
+
+// RefreshSelf loads the user an access token belongs to and syncs their row
+func (r *Repository) RefreshSelf(ctx context.Context, provider identity.Provider, accessToken string) (*User, error) {
+	u, err := provider.GetUser(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.SyncUser(ctx, u)
+}
+
+// MarkDeleted records that the account was deleted at the provider
+func (r *Repository) MarkDeleted(ctx context.Context, subject string) error {
+	now := time.Now()
+	return r.DB.WithContext(ctx).Model(&User{}).Where("subject = ?", subject).
+		Updates(map[string]interface{}{
+			"deleted_at": now,
+			"enabled":    false,
+			"synced_at":  now,
+			"updated_at": now,
+		}).Error
+}
+
+func (r *Repository) upsert(ctx context.Context, row *User, columns ...string) (*User, error) {
+	if row.Subject == "" {
+		return nil, errors.New("user has no subject")
+	}
+
+	base, err := types.NewBase()
+	if err != nil {
+		return nil, err
+	}
+	row.Base = *base
+	row.SyncedAt = base.CreatedAt
+
+	err = r.DB.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "subject"}},
+			DoUpdates: clause.AssignmentColumns(append(columns, "synced_at", "updated_at")),
+		}).
+		Create(row).Error
+	if err != nil {
+		return nil, err
+	}
+
+	r.known.Add(row.Subject)
+	return r.Get(ctx, row.Subject)
+}
+
+func fromAttributes(subject, username, email string, attributes map[string]string) *User {
+	if subject == "" {
+		subject = attributes["sub"]
+	}
+	if email == "" {
+		email = attributes["email"]
+	}
+
+	return &User{
+		Subject:       subject,
+		Username:      username,
+		Email:         email,
+		EmailVerified: attributes["email_verified"] == "true",
+		GivenName:     attributes["given_name"],
+		FamilyName:    attributes["family_name"],
+		Locale:        attributes["locale"],
+		Picture:       attributes["picture"],
+	}
+}
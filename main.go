@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -8,11 +9,21 @@ import (
 	"strconv"
 	"time"
 
+	"backend/internal/apikey"
+	"backend/internal/audit"
+	"backend/internal/authz"
+	"backend/internal/billing"
 	"backend/internal/handler"
+	"backend/internal/invitation"
 	"backend/internal/middlewares"
+	"backend/internal/passkey"
+	"backend/internal/passwordless"
 	"backend/internal/svc"
+	"backend/internal/types"
+	"backend/internal/user"
 	"backend/pkg/config"
 	"backend/pkg/database"
+	"backend/pkg/identity"
 
 	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 	"go.opentelemetry.io/otel"
@@ -53,9 +64,20 @@ func main() {
 	e := echo.New()
 	cfg := config.InitConfig()
 
+	e.IPExtractor, err = middlewares.IPExtractor(cfg.APP.TRUSTED_PROXIES)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	conn, _ := database.ConnectDB()
 
-	err = conn.AutoMigrate()
+	models := []interface{}{&user.User{}, &types.Profile{}, &authz.PolicyRecord{}, &audit.Event{}, &invitation.Invitation{}, &apikey.APIKey{}, &passwordless.Challenge{}, &passkey.Passkey{}, &passkey.Ceremony{}}
+	models = append(models, billing.Models()...)
+	if cfg.Auth.PROVIDER == identity.ProviderLocal {
+		models = append(models, identity.LocalModels()...)
+	}
+
+	err = conn.AutoMigrate(models...)
 
 	if err != nil {
 		e.Logger.Fatal(err)
@@ -70,13 +92,15 @@ func main() {
 
 	if !cfg.DevMode {
 		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-			AllowOrigins: []string{"go-boilerplate.nedim-akar.cloud"},
-			AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
+			AllowOrigins:     []string{"go-boilerplate.nedim-akar.cloud"},
+			AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, middlewares.HeaderAPIKey, cfg.Session.CSRF_HEADER},
+			ExposeHeaders:    []string{cfg.Session.CSRF_HEADER},
+			AllowCredentials: true,
 		}))
 	} else {
 		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 			AllowOrigins: []string{"*"},
-			AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
+			AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, middlewares.HeaderAPIKey, cfg.Session.CSRF_HEADER},
 		}))
 		e.Use(otelecho.Middleware("go-boilerplate"))
 		e.GET("/swagger/*", echoSwagger.WrapHandler)
@@ -117,7 +141,27 @@ func main() {
 	})
 
 	serviceCtx := svc.NewServiceContext(cfg, database.DB, e, &tracer)
+	if cfg.Auth.PROVIDER != identity.ProviderLocal {
+		if err := serviceCtx.JWKS.Start(context.Background()); err != nil {
+			e.Logger.Warn("jwks: initial key set fetch failed: ", err)
+		}
+		defer serviceCtx.JWKS.Stop()
+	}
+
+	if err := serviceCtx.Authz.Load(context.Background(), conn); err != nil {
+		e.Logger.Fatal(err)
+	}
+
+	plans, err := billing.ParsePlans(cfg.Billing.DEFAULT_PLAN, cfg.Billing.PLANS)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	if err := serviceCtx.Billing.SeedPlans(context.Background(), plans); err != nil {
+		e.Logger.Fatal(err)
+	}
+
 	e.Use(middlewares.Trace(serviceCtx))
+	e.Use(middlewares.CSRF(serviceCtx))
 	handler.RegisterHandlers(serviceCtx)
 
 	s := http.Server{
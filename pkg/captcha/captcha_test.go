@@ -0,0 +1,91 @@
+package captcha_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/pkg/captcha"
+)
+
+// siteverify answers like the Turnstile API and records the form it got
+func siteverify(t *testing.T, status int, body string) (*captcha.Turnstile, *http.Request) {
+	t.Helper()
+
+	got := &http.Request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		*got = *r
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return &captcha.Turnstile{Secret: "secret", URL: server.URL, HTTPClient: server.Client()}, got
+}
+
+func TestTurnstile(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		body   string
+		want   error
+		// failure is an error that is neither ErrMissing nor ErrInvalid
+		failure bool
+	}{
+		{name: "Success", token: "token", status: http.StatusOK, body: `{"success":true}`},
+		{name: "Missing", status: http.StatusOK, body: `{"success":true}`, want: captcha.ErrMissing},
+		{name: "Invalid", token: "token", status: http.StatusOK, body: `{"success":false,"error-codes":["invalid-input-response"]}`, want: captcha.ErrInvalid},
+		{name: "Expired", token: "token", status: http.StatusOK, body: `{"success":false,"error-codes":["timeout-or-duplicate"]}`, want: captcha.ErrInvalid},
+		{name: "WrongSecret", token: "token", status: http.StatusOK, body: `{"success":false,"error-codes":["invalid-input-secret"]}`, failure: true},
+		{name: "ServerError", token: "token", status: http.StatusInternalServerError, body: `oops`, failure: true},
+		{name: "NotJSON", token: "token", status: http.StatusOK, body: `<html>`, failure: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, got := siteverify(t, tt.status, tt.body)
+
+			err := verifier.Verify(context.Background(), tt.token, "203.0.113.7")
+			switch {
+			case tt.failure:
+				if err == nil || errors.Is(err, captcha.ErrMissing) || errors.Is(err, captcha.ErrInvalid) {
+					t.Fatalf("Verify() = %v, want a failure to check the token", err)
+				}
+			case !errors.Is(err, tt.want) || (tt.want == nil && err != nil):
+				t.Fatalf("Verify() = %v, want %v", err, tt.want)
+			}
+
+			if tt.token == "" {
+				if got.Method != "" {
+					t.Fatal("a missing token was sent to siteverify")
+				}
+				return
+			}
+			if got.PostForm.Get("secret") != "secret" || got.PostForm.Get("response") != tt.token || got.PostForm.Get("remoteip") != "203.0.113.7" {
+				t.Fatalf("siteverify got %v", got.PostForm)
+			}
+		})
+	}
+}
+
+func TestFake(t *testing.T) {
+	fake := captcha.Fake{Token: "pass"}
+
+	if err := fake.Verify(context.Background(), "pass", ""); err != nil {
+		t.Fatalf("right token: %v", err)
+	}
+	if err := fake.Verify(context.Background(), "", ""); !errors.Is(err, captcha.ErrMissing) {
+		t.Fatalf("no token: %v", err)
+	}
+	if err := fake.Verify(context.Background(), "fail", ""); !errors.Is(err, captcha.ErrInvalid) {
+		t.Fatalf("wrong token: %v", err)
+	}
+}
@@ -0,0 +1,1212 @@
+// Package cognitotest provides a deterministic in-memory implementation of the
+// Cognito client for exercising handlers without AWS.
+package cognitotest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	cognito "backend/pkg/cognito"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+)
+
+var _ cognito.Client = (*Client)(nil)
+
+// User is an account held by the fake user pool
+type User struct {
+	Sub              string
+	Username         string
+	Password         string
+	Confirmed        bool
+	Attributes       map[string]string
+	ConfirmationCode string
+	ResetCode        string
+	CodesExpired     bool
+
+	MFASecret string
+	// MFACode is the only TOTP code the fake accepts once a secret is associated
+	MFACode    string
+	MFAEnabled bool
+	// ForceChangePassword makes sign in stop at NEW_PASSWORD_REQUIRED
+	ForceChangePassword bool
+
+	// PendingEmail holds a changed address until EmailCode is verified
+	PendingEmail string
+	EmailCode    string
+
+	Groups   []string
+	Disabled bool
+}
+
+type session struct {
+	username  string
+	challenge string
+	// verified marks an MFA_SETUP session whose software token was verified
+	verified bool
+}
+
+// Client is an in-memory user pool. Codes and tokens are derived from a counter
+// so repeated runs produce the same values.
+type Client struct {
+	mu            sync.Mutex
+	seq           int
+	users         map[string]*User
+	accessTokens  map[string]string
+	refreshTokens map[string]string
+	sessions      map[string]session
+	groups        map[string]bool
+	failures      map[string]error
+
+	// MFARequired makes sign in of users without TOTP stop at MFA_SETUP, like a
+	// pool whose MFA setting is required
+	MFARequired bool
+
+	// VerifyCustomChallenge plays the VerifyAuthChallengeResponse trigger of the
+	// CUSTOM_AUTH flow. The flow is rejected like on a pool without triggers
+	// while it is nil. It is called with the client locked.
+	VerifyCustomChallenge func(username, answer string) bool
+}
+
+func New() *Client {
+	return &Client{
+		users:         map[string]*User{},
+		accessTokens:  map[string]string{},
+		refreshTokens: map[string]string{},
+		sessions:      map[string]session{},
+		groups:        map[string]bool{},
+		failures:      map[string]error{},
+	}
+}
+
+// Error builds an error the way the AWS SDK reports a Cognito exception
+func Error(code string) error {
+	return awserr.New(code, code, nil)
+}
+
+// FailNext makes the next call to method return err, e.g.
+// FailNext("SignUp", Error(cognitoidentityprovider.ErrCodeInternalErrorException)).
+func (c *Client) FailNext(method string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures[method] = err
+}
+
+// AddUser seeds the pool with an account
+func (c *Client) AddUser(username, password string, confirmed bool, attributes map[string]string) *User {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.addUser(username, password, confirmed, attributes)
+}
+
+// AddGroup creates a group users can be added to
+func (c *Client) AddGroup(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.groups[name] = true
+}
+
+// User returns a copy of the stored account
+func (c *Client) User(username string) (User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	u, ok := c.users[username]
+	if !ok {
+		return User{}, false
+	}
+
+	return *u, true
+}
+
+// Update applies fn to the stored account, e.g. to enable MFA or force a password change
+func (c *Client) Update(username string, fn func(u *User)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if u, ok := c.users[username]; ok {
+		fn(u)
+	}
+}
+
+// ExpireCodes makes the pending confirmation and reset codes of username expire
+func (c *Client) ExpireCodes(username string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if u, ok := c.users[username]; ok {
+		u.CodesExpired = true
+	}
+}
+
+func (c *Client) SignUp(input *cognitoidentityprovider.SignUpInput) (*cognitoidentityprovider.SignUpOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("SignUp"); err != nil {
+		return nil, err
+	}
+
+	username := aws.StringValue(input.Username)
+	if username == "" || aws.StringValue(input.Password) == "" || aws.StringValue(input.ClientId) == "" {
+		return nil, Error(cognitoidentityprovider.ErrCodeInvalidParameterException)
+	}
+
+	if _, ok := c.users[username]; ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeUsernameExistsException)
+	}
+
+	if !validPassword(aws.StringValue(input.Password)) {
+		return nil, Error(cognitoidentityprovider.ErrCodeInvalidPasswordException)
+	}
+
+	attributes := map[string]string{}
+	for _, attr := range input.UserAttributes {
+		attributes[aws.StringValue(attr.Name)] = aws.StringValue(attr.Value)
+	}
+
+	u := c.addUser(username, aws.StringValue(input.Password), false, attributes)
+	u.ConfirmationCode = c.code()
+
+	return &cognitoidentityprovider.SignUpOutput{
+		UserConfirmed: aws.Bool(false),
+		UserSub:       aws.String(u.Sub),
+	}, nil
+}
+
+func (c *Client) InitateAuth(input *cognitoidentityprovider.InitiateAuthInput) (*cognitoidentityprovider.InitiateAuthOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("InitateAuth"); err != nil {
+		return nil, err
+	}
+
+	params := input.AuthParameters
+	switch aws.StringValue(input.AuthFlow) {
+	case cognitoidentityprovider.AuthFlowTypeUserPasswordAuth:
+		u, ok := c.users[aws.StringValue(params["USERNAME"])]
+		if !ok || u.Password != aws.StringValue(params["PASSWORD"]) || u.Disabled {
+			return nil, Error(cognitoidentityprovider.ErrCodeNotAuthorizedException)
+		}
+		if !u.Confirmed {
+			return nil, Error(cognitoidentityprovider.ErrCodeUserNotConfirmedException)
+		}
+
+		if challenge := c.pendingChallenge(u); challenge != "" {
+			return &cognitoidentityprovider.InitiateAuthOutput{
+				ChallengeName:       aws.String(challenge),
+				Session:             aws.String(c.session(u, challenge)),
+				ChallengeParameters: map[string]*string{"USER_ID_FOR_SRP": aws.String(u.Username)},
+			}, nil
+		}
+
+		return &cognitoidentityprovider.InitiateAuthOutput{AuthenticationResult: c.signedIn(u)}, nil
+
+	case cognitoidentityprovider.AuthFlowTypeCustomAuth:
+		if c.VerifyCustomChallenge == nil {
+			return nil, Error(cognitoidentityprovider.ErrCodeInvalidParameterException)
+		}
+
+		u, ok := c.users[aws.StringValue(params["USERNAME"])]
+		if !ok || u.Disabled {
+			return nil, Error(cognitoidentityprovider.ErrCodeNotAuthorizedException)
+		}
+		if !u.Confirmed {
+			return nil, Error(cognitoidentityprovider.ErrCodeUserNotConfirmedException)
+		}
+
+		return &cognitoidentityprovider.InitiateAuthOutput{
+			ChallengeName: aws.String(cognitoidentityprovider.ChallengeNameTypeCustomChallenge),
+			Session:       aws.String(c.session(u, cognitoidentityprovider.ChallengeNameTypeCustomChallenge)),
+		}, nil
+
+	case cognitoidentityprovider.AuthFlowTypeRefreshToken, cognitoidentityprovider.AuthFlowTypeRefreshTokenAuth:
+		username, ok := c.refreshTokens[aws.StringValue(params["REFRESH_TOKEN"])]
+		if !ok {
+			return nil, Error(cognitoidentityprovider.ErrCodeNotAuthorizedException)
+		}
+
+		u, ok := c.users[username]
+		if !ok || u.Disabled {
+			return nil, Error(cognitoidentityprovider.ErrCodeNotAuthorizedException)
+		}
+
+		return &cognitoidentityprovider.InitiateAuthOutput{AuthenticationResult: c.tokens(u)}, nil
+	}
+
+	return nil, Error(cognitoidentityprovider.ErrCodeInvalidParameterException)
+}
+
+func (c *Client) ConfirmSignUp(input *cognitoidentityprovider.ConfirmSignUpInput) (*cognitoidentityprovider.ConfirmSignUpOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("ConfirmSignUp"); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.users[aws.StringValue(input.Username)]
+	if !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+	}
+
+	if u.CodesExpired {
+		return nil, Error(cognitoidentityprovider.ErrCodeExpiredCodeException)
+	}
+
+	if u.ConfirmationCode == "" || u.ConfirmationCode != aws.StringValue(input.ConfirmationCode) {
+		return nil, Error(cognitoidentityprovider.ErrCodeCodeMismatchException)
+	}
+
+	u.Confirmed = true
+	u.ConfirmationCode = ""
+	return &cognitoidentityprovider.ConfirmSignUpOutput{}, nil
+}
+
+func (c *Client) ForgotPassword(input *cognitoidentityprovider.ForgotPasswordInput) (*cognitoidentityprovider.ForgotPasswordOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("ForgotPassword"); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.users[aws.StringValue(input.Username)]
+	if !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+	}
+
+	u.ResetCode = c.code()
+	u.CodesExpired = false
+	return &cognitoidentityprovider.ForgotPasswordOutput{
+		CodeDeliveryDetails: &cognitoidentityprovider.CodeDeliveryDetailsType{
+			AttributeName:  aws.String("email"),
+			DeliveryMedium: aws.String(cognitoidentityprovider.DeliveryMediumTypeEmail),
+			Destination:    aws.String(u.Attributes["email"]),
+		},
+	}, nil
+}
+
+func (c *Client) GetUser(input *cognitoidentityprovider.GetUserInput) (*cognitoidentityprovider.GetUserOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("GetUser"); err != nil {
+		return nil, err
+	}
+
+	u, err := c.userByAccessToken(aws.StringValue(input.AccessToken))
+	if err != nil {
+		return nil, err
+	}
+
+	return &cognitoidentityprovider.GetUserOutput{
+		Username:       aws.String(u.Username),
+		UserAttributes: attributeList(u),
+	}, nil
+}
+
+func (c *Client) ConfirmForgotPassword(input *cognitoidentityprovider.ConfirmForgotPasswordInput) (*cognitoidentityprovider.ConfirmForgotPasswordOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("ConfirmForgotPassword"); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.users[aws.StringValue(input.Username)]
+	if !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+	}
+
+	if u.CodesExpired {
+		return nil, Error(cognitoidentityprovider.ErrCodeExpiredCodeException)
+	}
+
+	if u.ResetCode == "" || u.ResetCode != aws.StringValue(input.ConfirmationCode) {
+		return nil, Error(cognitoidentityprovider.ErrCodeCodeMismatchException)
+	}
+
+	if !validPassword(aws.StringValue(input.Password)) {
+		return nil, Error(cognitoidentityprovider.ErrCodeInvalidPasswordException)
+	}
+
+	u.Password = aws.StringValue(input.Password)
+	u.ResetCode = ""
+	return &cognitoidentityprovider.ConfirmForgotPasswordOutput{}, nil
+}
+
+func (c *Client) RespondToAuthChallenge(input *cognitoidentityprovider.RespondToAuthChallengeInput) (*cognitoidentityprovider.RespondToAuthChallengeOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("RespondToAuthChallenge"); err != nil {
+		return nil, err
+	}
+
+	sess, ok := c.sessions[aws.StringValue(input.Session)]
+	if !ok || sess.challenge != aws.StringValue(input.ChallengeName) {
+		return nil, Error(cognitoidentityprovider.ErrCodeNotAuthorizedException)
+	}
+
+	u, ok := c.users[sess.username]
+	if !ok || aws.StringValue(input.ChallengeResponses["USERNAME"]) != u.Username {
+		return nil, Error(cognitoidentityprovider.ErrCodeNotAuthorizedException)
+	}
+
+	switch sess.challenge {
+	case cognitoidentityprovider.ChallengeNameTypeSoftwareTokenMfa:
+		if aws.StringValue(input.ChallengeResponses["SOFTWARE_TOKEN_MFA_CODE"]) != u.MFACode {
+			return nil, Error(cognitoidentityprovider.ErrCodeCodeMismatchException)
+		}
+	case cognitoidentityprovider.ChallengeNameTypeNewPasswordRequired:
+		password := aws.StringValue(input.ChallengeResponses["NEW_PASSWORD"])
+		if !validPassword(password) {
+			return nil, Error(cognitoidentityprovider.ErrCodeInvalidPasswordException)
+		}
+		u.Password = password
+		u.ForceChangePassword = false
+	case cognitoidentityprovider.ChallengeNameTypeMfaSetup:
+		if !sess.verified {
+			return nil, Error(cognitoidentityprovider.ErrCodeNotAuthorizedException)
+		}
+		delete(c.sessions, aws.StringValue(input.Session))
+		// the token was just verified, it isn't asked for again
+		return &cognitoidentityprovider.RespondToAuthChallengeOutput{AuthenticationResult: c.signedIn(u)}, nil
+	case cognitoidentityprovider.ChallengeNameTypeCustomChallenge:
+		if c.VerifyCustomChallenge == nil || !c.VerifyCustomChallenge(u.Username, aws.StringValue(input.ChallengeResponses["ANSWER"])) {
+			return nil, Error(cognitoidentityprovider.ErrCodeNotAuthorizedException)
+		}
+		delete(c.sessions, aws.StringValue(input.Session))
+		// the define trigger issues tokens once the custom challenge is passed
+		return &cognitoidentityprovider.RespondToAuthChallengeOutput{AuthenticationResult: c.signedIn(u)}, nil
+	}
+
+	delete(c.sessions, aws.StringValue(input.Session))
+
+	if challenge := c.pendingChallenge(u); challenge != "" && challenge != sess.challenge {
+		return &cognitoidentityprovider.RespondToAuthChallengeOutput{
+			ChallengeName: aws.String(challenge),
+			Session:       aws.String(c.session(u, challenge)),
+		}, nil
+	}
+
+	return &cognitoidentityprovider.RespondToAuthChallengeOutput{AuthenticationResult: c.signedIn(u)}, nil
+}
+
+func (c *Client) AssociateSoftwareToken(input *cognitoidentityprovider.AssociateSoftwareTokenInput) (*cognitoidentityprovider.AssociateSoftwareTokenOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("AssociateSoftwareToken"); err != nil {
+		return nil, err
+	}
+
+	if input.Session != nil {
+		u, err := c.setupSession(aws.StringValue(input.Session))
+		if err != nil {
+			return nil, err
+		}
+
+		u.MFASecret = fmt.Sprintf("SECRET%010d", c.next())
+		u.MFACode = c.code()
+		delete(c.sessions, aws.StringValue(input.Session))
+
+		return &cognitoidentityprovider.AssociateSoftwareTokenOutput{
+			SecretCode: aws.String(u.MFASecret),
+			Session:    aws.String(c.session(u, cognitoidentityprovider.ChallengeNameTypeMfaSetup)),
+		}, nil
+	}
+
+	u, err := c.userByAccessToken(aws.StringValue(input.AccessToken))
+	if err != nil {
+		return nil, err
+	}
+
+	u.MFASecret = fmt.Sprintf("SECRET%010d", c.next())
+	u.MFACode = c.code()
+
+	return &cognitoidentityprovider.AssociateSoftwareTokenOutput{SecretCode: aws.String(u.MFASecret)}, nil
+}
+
+func (c *Client) VerifySoftwareToken(input *cognitoidentityprovider.VerifySoftwareTokenInput) (*cognitoidentityprovider.VerifySoftwareTokenOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("VerifySoftwareToken"); err != nil {
+		return nil, err
+	}
+
+	if input.Session != nil {
+		u, err := c.setupSession(aws.StringValue(input.Session))
+		if err != nil {
+			return nil, err
+		}
+		if u.MFASecret == "" {
+			return nil, Error(cognitoidentityprovider.ErrCodeSoftwareTokenMFANotFoundException)
+		}
+		if aws.StringValue(input.UserCode) != u.MFACode {
+			return nil, Error(cognitoidentityprovider.ErrCodeEnableSoftwareTokenMFAException)
+		}
+
+		u.MFAEnabled = true
+		delete(c.sessions, aws.StringValue(input.Session))
+		next := c.session(u, cognitoidentityprovider.ChallengeNameTypeMfaSetup)
+		sess := c.sessions[next]
+		sess.verified = true
+		c.sessions[next] = sess
+
+		return &cognitoidentityprovider.VerifySoftwareTokenOutput{
+			Status:  aws.String(cognitoidentityprovider.VerifySoftwareTokenResponseTypeSuccess),
+			Session: aws.String(next),
+		}, nil
+	}
+
+	u, err := c.userByAccessToken(aws.StringValue(input.AccessToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if u.MFASecret == "" {
+		return nil, Error(cognitoidentityprovider.ErrCodeSoftwareTokenMFANotFoundException)
+	}
+
+	if aws.StringValue(input.UserCode) != u.MFACode {
+		return nil, Error(cognitoidentityprovider.ErrCodeEnableSoftwareTokenMFAException)
+	}
+
+	return &cognitoidentityprovider.VerifySoftwareTokenOutput{
+		Status: aws.String(cognitoidentityprovider.VerifySoftwareTokenResponseTypeSuccess),
+	}, nil
+}
+
+func (c *Client) SetUserMFAPreference(input *cognitoidentityprovider.SetUserMFAPreferenceInput) (*cognitoidentityprovider.SetUserMFAPreferenceOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("SetUserMFAPreference"); err != nil {
+		return nil, err
+	}
+
+	u, err := c.userByAccessToken(aws.StringValue(input.AccessToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if settings := input.SoftwareTokenMfaSettings; settings != nil {
+		if aws.BoolValue(settings.Enabled) && u.MFASecret == "" {
+			return nil, Error(cognitoidentityprovider.ErrCodeInvalidParameterException)
+		}
+		u.MFAEnabled = aws.BoolValue(settings.Enabled)
+	}
+
+	return &cognitoidentityprovider.SetUserMFAPreferenceOutput{}, nil
+}
+
+func (c *Client) RevokeToken(input *cognitoidentityprovider.RevokeTokenInput) (*cognitoidentityprovider.RevokeTokenOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("RevokeToken"); err != nil {
+		return nil, err
+	}
+
+	token := aws.StringValue(input.Token)
+	if _, ok := c.refreshTokens[token]; !ok {
+		// Cognito answers unknown tokens with success as well
+		return &cognitoidentityprovider.RevokeTokenOutput{}, nil
+	}
+
+	delete(c.refreshTokens, token)
+	return &cognitoidentityprovider.RevokeTokenOutput{}, nil
+}
+
+func (c *Client) GlobalSignOut(input *cognitoidentityprovider.GlobalSignOutInput) (*cognitoidentityprovider.GlobalSignOutOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("GlobalSignOut"); err != nil {
+		return nil, err
+	}
+
+	u, err := c.userByAccessToken(aws.StringValue(input.AccessToken))
+	if err != nil {
+		return nil, err
+	}
+
+	c.signOut(u.Username)
+	return &cognitoidentityprovider.GlobalSignOutOutput{}, nil
+}
+
+func (c *Client) UpdateUserAttributes(input *cognitoidentityprovider.UpdateUserAttributesInput) (*cognitoidentityprovider.UpdateUserAttributesOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("UpdateUserAttributes"); err != nil {
+		return nil, err
+	}
+
+	u, err := c.userByAccessToken(aws.StringValue(input.AccessToken))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attr := range input.UserAttributes {
+		name := aws.StringValue(attr.Name)
+		switch name {
+		case "sub":
+			return nil, Error(cognitoidentityprovider.ErrCodeInvalidParameterException)
+		case "email":
+			// like a pool that keeps the original address until the new one is verified
+			u.PendingEmail = aws.StringValue(attr.Value)
+			u.EmailCode = c.code()
+		default:
+			u.Attributes[name] = aws.StringValue(attr.Value)
+		}
+	}
+
+	return &cognitoidentityprovider.UpdateUserAttributesOutput{}, nil
+}
+
+func (c *Client) ChangePassword(input *cognitoidentityprovider.ChangePasswordInput) (*cognitoidentityprovider.ChangePasswordOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("ChangePassword"); err != nil {
+		return nil, err
+	}
+
+	u, err := c.userByAccessToken(aws.StringValue(input.AccessToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Password != aws.StringValue(input.PreviousPassword) {
+		return nil, Error(cognitoidentityprovider.ErrCodeNotAuthorizedException)
+	}
+
+	if !validPassword(aws.StringValue(input.ProposedPassword)) {
+		return nil, Error(cognitoidentityprovider.ErrCodeInvalidPasswordException)
+	}
+
+	u.Password = aws.StringValue(input.ProposedPassword)
+	return &cognitoidentityprovider.ChangePasswordOutput{}, nil
+}
+
+func (c *Client) VerifyUserAttribute(input *cognitoidentityprovider.VerifyUserAttributeInput) (*cognitoidentityprovider.VerifyUserAttributeOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("VerifyUserAttribute"); err != nil {
+		return nil, err
+	}
+
+	u, err := c.userByAccessToken(aws.StringValue(input.AccessToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if aws.StringValue(input.AttributeName) != "email" || u.PendingEmail == "" {
+		return nil, Error(cognitoidentityprovider.ErrCodeInvalidParameterException)
+	}
+
+	if u.CodesExpired {
+		return nil, Error(cognitoidentityprovider.ErrCodeExpiredCodeException)
+	}
+
+	if u.EmailCode != aws.StringValue(input.Code) {
+		return nil, Error(cognitoidentityprovider.ErrCodeCodeMismatchException)
+	}
+
+	u.Attributes["email"] = u.PendingEmail
+	u.Attributes["email_verified"] = "true"
+	u.PendingEmail = ""
+	u.EmailCode = ""
+	return &cognitoidentityprovider.VerifyUserAttributeOutput{}, nil
+}
+
+func (c *Client) ResendConfirmationCode(input *cognitoidentityprovider.ResendConfirmationCodeInput) (*cognitoidentityprovider.ResendConfirmationCodeOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("ResendConfirmationCode"); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.users[aws.StringValue(input.Username)]
+	if !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+	}
+
+	if u.Confirmed {
+		return nil, awserr.New(cognitoidentityprovider.ErrCodeInvalidParameterException, "User is already confirmed.", nil)
+	}
+
+	u.ConfirmationCode = c.code()
+	u.CodesExpired = false
+	return &cognitoidentityprovider.ResendConfirmationCodeOutput{}, nil
+}
+
+func (c *Client) AdminGetUser(input *cognitoidentityprovider.AdminGetUserInput) (*cognitoidentityprovider.AdminGetUserOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("AdminGetUser"); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.users[aws.StringValue(input.Username)]
+	if !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+	}
+
+	status := cognitoidentityprovider.UserStatusTypeConfirmed
+	switch {
+	case !u.Confirmed:
+		status = cognitoidentityprovider.UserStatusTypeUnconfirmed
+	case u.ForceChangePassword:
+		status = cognitoidentityprovider.UserStatusTypeForceChangePassword
+	}
+
+	return &cognitoidentityprovider.AdminGetUserOutput{
+		Username:       aws.String(u.Username),
+		UserAttributes: attributeList(u),
+		UserStatus:     aws.String(status),
+		Enabled:        aws.Bool(!u.Disabled),
+	}, nil
+}
+
+func (c *Client) AdminAddUserToGroup(input *cognitoidentityprovider.AdminAddUserToGroupInput) (*cognitoidentityprovider.AdminAddUserToGroupOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("AdminAddUserToGroup"); err != nil {
+		return nil, err
+	}
+
+	u, group, err := c.groupMember(input.Username, input.GroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range u.Groups {
+		if g == group {
+			return &cognitoidentityprovider.AdminAddUserToGroupOutput{}, nil
+		}
+	}
+	u.Groups = append(u.Groups, group)
+
+	return &cognitoidentityprovider.AdminAddUserToGroupOutput{}, nil
+}
+
+func (c *Client) AdminRemoveUserFromGroup(input *cognitoidentityprovider.AdminRemoveUserFromGroupInput) (*cognitoidentityprovider.AdminRemoveUserFromGroupOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("AdminRemoveUserFromGroup"); err != nil {
+		return nil, err
+	}
+
+	u, group, err := c.groupMember(input.Username, input.GroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := u.Groups[:0]
+	for _, g := range u.Groups {
+		if g != group {
+			groups = append(groups, g)
+		}
+	}
+	u.Groups = groups
+
+	return &cognitoidentityprovider.AdminRemoveUserFromGroupOutput{}, nil
+}
+
+func (c *Client) AdminListGroupsForUser(input *cognitoidentityprovider.AdminListGroupsForUserInput) (*cognitoidentityprovider.AdminListGroupsForUserOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("AdminListGroupsForUser"); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.users[aws.StringValue(input.Username)]
+	if !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+	}
+
+	out := &cognitoidentityprovider.AdminListGroupsForUserOutput{}
+	for _, g := range u.Groups {
+		out.Groups = append(out.Groups, &cognitoidentityprovider.GroupType{
+			GroupName:  aws.String(g),
+			UserPoolId: input.UserPoolId,
+		})
+	}
+
+	return out, nil
+}
+
+// ListUsers supports filters of the form `name = "value"` and `name ^= "value"`
+// on username, status, cognito:user_status and any attribute. PaginationToken is the offset.
+func (c *Client) ListUsers(input *cognitoidentityprovider.ListUsersInput) (*cognitoidentityprovider.ListUsersOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("ListUsers"); err != nil {
+		return nil, err
+	}
+
+	match, err := parseFilter(aws.StringValue(input.Filter))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(c.users))
+	for name := range c.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var matched []*User
+	for _, name := range names {
+		if match(c.users[name]) {
+			matched = append(matched, c.users[name])
+		}
+	}
+
+	offset := 0
+	if input.PaginationToken != nil {
+		offset, err = strconv.Atoi(*input.PaginationToken)
+		if err != nil || offset < 0 || offset > len(matched) {
+			return nil, Error(cognitoidentityprovider.ErrCodeInvalidParameterException)
+		}
+	}
+
+	limit := int(aws.Int64Value(input.Limit))
+	if limit <= 0 || limit > 60 {
+		limit = 60
+	}
+
+	end := offset + limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	out := &cognitoidentityprovider.ListUsersOutput{}
+	for _, u := range matched[offset:end] {
+		out.Users = append(out.Users, userType(u))
+	}
+	if end < len(matched) {
+		out.PaginationToken = aws.String(strconv.Itoa(end))
+	}
+
+	return out, nil
+}
+
+func (c *Client) AdminDisableUser(input *cognitoidentityprovider.AdminDisableUserInput) (*cognitoidentityprovider.AdminDisableUserOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("AdminDisableUser"); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.users[aws.StringValue(input.Username)]
+	if !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+	}
+
+	u.Disabled = true
+	return &cognitoidentityprovider.AdminDisableUserOutput{}, nil
+}
+
+func (c *Client) AdminEnableUser(input *cognitoidentityprovider.AdminEnableUserInput) (*cognitoidentityprovider.AdminEnableUserOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("AdminEnableUser"); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.users[aws.StringValue(input.Username)]
+	if !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+	}
+
+	u.Disabled = false
+	return &cognitoidentityprovider.AdminEnableUserOutput{}, nil
+}
+
+func (c *Client) AdminResetUserPassword(input *cognitoidentityprovider.AdminResetUserPasswordInput) (*cognitoidentityprovider.AdminResetUserPasswordOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("AdminResetUserPassword"); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.users[aws.StringValue(input.Username)]
+	if !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+	}
+
+	// the old password stops working until the user picks a new one with the code
+	u.Password = ""
+	u.ResetCode = c.code()
+	u.CodesExpired = false
+	return &cognitoidentityprovider.AdminResetUserPasswordOutput{}, nil
+}
+
+func (c *Client) AdminDeleteUser(input *cognitoidentityprovider.AdminDeleteUserInput) (*cognitoidentityprovider.AdminDeleteUserOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("AdminDeleteUser"); err != nil {
+		return nil, err
+	}
+
+	username := aws.StringValue(input.Username)
+	if _, ok := c.users[username]; !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+	}
+
+	c.signOut(username)
+	delete(c.users, username)
+	return &cognitoidentityprovider.AdminDeleteUserOutput{}, nil
+}
+
+func (c *Client) AdminUserGlobalSignOut(input *cognitoidentityprovider.AdminUserGlobalSignOutInput) (*cognitoidentityprovider.AdminUserGlobalSignOutOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("AdminUserGlobalSignOut"); err != nil {
+		return nil, err
+	}
+
+	username := aws.StringValue(input.Username)
+	if _, ok := c.users[username]; !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+	}
+
+	c.signOut(username)
+	return &cognitoidentityprovider.AdminUserGlobalSignOutOutput{}, nil
+}
+
+// AdminCreateUser creates a confirmed account that has to set a new password on
+// first sign in. With MessageAction RESEND it only renews the temporary password.
+func (c *Client) AdminCreateUser(input *cognitoidentityprovider.AdminCreateUserInput) (*cognitoidentityprovider.AdminCreateUserOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("AdminCreateUser"); err != nil {
+		return nil, err
+	}
+
+	username := aws.StringValue(input.Username)
+	password := aws.StringValue(input.TemporaryPassword)
+	if password == "" {
+		password = fmt.Sprintf("Temp-%06d!", c.next())
+	}
+
+	u, exists := c.users[username]
+	if aws.StringValue(input.MessageAction) == cognitoidentityprovider.MessageActionTypeResend {
+		if !exists {
+			return nil, Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+		}
+		if !u.ForceChangePassword {
+			return nil, Error(cognitoidentityprovider.ErrCodeUnsupportedUserStateException)
+		}
+		u.Password = password
+		return &cognitoidentityprovider.AdminCreateUserOutput{User: userType(u)}, nil
+	}
+
+	if exists {
+		return nil, Error(cognitoidentityprovider.ErrCodeUsernameExistsException)
+	}
+
+	attributes := map[string]string{}
+	for _, attr := range input.UserAttributes {
+		attributes[aws.StringValue(attr.Name)] = aws.StringValue(attr.Value)
+	}
+
+	u = c.addUser(username, password, true, attributes)
+	u.ForceChangePassword = true
+
+	return &cognitoidentityprovider.AdminCreateUserOutput{User: userType(u)}, nil
+}
+
+// AdminUpdateUserAttributes sets the attributes right away, an email address
+// set by an admin doesn't wait for verification
+func (c *Client) AdminUpdateUserAttributes(input *cognitoidentityprovider.AdminUpdateUserAttributesInput) (*cognitoidentityprovider.AdminUpdateUserAttributesOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.failure("AdminUpdateUserAttributes"); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.users[aws.StringValue(input.Username)]
+	if !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+	}
+
+	for _, attr := range input.UserAttributes {
+		if aws.StringValue(attr.Name) == "sub" {
+			return nil, Error(cognitoidentityprovider.ErrCodeInvalidParameterException)
+		}
+	}
+	for _, attr := range input.UserAttributes {
+		u.Attributes[aws.StringValue(attr.Name)] = aws.StringValue(attr.Value)
+	}
+
+	return &cognitoidentityprovider.AdminUpdateUserAttributesOutput{}, nil
+}
+
+// failure pops the error registered with FailNext for method
+func (c *Client) failure(method string) error {
+	err, ok := c.failures[method]
+	if !ok {
+		return nil
+	}
+
+	delete(c.failures, method)
+	return err
+}
+
+func (c *Client) addUser(username, password string, confirmed bool, attributes map[string]string) *User {
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+
+	u := &User{
+		Sub:        fmt.Sprintf("00000000-0000-0000-0000-%012d", c.next()),
+		Username:   username,
+		Password:   password,
+		Confirmed:  confirmed,
+		Attributes: attributes,
+	}
+	u.Attributes["sub"] = u.Sub
+	c.users[username] = u
+
+	return u
+}
+
+func (c *Client) groupMember(username, group *string) (*User, string, error) {
+	u, ok := c.users[aws.StringValue(username)]
+	if !ok {
+		return nil, "", Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+	}
+
+	if !c.groups[aws.StringValue(group)] {
+		return nil, "", Error(cognitoidentityprovider.ErrCodeResourceNotFoundException)
+	}
+
+	return u, aws.StringValue(group), nil
+}
+
+func (c *Client) userByAccessToken(token string) (*User, error) {
+	username, ok := c.accessTokens[token]
+	if !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeNotAuthorizedException)
+	}
+
+	u, ok := c.users[username]
+	if !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeUserNotFoundException)
+	}
+
+	return u, nil
+}
+
+// signedIn issues a full token set including a refresh token
+func (c *Client) signedIn(u *User) *cognitoidentityprovider.AuthenticationResultType {
+	result := c.tokens(u)
+	result.RefreshToken = aws.String(fmt.Sprintf("refresh-%d", c.next()))
+	c.refreshTokens[*result.RefreshToken] = u.Username
+
+	return result
+}
+
+// signOut drops every token handed out to username
+func (c *Client) signOut(username string) {
+	for token, owner := range c.accessTokens {
+		if owner == username {
+			delete(c.accessTokens, token)
+		}
+	}
+	for token, owner := range c.refreshTokens {
+		if owner == username {
+			delete(c.refreshTokens, token)
+		}
+	}
+}
+
+// setupSession returns the user of the session of an MFA_SETUP challenge
+func (c *Client) setupSession(id string) (*User, error) {
+	sess, ok := c.sessions[id]
+	if !ok || sess.challenge != cognitoidentityprovider.ChallengeNameTypeMfaSetup {
+		return nil, Error(cognitoidentityprovider.ErrCodeNotAuthorizedException)
+	}
+
+	u, ok := c.users[sess.username]
+	if !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeNotAuthorizedException)
+	}
+
+	return u, nil
+}
+
+func (c *Client) session(u *User, challenge string) string {
+	id := fmt.Sprintf("session-%d", c.next())
+	c.sessions[id] = session{username: u.Username, challenge: challenge}
+
+	return id
+}
+
+func (c *Client) pendingChallenge(u *User) string {
+	switch {
+	case u.ForceChangePassword:
+		return cognitoidentityprovider.ChallengeNameTypeNewPasswordRequired
+	case u.MFAEnabled:
+		return cognitoidentityprovider.ChallengeNameTypeSoftwareTokenMfa
+	case c.MFARequired:
+		return cognitoidentityprovider.ChallengeNameTypeMfaSetup
+	}
+
+	return ""
+}
+
+func (c *Client) tokens(u *User) *cognitoidentityprovider.AuthenticationResultType {
+	n := c.next()
+	access := fmt.Sprintf("access-%d", n)
+	c.accessTokens[access] = u.Username
+
+	return &cognitoidentityprovider.AuthenticationResultType{
+		AccessToken: aws.String(access),
+		IdToken:     aws.String(fmt.Sprintf("id-%d", n)),
+		ExpiresIn:   aws.Int64(3600),
+		TokenType:   aws.String("Bearer"),
+	}
+}
+
+func (c *Client) next() int {
+	c.seq++
+	return c.seq
+}
+
+func (c *Client) code() string {
+	return fmt.Sprintf("%06d", c.next())
+}
+
+func attributeList(u *User) []*cognitoidentityprovider.AttributeType {
+	names := make([]string, 0, len(u.Attributes))
+	for name := range u.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]*cognitoidentityprovider.AttributeType, 0, len(names))
+	for _, name := range names {
+		out = append(out, &cognitoidentityprovider.AttributeType{
+			Name:  aws.String(name),
+			Value: aws.String(u.Attributes[name]),
+		})
+	}
+
+	return out
+}
+
+func userType(u *User) *cognitoidentityprovider.UserType {
+	return &cognitoidentityprovider.UserType{
+		Username:   aws.String(u.Username),
+		Attributes: attributeList(u),
+		Enabled:    aws.Bool(!u.Disabled),
+		UserStatus: aws.String(userStatus(u)),
+	}
+}
+
+func userStatus(u *User) string {
+	switch {
+	case !u.Confirmed:
+		return cognitoidentityprovider.UserStatusTypeUnconfirmed
+	case u.ForceChangePassword:
+		return cognitoidentityprovider.UserStatusTypeForceChangePassword
+	case u.Password == "":
+		return cognitoidentityprovider.UserStatusTypeResetRequired
+	}
+
+	return cognitoidentityprovider.UserStatusTypeConfirmed
+}
+
+// parseFilter understands the subset of the ListUsers filter syntax the fake supports
+func parseFilter(filter string) (func(u *User) bool, error) {
+	if strings.TrimSpace(filter) == "" {
+		return func(*User) bool { return true }, nil
+	}
+
+	op := "="
+	name, value, ok := strings.Cut(filter, "^=")
+	if ok {
+		op = "^="
+	} else if name, value, ok = strings.Cut(filter, "="); !ok {
+		return nil, Error(cognitoidentityprovider.ErrCodeInvalidParameterException)
+	}
+
+	name = strings.TrimSpace(name)
+	value, err := strconv.Unquote(strings.TrimSpace(value))
+	if err != nil {
+		return nil, Error(cognitoidentityprovider.ErrCodeInvalidParameterException)
+	}
+
+	return func(u *User) bool {
+		var actual string
+		switch name {
+		case "username":
+			actual = u.Username
+		case "cognito:user_status":
+			actual = userStatus(u)
+		case "status":
+			actual = "Enabled"
+			if u.Disabled {
+				actual = "Disabled"
+			}
+		default:
+			actual = u.Attributes[name]
+		}
+
+		if op == "^=" {
+			return strings.HasPrefix(actual, value)
+		}
+		return actual == value
+	}, nil
+}
+
+// validPassword mirrors the default user pool password policy
+func validPassword(password string) bool {
+	var upper, lower, digit, special bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			special = true
+		}
+	}
+
+	return len(password) >= 8 && upper && lower && digit && special
+}
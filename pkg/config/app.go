@@ -8,4 +8,8 @@ type App struct {
 	}
 	DEV  string `env:"IS_DEV, default=true"`
 	PORT string `env:"PORT, default=8080"`
+	// TRUSTED_PROXIES are the CIDR ranges of the load balancers in front of
+	// the server. X-Forwarded-For is only read from them, without any the
+	// client IP is the address of the connection.
+	TRUSTED_PROXIES []string `env:"APP_TRUSTED_PROXIES"`
 }
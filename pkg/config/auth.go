@@ -0,0 +1,102 @@
+package config
+
+import "time"
+
+type Auth struct {
+	PROVIDER   string        `env:"AUTH_PROVIDER,default=cognito"`
+	CLOCK_SKEW time.Duration `env:"AUTH_CLOCK_SKEW,default=30s"`
+	MFA_ISSUER string        `env:"AUTH_MFA_ISSUER,default=Go-Boilerplate"`
+	// MAX_TOKEN_LIFETIME bounds how long a sign-out-all has to be remembered
+	MAX_TOKEN_LIFETIME time.Duration `env:"AUTH_MAX_TOKEN_LIFETIME,default=24h"`
+	JWKS               struct {
+		REFRESH_INTERVAL time.Duration `env:"AUTH_JWKS_REFRESH_INTERVAL,default=1h"`
+		REFRESH_COOLDOWN time.Duration `env:"AUTH_JWKS_REFRESH_COOLDOWN,default=1m"`
+	}
+	// MACHINE_CLIENT_IDS are the app clients whose client credentials tokens are
+	// accepted for service to service calls
+	MACHINE_CLIENT_IDS []string `env:"AUTH_MACHINE_CLIENT_IDS"`
+	// MACHINE_USERS_SCOPE is the scope machine clients need to look up users,
+	// Cognito names custom scopes after their resource server
+	MACHINE_USERS_SCOPE string `env:"AUTH_MACHINE_USERS_SCOPE,default=users/read"`
+	// ADMIN_GROUP is the group whose members may use the admin endpoints
+	ADMIN_GROUP string `env:"AUTH_ADMIN_GROUP,default=admin"`
+	// LOGIN configures the brute-force protection of sign in and password reset
+	LOGIN struct {
+		MAX_FAILURES    int           `env:"AUTH_LOGIN_MAX_FAILURES,default=5"`
+		IP_MAX_FAILURES int           `env:"AUTH_LOGIN_IP_MAX_FAILURES,default=50"`
+		BACKOFF_BASE    time.Duration `env:"AUTH_LOGIN_BACKOFF_BASE,default=1s"`
+		BACKOFF_MAX     time.Duration `env:"AUTH_LOGIN_BACKOFF_MAX,default=30s"`
+		LOCKOUT         time.Duration `env:"AUTH_LOGIN_LOCKOUT,default=15m"`
+		WINDOW          time.Duration `env:"AUTH_LOGIN_WINDOW,default=15m"`
+	}
+	// RESEND throttles requests for new confirmation codes per username
+	RESEND struct {
+		INTERVAL time.Duration `env:"AUTH_RESEND_INTERVAL,default=60s"`
+		LIMIT    int           `env:"AUTH_RESEND_LIMIT,default=5"`
+		WINDOW   time.Duration `env:"AUTH_RESEND_WINDOW,default=1h"`
+	}
+	SIGNUP struct {
+		// OPEN allows self sign up; when false accounts are created by invitation only
+		OPEN bool `env:"AUTH_SIGNUP_OPEN,default=true"`
+		// ALLOWED_DOMAINS restricts self sign up to these email domains, empty allows any
+		ALLOWED_DOMAINS []string `env:"AUTH_SIGNUP_ALLOWED_DOMAINS"`
+		// DENIED_DOMAINS are refused even when they are allowed
+		DENIED_DOMAINS []string `env:"AUTH_SIGNUP_DENIED_DOMAINS"`
+		// BLOCK_DISPOSABLE refuses addresses of the bundled disposable email domains
+		BLOCK_DISPOSABLE bool `env:"AUTH_SIGNUP_BLOCK_DISPOSABLE,default=true"`
+		// IP_LIMIT bounds the sign ups per client IP and IP_WINDOW, 0 means no bound
+		IP_LIMIT  int           `env:"AUTH_SIGNUP_IP_LIMIT,default=10"`
+		IP_WINDOW time.Duration `env:"AUTH_SIGNUP_IP_WINDOW,default=1h"`
+	}
+	INVITATION struct {
+		TTL time.Duration `env:"AUTH_INVITATION_TTL,default=168h"`
+		// URL is the page that accepts invitations, the token is appended as ?token=
+		URL string `env:"AUTH_INVITATION_URL,default=http://localhost:3000/invitation"`
+	}
+	PASSWORDLESS struct {
+		// SECRET signs the answers to the custom auth challenge and has to be
+		// shared with the user pool's VerifyAuthChallengeResponse trigger.
+		// Passwordless sign in is disabled while it is empty.
+		SECRET       string        `env:"AUTH_PASSWORDLESS_SECRET"`
+		CODE_TTL     time.Duration `env:"AUTH_PASSWORDLESS_CODE_TTL,default=10m"`
+		MAX_ATTEMPTS int           `env:"AUTH_PASSWORDLESS_MAX_ATTEMPTS,default=5"`
+		// LINK_URL is the page that completes link sign ins, the token is appended as ?token=
+		LINK_URL string `env:"AUTH_PASSWORDLESS_LINK_URL,default=http://localhost:3000/passwordless"`
+	}
+	APIKEY struct {
+		// MAX_PER_USER bounds the active API keys of a user, 0 means no bound
+		MAX_PER_USER int `env:"AUTH_APIKEY_MAX_PER_USER,default=10"`
+		// TOUCH_INTERVAL is how often the last use of a busy key is written
+		TOUCH_INTERVAL time.Duration `env:"AUTH_APIKEY_TOUCH_INTERVAL,default=1m"`
+	}
+	PASSKEY struct {
+		// RP_ID is the domain passkeys are bound to, it can't change once users registered passkeys
+		RP_ID   string `env:"AUTH_PASSKEY_RP_ID,default=localhost"`
+		RP_NAME string `env:"AUTH_PASSKEY_RP_NAME,default=Go-Boilerplate"`
+		// ORIGINS are the web origins ceremonies may run on
+		ORIGINS           []string      `env:"AUTH_PASSKEY_ORIGINS,default=http://localhost:3000"`
+		USER_VERIFICATION string        `env:"AUTH_PASSKEY_USER_VERIFICATION,default=preferred"`
+		TIMEOUT           time.Duration `env:"AUTH_PASSKEY_TIMEOUT,default=5m"`
+		MAX_PER_USER      int           `env:"AUTH_PASSKEY_MAX_PER_USER,default=10"`
+		// IP_LIMIT bounds the passkey sign ins started per client IP and IP_WINDOW, 0 means no bound
+		IP_LIMIT  int           `env:"AUTH_PASSKEY_IP_LIMIT,default=30"`
+		IP_WINDOW time.Duration `env:"AUTH_PASSKEY_IP_WINDOW,default=10m"`
+	}
+	// TRIGGERS configures the receiver of the user pool's Lambda triggers
+	TRIGGERS struct {
+		// SECRET is shared with the forwarding Lambda, the receiver is disabled while it is empty
+		SECRET string `env:"AUTH_TRIGGERS_SECRET"`
+		// TOLERANCE is how old a signed event may be
+		TOLERANCE time.Duration `env:"AUTH_TRIGGERS_TOLERANCE,default=5m"`
+		// APP_NAME is used in the messages Cognito sends
+		APP_NAME string `env:"AUTH_TRIGGERS_APP_NAME,default=Go-Boilerplate"`
+	}
+	LOCAL struct {
+		ISSUER            string        `env:"AUTH_LOCAL_ISSUER,default=http://localhost:8080"`
+		CLIENT_ID         string        `env:"AUTH_LOCAL_CLIENT_ID,default=local"`
+		SIGNING_KEY       string        `env:"AUTH_LOCAL_SIGNING_KEY"`
+		ACCESS_TOKEN_TTL  time.Duration `env:"AUTH_LOCAL_ACCESS_TOKEN_TTL,default=1h"`
+		REFRESH_TOKEN_TTL time.Duration `env:"AUTH_LOCAL_REFRESH_TOKEN_TTL,default=720h"`
+		CODE_TTL          time.Duration `env:"AUTH_LOCAL_CODE_TTL,default=24h"`
+	}
+}
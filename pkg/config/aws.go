@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 )
@@ -21,3 +23,13 @@ func (a *AWS) GetAwsSession() *session.Session {
 		Region: aws.String(a.REGIONS),
 	}))
 }
+
+// CognitoIssuer returns the "iss" claim of tokens issued by the configured user pool
+func (a *AWS) CognitoIssuer() string {
+	return fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", a.REGIONS, a.COGNITO.USERPOOL_ID)
+}
+
+// CognitoJWKSURL returns the address of the user pool's public signing keys
+func (a *AWS) CognitoJWKSURL() string {
+	return a.CognitoIssuer() + "/.well-known/jwks.json"
+}
@@ -0,0 +1,15 @@
+package config
+
+import "time"
+
+// Billing configures the webhooks of the payment provider. The webhook endpoint
+// is off while WEBHOOK_SECRET is empty.
+type Billing struct {
+	// WEBHOOK_SECRET is the endpoint's signing secret, whsec_... with Stripe
+	WEBHOOK_SECRET    string        `env:"BILLING_WEBHOOK_SECRET"`
+	WEBHOOK_TOLERANCE time.Duration `env:"BILLING_WEBHOOK_TOLERANCE,default=5m"`
+	// DEFAULT_PLAN is the plan of users without a paid subscription
+	DEFAULT_PLAN string `env:"BILLING_DEFAULT_PLAN,default=free"`
+	// PLANS seeds the paid plans as code:price_id pairs, e.g. pro:price_123
+	PLANS []string `env:"BILLING_PLANS"`
+}
@@ -0,0 +1,11 @@
+package config
+
+// Captcha configures the bot check of sign up. It is off while DRIVER is empty.
+type Captcha struct {
+	// DRIVER is "turnstile" to verify tokens with Cloudflare Turnstile or a
+	// compatible siteverify API, or "fake" to accept FAKE_TOKEN only
+	DRIVER     string `env:"CAPTCHA_DRIVER"`
+	SECRET     string `env:"CAPTCHA_SECRET"`
+	VERIFY_URL string `env:"CAPTCHA_VERIFY_URL,default=https://challenges.cloudflare.com/turnstile/v0/siteverify"`
+	FAKE_TOKEN string `env:"CAPTCHA_FAKE_TOKEN,default=pass"`
+}
@@ -12,6 +12,12 @@ type Configuration struct {
 	APP     App
 	DB      DB
 	AWS     AWS
+	Auth    Auth
+	Session Session
+	OAuth   OAuth
+	Mail    Mail
+	Captcha Captcha
+	Billing Billing
 	Redis   Redis
 	DevMode bool
 }
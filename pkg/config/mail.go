@@ -0,0 +1,7 @@
+package config
+
+type Mail struct {
+	// DRIVER is "ses" to send through Amazon SES or "log" to print messages
+	DRIVER string `env:"MAIL_DRIVER,default=log"`
+	FROM   string `env:"MAIL_FROM,default=no-reply@go-boilerplate.nedim-akar.cloud"`
+}
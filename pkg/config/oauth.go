@@ -0,0 +1,44 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// OAuth configures federated sign in through the Cognito hosted UI. It is
+// disabled while DOMAIN is empty.
+type OAuth struct {
+	// DOMAIN is the hosted UI domain, e.g. https://example.auth.eu-central-1.amazoncognito.com.
+	// A local mock OIDC server works as well with the paths adjusted.
+	DOMAIN         string `env:"OAUTH_DOMAIN"`
+	AUTHORIZE_PATH string `env:"OAUTH_AUTHORIZE_PATH,default=/oauth2/authorize"`
+	TOKEN_PATH     string `env:"OAUTH_TOKEN_PATH,default=/oauth2/token"`
+	// CLIENT_ID falls back to AWS_COGNITO_CLIENT_ID so the tokens pass AuthValidator
+	CLIENT_ID     string `env:"OAUTH_CLIENT_ID"`
+	CLIENT_SECRET string `env:"OAUTH_CLIENT_SECRET"`
+	// REDIRECT_URL is the callback of this API registered with the app client
+	REDIRECT_URL string   `env:"OAUTH_REDIRECT_URL,default=http://localhost:8080/auth/oauth/callback"`
+	SCOPES       []string `env:"OAUTH_SCOPES,default=openid,email,profile"`
+	// PROVIDERS are the identity providers of the user pool that may be used,
+	// named as in Cognito. COGNITO shows the hosted UI itself.
+	PROVIDERS []string `env:"OAUTH_PROVIDERS,default=Google,SignInWithApple"`
+	// ISSUER overrides the iss ID tokens must carry, the user pool's by default
+	ISSUER string `env:"OAUTH_ISSUER"`
+	// JWKS_URL overrides where ID token keys are fetched from, for mock servers
+	JWKS_URL     string        `env:"OAUTH_JWKS_URL"`
+	STATE_TTL    time.Duration `env:"OAUTH_STATE_TTL,default=10m"`
+	STATE_COOKIE string        `env:"OAUTH_STATE_COOKIE,default=oauth_state"`
+}
+
+// Enabled reports whether federated sign in is configured
+func (o *OAuth) Enabled() bool {
+	return o.DOMAIN != ""
+}
+
+func (o *OAuth) AuthorizeURL() string {
+	return strings.TrimSuffix(o.DOMAIN, "/") + o.AUTHORIZE_PATH
+}
+
+func (o *OAuth) TokenURL() string {
+	return strings.TrimSuffix(o.DOMAIN, "/") + o.TOKEN_PATH
+}
@@ -0,0 +1,19 @@
+package config
+
+import "time"
+
+type Session struct {
+	// MODE is "token" to hand tokens to the client in the response body or
+	// "cookie" to keep them in HttpOnly cookies protected by a CSRF token
+	MODE            string        `env:"SESSION_MODE,default=token"`
+	COOKIE_DOMAIN   string        `env:"SESSION_COOKIE_DOMAIN"`
+	COOKIE_PATH     string        `env:"SESSION_COOKIE_PATH,default=/"`
+	COOKIE_SECURE   bool          `env:"SESSION_COOKIE_SECURE,default=true"`
+	COOKIE_SAMESITE string        `env:"SESSION_COOKIE_SAMESITE,default=lax"`
+	ID_COOKIE       string        `env:"SESSION_ID_COOKIE,default=token"`
+	ACCESS_COOKIE   string        `env:"SESSION_ACCESS_COOKIE,default=access_token"`
+	REFRESH_COOKIE  string        `env:"SESSION_REFRESH_COOKIE,default=refresh_token"`
+	REFRESH_MAX_AGE time.Duration `env:"SESSION_REFRESH_MAX_AGE,default=720h"`
+	CSRF_COOKIE     string        `env:"SESSION_CSRF_COOKIE,default=csrf_token"`
+	CSRF_HEADER     string        `env:"SESSION_CSRF_HEADER,default=X-CSRF-Token"`
+}
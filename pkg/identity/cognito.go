@@ -0,0 +1,701 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	cognito "backend/pkg/cognito"
+	"backend/pkg/jwks"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	_ Provider          = (*CognitoProvider)(nil)
+	_ MFAProvider       = (*CognitoProvider)(nil)
+	_ SessionRevoker    = (*CognitoProvider)(nil)
+	_ EmailChanger      = (*CognitoProvider)(nil)
+	_ GroupManager      = (*CognitoProvider)(nil)
+	_ UserAdministrator = (*CognitoProvider)(nil)
+)
+
+// CognitoProvider implements Provider on top of a Cognito user pool
+type CognitoProvider struct {
+	Client     cognito.Client
+	ClientID   string
+	UserPoolID string
+	issuer     string
+	keys       *jwks.Cache
+}
+
+func NewCognitoProvider(client cognito.Client, clientID, userPoolID, issuer string, keys *jwks.Cache) *CognitoProvider {
+	return &CognitoProvider{
+		Client:     client,
+		ClientID:   clientID,
+		UserPoolID: userPoolID,
+		issuer:     issuer,
+		keys:       keys,
+	}
+}
+
+func (p *CognitoProvider) Issuer() string {
+	return p.issuer
+}
+
+func (p *CognitoProvider) Audience() string {
+	return p.ClientID
+}
+
+func (p *CognitoProvider) Keyfunc(ctx context.Context) jwt.Keyfunc {
+	return p.keys.Keyfunc(ctx)
+}
+
+func (p *CognitoProvider) SignUp(_ context.Context, input SignUpInput) error {
+	_, err := p.Client.SignUp(&cognitoidentityprovider.SignUpInput{
+		ClientId: aws.String(p.ClientID),
+		Username: aws.String(input.Username),
+		Password: aws.String(input.Password),
+		UserAttributes: []*cognitoidentityprovider.AttributeType{
+			{
+				Name:  aws.String("email"),
+				Value: aws.String(input.Email),
+			},
+			{
+				Name:  aws.String("given_name"),
+				Value: aws.String(input.FirstName),
+			},
+			{
+				Name:  aws.String("family_name"),
+				Value: aws.String(input.LastName),
+			},
+			{
+				Name:  aws.String("custom:subscription_status"),
+				Value: aws.String(input.SubscriptionStatus),
+			},
+		},
+	})
+
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) SignIn(_ context.Context, username, password string) (*AuthResult, error) {
+	out, err := p.Client.InitateAuth(&cognitoidentityprovider.InitiateAuthInput{
+		AuthFlow: aws.String(cognitoidentityprovider.AuthFlowTypeUserPasswordAuth),
+		ClientId: aws.String(p.ClientID),
+		AuthParameters: map[string]*string{
+			"USERNAME": aws.String(username),
+			"PASSWORD": aws.String(password),
+		},
+	})
+	if err != nil {
+		return nil, mapCognitoError(err)
+	}
+
+	return authResult(out.AuthenticationResult, out.ChallengeName, out.Session, out.ChallengeParameters), nil
+}
+
+func (p *CognitoProvider) RespondToChallenge(_ context.Context, input ChallengeResponse) (*AuthResult, error) {
+	responses := map[string]*string{
+		"USERNAME": aws.String(input.Username),
+	}
+
+	switch input.Name {
+	case ChallengeSoftwareTokenMFA:
+		responses["SOFTWARE_TOKEN_MFA_CODE"] = aws.String(input.Code)
+	case ChallengeSMSMFA:
+		responses["SMS_MFA_CODE"] = aws.String(input.Code)
+	case ChallengeNewPasswordRequired:
+		responses["NEW_PASSWORD"] = aws.String(input.NewPassword)
+	case ChallengeMFASetup:
+		// the session of a verified software token is the answer
+	default:
+		return nil, &Error{Kind: ErrInvalidParameter, Err: errors.New("unsupported challenge " + input.Name)}
+	}
+
+	out, err := p.Client.RespondToAuthChallenge(&cognitoidentityprovider.RespondToAuthChallengeInput{
+		ClientId:           aws.String(p.ClientID),
+		ChallengeName:      aws.String(input.Name),
+		Session:            aws.String(input.Session),
+		ChallengeResponses: responses,
+	})
+	if err != nil {
+		return nil, mapCognitoError(err)
+	}
+
+	return authResult(out.AuthenticationResult, out.ChallengeName, out.Session, out.ChallengeParameters), nil
+}
+
+// SignInWithCustomChallenge runs the CUSTOM_AUTH flow. The user pool's auth
+// challenge triggers decide whether answer is accepted.
+func (p *CognitoProvider) SignInWithCustomChallenge(_ context.Context, username, answer string) (*AuthResult, error) {
+	out, err := p.Client.InitateAuth(&cognitoidentityprovider.InitiateAuthInput{
+		AuthFlow: aws.String(cognitoidentityprovider.AuthFlowTypeCustomAuth),
+		ClientId: aws.String(p.ClientID),
+		AuthParameters: map[string]*string{
+			"USERNAME": aws.String(username),
+		},
+	})
+	if err != nil {
+		return nil, mapCognitoError(err)
+	}
+
+	if aws.StringValue(out.ChallengeName) != ChallengeCustom {
+		return authResult(out.AuthenticationResult, out.ChallengeName, out.Session, out.ChallengeParameters), nil
+	}
+
+	res, err := p.Client.RespondToAuthChallenge(&cognitoidentityprovider.RespondToAuthChallengeInput{
+		ClientId:      aws.String(p.ClientID),
+		ChallengeName: aws.String(ChallengeCustom),
+		Session:       out.Session,
+		ChallengeResponses: map[string]*string{
+			"USERNAME": aws.String(username),
+			"ANSWER":   aws.String(answer),
+		},
+	})
+	if err != nil {
+		return nil, mapCognitoError(err)
+	}
+
+	if aws.StringValue(res.ChallengeName) == ChallengeCustom {
+		// a wrong answer is met with another custom challenge
+		return nil, &Error{Kind: ErrNotAuthorized, Err: errors.New("custom challenge answer was rejected")}
+	}
+
+	return authResult(res.AuthenticationResult, res.ChallengeName, res.Session, res.ChallengeParameters), nil
+}
+
+func (p *CognitoProvider) AssociateSoftwareToken(_ context.Context, accessToken string) (string, error) {
+	out, err := p.Client.AssociateSoftwareToken(&cognitoidentityprovider.AssociateSoftwareTokenInput{
+		AccessToken: aws.String(accessToken),
+	})
+	if err != nil {
+		return "", mapCognitoError(err)
+	}
+
+	return aws.StringValue(out.SecretCode), nil
+}
+
+func (p *CognitoProvider) VerifySoftwareToken(_ context.Context, accessToken, code, deviceName string) error {
+	input := &cognitoidentityprovider.VerifySoftwareTokenInput{
+		AccessToken: aws.String(accessToken),
+		UserCode:    aws.String(code),
+	}
+	if deviceName != "" {
+		input.FriendlyDeviceName = aws.String(deviceName)
+	}
+
+	out, err := p.Client.VerifySoftwareToken(input)
+	if err != nil {
+		return mapCognitoError(err)
+	}
+
+	if aws.StringValue(out.Status) != cognitoidentityprovider.VerifySoftwareTokenResponseTypeSuccess {
+		return &Error{Kind: ErrCodeMismatch}
+	}
+
+	_, err = p.Client.SetUserMFAPreference(&cognitoidentityprovider.SetUserMFAPreferenceInput{
+		AccessToken: aws.String(accessToken),
+		SoftwareTokenMfaSettings: &cognitoidentityprovider.SoftwareTokenMfaSettingsType{
+			Enabled:      aws.Bool(true),
+			PreferredMfa: aws.Bool(true),
+		},
+	})
+
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) AssociateSoftwareTokenSession(_ context.Context, session string) (string, string, error) {
+	out, err := p.Client.AssociateSoftwareToken(&cognitoidentityprovider.AssociateSoftwareTokenInput{
+		Session: aws.String(session),
+	})
+	if err != nil {
+		return "", "", mapCognitoError(err)
+	}
+
+	return aws.StringValue(out.SecretCode), aws.StringValue(out.Session), nil
+}
+
+// VerifySoftwareTokenSession needs no MFA preference to be set, a pool that
+// asks for MFA_SETUP uses the verified token from then on
+func (p *CognitoProvider) VerifySoftwareTokenSession(_ context.Context, session, code, deviceName string) (string, error) {
+	input := &cognitoidentityprovider.VerifySoftwareTokenInput{
+		Session:  aws.String(session),
+		UserCode: aws.String(code),
+	}
+	if deviceName != "" {
+		input.FriendlyDeviceName = aws.String(deviceName)
+	}
+
+	out, err := p.Client.VerifySoftwareToken(input)
+	if err != nil {
+		return "", mapCognitoError(err)
+	}
+
+	if aws.StringValue(out.Status) != cognitoidentityprovider.VerifySoftwareTokenResponseTypeSuccess {
+		return "", &Error{Kind: ErrCodeMismatch}
+	}
+
+	return aws.StringValue(out.Session), nil
+}
+
+func (p *CognitoProvider) RevokeToken(_ context.Context, refreshToken string) error {
+	_, err := p.Client.RevokeToken(&cognitoidentityprovider.RevokeTokenInput{
+		ClientId: aws.String(p.ClientID),
+		Token:    aws.String(refreshToken),
+	})
+
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) GlobalSignOut(_ context.Context, accessToken string) error {
+	_, err := p.Client.GlobalSignOut(&cognitoidentityprovider.GlobalSignOutInput{
+		AccessToken: aws.String(accessToken),
+	})
+
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) ConfirmSignUp(_ context.Context, username, code string) error {
+	_, err := p.Client.ConfirmSignUp(&cognitoidentityprovider.ConfirmSignUpInput{
+		ClientId:         aws.String(p.ClientID),
+		Username:         aws.String(username),
+		ConfirmationCode: aws.String(code),
+	})
+
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) ResendConfirmationCode(_ context.Context, username string) error {
+	_, err := p.Client.ResendConfirmationCode(&cognitoidentityprovider.ResendConfirmationCodeInput{
+		ClientId: aws.String(p.ClientID),
+		Username: aws.String(username),
+	})
+
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) IsConfirmed(_ context.Context, username string) (bool, error) {
+	out, err := p.Client.AdminGetUser(&cognitoidentityprovider.AdminGetUserInput{
+		UserPoolId: aws.String(p.UserPoolID),
+		Username:   aws.String(username),
+	})
+	if err != nil {
+		return false, mapCognitoError(err)
+	}
+
+	switch aws.StringValue(out.UserStatus) {
+	case cognitoidentityprovider.UserStatusTypeUnconfirmed:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+func (p *CognitoProvider) ForgotPassword(_ context.Context, username string) error {
+	_, err := p.Client.ForgotPassword(&cognitoidentityprovider.ForgotPasswordInput{
+		ClientId: aws.String(p.ClientID),
+		Username: aws.String(username),
+	})
+
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) ConfirmForgotPassword(_ context.Context, username, code, newPassword string) error {
+	_, err := p.Client.ConfirmForgotPassword(&cognitoidentityprovider.ConfirmForgotPasswordInput{
+		ClientId:         aws.String(p.ClientID),
+		Username:         aws.String(username),
+		ConfirmationCode: aws.String(code),
+		Password:         aws.String(newPassword),
+	})
+
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) Refresh(_ context.Context, refreshToken string) (*Tokens, error) {
+	out, err := p.Client.InitateAuth(&cognitoidentityprovider.InitiateAuthInput{
+		AuthFlow: aws.String(cognitoidentityprovider.AuthFlowTypeRefreshToken),
+		ClientId: aws.String(p.ClientID),
+		AuthParameters: map[string]*string{
+			"REFRESH_TOKEN": aws.String(refreshToken),
+		},
+	})
+	if err != nil {
+		return nil, mapCognitoError(err)
+	}
+
+	return tokensFromResult(out.AuthenticationResult), nil
+}
+
+func (p *CognitoProvider) GetUser(_ context.Context, accessToken string) (*User, error) {
+	out, err := p.Client.GetUser(&cognitoidentityprovider.GetUserInput{
+		AccessToken: aws.String(accessToken),
+	})
+	if err != nil {
+		return nil, mapCognitoError(err)
+	}
+
+	user := &User{
+		Username:   aws.StringValue(out.Username),
+		Confirmed:  true,
+		Attributes: make(map[string]string, len(out.UserAttributes)),
+	}
+	for _, attr := range out.UserAttributes {
+		user.Attributes[aws.StringValue(attr.Name)] = aws.StringValue(attr.Value)
+	}
+	user.Subject = user.Attributes["sub"]
+	user.Email = user.Attributes["email"]
+
+	return user, nil
+}
+
+func (p *CognitoProvider) LookupUser(ctx context.Context, username string) (*User, error) {
+	managed, err := p.GetManagedUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return &User{
+		Subject:    managed.Subject,
+		Username:   managed.Username,
+		Email:      managed.Email,
+		Confirmed:  managed.Status != cognitoidentityprovider.UserStatusTypeUnconfirmed,
+		Attributes: managed.Attributes,
+	}, nil
+}
+
+func (p *CognitoProvider) UpdateAttributes(_ context.Context, accessToken string, attributes map[string]string) error {
+	input := &cognitoidentityprovider.UpdateUserAttributesInput{AccessToken: aws.String(accessToken)}
+	for name, value := range attributes {
+		input.UserAttributes = append(input.UserAttributes, &cognitoidentityprovider.AttributeType{
+			Name:  aws.String(name),
+			Value: aws.String(value),
+		})
+	}
+
+	_, err := p.Client.UpdateUserAttributes(input)
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) ChangePassword(_ context.Context, accessToken, previousPassword, proposedPassword string) error {
+	_, err := p.Client.ChangePassword(&cognitoidentityprovider.ChangePasswordInput{
+		AccessToken:      aws.String(accessToken),
+		PreviousPassword: aws.String(previousPassword),
+		ProposedPassword: aws.String(proposedPassword),
+	})
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) VerifyPassword(ctx context.Context, username, password string) error {
+	result, err := p.SignIn(ctx, username, password)
+	if err != nil {
+		return err
+	}
+
+	// a challenge means the password was accepted; tokens are thrown away
+	if result.Tokens != nil && result.Tokens.RefreshToken != "" {
+		_ = p.RevokeToken(ctx, result.Tokens.RefreshToken)
+	}
+
+	return nil
+}
+
+// RequestEmailChange relies on the user pool sending a code to the new address
+// when the email attribute changes. Pools should keep the original address active
+// until the new one is verified so the change can't lock the user out.
+func (p *CognitoProvider) RequestEmailChange(ctx context.Context, accessToken, newEmail string) error {
+	return p.UpdateAttributes(ctx, accessToken, map[string]string{"email": newEmail})
+}
+
+func (p *CognitoProvider) VerifyEmailChange(_ context.Context, accessToken, code string) error {
+	_, err := p.Client.VerifyUserAttribute(&cognitoidentityprovider.VerifyUserAttributeInput{
+		AccessToken:   aws.String(accessToken),
+		AttributeName: aws.String("email"),
+		Code:          aws.String(code),
+	})
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) AddUserToGroup(_ context.Context, username, group string) error {
+	_, err := p.Client.AdminAddUserToGroup(&cognitoidentityprovider.AdminAddUserToGroupInput{
+		UserPoolId: aws.String(p.UserPoolID),
+		Username:   aws.String(username),
+		GroupName:  aws.String(group),
+	})
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) RemoveUserFromGroup(_ context.Context, username, group string) error {
+	_, err := p.Client.AdminRemoveUserFromGroup(&cognitoidentityprovider.AdminRemoveUserFromGroupInput{
+		UserPoolId: aws.String(p.UserPoolID),
+		Username:   aws.String(username),
+		GroupName:  aws.String(group),
+	})
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) ListGroupsForUser(_ context.Context, username string) ([]string, error) {
+	var groups []string
+	input := &cognitoidentityprovider.AdminListGroupsForUserInput{
+		UserPoolId: aws.String(p.UserPoolID),
+		Username:   aws.String(username),
+	}
+
+	for {
+		out, err := p.Client.AdminListGroupsForUser(input)
+		if err != nil {
+			return nil, mapCognitoError(err)
+		}
+
+		for _, group := range out.Groups {
+			groups = append(groups, aws.StringValue(group.GroupName))
+		}
+
+		if out.NextToken == nil {
+			return groups, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+func (p *CognitoProvider) ListUsers(_ context.Context, input ListUsersInput) (*UserPage, error) {
+	req := &cognitoidentityprovider.ListUsersInput{UserPoolId: aws.String(p.UserPoolID)}
+	if input.Attribute != "" {
+		op := "="
+		if input.Prefix {
+			op = "^="
+		}
+		req.Filter = aws.String(input.Attribute + " " + op + " " + strconv.Quote(input.Value))
+	}
+	if input.Limit > 0 {
+		req.Limit = aws.Int64(input.Limit)
+	}
+	if input.PageToken != "" {
+		req.PaginationToken = aws.String(input.PageToken)
+	}
+
+	out, err := p.Client.ListUsers(req)
+	if err != nil {
+		return nil, mapCognitoError(err)
+	}
+
+	page := &UserPage{
+		Users:         make([]ManagedUser, 0, len(out.Users)),
+		NextPageToken: aws.StringValue(out.PaginationToken),
+	}
+	for _, u := range out.Users {
+		page.Users = append(page.Users, managedUser(
+			u.Username, u.Attributes, u.UserStatus, u.Enabled, u.UserCreateDate, u.UserLastModifiedDate,
+		))
+	}
+
+	return page, nil
+}
+
+func (p *CognitoProvider) GetManagedUser(_ context.Context, username string) (*ManagedUser, error) {
+	out, err := p.Client.AdminGetUser(&cognitoidentityprovider.AdminGetUserInput{
+		UserPoolId: aws.String(p.UserPoolID),
+		Username:   aws.String(username),
+	})
+	if err != nil {
+		return nil, mapCognitoError(err)
+	}
+
+	user := managedUser(out.Username, out.UserAttributes, out.UserStatus, out.Enabled, out.UserCreateDate, out.UserLastModifiedDate)
+	return &user, nil
+}
+
+func (p *CognitoProvider) DisableUser(_ context.Context, username string) error {
+	_, err := p.Client.AdminDisableUser(&cognitoidentityprovider.AdminDisableUserInput{
+		UserPoolId: aws.String(p.UserPoolID),
+		Username:   aws.String(username),
+	})
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) EnableUser(_ context.Context, username string) error {
+	_, err := p.Client.AdminEnableUser(&cognitoidentityprovider.AdminEnableUserInput{
+		UserPoolId: aws.String(p.UserPoolID),
+		Username:   aws.String(username),
+	})
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) ResetUserPassword(_ context.Context, username string) error {
+	_, err := p.Client.AdminResetUserPassword(&cognitoidentityprovider.AdminResetUserPasswordInput{
+		UserPoolId: aws.String(p.UserPoolID),
+		Username:   aws.String(username),
+	})
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) DeleteUser(_ context.Context, username string) error {
+	_, err := p.Client.AdminDeleteUser(&cognitoidentityprovider.AdminDeleteUserInput{
+		UserPoolId: aws.String(p.UserPoolID),
+		Username:   aws.String(username),
+	})
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) SignOutUser(_ context.Context, username string) error {
+	_, err := p.Client.AdminUserGlobalSignOut(&cognitoidentityprovider.AdminUserGlobalSignOutInput{
+		UserPoolId: aws.String(p.UserPoolID),
+		Username:   aws.String(username),
+	})
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) AdminUpdateAttributes(_ context.Context, username string, attributes map[string]string) error {
+	input := &cognitoidentityprovider.AdminUpdateUserAttributesInput{
+		UserPoolId: aws.String(p.UserPoolID),
+		Username:   aws.String(username),
+	}
+	for name, value := range attributes {
+		input.UserAttributes = append(input.UserAttributes, &cognitoidentityprovider.AttributeType{
+			Name:  aws.String(name),
+			Value: aws.String(value),
+		})
+	}
+
+	_, err := p.Client.AdminUpdateUserAttributes(input)
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) ResendInvitation(_ context.Context, username string) error {
+	_, err := p.Client.AdminCreateUser(&cognitoidentityprovider.AdminCreateUserInput{
+		UserPoolId:    aws.String(p.UserPoolID),
+		Username:      aws.String(username),
+		MessageAction: aws.String(cognitoidentityprovider.MessageActionTypeResend),
+	})
+	return mapCognitoError(err)
+}
+
+func (p *CognitoProvider) InviteUser(_ context.Context, input InviteInput) (*ManagedUser, error) {
+	attributes := []*cognitoidentityprovider.AttributeType{
+		{Name: aws.String("email"), Value: aws.String(input.Email)},
+		// the invitee proves the address by using what was mailed to it
+		{Name: aws.String("email_verified"), Value: aws.String("true")},
+	}
+	if input.FirstName != "" {
+		attributes = append(attributes, &cognitoidentityprovider.AttributeType{Name: aws.String("given_name"), Value: aws.String(input.FirstName)})
+	}
+	if input.LastName != "" {
+		attributes = append(attributes, &cognitoidentityprovider.AttributeType{Name: aws.String("family_name"), Value: aws.String(input.LastName)})
+	}
+
+	createInput := &cognitoidentityprovider.AdminCreateUserInput{
+		UserPoolId:             aws.String(p.UserPoolID),
+		Username:               aws.String(input.Username),
+		UserAttributes:         attributes,
+		DesiredDeliveryMediums: []*string{aws.String(cognitoidentityprovider.DeliveryMediumTypeEmail)},
+	}
+	if input.TemporaryPassword != "" {
+		createInput.TemporaryPassword = aws.String(input.TemporaryPassword)
+	}
+	if input.SuppressMessage {
+		createInput.MessageAction = aws.String(cognitoidentityprovider.MessageActionTypeSuppress)
+	}
+
+	res, err := p.Client.AdminCreateUser(createInput)
+	if err != nil {
+		return nil, mapCognitoError(err)
+	}
+
+	user := managedUser(res.User.Username, res.User.Attributes, res.User.UserStatus, res.User.Enabled, res.User.UserCreateDate, res.User.UserLastModifiedDate)
+	return &user, nil
+}
+
+func managedUser(username *string, attrs []*cognitoidentityprovider.AttributeType, status *string, enabled *bool, created, modified *time.Time) ManagedUser {
+	user := ManagedUser{
+		Username:   aws.StringValue(username),
+		Status:     aws.StringValue(status),
+		Enabled:    aws.BoolValue(enabled),
+		CreatedAt:  aws.TimeValue(created),
+		UpdatedAt:  aws.TimeValue(modified),
+		Attributes: make(map[string]string, len(attrs)),
+	}
+	for _, attr := range attrs {
+		user.Attributes[aws.StringValue(attr.Name)] = aws.StringValue(attr.Value)
+	}
+	user.Subject = user.Attributes["sub"]
+	user.Email = user.Attributes["email"]
+
+	return user
+}
+
+func authResult(res *cognitoidentityprovider.AuthenticationResultType, challenge, session *string, params map[string]*string) *AuthResult {
+	if res != nil {
+		return &AuthResult{Tokens: tokensFromResult(res)}
+	}
+
+	return &AuthResult{Challenge: &Challenge{
+		Name:       aws.StringValue(challenge),
+		Session:    aws.StringValue(session),
+		Parameters: aws.StringValueMap(params),
+	}}
+}
+
+func tokensFromResult(res *cognitoidentityprovider.AuthenticationResultType) *Tokens {
+	if res == nil {
+		return &Tokens{}
+	}
+
+	return &Tokens{
+		AccessToken:  aws.StringValue(res.AccessToken),
+		IDToken:      aws.StringValue(res.IdToken),
+		RefreshToken: aws.StringValue(res.RefreshToken),
+		ExpiresIn:    aws.Int64Value(res.ExpiresIn),
+	}
+}
+
+// mapCognitoError translates Cognito error codes into the provider neutral errors
+func mapCognitoError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return err
+	}
+
+	var kind error
+	switch aerr.Code() {
+	case cognitoidentityprovider.ErrCodeInvalidParameterException:
+		kind = ErrInvalidParameter
+		if strings.Contains(aerr.Message(), "already confirmed") {
+			kind = ErrAlreadyConfirmed
+		}
+	case cognitoidentityprovider.ErrCodeUsernameExistsException, cognitoidentityprovider.ErrCodeAliasExistsException:
+		kind = ErrUserExists
+	case cognitoidentityprovider.ErrCodeInvalidPasswordException:
+		kind = ErrInvalidPassword
+	case cognitoidentityprovider.ErrCodeUserNotConfirmedException:
+		kind = ErrUserNotConfirmed
+	case cognitoidentityprovider.ErrCodeNotAuthorizedException, cognitoidentityprovider.ErrCodeUnauthorizedException:
+		kind = ErrNotAuthorized
+	case cognitoidentityprovider.ErrCodeUserNotFoundException:
+		kind = ErrUserNotFound
+	case cognitoidentityprovider.ErrCodeResourceNotFoundException:
+		kind = ErrNotFound
+	case cognitoidentityprovider.ErrCodeUnsupportedUserStateException:
+		kind = ErrInvalidState
+	case cognitoidentityprovider.ErrCodeCodeMismatchException, cognitoidentityprovider.ErrCodeEnableSoftwareTokenMFAException:
+		kind = ErrCodeMismatch
+	case cognitoidentityprovider.ErrCodeExpiredCodeException:
+		kind = ErrExpiredCode
+	case cognitoidentityprovider.ErrCodeLimitExceededException, cognitoidentityprovider.ErrCodeTooManyRequestsException:
+		kind = ErrLimitExceeded
+	case cognitoidentityprovider.ErrCodeUserLambdaValidationException:
+		kind = ErrRejected
+	default:
+		return err
+	}
+
+	return &Error{Kind: kind, Err: err}
+}
@@ -0,0 +1,270 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/lestrrat/go-jwx/jwk"
+)
+
+// Provider names accepted by the AUTH_PROVIDER setting
+const (
+	ProviderCognito = "cognito"
+	ProviderLocal   = "local"
+)
+
+// Provider is the set of account operations the auth handlers rely on. It is
+// implemented on top of Cognito and by a self-hosted Postgres provider.
+type Provider interface {
+	TokenVerifier
+
+	SignUp(ctx context.Context, input SignUpInput) error
+	SignIn(ctx context.Context, username, password string) (*AuthResult, error)
+	RespondToChallenge(ctx context.Context, input ChallengeResponse) (*AuthResult, error)
+	ConfirmSignUp(ctx context.Context, username, code string) error
+	ResendConfirmationCode(ctx context.Context, username string) error
+	// IsConfirmed reports whether the account has completed sign up confirmation
+	IsConfirmed(ctx context.Context, username string) (bool, error)
+	ForgotPassword(ctx context.Context, username string) error
+	ConfirmForgotPassword(ctx context.Context, username, code, newPassword string) error
+	Refresh(ctx context.Context, refreshToken string) (*Tokens, error)
+	GetUser(ctx context.Context, accessToken string) (*User, error)
+	// LookupUser returns an account by username, without a token of its user
+	LookupUser(ctx context.Context, username string) (*User, error)
+	UpdateAttributes(ctx context.Context, accessToken string, attributes map[string]string) error
+	ChangePassword(ctx context.Context, accessToken, previousPassword, proposedPassword string) error
+	// VerifyPassword checks a password without starting a session
+	VerifyPassword(ctx context.Context, username, password string) error
+}
+
+// TokenVerifier describes how tokens handed out by a provider are verified
+type TokenVerifier interface {
+	// Issuer is the expected "iss" claim
+	Issuer() string
+	// Audience is the expected "aud" claim of ID tokens and "client_id" claim of access tokens
+	Audience() string
+	// Keyfunc resolves the key a token was signed with
+	Keyfunc(ctx context.Context) jwt.Keyfunc
+}
+
+// MFAProvider is implemented by providers that support TOTP authenticator apps
+type MFAProvider interface {
+	// AssociateSoftwareToken starts enrolment and returns the shared secret
+	AssociateSoftwareToken(ctx context.Context, accessToken string) (string, error)
+	// VerifySoftwareToken completes enrolment and makes TOTP the preferred factor
+	VerifySoftwareToken(ctx context.Context, accessToken, code, deviceName string) error
+	// AssociateSoftwareTokenSession starts enrolment during a sign in stopped at
+	// MFA_SETUP and returns the shared secret and the session to verify it with
+	AssociateSoftwareTokenSession(ctx context.Context, session string) (secret, next string, err error)
+	// VerifySoftwareTokenSession completes it and returns the session to answer
+	// the MFA_SETUP challenge with
+	VerifySoftwareTokenSession(ctx context.Context, session, code, deviceName string) (string, error)
+}
+
+// SessionRevoker is implemented by providers that can end sessions server side
+type SessionRevoker interface {
+	// RevokeToken invalidates a refresh token and the tokens issued from it
+	RevokeToken(ctx context.Context, refreshToken string) error
+	// GlobalSignOut invalidates every refresh token of the access token's user
+	GlobalSignOut(ctx context.Context, accessToken string) error
+}
+
+// EmailChanger is implemented by providers that let users change their email
+// address. The new address only replaces the old one once it is verified.
+type EmailChanger interface {
+	// RequestEmailChange sends a verification code to the new address
+	RequestEmailChange(ctx context.Context, accessToken, newEmail string) error
+	// VerifyEmailChange confirms the code sent to the new address
+	VerifyEmailChange(ctx context.Context, accessToken, code string) error
+}
+
+// GroupManager is implemented by providers whose groups can be managed by
+// admins. Group changes show up in tokens issued after the change.
+type GroupManager interface {
+	AddUserToGroup(ctx context.Context, username, group string) error
+	RemoveUserFromGroup(ctx context.Context, username, group string) error
+	ListGroupsForUser(ctx context.Context, username string) ([]string, error)
+}
+
+// UserAdministrator is implemented by providers that expose user management to admins
+type UserAdministrator interface {
+	ListUsers(ctx context.Context, input ListUsersInput) (*UserPage, error)
+	GetManagedUser(ctx context.Context, username string) (*ManagedUser, error)
+	DisableUser(ctx context.Context, username string) error
+	EnableUser(ctx context.Context, username string) error
+	// ResetUserPassword invalidates the password and sends the user a reset code
+	ResetUserPassword(ctx context.Context, username string) error
+	DeleteUser(ctx context.Context, username string) error
+	// SignOutUser invalidates every refresh token of the user
+	SignOutUser(ctx context.Context, username string) error
+	// ResendInvitation sends a new temporary password to an invited user
+	ResendInvitation(ctx context.Context, username string) error
+}
+
+// AttributeAdministrator is implemented by providers where this service can
+// change a user's attributes on its own, e.g. custom attributes users must not
+// edit themselves. The change shows up in tokens issued after it.
+type AttributeAdministrator interface {
+	AdminUpdateAttributes(ctx context.Context, username string, attributes map[string]string) error
+}
+
+// Inviter is implemented by providers where admins can create accounts on
+// behalf of someone. The invitee signs in with a temporary password and has to
+// answer NEW_PASSWORD_REQUIRED before tokens are issued.
+type Inviter interface {
+	InviteUser(ctx context.Context, input InviteInput) (*ManagedUser, error)
+}
+
+// CustomAuthenticator is implemented by providers with custom authentication
+// challenges, which passwordless sign in is built on
+type CustomAuthenticator interface {
+	// SignInWithCustomChallenge starts a custom authentication flow for
+	// username and answers its challenge with answer
+	SignInWithCustomChallenge(ctx context.Context, username, answer string) (*AuthResult, error)
+}
+
+// KeySetPublisher is implemented by providers that sign their own tokens and
+// therefore have to publish the keys to verify them
+type KeySetPublisher interface {
+	KeySet() (*jwk.Set, error)
+}
+
+type SignUpInput struct {
+	Username           string
+	Password           string
+	Email              string
+	FirstName          string
+	LastName           string
+	SubscriptionStatus string
+}
+
+type InviteInput struct {
+	Username  string
+	Email     string
+	FirstName string
+	LastName  string
+	// TemporaryPassword is generated by the provider when empty
+	TemporaryPassword string
+	// SuppressMessage stops the provider from mailing the temporary password
+	SuppressMessage bool
+}
+
+// Challenges a sign-in can stop at before tokens are issued
+const (
+	ChallengeSoftwareTokenMFA    = "SOFTWARE_TOKEN_MFA"
+	ChallengeSMSMFA              = "SMS_MFA"
+	ChallengeNewPasswordRequired = "NEW_PASSWORD_REQUIRED"
+	ChallengeMFASetup            = "MFA_SETUP"
+	ChallengeCustom              = "CUSTOM_CHALLENGE"
+)
+
+// AuthResult is the outcome of a sign-in step: either tokens or another challenge
+type AuthResult struct {
+	Tokens    *Tokens
+	Challenge *Challenge
+}
+
+type Challenge struct {
+	Name       string            `json:"name"`
+	Session    string            `json:"session"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+type ChallengeResponse struct {
+	Name        string
+	Session     string
+	Username    string
+	Code        string
+	NewPassword string
+}
+
+type Tokens struct {
+	AccessToken  string `json:"accessToken"`
+	IDToken      string `json:"idToken"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	ExpiresIn    int64  `json:"expiresIn"`
+}
+
+type User struct {
+	Subject    string            `json:"sub"`
+	Username   string            `json:"username"`
+	Email      string            `json:"email"`
+	Confirmed  bool              `json:"confirmed"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// ListUsersInput selects users by one attribute, matching it exactly or as a prefix
+type ListUsersInput struct {
+	Attribute string
+	Value     string
+	Prefix    bool
+	Limit     int64
+	PageToken string
+}
+
+type UserPage struct {
+	Users         []ManagedUser `json:"users"`
+	NextPageToken string        `json:"nextPageToken,omitempty"`
+}
+
+// Statuses of a ManagedUser, named like the Cognito user statuses
+const (
+	StatusUnconfirmed         = "UNCONFIRMED"
+	StatusConfirmed           = "CONFIRMED"
+	StatusForceChangePassword = "FORCE_CHANGE_PASSWORD"
+	StatusResetRequired       = "RESET_REQUIRED"
+)
+
+// ManagedUser is the admin view of an account
+type ManagedUser struct {
+	Subject    string            `json:"sub"`
+	Username   string            `json:"username"`
+	Email      string            `json:"email"`
+	Status     string            `json:"status"`
+	Enabled    bool              `json:"enabled"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	UpdatedAt  time.Time         `json:"updatedAt"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+var (
+	ErrInvalidParameter = errors.New("invalid parameter")
+	ErrUserExists       = errors.New("user already exists")
+	ErrInvalidPassword  = errors.New("password does not conform to policy")
+	ErrUserNotConfirmed = errors.New("user is not confirmed")
+	ErrNotAuthorized    = errors.New("incorrect username or password")
+	ErrUserNotFound     = errors.New("user does not exist")
+	ErrCodeMismatch     = errors.New("invalid verification code provided")
+	ErrExpiredCode      = errors.New("verification code has expired")
+	ErrLimitExceeded    = errors.New("attempt limit exceeded")
+	ErrNotSupported     = errors.New("operation is not supported by the identity provider")
+	ErrAlreadyConfirmed = errors.New("user is already confirmed")
+	ErrNotFound         = errors.New("resource does not exist")
+	ErrInvalidState     = errors.New("operation is not possible in the current state of the user")
+	ErrRejected         = errors.New("operation was rejected by a hook of the identity provider")
+)
+
+// Error wraps a provider specific error with one of the sentinel errors above so
+// handlers can branch with errors.Is while still reporting the original message.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+
+	return e.Err.Error()
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,761 @@
+package identity
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+
+	"backend/internal/types"
+	"backend/pkg/secrets"
+
+	"github.com/oklog/ulid/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Purposes of one-time codes issued by the local provider
+const (
+	CodePurposeConfirmSignUp = "confirm_signup"
+	CodePurposeResetPassword = "reset_password"
+	CodePurposeChangeEmail   = "change_email"
+)
+
+const maxCodeAttempts = 5
+
+var (
+	_ Provider       = (*LocalProvider)(nil)
+	_ SessionRevoker = (*LocalProvider)(nil)
+	_ EmailChanger   = (*LocalProvider)(nil)
+)
+
+// LocalUser is an account managed by the local provider
+type LocalUser struct {
+	types.Base
+	Username           string `gorm:"uniqueIndex"`
+	Email              string
+	PendingEmail       string
+	PasswordHash       string
+	FirstName          string
+	LastName           string
+	Locale             string
+	Picture            string
+	SubscriptionStatus string
+	Confirmed          bool
+}
+
+func (LocalUser) TableName() string {
+	return "identity_users"
+}
+
+// LocalCode is a hashed one-time code for sign-up confirmation or password reset
+type LocalCode struct {
+	types.Base
+	UserID    ulid.ULID `gorm:"index"`
+	Purpose   string
+	CodeHash  string
+	Attempts  int
+	ExpiresAt time.Time
+}
+
+func (LocalCode) TableName() string {
+	return "identity_codes"
+}
+
+// LocalRefreshToken is a hashed refresh token handed out on sign-in
+type LocalRefreshToken struct {
+	types.Base
+	UserID    ulid.ULID `gorm:"index"`
+	TokenHash string    `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+func (LocalRefreshToken) TableName() string {
+	return "identity_refresh_tokens"
+}
+
+// LocalModels lists the tables the local provider needs migrated
+func LocalModels() []interface{} {
+	return []interface{}{&LocalUser{}, &LocalCode{}, &LocalRefreshToken{}}
+}
+
+// CodeSender delivers one-time codes to the user
+type CodeSender interface {
+	SendCode(ctx context.Context, email, purpose, code string) error
+}
+
+// LogCodeSender writes codes to the process log, for running without a mail server
+type LogCodeSender struct{}
+
+func (LogCodeSender) SendCode(_ context.Context, email, purpose, code string) error {
+	log.Printf("identity: %s code for %s: %s", purpose, email, code)
+	return nil
+}
+
+type LocalOptions struct {
+	RefreshTokenTTL time.Duration
+	CodeTTL         time.Duration
+	Sender          CodeSender
+}
+
+// LocalProvider implements Provider with users stored in Postgres and tokens
+// signed by this service, so the whole auth flow can run without AWS.
+type LocalProvider struct {
+	*Signer
+	db         *gorm.DB
+	refreshTTL time.Duration
+	codeTTL    time.Duration
+	sender     CodeSender
+	// codeKey keys the hashes of codes, it is derived from the signing key
+	// so codes stored in the database can't be recovered without it
+	codeKey []byte
+}
+
+// dummyPasswordHash is compared against when the account doesn't exist, so a
+// sign in takes as long for an unknown username as for a wrong password
+const dummyPasswordHash = "$2a$10$3voWJEiAqHWJ/7alMAJJe.s/ZKCySibyqBUcZzMsdVelfhCqvZfoS"
+
+func NewLocalProvider(db *gorm.DB, signer *Signer, opts LocalOptions) *LocalProvider {
+	if opts.RefreshTokenTTL <= 0 {
+		opts.RefreshTokenTTL = 30 * 24 * time.Hour
+	}
+	if opts.CodeTTL <= 0 {
+		opts.CodeTTL = 24 * time.Hour
+	}
+	if opts.Sender == nil {
+		opts.Sender = LogCodeSender{}
+	}
+
+	return &LocalProvider{
+		Signer:     signer,
+		db:         db,
+		refreshTTL: opts.RefreshTokenTTL,
+		codeTTL:    opts.CodeTTL,
+		sender:     opts.Sender,
+		codeKey:    signer.derive("local codes"),
+	}
+}
+
+func (p *LocalProvider) SignUp(ctx context.Context, input SignUpInput) error {
+	if input.Username == "" || input.Password == "" {
+		return &Error{Kind: ErrInvalidParameter, Err: errors.New("username and password are required")}
+	}
+
+	if err := ValidatePassword(input.Password); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	base, err := types.NewBase()
+	if err != nil {
+		return err
+	}
+
+	user := &LocalUser{
+		Base:               *base,
+		Username:           input.Username,
+		Email:              input.Email,
+		PasswordHash:       string(hash),
+		FirstName:          input.FirstName,
+		LastName:           input.LastName,
+		SubscriptionStatus: input.SubscriptionStatus,
+	}
+
+	// the unique index on the username decides between concurrent sign ups
+	if err := p.db.WithContext(ctx).Create(user).Error; err != nil {
+		if p.isDuplicate(err) {
+			return &Error{Kind: ErrUserExists, Err: errors.New("an account with the given username already exists")}
+		}
+		return err
+	}
+
+	return p.issueCode(ctx, user, CodePurposeConfirmSignUp, user.Email)
+}
+
+func (p *LocalProvider) SignIn(ctx context.Context, username, password string) (*AuthResult, error) {
+	user, err := p.findUser(ctx, username)
+	if errors.Is(err, ErrUserNotFound) {
+		// don't reveal whether the account exists, not even by answering sooner
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+		return nil, &Error{Kind: ErrNotAuthorized}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, &Error{Kind: ErrNotAuthorized}
+	}
+
+	if !user.Confirmed {
+		return nil, &Error{Kind: ErrUserNotConfirmed}
+	}
+
+	tokens, err := p.Mint(p.claims(user))
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := p.issueRefreshToken(p.db.WithContext(ctx), user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens.RefreshToken = refreshToken
+	return &AuthResult{Tokens: tokens}, nil
+}
+
+// RespondToChallenge is never needed, the local provider does not issue challenges
+func (p *LocalProvider) RespondToChallenge(_ context.Context, _ ChallengeResponse) (*AuthResult, error) {
+	return nil, &Error{Kind: ErrNotSupported}
+}
+
+func (p *LocalProvider) ConfirmSignUp(ctx context.Context, username, code string) error {
+	user, err := p.findUser(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	if err := p.consumeCode(ctx, user, CodePurposeConfirmSignUp, code); err != nil {
+		return err
+	}
+
+	return p.db.WithContext(ctx).Model(user).Updates(map[string]interface{}{
+		"confirmed":  true,
+		"updated_at": time.Now(),
+	}).Error
+}
+
+func (p *LocalProvider) ResendConfirmationCode(ctx context.Context, username string) error {
+	user, err := p.findUser(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	if user.Confirmed {
+		return &Error{Kind: ErrAlreadyConfirmed}
+	}
+
+	return p.issueCode(ctx, user, CodePurposeConfirmSignUp, user.Email)
+}
+
+func (p *LocalProvider) IsConfirmed(ctx context.Context, username string) (bool, error) {
+	user, err := p.findUser(ctx, username)
+	if err != nil {
+		return false, err
+	}
+
+	return user.Confirmed, nil
+}
+
+func (p *LocalProvider) ForgotPassword(ctx context.Context, username string) error {
+	user, err := p.findUser(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	return p.issueCode(ctx, user, CodePurposeResetPassword, user.Email)
+}
+
+func (p *LocalProvider) ConfirmForgotPassword(ctx context.Context, username, code, newPassword string) error {
+	// checked before the lookup so a weak password fails alike for unknown users
+	if err := ValidatePassword(newPassword); err != nil {
+		return err
+	}
+
+	user, err := p.findUser(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	if err := p.consumeCode(ctx, user, CodePurposeResetPassword, code); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{"password_hash": string(hash), "updated_at": now}).Error; err != nil {
+			return err
+		}
+
+		// a password reset ends every existing session
+		return tx.Model(&LocalRefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", user.ID).
+			Update("revoked_at", now).Error
+	})
+}
+
+func (p *LocalProvider) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	var stored LocalRefreshToken
+	err := p.db.WithContext(ctx).Where("token_hash = ?", secrets.Hash(refreshToken)).First(&stored).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, &Error{Kind: ErrNotAuthorized, Err: errors.New("invalid refresh token")}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, &Error{Kind: ErrNotAuthorized, Err: errors.New("refresh token has expired")}
+	}
+
+	var user LocalUser
+	if err := p.db.WithContext(ctx).Where("id = ?", stored.UserID).First(&user).Error; err != nil {
+		return nil, &Error{Kind: ErrNotAuthorized, Err: err}
+	}
+
+	tokens, err := p.Mint(p.claims(&user))
+	if err != nil {
+		return nil, err
+	}
+
+	// rotate: the presented refresh token is spent and a new one replaces it
+	err = p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&LocalRefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", stored.ID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return &Error{Kind: ErrNotAuthorized, Err: errors.New("refresh token has already been used")}
+		}
+
+		tokens.RefreshToken, err = p.issueRefreshToken(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (p *LocalProvider) RevokeToken(ctx context.Context, refreshToken string) error {
+	return p.db.WithContext(ctx).Model(&LocalRefreshToken{}).
+		Where("token_hash = ? AND revoked_at IS NULL", secrets.Hash(refreshToken)).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (p *LocalProvider) GlobalSignOut(ctx context.Context, accessToken string) error {
+	user, err := p.GetUser(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	id, err := ulid.ParseStrict(user.Subject)
+	if err != nil {
+		return err
+	}
+
+	return p.db.WithContext(ctx).Model(&LocalRefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (p *LocalProvider) GetUser(ctx context.Context, accessToken string) (*User, error) {
+	user, err := p.userByAccessToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.user(user), nil
+}
+
+func (p *LocalProvider) LookupUser(ctx context.Context, username string) (*User, error) {
+	user, err := p.findUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.user(user), nil
+}
+
+func (p *LocalProvider) user(user *LocalUser) *User {
+	sub := user.ID.String()
+	attributes := p.claims(user).Attributes
+	attributes["sub"] = sub
+	attributes["email"] = user.Email
+
+	return &User{
+		Subject:    sub,
+		Username:   user.Username,
+		Email:      user.Email,
+		Confirmed:  user.Confirmed,
+		Attributes: attributes,
+	}
+}
+
+// localAttributes maps the standard attributes users may change to columns
+var localAttributes = map[string]string{
+	"given_name":  "first_name",
+	"family_name": "last_name",
+	"locale":      "locale",
+	"picture":     "picture",
+}
+
+func (p *LocalProvider) UpdateAttributes(ctx context.Context, accessToken string, attributes map[string]string) error {
+	user, err := p.userByAccessToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	for name, value := range attributes {
+		column, ok := localAttributes[name]
+		if !ok {
+			return &Error{Kind: ErrInvalidParameter, Err: errors.New("attribute " + name + " cannot be changed")}
+		}
+		updates[column] = value
+	}
+
+	return p.db.WithContext(ctx).Model(user).Updates(updates).Error
+}
+
+// localAdminAttributes are the attributes only this service may change
+var localAdminAttributes = map[string]string{
+	"custom:subscription_status": "subscription_status",
+}
+
+func (p *LocalProvider) AdminUpdateAttributes(ctx context.Context, username string, attributes map[string]string) error {
+	user, err := p.findUser(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	for name, value := range attributes {
+		column, ok := localAttributes[name]
+		if !ok {
+			column, ok = localAdminAttributes[name]
+		}
+		if !ok {
+			return &Error{Kind: ErrInvalidParameter, Err: errors.New("attribute " + name + " cannot be changed")}
+		}
+		updates[column] = value
+	}
+
+	return p.db.WithContext(ctx).Model(user).Updates(updates).Error
+}
+
+func (p *LocalProvider) ChangePassword(ctx context.Context, accessToken, previousPassword, proposedPassword string) error {
+	user, err := p.userByAccessToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(previousPassword)) != nil {
+		return &Error{Kind: ErrNotAuthorized, Err: errors.New("incorrect password")}
+	}
+
+	if err := ValidatePassword(proposedPassword); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(proposedPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return p.db.WithContext(ctx).Model(user).
+		Updates(map[string]interface{}{"password_hash": string(hash), "updated_at": time.Now()}).Error
+}
+
+func (p *LocalProvider) VerifyPassword(ctx context.Context, username, password string) error {
+	user, err := p.findUser(ctx, username)
+	if errors.Is(err, ErrUserNotFound) {
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+		return &Error{Kind: ErrNotAuthorized}
+	}
+	if err != nil {
+		return err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return &Error{Kind: ErrNotAuthorized}
+	}
+
+	return nil
+}
+
+func (p *LocalProvider) RequestEmailChange(ctx context.Context, accessToken, newEmail string) error {
+	user, err := p.userByAccessToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	var taken int64
+	err = p.db.WithContext(ctx).Model(&LocalUser{}).
+		Where("(email = ? OR username = ?) AND id <> ?", newEmail, newEmail, user.ID).
+		Count(&taken).Error
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return &Error{Kind: ErrUserExists, Err: errors.New("email address is already in use")}
+	}
+
+	if err := p.db.WithContext(ctx).Model(user).Update("pending_email", newEmail).Error; err != nil {
+		return err
+	}
+
+	return p.issueCode(ctx, user, CodePurposeChangeEmail, newEmail)
+}
+
+func (p *LocalProvider) VerifyEmailChange(ctx context.Context, accessToken, code string) error {
+	user, err := p.userByAccessToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	if user.PendingEmail == "" {
+		return &Error{Kind: ErrInvalidParameter, Err: errors.New("no email change is pending")}
+	}
+
+	if err := p.consumeCode(ctx, user, CodePurposeChangeEmail, code); err != nil {
+		return err
+	}
+
+	return p.db.WithContext(ctx).Model(user).Updates(map[string]interface{}{
+		"email":         user.PendingEmail,
+		"pending_email": "",
+		"updated_at":    time.Now(),
+	}).Error
+}
+
+// userByAccessToken loads the account an access token minted by this provider belongs to
+func (p *LocalProvider) userByAccessToken(ctx context.Context, accessToken string) (*LocalUser, error) {
+	claims, err := p.Verify(ctx, accessToken)
+	if err != nil {
+		return nil, &Error{Kind: ErrNotAuthorized, Err: err}
+	}
+
+	if use, _ := claims["token_use"].(string); use != "access" {
+		return nil, &Error{Kind: ErrNotAuthorized, Err: errors.New("access token required")}
+	}
+
+	sub, _ := claims["sub"].(string)
+	id, err := ulid.ParseStrict(sub)
+	if err != nil {
+		return nil, &Error{Kind: ErrNotAuthorized, Err: err}
+	}
+
+	var user LocalUser
+	err = p.db.WithContext(ctx).Where("id = ?", id).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, &Error{Kind: ErrUserNotFound}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// issueRefreshToken stores the hash of a new refresh token and returns the token
+func (p *LocalProvider) issueRefreshToken(db *gorm.DB, userID ulid.ULID) (string, error) {
+	refreshToken, err := secrets.Token()
+	if err != nil {
+		return "", err
+	}
+
+	base, err := types.NewBase()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Create(&LocalRefreshToken{
+		Base:      *base,
+		UserID:    userID,
+		TokenHash: secrets.Hash(refreshToken),
+		ExpiresAt: time.Now().Add(p.refreshTTL),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+func (p *LocalProvider) claims(user *LocalUser) Claims {
+	attributes := map[string]string{
+		"given_name":                 user.FirstName,
+		"family_name":                user.LastName,
+		"custom:subscription_status": user.SubscriptionStatus,
+	}
+	if user.Locale != "" {
+		attributes["locale"] = user.Locale
+	}
+	if user.Picture != "" {
+		attributes["picture"] = user.Picture
+	}
+
+	return Claims{
+		Subject:    user.ID.String(),
+		Username:   user.Username,
+		Email:      user.Email,
+		Attributes: attributes,
+	}
+}
+
+func (p *LocalProvider) findUser(ctx context.Context, username string) (*LocalUser, error) {
+	var user LocalUser
+	err := p.db.WithContext(ctx).Where("username = ?", username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, &Error{Kind: ErrUserNotFound}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// issueCode replaces the user's code for purpose and sends it to the given address
+func (p *LocalProvider) issueCode(ctx context.Context, user *LocalUser, purpose, email string) error {
+	code, err := secrets.Code()
+	if err != nil {
+		return err
+	}
+
+	base, err := types.NewBase()
+	if err != nil {
+		return err
+	}
+
+	err = p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// only the latest code of a purpose is valid
+		if err := tx.Where("user_id = ? AND purpose = ?", user.ID, purpose).Delete(&LocalCode{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&LocalCode{
+			Base:      *base,
+			UserID:    user.ID,
+			Purpose:   purpose,
+			CodeHash:  secrets.HashCode(p.codeKey, code),
+			ExpiresAt: time.Now().Add(p.codeTTL),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.sender.SendCode(ctx, email, purpose, code)
+}
+
+func (p *LocalProvider) consumeCode(ctx context.Context, user *LocalUser, purpose, code string) error {
+	var stored LocalCode
+	err := p.db.WithContext(ctx).Where("user_id = ? AND purpose = ?", user.ID, purpose).First(&stored).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &Error{Kind: ErrCodeMismatch}
+	}
+	if err != nil {
+		return err
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return &Error{Kind: ErrExpiredCode}
+	}
+
+	if stored.Attempts >= maxCodeAttempts {
+		return &Error{Kind: ErrLimitExceeded}
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stored.CodeHash), []byte(secrets.HashCode(p.codeKey, code))) != 1 {
+		return p.countCodeAttempt(ctx, &stored)
+	}
+
+	// the conditional delete makes sure concurrent requests with the same
+	// code can't both succeed
+	res := p.db.WithContext(ctx).Delete(&LocalCode{}, "id = ?", stored.ID)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return &Error{Kind: ErrCodeMismatch}
+	}
+
+	return nil
+}
+
+// isDuplicate reports whether err is a unique constraint violation, in the
+// words of whichever database the provider runs on
+func (p *LocalProvider) isDuplicate(err error) bool {
+	if translator, ok := p.db.Dialector.(gorm.ErrorTranslator); ok {
+		err = translator.Translate(err)
+	}
+
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+// countCodeAttempt records a wrong code. The update is conditional so
+// concurrent guesses can't take more attempts than allowed.
+func (p *LocalProvider) countCodeAttempt(ctx context.Context, stored *LocalCode) error {
+	res := p.db.WithContext(ctx).Model(&LocalCode{}).
+		Where("id = ? AND attempts < ?", stored.ID, maxCodeAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		// other requests used the last attempt in the meantime
+		return &Error{Kind: ErrLimitExceeded}
+	}
+
+	return &Error{Kind: ErrCodeMismatch}
+}
+
+// ValidatePassword applies the same rules as the default Cognito password policy
+func ValidatePassword(password string) error {
+	var upper, lower, digit, special bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			special = true
+		}
+	}
+
+	var missing []string
+	if len(password) < 8 {
+		missing = append(missing, "at least 8 characters")
+	}
+	if !upper {
+		missing = append(missing, "an uppercase letter")
+	}
+	if !lower {
+		missing = append(missing, "a lowercase letter")
+	}
+	if !digit {
+		missing = append(missing, "a number")
+	}
+	if !special {
+		missing = append(missing, "a special character")
+	}
+
+	if len(missing) > 0 {
+		return &Error{Kind: ErrInvalidPassword, Err: fmt.Errorf("password must contain %s", strings.Join(missing, ", "))}
+	}
+
+	return nil
+}
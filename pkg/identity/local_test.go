@@ -0,0 +1,168 @@
+package identity_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/internal/testdb"
+	"backend/pkg/identity"
+)
+
+// codes keeps the last code sent for every address and purpose
+type codes struct {
+	mu   sync.Mutex
+	sent map[string]string
+}
+
+func (c *codes) SendCode(_ context.Context, email, purpose, code string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sent[email+"/"+purpose] = code
+	return nil
+}
+
+func (c *codes) last(email, purpose string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.sent[email+"/"+purpose]
+}
+
+func newLocalProvider(t *testing.T) (*identity.LocalProvider, *codes) {
+	t.Helper()
+
+	signer, err := identity.NewSigner("http://localhost:8080", "local", "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := &codes{sent: map[string]string{}}
+	db := testdb.Open(t, identity.LocalModels()...)
+	return identity.NewLocalProvider(db, signer, identity.LocalOptions{Sender: sent}), sent
+}
+
+func TestLocalConfirmForgotPassword(t *testing.T) {
+	ctx := context.Background()
+	p, sent := newLocalProvider(t)
+
+	const email = "alice@example.com"
+	err := p.SignUp(ctx, identity.SignUpInput{Username: email, Email: email, Password: "Passw0rd!", FirstName: "Alice", LastName: "Doe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ConfirmSignUp(ctx, email, sent.last(email, identity.CodePurposeConfirmSignUp)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ForgotPassword(ctx, email); err != nil {
+		t.Fatal(err)
+	}
+	code := sent.last(email, identity.CodePurposeResetPassword)
+
+	// a weak password fails alike for known and unknown accounts
+	for _, username := range []string{email, "nobody@example.com"} {
+		if err := p.ConfirmForgotPassword(ctx, username, code, "weak"); !errors.Is(err, identity.ErrInvalidPassword) {
+			t.Fatalf("%s: err = %v, want %v", username, err, identity.ErrInvalidPassword)
+		}
+	}
+
+	if err := p.ConfirmForgotPassword(ctx, "nobody@example.com", code, "N3wPassword!"); !errors.Is(err, identity.ErrUserNotFound) {
+		t.Fatalf("unknown user: err = %v, want %v", err, identity.ErrUserNotFound)
+	}
+	if err := p.ConfirmForgotPassword(ctx, email, code, "N3wPassword!"); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if _, err := p.SignIn(ctx, email, "N3wPassword!"); err != nil {
+		t.Fatalf("sign in with the new password: %v", err)
+	}
+}
+
+func TestLocalCodeAttemptLimit(t *testing.T) {
+	ctx := context.Background()
+	p, sent := newLocalProvider(t)
+
+	const email = "alice@example.com"
+	err := p.SignUp(ctx, identity.SignUpInput{Username: email, Email: email, Password: "Passw0rd!", FirstName: "Alice", LastName: "Doe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := sent.last(email, identity.CodePurposeConfirmSignUp)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := p.ConfirmSignUp(ctx, email, wrong); !errors.Is(err, identity.ErrCodeMismatch) {
+			t.Fatalf("guess %d: err = %v, want %v", i+1, err, identity.ErrCodeMismatch)
+		}
+	}
+	if err := p.ConfirmSignUp(ctx, email, wrong); !errors.Is(err, identity.ErrLimitExceeded) {
+		t.Fatalf("wrong code after the limit: err = %v, want %v", err, identity.ErrLimitExceeded)
+	}
+	if err := p.ConfirmSignUp(ctx, email, code); !errors.Is(err, identity.ErrLimitExceeded) {
+		t.Fatalf("right code after the limit: err = %v, want %v", err, identity.ErrLimitExceeded)
+	}
+
+	// a new code starts over
+	if err := p.ResendConfirmationCode(ctx, email); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ConfirmSignUp(ctx, email, sent.last(email, identity.CodePurposeConfirmSignUp)); err != nil {
+		t.Fatalf("new code: %v", err)
+	}
+}
+
+func TestLocalSignUpExistingUser(t *testing.T) {
+	ctx := context.Background()
+	p, _ := newLocalProvider(t)
+
+	const email = "alice@example.com"
+	input := identity.SignUpInput{Username: email, Email: email, Password: "Passw0rd!", FirstName: "Alice", LastName: "Doe"}
+	if err := p.SignUp(ctx, input); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SignUp(ctx, input); !errors.Is(err, identity.ErrUserExists) {
+		t.Fatalf("err = %v, want %v", err, identity.ErrUserExists)
+	}
+}
+
+func TestLocalUnknownUserTakesAsLong(t *testing.T) {
+	ctx := context.Background()
+	p, sent := newLocalProvider(t)
+
+	const email = "alice@example.com"
+	if err := p.SignUp(ctx, identity.SignUpInput{Username: email, Email: email, Password: "Passw0rd!"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ConfirmSignUp(ctx, email, sent.last(email, identity.CodePurposeConfirmSignUp)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the answer for an unknown account mustn't come back before the password
+	// of a real one has been checked
+	start := time.Now()
+	if _, err := p.SignIn(ctx, email, "Wr0ng-password"); !errors.Is(err, identity.ErrNotAuthorized) {
+		t.Fatalf("err = %v, want %v", err, identity.ErrNotAuthorized)
+	}
+	wrongPassword := time.Since(start)
+
+	start = time.Now()
+	if _, err := p.SignIn(ctx, "nobody@example.com", "Wr0ng-password"); !errors.Is(err, identity.ErrNotAuthorized) {
+		t.Fatalf("err = %v, want %v", err, identity.ErrNotAuthorized)
+	}
+	if unknown := time.Since(start); unknown < wrongPassword/2 {
+		t.Fatalf("unknown user answered in %s, a wrong password in %s", unknown, wrongPassword)
+	}
+
+	start = time.Now()
+	if err := p.VerifyPassword(ctx, "nobody@example.com", "Wr0ng-password"); !errors.Is(err, identity.ErrNotAuthorized) {
+		t.Fatalf("err = %v, want %v", err, identity.ErrNotAuthorized)
+	}
+	if unknown := time.Since(start); unknown < wrongPassword/2 {
+		t.Fatalf("unknown user verified in %s, a wrong password in %s", unknown, wrongPassword)
+	}
+}
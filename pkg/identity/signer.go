@@ -0,0 +1,222 @@
+package identity
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"backend/pkg/jwks"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/lestrrat/go-jwx/jwk"
+	"github.com/oklog/ulid/v2"
+)
+
+// Signer mints Cognito shaped tokens signed with a key held by this service
+type Signer struct {
+	issuer   string
+	audience string
+	ttl      time.Duration
+	key      *rsa.PrivateKey
+	kid      string
+}
+
+// NewSigner parses a PEM encoded RSA private key (PKCS#1 or PKCS#8). An empty
+// key generates a throwaway one, which only makes sense for local development.
+func NewSigner(issuer, audience, privateKeyPEM string, ttl time.Duration) (*Signer, error) {
+	var key *rsa.PrivateKey
+	if privateKeyPEM == "" {
+		generated, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		key = generated
+	} else {
+		parsed, err := parseRSAPrivateKey([]byte(privateKeyPEM))
+		if err != nil {
+			return nil, err
+		}
+		key = parsed
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+
+	return &Signer{
+		issuer:   issuer,
+		audience: audience,
+		ttl:      ttl,
+		key:      key,
+		kid:      base64.RawURLEncoding.EncodeToString(sum[:12]),
+	}, nil
+}
+
+// derive returns a key for purpose that only the holder of the signing key can
+// compute, for secrets of the local provider that aren't tokens
+func (s *Signer) derive(purpose string) []byte {
+	mac := hmac.New(sha256.New, x509.MarshalPKCS1PrivateKey(s.key))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func (s *Signer) Issuer() string {
+	return s.issuer
+}
+
+func (s *Signer) Audience() string {
+	return s.audience
+}
+
+func (s *Signer) TTL() time.Duration {
+	return s.ttl
+}
+
+func (s *Signer) Keyfunc(_ context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if kid, _ := token.Header["kid"].(string); kid != s.kid {
+			return nil, jwks.ErrKeyNotFound
+		}
+
+		return &s.key.PublicKey, nil
+	}
+}
+
+// KeySet returns the public half of the signing key as a JSON Web Key Set
+func (s *Signer) KeySet() (*jwk.Set, error) {
+	key, err := jwk.New(&s.key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range map[string]string{
+		jwk.KeyIDKey:     s.kid,
+		jwk.AlgorithmKey: jwt.SigningMethodRS256.Alg(),
+		jwk.KeyUsageKey:  string(jwk.ForSignature),
+	} {
+		if err := key.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return &jwk.Set{Keys: []jwk.Key{key}}, nil
+}
+
+// Claims carries what ends up in the minted tokens
+type Claims struct {
+	Subject    string
+	Username   string
+	Email      string
+	Groups     []string
+	Attributes map[string]string
+}
+
+// Mint issues an access token and an ID token for the given claims
+func (s *Signer) Mint(c Claims) (*Tokens, error) {
+	now := time.Now()
+	base := jwt.MapClaims{
+		"origin_jti": ulid.Make().String(),
+		"sub":        c.Subject,
+		"iss":        s.issuer,
+		"iat":        now.Unix(),
+		"auth_time":  now.Unix(),
+		"exp":        now.Add(s.ttl).Unix(),
+	}
+	if len(c.Groups) > 0 {
+		base["cognito:groups"] = c.Groups
+	}
+
+	access := copyClaims(base)
+	access["token_use"] = "access"
+	access["client_id"] = s.audience
+	access["username"] = c.Username
+	access["jti"] = ulid.Make().String()
+
+	id := copyClaims(base)
+	id["token_use"] = "id"
+	id["aud"] = s.audience
+	id["cognito:username"] = c.Username
+	id["email"] = c.Email
+	for name, value := range c.Attributes {
+		id[name] = value
+	}
+	id["jti"] = ulid.Make().String()
+
+	accessToken, err := s.sign(access)
+	if err != nil {
+		return nil, err
+	}
+
+	idToken, err := s.sign(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tokens{
+		AccessToken: accessToken,
+		IDToken:     idToken,
+		ExpiresIn:   int64(s.ttl.Seconds()),
+	}, nil
+}
+
+// Verify checks the signature, issuer and expiry of a token minted by this signer
+func (s *Signer) Verify(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	parser := &jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS256.Alg()}}
+	token, err := parser.Parse(tokenString, s.Keyfunc(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !claims.VerifyIssuer(s.issuer, true) {
+		return nil, errors.New("token was not issued by this provider")
+	}
+
+	return claims, nil
+}
+
+func (s *Signer) sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.kid
+	return token.SignedString(s.key)
+}
+
+func copyClaims(in jwt.MapClaims) jwt.MapClaims {
+	out := make(jwt.MapClaims, len(in)+8)
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key is not an RSA key")
+	}
+
+	return key, nil
+}
@@ -0,0 +1,313 @@
+package jwks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/lestrrat/go-jwx/jwk"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var (
+	ErrMissingKeyID = errors.New("expecting JWT header to have string kid")
+	ErrKeyNotFound  = errors.New("unable to find key")
+	// ErrKeySetUnavailable is returned while no key set could be loaded yet
+	ErrKeySetUnavailable = errors.New("key set has not been loaded")
+)
+
+var tracer = otel.GetTracerProvider().Tracer("pkg.jwks")
+
+// Options configures a Cache. Zero values fall back to sensible defaults.
+type Options struct {
+	// RefreshInterval is how often the key set is re-fetched in the background.
+	RefreshInterval time.Duration
+	// RefreshCooldown is the minimum time between two fetches triggered by an unknown kid.
+	RefreshCooldown time.Duration
+	// HTTPClient is used to fetch the key set.
+	HTTPClient *http.Client
+}
+
+// Stats is a snapshot of the cache counters.
+type Stats struct {
+	Hits          int64     `json:"hits"`
+	Misses        int64     `json:"misses"`
+	Refreshes     int64     `json:"refreshes"`
+	RefreshErrors int64     `json:"refreshErrors"`
+	LastRefresh   time.Time `json:"lastRefresh"`
+	LastFailure   time.Time `json:"lastFailure"`
+}
+
+// Cache holds a JSON Web Key Set in memory, refreshes it periodically and
+// re-fetches it when a token is signed with a key it has not seen yet.
+type Cache struct {
+	url     string
+	client  *http.Client
+	refresh time.Duration
+	cool    time.Duration
+
+	mu  sync.RWMutex
+	set *jwk.Set
+	// lastRefresh is the last successful fetch, lastFailure the last failed one
+	lastRefresh time.Time
+	lastFailure time.Time
+	lastErr     error
+
+	// fetchMu serialises fetches so concurrent misses only cause one request.
+	fetchMu sync.Mutex
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	refreshes     atomic.Int64
+	refreshErrors atomic.Int64
+
+	hitCounter  metric.Int64Counter
+	missCounter metric.Int64Counter
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func New(url string, opts Options) *Cache {
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = time.Hour
+	}
+	if opts.RefreshCooldown <= 0 {
+		opts.RefreshCooldown = time.Minute
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	meter := otel.GetMeterProvider().Meter("pkg.jwks")
+	hitCounter, _ := meter.Int64Counter("jwks.cache.hits", metric.WithDescription("Key lookups served from the cached key set"))
+	missCounter, _ := meter.Int64Counter("jwks.cache.misses", metric.WithDescription("Key lookups that required a fetch"))
+
+	return &Cache{
+		url:         url,
+		client:      opts.HTTPClient,
+		refresh:     opts.RefreshInterval,
+		cool:        opts.RefreshCooldown,
+		hitCounter:  hitCounter,
+		missCounter: missCounter,
+		stop:        make(chan struct{}),
+	}
+}
+
+// URL returns the address the key set is fetched from.
+func (c *Cache) URL() string {
+	return c.url
+}
+
+// Start loads the key set and keeps it fresh until ctx is done or Stop is called.
+// A failed initial load is returned but the background refresh still runs.
+func (c *Cache) Start(ctx context.Context) error {
+	err := c.Refresh(ctx)
+
+	go func() {
+		ticker := time.NewTicker(c.refresh)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				_ = c.Refresh(ctx)
+			}
+		}
+	}()
+
+	return err
+}
+
+// Stop ends the background refresh.
+func (c *Cache) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// Refresh fetches the key set unconditionally and replaces the cached one on success.
+func (c *Cache) Refresh(ctx context.Context) error {
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	return c.fetch(ctx)
+}
+
+// Lookup returns the public key for kid, fetching the key set when the kid is
+// unknown and the last fetch is older than the cooldown. It returns
+// ErrKeySetUnavailable rather than ErrKeyNotFound while no set has been loaded.
+func (c *Cache) Lookup(ctx context.Context, kid string) (interface{}, error) {
+	ctx, span := tracer.Start(ctx, "jwks.Lookup")
+	defer span.End()
+	span.SetAttributes(attribute.String("jwks.kid", kid))
+
+	if key, ok := c.lookup(kid); ok {
+		c.hits.Add(1)
+		c.hitCounter.Add(ctx, 1)
+		span.SetAttributes(attribute.Bool("jwks.cache_hit", true))
+		return key.Materialize()
+	}
+
+	c.misses.Add(1)
+	c.missCounter.Add(ctx, 1)
+	span.SetAttributes(attribute.Bool("jwks.cache_hit", false))
+
+	c.fetchMu.Lock()
+	// another request may have fetched the set while we were waiting
+	if key, ok := c.lookup(kid); ok {
+		c.fetchMu.Unlock()
+		return key.Materialize()
+	}
+
+	c.mu.RLock()
+	lastAttempt := c.lastRefresh
+	if c.lastFailure.After(lastAttempt) {
+		lastAttempt = c.lastFailure
+	}
+	coolingDown := !lastAttempt.IsZero() && time.Since(lastAttempt) < c.cool
+	c.mu.RUnlock()
+
+	if !coolingDown {
+		// the fetch serves every request waiting for the set, it must not be
+		// cut short by the one that happened to trigger it
+		if err := c.fetch(detached{ctx}); err != nil {
+			c.fetchMu.Unlock()
+			span.RecordError(err)
+			return nil, err
+		}
+	}
+	c.fetchMu.Unlock()
+
+	if key, ok := c.lookup(kid); ok {
+		return key.Materialize()
+	}
+
+	c.mu.RLock()
+	loaded, lastErr := c.set != nil, c.lastErr
+	c.mu.RUnlock()
+	if !loaded {
+		// without a set the kid can't be judged, the token may well be valid
+		err := ErrKeySetUnavailable
+		if lastErr != nil {
+			err = fmt.Errorf("%w: %v", ErrKeySetUnavailable, lastErr)
+		}
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.RecordError(ErrKeyNotFound)
+	return nil, ErrKeyNotFound
+}
+
+// Keyfunc adapts the cache to jwt.Parse.
+func (c *Cache) Keyfunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, ErrMissingKeyID
+		}
+
+		return c.Lookup(ctx, kid)
+	}
+}
+
+// Stats returns the current counters.
+func (c *Cache) Stats() Stats {
+	c.mu.RLock()
+	lastRefresh, lastFailure := c.lastRefresh, c.lastFailure
+	c.mu.RUnlock()
+
+	return Stats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Refreshes:     c.refreshes.Load(),
+		RefreshErrors: c.refreshErrors.Load(),
+		LastRefresh:   lastRefresh,
+		LastFailure:   lastFailure,
+	}
+}
+
+func (c *Cache) lookup(kid string) (jwk.Key, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.set == nil {
+		return nil, false
+	}
+
+	if keys := c.set.LookupKeyID(kid); len(keys) == 1 {
+		return keys[0], true
+	}
+
+	return nil, false
+}
+
+// fetch must be called with fetchMu held.
+func (c *Cache) fetch(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "jwks.fetch")
+	defer span.End()
+
+	set, err := c.download(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// a failed attempt counts for the cooldown but doesn't make the set fresh
+	if err != nil {
+		c.lastFailure = time.Now()
+		c.lastErr = err
+		c.refreshErrors.Add(1)
+		span.RecordError(err)
+		return err
+	}
+
+	c.lastRefresh = time.Now()
+	c.lastErr = nil
+	c.refreshes.Add(1)
+	c.set = set
+	span.SetAttributes(attribute.Int("jwks.keys", len(set.Keys)))
+	return nil
+}
+
+func (c *Cache) download(ctx context.Context) (*jwk.Set, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch remote JWK: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch remote JWK (status %d)", res.StatusCode)
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWK HTTP response body: %w", err)
+	}
+
+	return jwk.Parse(buf)
+}
+
+// detached keeps the values of a context, like the trace, but not its
+// cancellation or deadline
+type detached struct{ context.Context }
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
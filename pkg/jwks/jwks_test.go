@@ -0,0 +1,303 @@
+package jwks_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"backend/pkg/jwks"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// keyServer serves a JWKS endpoint whose keys and health the test controls
+type keyServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	keys     map[string]*rsa.PrivateKey
+	failing  bool
+	requests atomic.Int64
+}
+
+func newKeyServer(t *testing.T, kids ...string) *keyServer {
+	t.Helper()
+
+	s := &keyServer{keys: map[string]*rsa.PrivateKey{}}
+	for _, kid := range kids {
+		s.add(t, kid)
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		set := struct {
+			Keys []map[string]string `json:"keys"`
+		}{}
+		for kid, key := range s.keys {
+			set.Keys = append(set.Keys, map[string]string{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": kid,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *keyServer) add(t *testing.T, kid string) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	s.keys[kid] = key
+	s.mu.Unlock()
+
+	return key
+}
+
+// rotate replaces every key with a new one under kid
+func (s *keyServer) rotate(t *testing.T, kid string) {
+	t.Helper()
+
+	s.mu.Lock()
+	s.keys = map[string]*rsa.PrivateKey{}
+	s.mu.Unlock()
+
+	s.add(t, kid)
+}
+
+func (s *keyServer) fail(failing bool) {
+	s.mu.Lock()
+	s.failing = failing
+	s.mu.Unlock()
+}
+
+func (s *keyServer) sign(t *testing.T, kid string) string {
+	t.Helper()
+
+	s.mu.Lock()
+	key := s.keys[kid]
+	s.mu.Unlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "alice"})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func lookup(t *testing.T, cache *jwks.Cache, kid string) *rsa.PublicKey {
+	t.Helper()
+
+	key, err := cache.Lookup(context.Background(), kid)
+	if err != nil {
+		t.Fatalf("Lookup(%q): %v", kid, err)
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		t.Fatalf("Lookup(%q) returned %T", kid, key)
+	}
+
+	return pub
+}
+
+func TestLookupCacheHit(t *testing.T) {
+	server := newKeyServer(t, "k1")
+	cache := jwks.New(server.URL, jwks.Options{})
+
+	first := lookup(t, cache, "k1")
+	second := lookup(t, cache, "k1")
+	if first.N.Cmp(second.N) != 0 {
+		t.Fatal("the cached key changed")
+	}
+
+	if n := server.requests.Load(); n != 1 {
+		t.Fatalf("fetched %d times, want 1", n)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Refreshes != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestLookupRotatesOnUnknownKid(t *testing.T) {
+	server := newKeyServer(t, "k1")
+	cache := jwks.New(server.URL, jwks.Options{RefreshCooldown: time.Nanosecond})
+	if err := cache.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	server.rotate(t, "k2")
+	time.Sleep(time.Millisecond)
+
+	token, err := jwt.Parse(server.sign(t, "k2"), cache.Keyfunc(context.Background()))
+	if err != nil || !token.Valid {
+		t.Fatalf("token signed with the rotated key: %v", err)
+	}
+	if n := server.requests.Load(); n != 2 {
+		t.Fatalf("fetched %d times, want 2", n)
+	}
+
+	// the old key is gone from the set
+	time.Sleep(time.Millisecond)
+	if _, err := cache.Lookup(context.Background(), "k1"); !errors.Is(err, jwks.ErrKeyNotFound) {
+		t.Fatalf("retired key: err = %v, want %v", err, jwks.ErrKeyNotFound)
+	}
+}
+
+func TestLookupRefreshCooldown(t *testing.T) {
+	server := newKeyServer(t, "k1")
+	cache := jwks.New(server.URL, jwks.Options{RefreshCooldown: time.Hour})
+	if err := cache.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a flood of made up kids must not turn into a flood of fetches
+	for i := 0; i < 10; i++ {
+		if _, err := cache.Lookup(context.Background(), "unknown"); !errors.Is(err, jwks.ErrKeyNotFound) {
+			t.Fatalf("err = %v, want %v", err, jwks.ErrKeyNotFound)
+		}
+	}
+	if n := server.requests.Load(); n != 1 {
+		t.Fatalf("fetched %d times, want 1", n)
+	}
+
+	// a key rotated in during the cooldown is picked up by the next refresh
+	server.add(t, "k2")
+	if _, err := cache.Lookup(context.Background(), "k2"); !errors.Is(err, jwks.ErrKeyNotFound) {
+		t.Fatalf("err = %v, want %v", err, jwks.ErrKeyNotFound)
+	}
+	if err := cache.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	lookup(t, cache, "k2")
+}
+
+func TestLookupServesStaleKeys(t *testing.T) {
+	server := newKeyServer(t, "k1")
+	cache := jwks.New(server.URL, jwks.Options{RefreshCooldown: time.Nanosecond})
+	if err := cache.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	server.fail(true)
+	if err := cache.Refresh(context.Background()); err == nil {
+		t.Fatal("refresh against a failing endpoint succeeded")
+	}
+
+	// the keys fetched before the outage keep verifying tokens
+	token, err := jwt.Parse(server.sign(t, "k1"), cache.Keyfunc(context.Background()))
+	if err != nil || !token.Valid {
+		t.Fatalf("token signed with the cached key: %v", err)
+	}
+
+	// a miss tries the endpoint and reports why it failed
+	time.Sleep(time.Millisecond)
+	if _, err := cache.Lookup(context.Background(), "unknown"); err == nil || errors.Is(err, jwks.ErrKeyNotFound) {
+		t.Fatalf("err = %v, want the fetch error", err)
+	}
+	lookup(t, cache, "k1")
+
+	if stats := cache.Stats(); stats.RefreshErrors != 2 || stats.Refreshes != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestKeyfuncMissingKid(t *testing.T) {
+	server := newKeyServer(t, "k1")
+	cache := jwks.New(server.URL, jwks.Options{})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "alice"})
+	signed, err := token.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = jwt.Parse(signed, cache.Keyfunc(context.Background()))
+	var validation *jwt.ValidationError
+	if !errors.As(err, &validation) || !errors.Is(validation.Inner, jwks.ErrMissingKeyID) {
+		t.Fatalf("err = %v, want %v", err, jwks.ErrMissingKeyID)
+	}
+	if n := server.requests.Load(); n != 0 {
+		t.Fatalf("fetched %d times, want 0", n)
+	}
+}
+
+func TestLookupBeforeFirstLoad(t *testing.T) {
+	server := newKeyServer(t, "k1")
+	server.fail(true)
+	cache := jwks.New(server.URL, jwks.Options{RefreshCooldown: time.Hour})
+
+	// the miss tries the endpoint and reports why it failed
+	if _, err := cache.Lookup(context.Background(), "k1"); err == nil || errors.Is(err, jwks.ErrKeyNotFound) {
+		t.Fatalf("err = %v, want the fetch error", err)
+	}
+	// during the cooldown the kid isn't called unknown, there is no set to know it
+	if _, err := cache.Lookup(context.Background(), "k1"); !errors.Is(err, jwks.ErrKeySetUnavailable) {
+		t.Fatalf("err = %v, want %v", err, jwks.ErrKeySetUnavailable)
+	}
+	if n := server.requests.Load(); n != 1 {
+		t.Fatalf("fetched %d times, want 1", n)
+	}
+
+	stats := cache.Stats()
+	if !stats.LastRefresh.IsZero() || stats.LastFailure.IsZero() {
+		t.Fatalf("stats = %+v, want only a failure", stats)
+	}
+
+	server.fail(false)
+	if err := cache.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	lookup(t, cache, "k1")
+	if stats := cache.Stats(); stats.LastRefresh.Before(stats.LastFailure) {
+		t.Fatalf("stats = %+v, want the refresh after the failure", stats)
+	}
+}
+
+func TestLookupFetchOutlivesRequest(t *testing.T) {
+	server := newKeyServer(t, "k1")
+	cache := jwks.New(server.URL, jwks.Options{RefreshCooldown: time.Hour})
+
+	// the client that triggered the fetch went away, the others still need the set
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := cache.Lookup(ctx, "k1"); err != nil {
+		t.Fatalf("Lookup() with a canceled request: %v", err)
+	}
+	if stats := cache.Stats(); stats.RefreshErrors != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
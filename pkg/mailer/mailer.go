@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"context"
+	"log"
+
+	"backend/pkg/config"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+)
+
+// Drivers accepted by the MAIL_DRIVER setting
+const (
+	DriverSES = "ses"
+	DriverLog = "log"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers plain text notification emails
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+func NewMailer(cfg config.Configuration) Mailer {
+	if cfg.Mail.DRIVER == DriverSES {
+		return &SES{Client: ses.New(cfg.AWS.GetAwsSession()), From: cfg.Mail.FROM}
+	}
+
+	return LogMailer{}
+}
+
+type SES struct {
+	Client *ses.SES
+	From   string
+}
+
+func (m *SES) Send(ctx context.Context, msg Message) error {
+	_, err := m.Client.SendEmailWithContext(ctx, &ses.SendEmailInput{
+		Source:      aws.String(m.From),
+		Destination: &ses.Destination{ToAddresses: []*string{aws.String(msg.To)}},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String(msg.Subject), Charset: aws.String("UTF-8")},
+			Body: &ses.Body{
+				Text: &ses.Content{Data: aws.String(msg.Body), Charset: aws.String("UTF-8")},
+			},
+		},
+	})
+	return err
+}
+
+// LogMailer writes messages to the process log, for running without a mail server
+type LogMailer struct{}
+
+func (LogMailer) Send(_ context.Context, msg Message) error {
+	log.Printf("mailer: to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,217 @@
+// Package oauth runs the OAuth 2.0 authorization code flow with PKCE against
+// the Cognito hosted UI or any OpenID Connect provider with the same endpoints.
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var tracer = otel.GetTracerProvider().Tracer("pkg.oauth")
+
+var (
+	ErrNonceMismatch  = errors.New("id token nonce does not match the authorization request")
+	ErrIssuerMismatch = errors.New("id token was not issued by the configured issuer")
+)
+
+type Config struct {
+	AuthorizeURL string
+	TokenURL     string
+	ClientID     string
+	// ClientSecret is only needed for app clients that have one
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// Issuer is the iss claim ID tokens must carry
+	Issuer string
+	// Keyfunc resolves the keys ID tokens are signed with
+	Keyfunc    func(ctx context.Context) jwt.Keyfunc
+	HTTPClient *http.Client
+}
+
+// Client talks to the authorization server
+type Client struct {
+	cfg Config
+}
+
+func New(cfg Config) *Client {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Client{cfg: cfg}
+}
+
+// AuthRequest holds the per sign in values of an authorization request
+type AuthRequest struct {
+	State         string
+	Nonce         string
+	CodeChallenge string
+	// IdentityProvider skips the hosted UI and sends the user straight to the
+	// named provider of the user pool, e.g. Google or a SAML IdP
+	IdentityProvider string
+}
+
+// AuthCodeURL is where the user agent is sent to sign in
+func (c *Client) AuthCodeURL(r AuthRequest) string {
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.cfg.ClientID},
+		"redirect_uri":          {c.cfg.RedirectURL},
+		"scope":                 {strings.Join(c.cfg.Scopes, " ")},
+		"state":                 {r.State},
+		"nonce":                 {r.Nonce},
+		"code_challenge":        {r.CodeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	if r.IdentityProvider != "" {
+		q.Set("identity_provider", r.IdentityProvider)
+	}
+
+	sep := "?"
+	if strings.Contains(c.cfg.AuthorizeURL, "?") {
+		sep = "&"
+	}
+
+	return c.cfg.AuthorizeURL + sep + q.Encode()
+}
+
+// Token is the response of the token endpoint
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// Error is an error response of the token endpoint
+type Error struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oauth: %s: %s", e.Code, e.Description)
+	}
+
+	return fmt.Sprintf("oauth: %s (status %d)", e.Code, e.StatusCode)
+}
+
+// Exchange trades an authorization code and its PKCE verifier for tokens
+func (c *Client) Exchange(ctx context.Context, code, verifier string) (*Token, error) {
+	ctx, span := tracer.Start(ctx, "oauth.Exchange")
+	defer span.End()
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {c.cfg.ClientID},
+		"code":          {code},
+		"redirect_uri":  {c.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	}
+
+	res, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	defer res.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
+
+	if res.StatusCode != http.StatusOK {
+		oauthErr := &Error{StatusCode: res.StatusCode}
+		_ = json.NewDecoder(res.Body).Decode(oauthErr)
+		if oauthErr.Code == "" {
+			oauthErr.Code = "server_error"
+		}
+		span.RecordError(oauthErr)
+		return nil, oauthErr
+	}
+
+	var token Token
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	if token.AccessToken == "" || token.IDToken == "" {
+		return nil, &Error{StatusCode: res.StatusCode, Code: "invalid_response", Description: "token response lacks the access or id token"}
+	}
+
+	return &token, nil
+}
+
+// VerifyIDToken checks the signature, issuer, audience and nonce of an ID token
+func (c *Client) VerifyIDToken(ctx context.Context, idToken, nonce string) (jwt.MapClaims, error) {
+	parser := &jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS256.Alg()}}
+	token, err := parser.Parse(idToken, c.cfg.Keyfunc(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("oauth: id token has no claims")
+	}
+
+	// keys may be shared with other issuers, e.g. a mock server or another pool
+	if iss, _ := claims["iss"].(string); iss == "" || iss != c.cfg.Issuer {
+		return nil, ErrIssuerMismatch
+	}
+
+	if !claims.VerifyAudience(c.cfg.ClientID, true) {
+		return nil, errors.New("oauth: id token was issued for another client")
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	return claims, nil
+}
+
+// NewVerifier returns a random PKCE code verifier
+func NewVerifier() (string, error) {
+	return RandomString(32)
+}
+
+// Challenge derives the S256 code challenge of a verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// RandomString returns n random bytes, base64url encoded
+func RandomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
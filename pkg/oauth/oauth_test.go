@@ -0,0 +1,425 @@
+package oauth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/pkg/jwks"
+	"backend/pkg/oauth"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	clientID     = "client"
+	clientSecret = "secret"
+	redirectURL  = "http://localhost:8080/auth/oauth/callback"
+	kid          = "mock-key"
+)
+
+// provider is a mock OpenID Connect provider with authorize, token and JWKS
+// endpoints, enough for the authorization code flow with PKCE
+type provider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+// grant is what an authorization code was issued for
+type grant struct {
+	challenge string
+	nonce     string
+}
+
+func newProvider(t *testing.T) *provider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &provider{key: key, codes: map[string]grant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/authorize", p.authorize)
+	mux.HandleFunc("/oauth2/token", p.token)
+	mux.HandleFunc("/.well-known/jwks.json", p.jwks)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+func (p *provider) issuer() string {
+	return p.URL
+}
+
+// authorize signs the user in right away and redirects back with a code
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != clientID || q.Get("redirect_uri") != redirectURL || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code, _ := oauth.RandomString(16)
+	p.mu.Lock()
+	p.codes[code] = grant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	p.mu.Unlock()
+
+	http.Redirect(w, r, redirectURL+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+}
+
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(code string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+
+	if id, secret, ok := r.BasicAuth(); !ok || id != clientID || secret != clientSecret {
+		fail("invalid_client")
+		return
+	}
+
+	p.mu.Lock()
+	g, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != redirectURL {
+		fail("invalid_grant")
+		return
+	}
+	if oauth.Challenge(r.PostFormValue("code_verifier")) != g.challenge {
+		fail("invalid_grant")
+		return
+	}
+
+	idToken, err := p.sign(jwt.MapClaims{"nonce": g.nonce})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(oauth.Token{
+		AccessToken:  "access",
+		IDToken:      idToken,
+		RefreshToken: "refresh",
+		TokenType:    "Bearer",
+		ExpiresIn:    3600,
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// sign issues an ID token for alice, claims override the defaults
+func (p *provider) sign(claims jwt.MapClaims) (string, error) {
+	now := time.Now()
+	all := jwt.MapClaims{
+		"iss":              p.issuer(),
+		"aud":              clientID,
+		"sub":              "00000000-0000-0000-0000-000000000001",
+		"cognito:username": "alice",
+		"token_use":        "id",
+		"iat":              now.Unix(),
+		"exp":              now.Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(all, k)
+			continue
+		}
+		all[k] = v
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, all)
+	token.Header["kid"] = kid
+	return token.SignedString(p.key)
+}
+
+func (p *provider) client() *oauth.Client {
+	keys := jwks.New(p.URL+"/.well-known/jwks.json", jwks.Options{})
+	return oauth.New(oauth.Config{
+		AuthorizeURL: p.URL + "/oauth2/authorize",
+		TokenURL:     p.URL + "/oauth2/token",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email"},
+		Issuer:       p.issuer(),
+		Keyfunc:      keys.Keyfunc,
+	})
+}
+
+// authorize follows the authorization URL like a user agent and returns the code
+func authorize(t *testing.T, c *oauth.Client, r oauth.AuthRequest) string {
+	t.Helper()
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := noRedirect.Get(c.AuthCodeURL(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d, location %q", res.StatusCode, res.Header.Get("Location"))
+	}
+	if got := location.Query().Get("state"); got != r.State {
+		t.Fatalf("state = %q, want %q", got, r.State)
+	}
+
+	return location.Query().Get("code")
+}
+
+func newAuthRequest(t *testing.T) (oauth.AuthRequest, string) {
+	t.Helper()
+
+	verifier, err := oauth.NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return oauth.AuthRequest{State: "state", Nonce: "nonce", CodeChallenge: oauth.Challenge(verifier)}, verifier
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	p := newProvider(t)
+	c := p.client()
+	ctx := context.Background()
+
+	r, verifier := newAuthRequest(t)
+	token, err := c.Exchange(ctx, authorize(t, c, r), verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	claims, err := c.VerifyIDToken(ctx, token.IDToken, r.Nonce)
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if claims["cognito:username"] != "alice" {
+		t.Fatalf("claims = %v", claims)
+	}
+}
+
+func TestExchangeErrors(t *testing.T) {
+	p := newProvider(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		run  func(c *oauth.Client) error
+		code string
+	}{
+		{
+			name: "WrongVerifier",
+			run: func(c *oauth.Client) error {
+				r, _ := newAuthRequest(t)
+				_, err := c.Exchange(ctx, authorize(t, c, r), "another-verifier")
+				return err
+			},
+			code: "invalid_grant",
+		},
+		{
+			name: "CodeUsedTwice",
+			run: func(c *oauth.Client) error {
+				r, verifier := newAuthRequest(t)
+				code := authorize(t, c, r)
+				if _, err := c.Exchange(ctx, code, verifier); err != nil {
+					t.Fatal(err)
+				}
+				_, err := c.Exchange(ctx, code, verifier)
+				return err
+			},
+			code: "invalid_grant",
+		},
+		{
+			name: "WrongClientSecret",
+			run: func(*oauth.Client) error {
+				c := oauth.New(oauth.Config{
+					AuthorizeURL: p.URL + "/oauth2/authorize",
+					TokenURL:     p.URL + "/oauth2/token",
+					ClientID:     clientID,
+					ClientSecret: "wrong",
+					RedirectURL:  redirectURL,
+				})
+				r, verifier := newAuthRequest(t)
+				_, err := c.Exchange(ctx, authorize(t, c, r), verifier)
+				return err
+			},
+			code: "invalid_client",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run(p.client())
+
+			var oauthErr *oauth.Error
+			if !errors.As(err, &oauthErr) || oauthErr.Code != tt.code || oauthErr.StatusCode != http.StatusBadRequest {
+				t.Fatalf("err = %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	p := newProvider(t)
+	c := p.client()
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token func() (string, error)
+		nonce string
+		// valid tokens verify, others fail with wantErr when it is set
+		valid   bool
+		wantErr error
+	}{
+		{
+			name:  "Valid",
+			token: func() (string, error) { return p.sign(jwt.MapClaims{"nonce": "nonce"}) },
+			nonce: "nonce",
+			valid: true,
+		},
+		{
+			name:    "OtherIssuer",
+			token:   func() (string, error) { return p.sign(jwt.MapClaims{"nonce": "nonce", "iss": "https://evil.example"}) },
+			nonce:   "nonce",
+			wantErr: oauth.ErrIssuerMismatch,
+		},
+		{
+			name:    "NoIssuer",
+			token:   func() (string, error) { return p.sign(jwt.MapClaims{"nonce": "nonce", "iss": nil}) },
+			nonce:   "nonce",
+			wantErr: oauth.ErrIssuerMismatch,
+		},
+		{
+			name:  "OtherAudience",
+			token: func() (string, error) { return p.sign(jwt.MapClaims{"nonce": "nonce", "aud": "another-client"}) },
+			nonce: "nonce",
+		},
+		{
+			name:    "OtherNonce",
+			token:   func() (string, error) { return p.sign(jwt.MapClaims{"nonce": "replayed"}) },
+			nonce:   "nonce",
+			wantErr: oauth.ErrNonceMismatch,
+		},
+		{
+			name: "Expired",
+			token: func() (string, error) {
+				return p.sign(jwt.MapClaims{"nonce": "nonce", "exp": time.Now().Add(-time.Minute).Unix()})
+			},
+			nonce: "nonce",
+		},
+		{
+			name: "OtherKey",
+			token: func() (string, error) {
+				token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": p.issuer(), "aud": clientID, "nonce": "nonce"})
+				token.Header["kid"] = kid
+				return token.SignedString(other)
+			},
+			nonce: "nonce",
+		},
+		{
+			name: "SymmetricAlgorithm",
+			token: func() (string, error) {
+				token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": p.issuer(), "aud": clientID, "nonce": "nonce"})
+				token.Header["kid"] = kid
+				return token.SignedString([]byte("secret"))
+			},
+			nonce: "nonce",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.token()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = c.VerifyIDToken(context.Background(), token, tt.nonce)
+			switch {
+			case tt.valid:
+				if err != nil {
+					t.Fatalf("err = %v", err)
+				}
+			case err == nil:
+				t.Fatal("token was accepted")
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	c := oauth.New(oauth.Config{
+		AuthorizeURL: "https://auth.example/oauth2/authorize?lang=en",
+		ClientID:     clientID,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email"},
+	})
+
+	raw := c.AuthCodeURL(oauth.AuthRequest{State: "s", Nonce: "n", CodeChallenge: "c", IdentityProvider: "Google"})
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+	for key, want := range map[string]string{
+		"lang":                  "en",
+		"response_type":         "code",
+		"client_id":             clientID,
+		"redirect_uri":          redirectURL,
+		"scope":                 "openid email",
+		"state":                 "s",
+		"nonce":                 "n",
+		"code_challenge":        "c",
+		"code_challenge_method": "S256",
+		"identity_provider":     "Google",
+	} {
+		if got := q.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if strings.Count(raw, "?") != 1 {
+		t.Errorf("url %q has more than one query", raw)
+	}
+}
@@ -0,0 +1,49 @@
+// Package secrets makes the one time codes and tokens handed out to users and
+// hashes them for storage. Only the hash of a secret is ever stored.
+package secrets
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+)
+
+// Code returns a random six digit code for users to type in
+func Code() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// Token returns 32 random bytes, base64url encoded
+func Token() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Hash returns the hex encoded SHA-256 of a token. Tokens carry 256 random bits,
+// so a plain hash is safe to store and look up by. Codes are not, see HashCode.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashCode returns the hex encoded HMAC-SHA256 of a code under key. A six digit
+// code has a million values and its plain hash is reversed by hashing them all,
+// so stored codes are only safe with a key that isn't stored next to them.
+func HashCode(key []byte, code string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,54 @@
+package secrets_test
+
+import (
+	"regexp"
+	"testing"
+
+	"backend/pkg/secrets"
+)
+
+func TestCode(t *testing.T) {
+	digits := regexp.MustCompile(`^[0-9]{6}$`)
+	for i := 0; i < 100; i++ {
+		code, err := secrets.Code()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !digits.MatchString(code) {
+			t.Fatalf("code %q is not six digits", code)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	a, err := secrets.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := secrets.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a) != 43 || a == b {
+		t.Fatalf("tokens %q and %q", a, b)
+	}
+}
+
+func TestHash(t *testing.T) {
+	const want = "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+	if got := secrets.Hash("secret"); got != want {
+		t.Fatalf("Hash = %s, want %s", got, want)
+	}
+}
+
+func TestHashCode(t *testing.T) {
+	const want = "4df81f55d708ae1720d5f65ef42f3475dc168fa23fde424ac5944f87c309b05f"
+	if got := secrets.HashCode([]byte("key"), "123456"); got != want {
+		t.Fatalf("HashCode = %s, want %s", got, want)
+	}
+	// without the key the hash can't be recomputed from the code alone
+	if secrets.HashCode([]byte("other"), "123456") == want || secrets.Hash("123456") == want {
+		t.Fatal("the hash doesn't depend on the key")
+	}
+}
@@ -0,0 +1,239 @@
+// Package trigger receives Cognito Lambda trigger events over HTTP. A thin
+// Lambda function attached to the user pool forwards the event it is invoked
+// with, signed with a shared secret, and returns the event this service
+// answers with to Cognito. When the answer is not a 200 the function throws
+// with the message of the answer, which makes Cognito fail the operation.
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HeaderSignature carries the signature of a forwarded event, see package
+// webhook for the scheme
+const HeaderSignature = "X-Trigger-Signature"
+
+// Kinds of triggers, the part of the trigger source before the underscore
+const (
+	KindPreSignUp                   = "PreSignUp"
+	KindPostConfirmation            = "PostConfirmation"
+	KindPreTokenGeneration          = "TokenGeneration"
+	KindCustomMessage               = "CustomMessage"
+	KindDefineAuthChallenge         = "DefineAuthChallenge"
+	KindCreateAuthChallenge         = "CreateAuthChallenge"
+	KindVerifyAuthChallengeResponse = "VerifyAuthChallengeResponse"
+)
+
+var ErrUnknownTrigger = errors.New("trigger source is not supported")
+
+// Event is the envelope every trigger event shares. Request and Response are
+// decoded into the types of the trigger by Dispatch.
+type Event struct {
+	Version       string          `json:"version"`
+	TriggerSource string          `json:"triggerSource"`
+	Region        string          `json:"region"`
+	UserPoolID    string          `json:"userPoolId"`
+	UserName      string          `json:"userName"`
+	CallerContext CallerContext   `json:"callerContext"`
+	Request       json.RawMessage `json:"request"`
+	Response      json.RawMessage `json:"response"`
+}
+
+type CallerContext struct {
+	AWSSDKVersion string `json:"awsSdkVersion"`
+	ClientID      string `json:"clientId"`
+}
+
+// Kind returns the kind of trigger the event is for
+func (e *Event) Kind() string {
+	kind, _, _ := strings.Cut(e.TriggerSource, "_")
+	return kind
+}
+
+type PreSignUpRequest struct {
+	UserAttributes map[string]string `json:"userAttributes"`
+	ValidationData map[string]string `json:"validationData"`
+	ClientMetadata map[string]string `json:"clientMetadata"`
+}
+
+type PreSignUpResponse struct {
+	AutoConfirmUser bool `json:"autoConfirmUser"`
+	AutoVerifyEmail bool `json:"autoVerifyEmail"`
+	AutoVerifyPhone bool `json:"autoVerifyPhone"`
+}
+
+type PostConfirmationRequest struct {
+	UserAttributes map[string]string `json:"userAttributes"`
+	ClientMetadata map[string]string `json:"clientMetadata"`
+}
+
+type PostConfirmationResponse struct{}
+
+type PreTokenGenerationRequest struct {
+	UserAttributes     map[string]string  `json:"userAttributes"`
+	GroupConfiguration GroupConfiguration `json:"groupConfiguration"`
+	ClientMetadata     map[string]string  `json:"clientMetadata"`
+}
+
+type GroupConfiguration struct {
+	GroupsToOverride   []string `json:"groupsToOverride"`
+	IAMRolesToOverride []string `json:"iamRolesToOverride"`
+	PreferredRole      *string  `json:"preferredRole"`
+}
+
+type PreTokenGenerationResponse struct {
+	ClaimsOverrideDetails *ClaimsOverrideDetails `json:"claimsOverrideDetails"`
+}
+
+// ClaimsOverrideDetails changes the claims of the ID token
+type ClaimsOverrideDetails struct {
+	ClaimsToAddOrOverride map[string]string   `json:"claimsToAddOrOverride,omitempty"`
+	ClaimsToSuppress      []string            `json:"claimsToSuppress,omitempty"`
+	GroupOverrideDetails  *GroupConfiguration `json:"groupOverrideDetails,omitempty"`
+}
+
+type CustomMessageRequest struct {
+	UserAttributes    map[string]string `json:"userAttributes"`
+	CodeParameter     string            `json:"codeParameter"`
+	LinkParameter     string            `json:"linkParameter"`
+	UsernameParameter string            `json:"usernameParameter"`
+	ClientMetadata    map[string]string `json:"clientMetadata"`
+}
+
+// CustomMessageResponse replaces the messages Cognito sends. Empty fields keep
+// the messages configured in the user pool. The email message has to contain
+// the code parameter of the request.
+type CustomMessageResponse struct {
+	SMSMessage   string `json:"smsMessage,omitempty"`
+	EmailMessage string `json:"emailMessage,omitempty"`
+	EmailSubject string `json:"emailSubject,omitempty"`
+}
+
+// ChallengeResult is one step of a custom authentication session
+type ChallengeResult struct {
+	ChallengeName     string `json:"challengeName"`
+	ChallengeResult   bool   `json:"challengeResult"`
+	ChallengeMetadata string `json:"challengeMetadata,omitempty"`
+}
+
+type DefineAuthChallengeRequest struct {
+	UserAttributes map[string]string `json:"userAttributes"`
+	Session        []ChallengeResult `json:"session"`
+	UserNotFound   bool              `json:"userNotFound"`
+	ClientMetadata map[string]string `json:"clientMetadata"`
+}
+
+type DefineAuthChallengeResponse struct {
+	ChallengeName      string `json:"challengeName,omitempty"`
+	IssueTokens        bool   `json:"issueTokens"`
+	FailAuthentication bool   `json:"failAuthentication"`
+}
+
+type CreateAuthChallengeRequest struct {
+	UserAttributes map[string]string `json:"userAttributes"`
+	ChallengeName  string            `json:"challengeName"`
+	Session        []ChallengeResult `json:"session"`
+	UserNotFound   bool              `json:"userNotFound"`
+	ClientMetadata map[string]string `json:"clientMetadata"`
+}
+
+type CreateAuthChallengeResponse struct {
+	PublicChallengeParameters  map[string]string `json:"publicChallengeParameters"`
+	PrivateChallengeParameters map[string]string `json:"privateChallengeParameters"`
+	ChallengeMetadata          string            `json:"challengeMetadata,omitempty"`
+}
+
+type VerifyAuthChallengeRequest struct {
+	UserAttributes             map[string]string `json:"userAttributes"`
+	PrivateChallengeParameters map[string]string `json:"privateChallengeParameters"`
+	ChallengeAnswer            string            `json:"challengeAnswer"`
+	UserNotFound               bool              `json:"userNotFound"`
+	ClientMetadata             map[string]string `json:"clientMetadata"`
+}
+
+type VerifyAuthChallengeResponse struct {
+	AnswerCorrect bool `json:"answerCorrect"`
+}
+
+// Hooks are the Go functions triggers are dispatched to. A trigger without a
+// hook is answered with the response Cognito sent, which keeps its defaults.
+type Hooks struct {
+	PreSignUp                   func(ctx context.Context, e *Event, req *PreSignUpRequest, res *PreSignUpResponse) error
+	PostConfirmation            func(ctx context.Context, e *Event, req *PostConfirmationRequest, res *PostConfirmationResponse) error
+	PreTokenGeneration          func(ctx context.Context, e *Event, req *PreTokenGenerationRequest, res *PreTokenGenerationResponse) error
+	CustomMessage               func(ctx context.Context, e *Event, req *CustomMessageRequest, res *CustomMessageResponse) error
+	DefineAuthChallenge         func(ctx context.Context, e *Event, req *DefineAuthChallengeRequest, res *DefineAuthChallengeResponse) error
+	CreateAuthChallenge         func(ctx context.Context, e *Event, req *CreateAuthChallengeRequest, res *CreateAuthChallengeResponse) error
+	VerifyAuthChallengeResponse func(ctx context.Context, e *Event, req *VerifyAuthChallengeRequest, res *VerifyAuthChallengeResponse) error
+}
+
+// Dispatch runs the hook of the event's trigger and writes its response back
+// into the event
+func (h *Hooks) Dispatch(ctx context.Context, e *Event) error {
+	switch e.Kind() {
+	case KindPreSignUp:
+		return dispatch(ctx, e, h.PreSignUp)
+	case KindPostConfirmation:
+		return dispatch(ctx, e, h.PostConfirmation)
+	case KindPreTokenGeneration:
+		return dispatch(ctx, e, h.PreTokenGeneration)
+	case KindCustomMessage:
+		return dispatch(ctx, e, h.CustomMessage)
+	case KindDefineAuthChallenge:
+		return dispatch(ctx, e, h.DefineAuthChallenge)
+	case KindCreateAuthChallenge:
+		return dispatch(ctx, e, h.CreateAuthChallenge)
+	case KindVerifyAuthChallengeResponse:
+		return dispatch(ctx, e, h.VerifyAuthChallengeResponse)
+	default:
+		return ErrUnknownTrigger
+	}
+}
+
+func dispatch[Req, Res any](ctx context.Context, e *Event, hook func(context.Context, *Event, *Req, *Res) error) error {
+	if hook == nil {
+		return nil
+	}
+
+	var req Req
+	var res Res
+	if len(e.Request) > 0 {
+		if err := json.Unmarshal(e.Request, &req); err != nil {
+			return fmt.Errorf("trigger request: %w", err)
+		}
+	}
+	if len(e.Response) > 0 && string(e.Response) != "null" {
+		if err := json.Unmarshal(e.Response, &res); err != nil {
+			return fmt.Errorf("trigger response: %w", err)
+		}
+	}
+
+	if err := hook(ctx, e, &req, &res); err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	e.Response = raw
+	return nil
+}
+
+// Rejection is returned by hooks to refuse the operation with a message Cognito
+// shows to the client, e.g. a sign up from a domain that isn't allowed
+type Rejection struct {
+	Message string
+}
+
+func (r *Rejection) Error() string {
+	return r.Message
+}
+
+func Reject(format string, args ...interface{}) error {
+	return &Rejection{Message: fmt.Sprintf(format, args...)}
+}
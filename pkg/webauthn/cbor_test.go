@@ -0,0 +1,110 @@
+package webauthn
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want interface{}
+		n    int
+	}{
+		{"Uint", "1818", int64(24), 2},
+		{"NegativeInt", "20", int64(-1), 1},
+		{"Bytes", "420102", []byte{1, 2}, 3},
+		{"Text", "63666d74", "fmt", 4},
+		{"Array", "820102", []interface{}{int64(1), int64(2)}, 3},
+		{"IntKeys", "a2010221420102", map[interface{}]interface{}{int64(1): int64(2), int64(-2): []byte{1, 2}}, 7},
+		{"TextKeys", "a163666d74646e6f6e65", map[interface{}]interface{}{"fmt": "none"}, 10},
+		{"Simple", "83f4f5f6", []interface{}{false, true, nil}, 4},
+		{"TrailingBytes", "01ff", int64(1), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, n, err := decodeCBOR(mustHex(t, tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || n != tt.n {
+				t.Fatalf("got %#v after %d bytes, want %#v after %d", got, n, tt.want, tt.n)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"Empty", ""},
+		{"Truncated", "1a0001"},
+		{"ShortBytes", "4401"},
+		{"ArrayKey", "a18000"},
+		{"MapKey", "a1a00000"},
+		{"ByteStringKey", "a1420102"},
+		{"BoolKey", "a1f500"},
+		{"NullKey", "a1f600"},
+		{"HugeArray", "9bffffffffffffffff"},
+		{"HugeMap", "bbffffffffffffffff"},
+		{"IntOverflow", "1bffffffffffffffff"},
+		{"Float", "fa3f800000"},
+		{"Tag", "c001"},
+		{"Indefinite", "9f01ff"},
+		{"TooDeep", strings.Repeat("81", maxDepth+1) + "01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeCBOR(mustHex(t, tt.in)); !errors.Is(err, errCBOR) {
+				t.Fatalf("err = %v, want %v", err, errCBOR)
+			}
+		})
+	}
+}
+
+// FuzzDecodeCBOR feeds attacker controlled attestation objects and COSE keys
+// to the decoder, which must return errCBOR instead of panicking
+func FuzzDecodeCBOR(f *testing.F) {
+	for _, seed := range []string{
+		"a2010221420102",
+		"a163666d74646e6f6e65",
+		"a18000",
+		"a1a00000",
+		"9f01ff",
+	} {
+		b, _ := hex.DecodeString(seed)
+		f.Add(b)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v, n, err := decodeCBOR(data)
+		if err != nil {
+			if !errors.Is(err, errCBOR) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			return
+		}
+		if n <= 0 || n > len(data) {
+			t.Fatalf("decoded %v from %d of %d bytes", v, n, len(data))
+		}
+	})
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
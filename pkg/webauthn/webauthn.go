@@ -0,0 +1,407 @@
+// Package webauthn implements the relying party side of the WebAuthn
+// registration and authentication ceremonies for passkeys. Attestation
+// statements are not verified, the options ask authenticators for none.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// User verification requirements
+const (
+	UserVerificationRequired    = "required"
+	UserVerificationPreferred   = "preferred"
+	UserVerificationDiscouraged = "discouraged"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagBackupEligible         = 0x08
+	flagBackedUp               = 0x10
+	flagAttestedCredentialData = 0x40
+)
+
+var (
+	ErrInvalidClientData  = errors.New("webauthn: client data does not match the ceremony")
+	ErrInvalidOrigin      = errors.New("webauthn: origin is not allowed")
+	ErrInvalidRPID        = errors.New("webauthn: credential is scoped to another relying party")
+	ErrUserNotPresent     = errors.New("webauthn: user presence was not asserted")
+	ErrUserNotVerified    = errors.New("webauthn: user verification is required")
+	ErrInvalidSignature   = errors.New("webauthn: signature is not valid")
+	ErrSignCountRegressed = errors.New("webauthn: signature counter did not increase, the authenticator may be cloned")
+	ErrMalformed          = errors.New("webauthn: malformed response")
+)
+
+// Config describes the relying party
+type Config struct {
+	RPID             string
+	RPName           string
+	Origins          []string
+	UserVerification string
+	Timeout          time.Duration
+}
+
+// RelyingParty runs ceremonies for one relying party
+type RelyingParty struct {
+	cfg Config
+}
+
+func New(cfg Config) *RelyingParty {
+	if cfg.UserVerification == "" {
+		cfg.UserVerification = UserVerificationPreferred
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Minute
+	}
+
+	return &RelyingParty{cfg: cfg}
+}
+
+// Timeout is how long a ceremony may take
+func (rp *RelyingParty) Timeout() time.Duration {
+	return rp.cfg.Timeout
+}
+
+// NewChallenge returns a random ceremony challenge
+func NewChallenge() ([]byte, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// User is the account a credential is registered for
+type User struct {
+	// ID is the user handle, it must not contain personal data
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// CreationOptions are the PublicKeyCredentialCreationOptions passed to
+// navigator.credentials.create, binary fields base64url encoded
+type CreationOptions struct {
+	Challenge              string                   `json:"challenge"`
+	RP                     map[string]string        `json:"rp"`
+	User                   map[string]string        `json:"user"`
+	PubKeyCredParams       []map[string]interface{} `json:"pubKeyCredParams"`
+	Timeout                int64                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor   `json:"excludeCredentials"`
+	AuthenticatorSelection map[string]interface{}   `json:"authenticatorSelection"`
+	Attestation            string                   `json:"attestation"`
+}
+
+// RequestOptions are the PublicKeyCredentialRequestOptions passed to
+// navigator.credentials.get
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// CreationOptions builds the options of a registration ceremony. exclude lists
+// the credentials the user already has so an authenticator isn't registered twice.
+func (rp *RelyingParty) CreationOptions(challenge []byte, user User, exclude []CredentialDescriptor) CreationOptions {
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+
+	params := make([]map[string]interface{}, 0, 3)
+	for _, alg := range []int64{AlgES256, AlgEdDSA, AlgRS256} {
+		params = append(params, map[string]interface{}{"type": "public-key", "alg": alg})
+	}
+
+	return CreationOptions{
+		Challenge: encode(challenge),
+		RP:        map[string]string{"id": rp.cfg.RPID, "name": rp.cfg.RPName},
+		User: map[string]string{
+			"id":          encode(user.ID),
+			"name":        user.Name,
+			"displayName": user.DisplayName,
+		},
+		PubKeyCredParams:   params,
+		Timeout:            rp.cfg.Timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: map[string]interface{}{
+			"residentKey":      "preferred",
+			"userVerification": rp.cfg.UserVerification,
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions builds the options of an authentication ceremony. Without
+// allow the authenticator offers its discoverable credentials.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow []CredentialDescriptor) RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+
+	return RequestOptions{
+		Challenge:        encode(challenge),
+		RPID:             rp.cfg.RPID,
+		Timeout:          rp.cfg.Timeout.Milliseconds(),
+		AllowCredentials: allow,
+		UserVerification: rp.cfg.UserVerification,
+	}
+}
+
+// AttestationResponse is the response of navigator.credentials.create,
+// binary fields base64url encoded as by PublicKeyCredential.toJSON
+type AttestationResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON"`
+	AttestationObject string   `json:"attestationObject"`
+	Transports        []string `json:"transports"`
+}
+
+// AssertionResponse is the response of navigator.credentials.get
+type AssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+// Credential is a newly registered credential
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte
+	Algorithm      int64
+	SignCount      uint32
+	AAGUID         []byte
+	Transports     []string
+	UserVerified   bool
+	BackupEligible bool
+	BackedUp       bool
+}
+
+// VerifyRegistration checks the response of a registration ceremony started
+// with challenge and returns the credential to store
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, res AttestationResponse) (*Credential, error) {
+	clientData, err := decode(res.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyClientData(clientData, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	rawObject, err := decode(res.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	v, _, err := decodeCBOR(rawObject)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrMalformed
+	}
+	authData, ok := object["authData"].([]byte)
+	if !ok {
+		return nil, ErrMalformed
+	}
+
+	data, err := rp.parseAuthData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if data.flags&flagAttestedCredentialData == 0 || len(data.rest) < 18 {
+		return nil, ErrMalformed
+	}
+
+	aaguid := data.rest[:16]
+	idLen := int(binary.BigEndian.Uint16(data.rest[16:18]))
+	if idLen == 0 || idLen > 1023 || len(data.rest) < 18+idLen {
+		return nil, ErrMalformed
+	}
+	id := data.rest[18 : 18+idLen]
+
+	keyBytes := data.rest[18+idLen:]
+	_, keyLen, err := decodeCBOR(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseCOSEKey(keyBytes[:keyLen])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:             append([]byte(nil), id...),
+		PublicKey:      append([]byte(nil), keyBytes[:keyLen]...),
+		Algorithm:      key.alg,
+		SignCount:      data.signCount,
+		AAGUID:         append([]byte(nil), aaguid...),
+		Transports:     res.Transports,
+		UserVerified:   data.flags&flagUserVerified != 0,
+		BackupEligible: data.flags&flagBackupEligible != 0,
+		BackedUp:       data.flags&flagBackedUp != 0,
+	}, nil
+}
+
+// Assertion is the outcome of a verified authentication ceremony
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+	BackedUp     bool
+}
+
+// VerifyAssertion checks the response of an authentication ceremony started
+// with challenge against a stored credential public key and sign counter
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, publicKey []byte, storedSignCount uint32, res AssertionResponse) (*Assertion, error) {
+	clientData, err := decode(res.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyClientData(clientData, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+
+	authData, err := decode(res.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	data, err := rp.parseAuthData(authData)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := decode(res.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientData)
+	message := append(append([]byte(nil), authData...), clientDataHash[:]...)
+	if !key.verify(message, sig) {
+		return nil, ErrInvalidSignature
+	}
+
+	// authenticators without a counter always report zero
+	if (data.signCount != 0 || storedSignCount != 0) && data.signCount <= storedSignCount {
+		return nil, ErrSignCountRegressed
+	}
+
+	return &Assertion{
+		SignCount:    data.signCount,
+		UserVerified: data.flags&flagUserVerified != 0,
+		BackedUp:     data.flags&flagBackedUp != 0,
+	}, nil
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return ErrMalformed
+	}
+
+	got, err := decode(cd.Challenge)
+	if err != nil || cd.Type != ceremony || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrInvalidClientData
+	}
+
+	for _, origin := range rp.cfg.Origins {
+		if cd.Origin == origin {
+			return nil
+		}
+	}
+
+	return ErrInvalidOrigin
+}
+
+type authenticatorData struct {
+	flags     byte
+	signCount uint32
+	rest      []byte
+}
+
+func (rp *RelyingParty) parseAuthData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, ErrMalformed
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.cfg.RPID))
+	if !bytes.Equal(raw[:32], rpIDHash[:]) {
+		return nil, ErrInvalidRPID
+	}
+
+	data := &authenticatorData{
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+		rest:      raw[37:],
+	}
+
+	if data.flags&flagUserPresent == 0 {
+		return nil, ErrUserNotPresent
+	}
+	if rp.cfg.UserVerification == UserVerificationRequired && data.flags&flagUserVerified == 0 {
+		return nil, ErrUserNotVerified
+	}
+
+	return data, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decode accepts base64url with or without padding
+func decode(s string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(trimPadding(s))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	return b, nil
+}
+
+func trimPadding(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '=' {
+		s = s[:len(s)-1]
+	}
+
+	return s
+}
+
+// Encode base64url encodes binary values for the JSON of a ceremony
+func Encode(b []byte) string {
+	return encode(b)
+}
+
+// Decode reverses Encode
+func Decode(s string) ([]byte, error) {
+	return decode(s)
+}
@@ -0,0 +1,216 @@
+package webauthn_test
+
+import (
+	"errors"
+	"testing"
+
+	"backend/pkg/webauthn"
+	"backend/pkg/webauthn/webauthntest"
+)
+
+const (
+	rpID   = "example.com"
+	origin = "https://example.com"
+)
+
+func newRelyingParty(userVerification string) *webauthn.RelyingParty {
+	return webauthn.New(webauthn.Config{
+		RPID:             rpID,
+		RPName:           "Example",
+		Origins:          []string{origin},
+		UserVerification: userVerification,
+	})
+}
+
+func challenge(t *testing.T) []byte {
+	t.Helper()
+
+	c, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// register runs a registration ceremony between rp and a and returns the stored credential
+func register(t *testing.T, rp *webauthn.RelyingParty, a *webauthntest.Authenticator) *webauthn.Credential {
+	t.Helper()
+
+	c := challenge(t)
+	_, res, err := a.Register(rp.CreationOptions(c, webauthn.User{ID: []byte("user-1"), Name: "alice"}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cred, err := rp.VerifyRegistration(c, res)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+
+	return cred
+}
+
+func TestCeremonies(t *testing.T) {
+	rp := newRelyingParty(webauthn.UserVerificationPreferred)
+	a := webauthntest.New(rpID, origin)
+
+	cred := register(t, rp, a)
+	if cred.Algorithm != webauthn.AlgES256 || !cred.UserVerified || cred.SignCount != 0 {
+		t.Fatalf("unexpected credential %+v", cred)
+	}
+
+	signCount := cred.SignCount
+	for i := 0; i < 2; i++ {
+		c := challenge(t)
+		allow := []webauthn.CredentialDescriptor{{Type: "public-key", ID: webauthn.Encode(cred.ID)}}
+		id, res, err := a.Assert(rp.RequestOptions(c, allow))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != webauthn.Encode(cred.ID) {
+			t.Fatalf("asserted with %s, want %s", id, webauthn.Encode(cred.ID))
+		}
+
+		assertion, err := rp.VerifyAssertion(c, cred.PublicKey, signCount, res)
+		if err != nil {
+			t.Fatalf("VerifyAssertion: %v", err)
+		}
+		if assertion.SignCount <= signCount {
+			t.Fatalf("sign count %d did not move past %d", assertion.SignCount, signCount)
+		}
+		signCount = assertion.SignCount
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		rp      *webauthn.RelyingParty
+		auth    *webauthntest.Authenticator
+		tamper  func(c []byte, res *webauthn.AttestationResponse) []byte
+		wantErr error
+	}{
+		{
+			name: "OtherChallenge",
+			rp:   newRelyingParty(webauthn.UserVerificationPreferred),
+			auth: webauthntest.New(rpID, origin),
+			tamper: func(c []byte, res *webauthn.AttestationResponse) []byte {
+				return append([]byte{0}, c[1:]...)
+			},
+			wantErr: webauthn.ErrInvalidClientData,
+		},
+		{
+			name:    "OtherOrigin",
+			rp:      newRelyingParty(webauthn.UserVerificationPreferred),
+			auth:    webauthntest.New(rpID, "https://evil.example"),
+			wantErr: webauthn.ErrInvalidOrigin,
+		},
+		{
+			name:    "OtherRelyingParty",
+			rp:      newRelyingParty(webauthn.UserVerificationPreferred),
+			auth:    webauthntest.New("evil.example", origin),
+			wantErr: webauthn.ErrInvalidRPID,
+		},
+		{
+			name: "UserNotVerified",
+			rp:   newRelyingParty(webauthn.UserVerificationRequired),
+			auth: func() *webauthntest.Authenticator {
+				a := webauthntest.New(rpID, origin)
+				a.UserVerified = false
+				return a
+			}(),
+			wantErr: webauthn.ErrUserNotVerified,
+		},
+		{
+			// a map keyed by an array used to panic the decoder
+			name: "UnhashableMapKey",
+			rp:   newRelyingParty(webauthn.UserVerificationPreferred),
+			auth: webauthntest.New(rpID, origin),
+			tamper: func(c []byte, res *webauthn.AttestationResponse) []byte {
+				res.AttestationObject = webauthn.Encode([]byte{0xa1, 0x80, 0x00})
+				return c
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := challenge(t)
+			_, res, err := tt.auth.Register(tt.rp.CreationOptions(c, webauthn.User{ID: []byte("user-1"), Name: "alice"}, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				c = tt.tamper(c, &res)
+			}
+
+			_, err = tt.rp.VerifyRegistration(c, res)
+			if err == nil {
+				t.Fatal("registration was accepted")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(a *webauthntest.Authenticator)
+		tamper  func(res *webauthn.AssertionResponse)
+		wantErr error
+	}{
+		{
+			name:    "ClonedAuthenticator",
+			setup:   func(a *webauthntest.Authenticator) { a.FreezeCounter = true },
+			wantErr: webauthn.ErrSignCountRegressed,
+		},
+		{
+			name: "TamperedSignature",
+			tamper: func(res *webauthn.AssertionResponse) {
+				sig, _ := webauthn.Decode(res.Signature)
+				sig[len(sig)-1] ^= 0xff
+				res.Signature = webauthn.Encode(sig)
+			},
+			wantErr: webauthn.ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newRelyingParty(webauthn.UserVerificationPreferred)
+			a := webauthntest.New(rpID, origin)
+			cred := register(t, rp, a)
+
+			// the first assertion moves the stored counter to one
+			c := challenge(t)
+			_, res, err := a.Assert(rp.RequestOptions(c, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			first, err := rp.VerifyAssertion(c, cred.PublicKey, cred.SignCount, res)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.setup != nil {
+				tt.setup(a)
+			}
+			c = challenge(t)
+			_, res, err = a.Assert(rp.RequestOptions(c, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				tt.tamper(&res)
+			}
+
+			if _, err := rp.VerifyAssertion(c, cred.PublicKey, first.SignCount, res); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
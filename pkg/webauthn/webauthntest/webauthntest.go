@@ -0,0 +1,219 @@
+// Package webauthntest provides a software authenticator that answers the
+// WebAuthn ceremonies of pkg/webauthn without a browser or security key.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"backend/pkg/webauthn"
+)
+
+// Authenticator holds ES256 credentials for one relying party and origin
+type Authenticator struct {
+	RPID   string
+	Origin string
+	// UserVerified sets the UV flag on every response
+	UserVerified bool
+	// FreezeCounter keeps the signature counter from moving, like a cloned authenticator
+	FreezeCounter bool
+
+	mu          sync.Mutex
+	credentials map[string]*credential
+}
+
+type credential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	userHandle []byte
+	counter    uint32
+}
+
+var ErrNoCredential = errors.New("webauthntest: no credential for the requested ceremony")
+
+func New(rpID, origin string) *Authenticator {
+	return &Authenticator{
+		RPID:         rpID,
+		Origin:       origin,
+		UserVerified: true,
+		credentials:  map[string]*credential{},
+	}
+}
+
+// Register answers navigator.credentials.create. It returns the ID of the new
+// credential next to the response.
+func (a *Authenticator) Register(opts webauthn.CreationOptions) (string, webauthn.AttestationResponse, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", webauthn.AttestationResponse{}, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", webauthn.AttestationResponse{}, err
+	}
+
+	userHandle, err := webauthn.Decode(opts.User["id"])
+	if err != nil {
+		return "", webauthn.AttestationResponse{}, err
+	}
+
+	cred := &credential{id: id, key: key, userHandle: userHandle}
+
+	attested := make([]byte, 16, 18+len(id))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(id)))
+	attested = append(attested, id...)
+	attested = append(attested, coseKey(&key.PublicKey)...)
+
+	authData := a.authData(0x40, cred.counter, attested)
+	object := encodeMap([][2][]byte{
+		{encodeText("fmt"), encodeText("none")},
+		{encodeText("attStmt"), encodeMap(nil)},
+		{encodeText("authData"), encodeBytes(authData)},
+	})
+
+	clientData, err := a.clientData("webauthn.create", opts.Challenge)
+	if err != nil {
+		return "", webauthn.AttestationResponse{}, err
+	}
+
+	a.mu.Lock()
+	a.credentials[webauthn.Encode(id)] = cred
+	a.mu.Unlock()
+
+	return webauthn.Encode(id), webauthn.AttestationResponse{
+		ClientDataJSON:    webauthn.Encode(clientData),
+		AttestationObject: webauthn.Encode(object),
+		Transports:        []string{"internal", "hybrid"},
+	}, nil
+}
+
+// Assert answers navigator.credentials.get with the first allowed credential,
+// or with any credential when the options allow every discoverable one. It
+// returns the ID of the credential used next to the response.
+func (a *Authenticator) Assert(opts webauthn.RequestOptions) (string, webauthn.AssertionResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var cred *credential
+	if len(opts.AllowCredentials) == 0 {
+		for _, c := range a.credentials {
+			cred = c
+			break
+		}
+	}
+	for _, allowed := range opts.AllowCredentials {
+		if c, ok := a.credentials[allowed.ID]; ok {
+			cred = c
+			break
+		}
+	}
+	if cred == nil {
+		return "", webauthn.AssertionResponse{}, ErrNoCredential
+	}
+
+	if !a.FreezeCounter {
+		cred.counter++
+	}
+	authData := a.authData(0, cred.counter, nil)
+
+	clientData, err := a.clientData("webauthn.get", opts.Challenge)
+	if err != nil {
+		return "", webauthn.AssertionResponse{}, err
+	}
+
+	hash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), hash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return "", webauthn.AssertionResponse{}, err
+	}
+
+	return webauthn.Encode(cred.id), webauthn.AssertionResponse{
+		ClientDataJSON:    webauthn.Encode(clientData),
+		AuthenticatorData: webauthn.Encode(authData),
+		Signature:         webauthn.Encode(sig),
+		UserHandle:        webauthn.Encode(cred.userHandle),
+	}, nil
+}
+
+func (a *Authenticator) authData(flags byte, counter uint32, attested []byte) []byte {
+	flags |= 0x01
+	if a.UserVerified {
+		flags |= 0x04
+	}
+
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	out := append(rpIDHash[:], flags)
+	out = binary.BigEndian.AppendUint32(out, counter)
+	return append(out, attested...)
+}
+
+func (a *Authenticator) clientData(ceremony, challenge string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+}
+
+func coseKey(key *ecdsa.PublicKey) []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	return encodeMap([][2][]byte{
+		{encodeInt(1), encodeInt(2)},
+		{encodeInt(3), encodeInt(webauthn.AlgES256)},
+		{encodeInt(-1), encodeInt(1)},
+		{encodeInt(-2), encodeBytes(x)},
+		{encodeInt(-3), encodeBytes(y)},
+	})
+}
+
+// The CBOR encoder below only writes the items used above
+
+func encodeHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+}
+
+func encodeInt(n int64) []byte {
+	if n < 0 {
+		return encodeHead(1, uint64(-1-n))
+	}
+	return encodeHead(0, uint64(n))
+}
+
+func encodeBytes(b []byte) []byte {
+	return append(encodeHead(2, uint64(len(b))), b...)
+}
+
+func encodeText(s string) []byte {
+	return append(encodeHead(3, uint64(len(s))), s...)
+}
+
+func encodeMap(pairs [][2][]byte) []byte {
+	out := encodeHead(5, uint64(len(pairs)))
+	for _, pair := range pairs {
+		out = append(out, pair[0]...)
+		out = append(out, pair[1]...)
+	}
+	return out
+}
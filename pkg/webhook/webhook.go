@@ -0,0 +1,78 @@
+// Package webhook signs and verifies HTTP callbacks with the scheme Stripe
+// popularised: a header of the form t=<unix seconds>,v1=<hex HMAC-SHA256 of
+// "t.body">. More than one v1 entry may be sent while a secret is rotated.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSignatureMissing = errors.New("webhook signature is missing")
+	ErrSignatureInvalid = errors.New("webhook signature is not valid")
+	ErrSignatureExpired = errors.New("webhook signature is too old")
+)
+
+// Sign returns the signature header value of body sent at t
+func Sign(secret []byte, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + mac(secret, ts, body)
+}
+
+// Verify checks the signature header of body. Signatures older than tolerance
+// are rejected so captured requests can't be replayed later.
+func Verify(secret []byte, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	if header == "" {
+		return ErrSignatureMissing
+	}
+
+	var ts string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrSignatureInvalid
+	}
+
+	expected := mac(secret, ts, body)
+	valid := false
+	for _, sig := range signatures {
+		// every signature is compared so the timing doesn't tell which one matched
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			valid = true
+		}
+	}
+	if !valid {
+		return ErrSignatureInvalid
+	}
+
+	sent := time.Unix(sec, 0)
+	if now.Sub(sent) > tolerance || sent.Sub(now) > tolerance {
+		return ErrSignatureExpired
+	}
+
+	return nil
+}
+
+func mac(secret []byte, ts string, body []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}